@@ -0,0 +1,123 @@
+package zstash
+
+import (
+	"fmt"
+	"time"
+)
+
+// AggregateResult summarises the results of RestoreAll or SaveAll, giving a
+// single roll-up per job for reporting.
+//
+// Durations are summed over the caches, so may exceed the elapsed time when
+// caches were processed concurrently.
+type AggregateResult struct {
+	// Caches is the number of caches attempted.
+	Caches int
+
+	// Hits is the number of caches restored from the exact key.
+	Hits int
+
+	// Fallbacks is the number of caches restored from a fallback key.
+	Fallbacks int
+
+	// Misses is the number of caches which weren't restored.
+	Misses int
+
+	// Created is the number of caches saved to a new cache entry.
+	Created int
+
+	// Existing is the number of caches not saved as the entry already existed.
+	Existing int
+
+	// Errors is the number of caches which failed.
+	Errors int
+
+	// BytesUploaded is the total number of bytes uploaded by saves.
+	BytesUploaded int64
+
+	// BytesDownloaded is the total number of bytes downloaded by restores.
+	BytesDownloaded int64
+
+	// ArchiveDuration is the total time spent building or extracting archives.
+	ArchiveDuration time.Duration
+
+	// TransferDuration is the total time spent uploading or downloading.
+	TransferDuration time.Duration
+
+	// TotalDuration is the total end-to-end duration of the operations.
+	TotalDuration time.Duration
+}
+
+// HitRate returns the fraction of restored caches which hit the exact key,
+// or 0 if no caches were restored without error.
+func (a AggregateResult) HitRate() float64 {
+	restores := a.Hits + a.Fallbacks + a.Misses
+	if restores == 0 {
+		return 0
+	}
+
+	return float64(a.Hits) / float64(restores)
+}
+
+// String returns the aggregate as a single line of key=value fields, such as
+// "caches=3 hits=2 fallbacks=1 misses=0 errors=0 hit_rate=0.67 ...", for
+// printing at the end of a job.
+func (a AggregateResult) String() string {
+	return fmt.Sprintf(
+		"caches=%d hits=%d fallbacks=%d misses=%d created=%d existing=%d errors=%d hit_rate=%.2f bytes_uploaded=%d bytes_downloaded=%d archive_duration=%s transfer_duration=%s total_duration=%s",
+		a.Caches, a.Hits, a.Fallbacks, a.Misses, a.Created, a.Existing, a.Errors, a.HitRate(),
+		a.BytesUploaded, a.BytesDownloaded, a.ArchiveDuration, a.TransferDuration, a.TotalDuration,
+	)
+}
+
+// Aggregate summarises the results of each cache.
+func (r RestoreAllResult) Aggregate() AggregateResult {
+	aggregate := AggregateResult{Caches: len(r.Caches)}
+
+	for _, result := range r.Caches {
+		switch result.Status() {
+		case "error":
+			aggregate.Errors++
+			continue
+		case "hit":
+			aggregate.Hits++
+		case "fallback":
+			aggregate.Fallbacks++
+		default:
+			aggregate.Misses++
+		}
+
+		aggregate.BytesDownloaded += result.Result.Transfer.BytesTransferred
+		aggregate.ArchiveDuration += result.Result.Archive.Duration
+		aggregate.TransferDuration += result.Result.Transfer.Duration
+		aggregate.TotalDuration += result.Result.TotalDuration
+	}
+
+	return aggregate
+}
+
+// Aggregate summarises the results of each cache.
+func (r SaveAllResult) Aggregate() AggregateResult {
+	aggregate := AggregateResult{Caches: len(r.Caches)}
+
+	for _, result := range r.Caches {
+		switch result.Status() {
+		case "error":
+			aggregate.Errors++
+			continue
+		case "created":
+			aggregate.Created++
+		default:
+			aggregate.Existing++
+		}
+
+		if result.Result.Transfer != nil {
+			aggregate.BytesUploaded += result.Result.Transfer.BytesTransferred
+			aggregate.TransferDuration += result.Result.Transfer.Duration
+		}
+		aggregate.ArchiveDuration += result.Result.Archive.Duration
+		aggregate.TotalDuration += result.Result.TotalDuration
+	}
+
+	return aggregate
+}
@@ -0,0 +1,76 @@
+package zstash
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreAllResult_Aggregate(t *testing.T) {
+	result := RestoreAllResult{
+		Caches: []CacheRestoreResult{
+			{CacheID: "node_modules", Result: RestoreResult{
+				CacheHit:      true,
+				CacheRestored: true,
+				Archive:       ArchiveMetrics{Duration: time.Second},
+				Transfer:      TransferMetrics{BytesTransferred: 100, Duration: 2 * time.Second},
+				TotalDuration: 4 * time.Second,
+			}},
+			{CacheID: "gems", Result: RestoreResult{
+				CacheRestored: true,
+				FallbackUsed:  true,
+				Archive:       ArchiveMetrics{Duration: time.Second},
+				Transfer:      TransferMetrics{BytesTransferred: 50, Duration: time.Second},
+				TotalDuration: 3 * time.Second,
+			}},
+			{CacheID: "go", Result: RestoreResult{TotalDuration: time.Second}},
+			{CacheID: "pip", Err: errors.New("download failed")},
+		},
+	}
+
+	aggregate := result.Aggregate()
+	assert.Equal(t, AggregateResult{
+		Caches:           4,
+		Hits:             1,
+		Fallbacks:        1,
+		Misses:           1,
+		Errors:           1,
+		BytesDownloaded:  150,
+		ArchiveDuration:  2 * time.Second,
+		TransferDuration: 3 * time.Second,
+		TotalDuration:    8 * time.Second,
+	}, aggregate)
+	assert.InDelta(t, 1.0/3, aggregate.HitRate(), 0.001)
+	assert.Equal(t, "caches=4 hits=1 fallbacks=1 misses=1 created=0 existing=0 errors=1 hit_rate=0.33 bytes_uploaded=0 bytes_downloaded=150 archive_duration=2s transfer_duration=3s total_duration=8s", aggregate.String())
+}
+
+func TestSaveAllResult_Aggregate(t *testing.T) {
+	result := SaveAllResult{
+		Caches: []CacheSaveResult{
+			{CacheID: "node_modules", Result: SaveResult{
+				CacheCreated:  true,
+				Archive:       ArchiveMetrics{Duration: time.Second},
+				Transfer:      &TransferMetrics{BytesTransferred: 100, Duration: 2 * time.Second},
+				TotalDuration: 4 * time.Second,
+			}},
+			{CacheID: "go", Result: SaveResult{TotalDuration: time.Second}},
+			{CacheID: "pip", Err: errors.New("upload failed")},
+		},
+	}
+
+	aggregate := result.Aggregate()
+	assert.Equal(t, AggregateResult{
+		Caches:           3,
+		Created:          1,
+		Existing:         1,
+		Errors:           1,
+		BytesUploaded:    100,
+		ArchiveDuration:  time.Second,
+		TransferDuration: 2 * time.Second,
+		TotalDuration:    5 * time.Second,
+	}, aggregate)
+	assert.Zero(t, aggregate.HitRate())
+	assert.Equal(t, "node_modules=created go=exists pip=error", result.Summary())
+}
@@ -3,16 +3,18 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/buildkite/zstash/internal/logging"
 	"github.com/buildkite/zstash/internal/trace"
 	"github.com/google/go-querystring/query"
 	"github.com/klauspost/compress/gzhttp"
@@ -26,6 +28,10 @@ const (
 
 var (
 	ErrCacheEntryNotFound = errors.New("cache entry not found")
+
+	// ErrCacheRegistryNotFound is returned when the requested cache registry
+	// doesn't exist or isn't accessible with the token.
+	ErrCacheRegistryNotFound = errors.New("cache registry not found")
 )
 
 // CacheClient defines the interface for cache API operations.
@@ -75,12 +81,18 @@ type CacheCreateReq struct {
 	Pipeline     string   `json:"pipeline"`
 	Branch       string   `json:"branch"`
 	Organization string   `json:"owner"`
+	Force        bool     `json:"force,omitempty"` // replace an existing committed entry for the key
 }
 
+// maxRetrieveURLLength is the longest retrieve URL sent with GET, as the API
+// and proxies in front of it truncate long query strings. Longer requests,
+// such as those with many fallback keys, are sent with POST.
+const maxRetrieveURLLength = 2048
+
 type CacheRetrieveReq struct {
-	Key          string `url:"key"`
-	Branch       string `url:"branch"`
-	FallbackKeys string `url:"fallback_keys"`
+	Key          string `url:"key" json:"key"`
+	Branch       string `url:"branch" json:"branch"`
+	FallbackKeys string `url:"fallback_keys" json:"fallback_keys"`
 }
 
 type CacheRetrieveResp struct {
@@ -93,14 +105,19 @@ type CacheRetrieveResp struct {
 	Multipart            bool      `json:"multipart"`
 	DownloadInstructions []string  `json:"download_instructions"`
 	Message              string    `json:"message"`
+	Pending              bool      `json:"pending"`   // an uncommitted entry exists for the key, which another job may still be uploading
+	FileSize             int       `json:"file_size"` // the size of the archive, zero if not reported
 }
 
 type CacheCreateResp struct {
-	UploadID           string   `json:"upload_id"` // the identifier used to write the key in blob storage
-	StoreObjectName    string   `json:"store_object_name"`
-	Multipart          bool     `json:"multipart"`
-	UploadInstructions []string `json:"upload_instructions"`
-	Message            string   `json:"message"`
+	UploadID           string    `json:"upload_id"` // the identifier used to write the key in blob storage
+	StoreObjectName    string    `json:"store_object_name"`
+	Multipart          bool      `json:"multipart"`
+	UploadInstructions []string  `json:"upload_instructions"`
+	Message            string    `json:"message"`
+	ExpiresAt          time.Time `json:"expires_at"` // when the cache entry expires, forwarded to stores which support expiry
+	Pending            bool      `json:"pending"`    // an uncommitted entry for the key already existed and was returned, its object may already be uploaded
+	Digest             string    `json:"digest"`     // the digest recorded for a pending entry
 }
 
 type CachePeekReq struct {
@@ -129,9 +146,10 @@ type CachePeekResp struct {
 }
 
 type CacheRegistryResp struct {
-	UUID  string `json:"uuid"`
-	Name  string `json:"name"`
-	Store string `json:"store"` // The store used for the cache registry
+	UUID        string   `json:"uuid"`
+	Name        string   `json:"name"`
+	Store       string   `json:"store"`                 // The store used for the cache registry
+	Compression []string `json:"compression,omitempty"` // the compression formats the registry accepts, empty if it doesn't advertise them
 }
 
 type CacheCommitReq struct {
@@ -141,10 +159,146 @@ type CacheCommitResp struct {
 	Message string `json:"message"`
 }
 
-func NewClient(ctx context.Context, version, endpoint, token string) Client {
+// ClientOption configures a Client created by NewClient.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	retry      RetryPolicy
+	timeout    time.Duration
+	recordMode RecordMode
+	recordPath string
+	httpClient *http.Client
+	transport  http.RoundTripper
+	tlsConfig  *tls.Config
+	proxy      func(*http.Request) (*url.URL, error)
+
+	fallbackEndpoints []string
+	failoverCooldown  time.Duration
+}
+
+// DefaultTimeout is the time limit for each API call made by a client created
+// by NewClient, including retries.
+const DefaultTimeout = 60 * time.Second
+
+// WithTimeout sets the time limit for each API call, including retries and
+// reading the response, so a hung connection doesn't stall the job. Zero
+// disables the limit.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithHTTPClient bases the client on a copy of the given http.Client, keeping
+// its transport, cookie jar and redirect policy. The timeout is still set
+// using WithTimeout.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(o *clientOptions) {
+		o.httpClient = client
+	}
+}
+
+// WithTransport sends requests using the given transport instead of
+// http.DefaultTransport, e.g. to add client certificates or instrumentation.
+// Authentication, compression and retries are still handled by the client.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the API, e.g. a
+// custom CA pool for a TLS intercepting proxy or client certificates for mTLS.
+// The transport must be an *http.Transport, which is cloned before use.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(o *clientOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithProxy sends requests through the proxy at the given URL, rather than
+// the proxy configured by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables. The transport must be an *http.Transport, which is
+// cloned before use.
+func WithProxy(proxyURL *url.URL) ClientOption {
+	return func(o *clientOptions) {
+		o.proxy = http.ProxyURL(proxyURL)
+	}
+}
+
+// baseTransport returns the transport requests are sent with, applying the TLS
+// and proxy options.
+func (o clientOptions) baseTransport() (http.RoundTripper, error) {
+	base := o.transport
+	if base == nil && o.httpClient != nil {
+		base = o.httpClient.Transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if o.tlsConfig == nil && o.proxy == nil {
+		return base, nil
+	}
+
+	httpTransport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("TLS and proxy options require an *http.Transport, got %T", base)
+	}
+
+	httpTransport = httpTransport.Clone()
+	if o.tlsConfig != nil {
+		httpTransport.TLSClientConfig = o.tlsConfig.Clone()
+	}
+	if o.proxy != nil {
+		httpTransport.Proxy = o.proxy
+	}
+
+	return httpTransport, nil
+}
+
+// NewClient creates a client for the agent cache API. Requests which fail with
+// transient errors are retried using DefaultRetryPolicy, unless overridden
+// using WithRetryPolicy. Each call is limited to DefaultTimeout, unless
+// overridden using WithTimeout.
+//
+// API interactions are recorded or replayed when configured using WithRecorder,
+// or the BUILDKITE_ZSTASH_API_RECORD_MODE and BUILDKITE_ZSTASH_API_FIXTURES
+// environment variables.
+//
+// Requests are sent using http.DefaultTransport, unless customised using
+// WithHTTPClient, WithTransport, WithTLSConfig or WithProxy. Requests fail
+// over to the endpoints set using WithFallbackEndpoints when the endpoint is
+// unavailable.
+func NewClient(ctx context.Context, version, endpoint, token string, opts ...ClientOption) Client {
+	options := clientOptions{
+		retry:      DefaultRetryPolicy,
+		timeout:    DefaultTimeout,
+		recordMode: RecordMode(os.Getenv(RecordModeEnv)),
+		recordPath: os.Getenv(RecordFixturesEnv),
+
+		failoverCooldown: DefaultFailoverCooldown,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	client := &http.Client{}
+	if options.httpClient != nil {
+		*client = *options.httpClient
+	}
+	client.Timeout = options.timeout
+
+	base, err := options.baseTransport()
+	if err != nil {
+		// surface the misconfiguration on every request rather than silently
+		// ignoring the options
+		base = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, fmt.Errorf("failed to configure API transport: %w", err)
+		})
+	}
 
-	client.Transport = gzhttp.Transport(roundTripperFunc(
+	transport := gzhttp.Transport(roundTripperFunc(
 		func(req *http.Request) (*http.Response, error) {
 			req = req.Clone(req.Context())
 			req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
@@ -152,10 +306,30 @@ func NewClient(ctx context.Context, version, endpoint, token string) Client {
 			req.Header.Set("Accept", "application/json")
 			req.Header.Set("Content-Type", "application/json")
 			req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-			return http.DefaultTransport.RoundTrip(req)
+			return base.RoundTrip(req)
 		}),
 	)
 
+	if options.recordMode != RecordModeOff {
+		recorder, err := newRecorderTransport(transport, options.recordMode, options.recordPath)
+		if err != nil {
+			// surface the misconfiguration on every request rather than silently
+			// sending requests to the API
+			transport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("failed to configure API recorder: %w", err)
+			})
+		} else {
+			transport = recorder
+		}
+	}
+
+	client.Transport = &retryTransport{next: transport, policy: options.retry}
+
+	if len(options.fallbackEndpoints) > 0 {
+		endpoints := append([]string{endpoint}, options.fallbackEndpoints...)
+		client.Transport = newFailoverTransport(client.Transport, endpoints, options.failoverCooldown)
+	}
+
 	return Client{client: client, endpoint: endpoint}
 }
 
@@ -201,7 +375,7 @@ func handleCacheResponse[T MessageGetter](span otel.Span, res *http.Response, re
 		case CacheEntryNotFound:
 			return resp, false, nil
 		case CacheRegistryNotFound:
-			return resp, false, trace.NewError(span, "cache registry not found: %s", res.Status)
+			return resp, false, trace.NewError(span, "%w: %s", ErrCacheRegistryNotFound, res.Status)
 		}
 		return resp, false, trace.NewError(span, "not found: %s", res.Status)
 	case http.StatusBadRequest:
@@ -223,6 +397,13 @@ func (c Client) CacheRegistry(ctx context.Context, registry string) (CacheRegist
 	}
 
 	res, resp, err := doRequest[any, CacheRegistryResp](ctx, c.client, http.MethodGet, u.String(), nil)
+
+	// the body of a missing registry's response isn't a registry, so may
+	// fail to decode
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return resp, trace.NewError(span, "failed to get cache registry: %w: %s", ErrCacheRegistryNotFound, res.Status)
+	}
+
 	if err != nil {
 		return resp, trace.NewError(span, "failed to do request: %w", err)
 	}
@@ -282,7 +463,7 @@ func (c Client) CacheCommit(ctx context.Context, registry string, commit CacheCo
 		return resp, trace.NewError(span, "failed to do request: %w", err)
 	}
 
-	slog.Debug("Cache committed with the following parameters", "resp", resp)
+	logging.FromContext(ctx).Debug("Cache committed with the following parameters", "resp", resp)
 
 	if res.StatusCode != http.StatusOK {
 		return resp, trace.NewError(span, "failed to commit: %s", res.Status)
@@ -295,6 +476,9 @@ func (c Client) CacheCreate(ctx context.Context, registry string, create CacheCr
 	ctx, span := trace.Start(ctx, "Client.CacheCreate")
 	defer span.End()
 
+	// stores link their spans to the call which provided the object
+	trace.RecordLink(ctx, span)
+
 	var resp CacheCreateResp
 
 	u, err := url.Parse(fmt.Sprintf("%s/cache_registries/%s/store", c.endpoint, registry))
@@ -318,6 +502,9 @@ func (c Client) CacheRetrieve(ctx context.Context, registry string, retrieve Cac
 	ctx, span := trace.Start(ctx, "Client.CacheRetrieve")
 	defer span.End()
 
+	// stores link their spans to the call which provided the object
+	trace.RecordLink(ctx, span)
+
 	var resp CacheRetrieveResp
 
 	queryParams, err := query.Values(retrieve)
@@ -332,14 +519,21 @@ func (c Client) CacheRetrieve(ctx context.Context, registry string, retrieve Cac
 
 	u.RawQuery = queryParams.Encode()
 
-	slog.Debug("Cache retrieve URL", "url", u.String())
+	// long fallback key chains are sent in the body rather than truncated
+	method, body := http.MethodGet, (*CacheRetrieveReq)(nil)
+	if len(u.String()) > maxRetrieveURLLength {
+		u.RawQuery = ""
+		method, body = http.MethodPost, &retrieve
+	}
+
+	logging.FromContext(ctx).Debug("Cache retrieve URL", "url", u.String(), "method", method)
 
-	res, resp, err := doRequest[CacheRetrieveReq, CacheRetrieveResp](ctx, c.client, http.MethodGet, u.String(), nil)
+	res, resp, err := doRequest[CacheRetrieveReq, CacheRetrieveResp](ctx, c.client, method, u.String(), body)
 	if err != nil {
 		return resp, false, trace.NewError(span, "failed to do request: %w", err)
 	}
 
-	slog.Debug("Cache retrieved with the following parameters",
+	logging.FromContext(ctx).Debug("Cache retrieved with the following parameters",
 		"resp", resp,
 		"status", res.Status,
 		"code", res.StatusCode)
@@ -399,10 +593,10 @@ func doRequest[T any, V any](ctx context.Context, client *http.Client, method st
 		return nil, resp, trace.NewError(span, "failed to read response body: %w", err)
 	}
 
-	slog.Debug("API call", "method", method, "url", url, "status", res.StatusCode, "body", string(respBody))
+	logging.FromContext(ctx).Debug("API call", "method", method, "url", url, "status", res.StatusCode, "body", string(respBody))
 
 	if err = json.Unmarshal(respBody, &resp); err != nil {
-		return nil, resp, trace.NewError(span, "failed to decode response body: %w", err)
+		return res, resp, trace.NewError(span, "failed to decode response body: %w", err)
 	}
 
 	return res, resp, nil
@@ -2,9 +2,15 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -19,6 +25,170 @@ func TestNewClient(t *testing.T) {
 	if client.client == nil {
 		t.Error("Expected client to be initialized")
 	}
+
+	if client.client.Timeout != DefaultTimeout {
+		t.Errorf("Expected timeout %s, got %s", DefaultTimeout, client.client.Timeout)
+	}
+}
+
+func TestNewClient_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// simulate a hung connection
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := NewClient(context.Background(), "1.0.0", server.URL, "test-token",
+		WithTimeout(50*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+	)
+
+	start := time.Now()
+	_, err := client.CacheRegistry(context.Background(), "test-slug")
+	if err == nil {
+		t.Fatal("Expected error for timed out request")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected request to time out promptly, took %s", elapsed)
+	}
+}
+
+func registryHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token test-token" {
+			t.Errorf("Expected Authorization header 'Token test-token', got '%s'", r.Header.Get("Authorization"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CacheRegistryResp{Name: "test-slug"})
+	}
+}
+
+func TestNewClient_WithTransport(t *testing.T) {
+	server := httptest.NewServer(registryHandler(t))
+	defer server.Close()
+
+	var calls atomic.Int64
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	client := NewClient(context.Background(), "1.0.0", server.URL, "test-token", WithTransport(transport))
+
+	resp, err := client.CacheRegistry(context.Background(), "test-slug")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if resp.Name != "test-slug" {
+		t.Errorf("Expected name 'test-slug', got '%s'", resp.Name)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected custom transport to be called once, got %d", calls.Load())
+	}
+}
+
+func TestNewClient_WithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(registryHandler(t))
+	defer server.Close()
+
+	var calls atomic.Int64
+	httpClient := &http.Client{
+		Timeout: time.Hour,
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}
+
+	client := NewClient(context.Background(), "1.0.0", server.URL, "test-token", WithHTTPClient(httpClient))
+
+	if client.client == httpClient {
+		t.Error("Expected the http.Client to be copied")
+	}
+	if client.client.Timeout != DefaultTimeout {
+		t.Errorf("Expected timeout %s, got %s", DefaultTimeout, client.client.Timeout)
+	}
+
+	if _, err := client.CacheRegistry(context.Background(), "test-slug"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected http.Client transport to be called once, got %d", calls.Load())
+	}
+}
+
+func TestNewClient_WithTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(registryHandler(t))
+	defer server.Close()
+
+	// the server's certificate isn't trusted by default
+	client := NewClient(context.Background(), "1.0.0", server.URL, "test-token",
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+	)
+	if _, err := client.CacheRegistry(context.Background(), "test-slug"); err == nil {
+		t.Fatal("Expected error for untrusted certificate")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	client = NewClient(context.Background(), "1.0.0", server.URL, "test-token",
+		WithTLSConfig(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}),
+	)
+	if _, err := client.CacheRegistry(context.Background(), "test-slug"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestNewClient_WithProxy(t *testing.T) {
+	var proxied atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// proxied requests use the absolute URL of the API
+		if r.URL.Host != "api.example.com" {
+			t.Errorf("Expected request for api.example.com, got '%s'", r.URL.Host)
+		}
+		proxied.Add(1)
+		registryHandler(t)(w, r)
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(context.Background(), "1.0.0", "http://api.example.com", "test-token", WithProxy(proxyURL))
+
+	if _, err := client.CacheRegistry(context.Background(), "test-slug"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if proxied.Load() != 1 {
+		t.Errorf("Expected one request through the proxy, got %d", proxied.Load())
+	}
+}
+
+func TestNewClient_WithTLSConfigCustomTransport(t *testing.T) {
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("Expected custom transport not to be called")
+		return nil, errors.New("unexpected request")
+	})
+
+	client := NewClient(context.Background(), "1.0.0", "https://api.example.com", "test-token",
+		WithTransport(transport),
+		WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+	)
+
+	_, err := client.CacheRegistry(context.Background(), "test-slug")
+	if err == nil || !strings.Contains(err.Error(), "require an *http.Transport") {
+		t.Fatalf("Expected transport configuration error, got %v", err)
+	}
 }
 
 func TestCachePeekExists_Success(t *testing.T) {
@@ -246,6 +416,49 @@ func TestCacheRetrieve_NotFound(t *testing.T) {
 	}
 }
 
+func TestCacheRetrieve_LongFallbackKeys(t *testing.T) {
+	fallbackKeys := strings.TrimSuffix(strings.Repeat("v1-fallback-key-with-a-long-suffix,", 100), ",")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST method, got %s", r.Method)
+		}
+
+		if r.URL.RawQuery != "" {
+			t.Errorf("Expected no query string, got '%s'", r.URL.RawQuery)
+		}
+
+		var req CacheRetrieveReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Expected JSON body, got %v", err)
+		}
+
+		if req.Key != "test-key" || req.FallbackKeys != fallbackKeys {
+			t.Errorf("Expected key and fallback keys in body, got %+v", req)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(CacheRetrieveResp{Key: "v1-fallback-key-with-a-long-suffix", Fallback: true})
+	}))
+	defer server.Close()
+
+	client := NewClient(context.Background(), "1.0.0", server.URL, "test-token")
+
+	resp, found, err := client.CacheRetrieve(context.Background(), "test-slug", CacheRetrieveReq{
+		Key:          "test-key",
+		Branch:       "main",
+		FallbackKeys: fallbackKeys,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !found || !resp.Fallback {
+		t.Error("Expected fallback cache to be found")
+	}
+}
+
 func TestCacheCommit_Success(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
@@ -322,6 +535,25 @@ func TestCachePeekExists_CacheRegistryNotFound(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for cache registry not found")
 	}
+	if !errors.Is(err, ErrCacheRegistryNotFound) {
+		t.Errorf("Expected ErrCacheRegistryNotFound, got %v", err)
+	}
+}
+
+func TestCacheRegistry_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(CachePeekResp{Message: CacheRegistryNotFound})
+	}))
+	defer server.Close()
+
+	client := NewClient(context.Background(), "1.0.0", server.URL, "test-token")
+
+	_, err := client.CacheRegistry(context.Background(), "missing")
+	if !errors.Is(err, ErrCacheRegistryNotFound) {
+		t.Errorf("Expected ErrCacheRegistryNotFound, got %v", err)
+	}
 }
 
 func TestDoRequest_NoBody(t *testing.T) {
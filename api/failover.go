@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// DefaultFailoverCooldown is how long an endpoint which failed is skipped in
+// favour of the fallback endpoints, before requests are sent to it again.
+const DefaultFailoverCooldown = 30 * time.Second
+
+// WithFallbackEndpoints sets endpoints requests fail over to when the primary
+// endpoint passed to NewClient is unavailable, e.g. the agent API in another
+// region. Endpoints are tried in order after a request fails with a
+// connection error or a 502, 503 or 504 response once its retries are
+// exhausted, and an endpoint which failed is skipped for the failover
+// cooldown so later requests go straight to a healthy endpoint.
+func WithFallbackEndpoints(endpoints ...string) ClientOption {
+	return func(o *clientOptions) {
+		o.fallbackEndpoints = endpoints
+	}
+}
+
+// WithFailoverCooldown sets how long an endpoint which failed is skipped in
+// favour of the fallback endpoints. Defaults to DefaultFailoverCooldown.
+func WithFailoverCooldown(cooldown time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.failoverCooldown = cooldown
+	}
+}
+
+// failoverTransport sends requests for the primary endpoint to the first
+// healthy endpoint, failing over to the next when an endpoint is unavailable.
+type failoverTransport struct {
+	next      http.RoundTripper
+	endpoints []*endpointHealth
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+// endpointHealth tracks when an endpoint last failed.
+type endpointHealth struct {
+	url string
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+}
+
+func newFailoverTransport(next http.RoundTripper, endpoints []string, cooldown time.Duration) *failoverTransport {
+	t := &failoverTransport{next: next, cooldown: cooldown, now: time.Now}
+	for _, endpoint := range endpoints {
+		t.endpoints = append(t.endpoints, &endpointHealth{url: strings.TrimSuffix(endpoint, "/")})
+	}
+
+	return t
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	// requests are built for the primary endpoint
+	path, ok := strings.CutPrefix(req.URL.String(), t.endpoints[0].url)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	candidates := t.candidates()
+
+	for i, endpoint := range candidates {
+		attempt, err := t.requestFor(req, endpoint, path, i > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := t.next.RoundTrip(attempt)
+
+		if !isEndpointFailure(ctx, res, err) {
+			endpoint.markHealthy()
+			return res, err
+		}
+
+		endpoint.markUnhealthy(t.now().Add(t.cooldown))
+
+		// the request body must be replayable to fail over
+		if i == len(candidates)-1 || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			return res, err
+		}
+
+		next := candidates[i+1].url
+		if err != nil {
+			logging.FromContext(ctx).Warn("API endpoint unavailable, failing over", "endpoint", endpoint.url, "next_endpoint", next, "error", err)
+		} else {
+			logging.FromContext(ctx).Warn("API endpoint unavailable, failing over", "endpoint", endpoint.url, "next_endpoint", next, "status", res.Status)
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+	}
+
+	// unreachable, there is always at least the primary endpoint
+	return nil, errors.New("no API endpoints")
+}
+
+// candidates returns the endpoints in the order they're tried, healthy
+// endpoints in their configured order followed by those still cooling down,
+// so requests are still attempted when every endpoint has recently failed.
+func (t *failoverTransport) candidates() []*endpointHealth {
+	now := t.now()
+
+	var healthy, unhealthy []*endpointHealth
+	for _, endpoint := range t.endpoints {
+		if endpoint.healthy(now) {
+			healthy = append(healthy, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// requestFor returns the request sent to the endpoint, replaying the body if
+// a previous endpoint consumed it.
+func (t *failoverTransport) requestFor(req *http.Request, endpoint *endpointHealth, path string, replay bool) (*http.Request, error) {
+	u, err := url.Parse(endpoint.url + path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url for endpoint %s: %w", endpoint.url, err)
+	}
+
+	attempt := req.Clone(req.Context())
+	attempt.URL = u
+	attempt.Host = ""
+
+	if replay && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to reset request body: %w", err)
+		}
+		attempt.Body = body
+	}
+
+	return attempt, nil
+}
+
+func (e *endpointHealth) healthy(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return !now.Before(e.unhealthyUntil)
+}
+
+func (e *endpointHealth) markHealthy() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.unhealthyUntil = time.Time{}
+}
+
+func (e *endpointHealth) markUnhealthy(until time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.unhealthyUntil = until
+}
+
+// isEndpointFailure reports whether a request failed because the endpoint is
+// unavailable, rather than rejecting the request. Rate limited requests
+// aren't failed over, as the fallback endpoints share the same limits.
+func isEndpointFailure(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newCommitServer returns a server responding to commits with status,
+// counting the requests it receives.
+func newCommitServer(t *testing.T, status int, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		var body CacheCommitReq
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, "upload-id", body.UploadID, "request body should be replayed on failover")
+		require.Equal(t, "/v1/cache_registries/test-slug/commit", r.URL.Path)
+		require.Equal(t, "Token test-token", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(CacheCommitResp{Message: http.StatusText(status)})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestClient_Failover(t *testing.T) {
+	assert := require.New(t)
+
+	var primaryRequests, fallbackRequests atomic.Int32
+	primary := newCommitServer(t, http.StatusServiceUnavailable, &primaryRequests)
+	fallback := newCommitServer(t, http.StatusOK, &fallbackRequests)
+
+	client := NewClient(context.Background(), "1.0.0", primary.URL+"/v1", "test-token",
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithFallbackEndpoints(fallback.URL+"/v1/"),
+	)
+
+	now := time.Now()
+	client.client.Transport.(*failoverTransport).now = func() time.Time { return now }
+
+	resp, err := client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+	assert.NoError(err)
+	assert.Equal("OK", resp.Message)
+	assert.Equal(int32(2), primaryRequests.Load(), "the primary endpoint is retried before failing over")
+	assert.Equal(int32(1), fallbackRequests.Load())
+
+	// the primary endpoint is skipped while it cools down
+	_, err = client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+	assert.NoError(err)
+	assert.Equal(int32(2), primaryRequests.Load())
+	assert.Equal(int32(2), fallbackRequests.Load())
+
+	// and tried again once it has
+	now = now.Add(DefaultFailoverCooldown)
+	_, err = client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+	assert.NoError(err)
+	assert.Equal(int32(4), primaryRequests.Load())
+	assert.Equal(int32(3), fallbackRequests.Load())
+}
+
+func TestClient_FailoverConnectionError(t *testing.T) {
+	assert := require.New(t)
+
+	var fallbackRequests atomic.Int32
+	fallback := newCommitServer(t, http.StatusOK, &fallbackRequests)
+
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+
+	client := NewClient(context.Background(), "1.0.0", unavailable.URL+"/v1", "test-token",
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithFallbackEndpoints(fallback.URL+"/v1"),
+	)
+
+	_, err := client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+	assert.NoError(err)
+	assert.Equal(int32(1), fallbackRequests.Load())
+}
+
+func TestClient_FailoverAllUnavailable(t *testing.T) {
+	assert := require.New(t)
+
+	var primaryRequests, fallbackRequests atomic.Int32
+	primary := newCommitServer(t, http.StatusBadGateway, &primaryRequests)
+	fallback := newCommitServer(t, http.StatusServiceUnavailable, &fallbackRequests)
+
+	client := NewClient(context.Background(), "1.0.0", primary.URL+"/v1", "test-token",
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithFallbackEndpoints(fallback.URL+"/v1"),
+	)
+
+	_, err := client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+	assert.ErrorContains(err, "503")
+
+	// every endpoint is cooling down, so they're still tried in order
+	_, err = client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+	assert.Error(err)
+	assert.Equal(int32(2), primaryRequests.Load())
+	assert.Equal(int32(2), fallbackRequests.Load())
+}
+
+func TestClient_FailoverRequestErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusTooManyRequests} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			assert := require.New(t)
+
+			var primaryRequests, fallbackRequests atomic.Int32
+			primary := newCommitServer(t, status, &primaryRequests)
+			fallback := newCommitServer(t, http.StatusOK, &fallbackRequests)
+
+			client := NewClient(context.Background(), "1.0.0", primary.URL+"/v1", "test-token",
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+				WithFallbackEndpoints(fallback.URL+"/v1"),
+			)
+
+			_, err := client.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-id"})
+			assert.Error(err)
+			assert.Equal(int32(1), primaryRequests.Load())
+			assert.Zero(fallbackRequests.Load(), "requests rejected by the endpoint aren't failed over")
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const (
+	// RecordModeEnv is the environment variable used to enable recording or
+	// replaying of API interactions, when not configured using WithRecorder.
+	RecordModeEnv = "BUILDKITE_ZSTASH_API_RECORD_MODE"
+	// RecordFixturesEnv is the environment variable used to configure the
+	// fixture file API interactions are recorded to or replayed from.
+	RecordFixturesEnv = "BUILDKITE_ZSTASH_API_FIXTURES"
+)
+
+// RecordMode controls whether API interactions are recorded or replayed.
+type RecordMode string
+
+const (
+	// RecordModeOff sends requests to the API without recording them (default).
+	RecordModeOff RecordMode = ""
+	// RecordModeRecord sends requests to the API and records each interaction
+	// to the fixture file.
+	RecordModeRecord RecordMode = "record"
+	// RecordModeReplay replays interactions from the fixture file without
+	// sending any requests to the API.
+	RecordModeReplay RecordMode = "replay"
+)
+
+// ErrNoRecordedInteraction is returned in replay mode when a request doesn't
+// match any unused interaction in the fixture file.
+var ErrNoRecordedInteraction = errors.New("no recorded interaction matches request")
+
+// Interaction is a recorded API request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a recorded API request. Headers, including the
+// Authorization header, are never recorded.
+type RecordedRequest struct {
+	Method string `json:"method"`
+	// URL is the request path and query, excluding the endpoint host so fixtures
+	// can be replayed against any endpoint.
+	URL  string `json:"url"`
+	Body string `json:"body,omitempty"`
+}
+
+// RecordedResponse is a recorded API response.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Fixtures is the contents of a fixture file.
+type Fixtures struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// WithRecorder records API interactions to, or replays them from, the fixture
+// file at path. This is intended for building offline tests against the cache
+// registry API.
+func WithRecorder(mode RecordMode, path string) ClientOption {
+	return func(o *clientOptions) {
+		o.recordMode = mode
+		o.recordPath = path
+	}
+}
+
+// recorderTransport records or replays API interactions.
+type recorderTransport struct {
+	next http.RoundTripper
+	mode RecordMode
+	path string
+
+	mu       sync.Mutex
+	fixtures Fixtures
+	used     []bool
+}
+
+func newRecorderTransport(next http.RoundTripper, mode RecordMode, path string) (*recorderTransport, error) {
+	if path == "" {
+		return nil, fmt.Errorf("fixture path is required to %s API interactions", mode)
+	}
+
+	t := &recorderTransport{next: next, mode: mode, path: path}
+
+	switch mode {
+	case RecordModeRecord:
+		// start from an empty fixture file
+	case RecordModeReplay:
+		data, err := os.ReadFile(path) // #nosec G304 -- fixture path is configured by the user
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixtures: %w", err)
+		}
+
+		if err := json.Unmarshal(data, &t.fixtures); err != nil {
+			return nil, fmt.Errorf("failed to decode fixtures: %w", err)
+		}
+
+		t.used = make([]bool, len(t.fixtures.Interactions))
+	default:
+		return nil, fmt.Errorf("invalid record mode: %q", mode)
+	}
+
+	return t, nil
+}
+
+func (t *recorderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, body, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if t.mode == RecordModeReplay {
+		return t.replay(req, recorded)
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	if err := t.record(Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: res.StatusCode,
+			Header:     recordedHeader(res.Header),
+			Body:       string(resBody),
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// record appends the interaction and rewrites the fixture file, so fixtures
+// are kept even if the process exits without closing the client.
+func (t *recorderTransport) record(interaction Interaction) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.fixtures.Interactions = append(t.fixtures.Interactions, interaction)
+
+	data, err := json.MarshalIndent(t.fixtures, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fixtures: %w", err)
+	}
+
+	if err := os.WriteFile(t.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write fixtures: %w", err)
+	}
+
+	return nil
+}
+
+// replay returns the response of the first unused interaction matching the request.
+func (t *recorderTransport) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, interaction := range t.fixtures.Interactions {
+		if t.used[i] || interaction.Request != recorded {
+			continue
+		}
+
+		t.used[i] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrNoRecordedInteraction, recorded.Method, recorded.URL)
+}
+
+// recordRequest captures the request, returning the consumed body so it can still be sent.
+func recordRequest(req *http.Request) (RecordedRequest, []byte, error) {
+	recorded := RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return recorded, nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return recorded, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	recorded.Body = string(body)
+
+	return recorded, body, nil
+}
+
+// recordedHeader returns the response headers worth keeping in fixtures.
+func recordedHeader(header http.Header) http.Header {
+	recorded := http.Header{}
+	for _, name := range []string{"Content-Type", "Retry-After"} {
+		if value := header.Get(name); value != "" {
+			recorded.Set(name, value)
+		}
+	}
+	return recorded
+}
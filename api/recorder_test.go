@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	assert := require.New(t)
+
+	fixtures := filepath.Join(t.TempDir(), "fixtures.json")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Token test-token", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/cache_registries/test-slug/peek":
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(CachePeekResp{Store: "local_file", Key: r.URL.Query().Get("key")})
+		case "/cache_registries/test-slug/commit":
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(CacheCommitResp{Message: "committed"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	// record against the live server
+	recorder := NewClient(context.Background(), "1.0.0", server.URL, "test-token", WithRecorder(RecordModeRecord, fixtures))
+
+	peekResp, exists, err := recorder.CachePeekExists(context.Background(), "test-slug", CachePeekReq{Key: "v1-key", Branch: "main"})
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal("v1-key", peekResp.Key)
+
+	_, err = recorder.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-1"})
+	assert.NoError(err)
+
+	server.Close()
+
+	data, err := os.ReadFile(fixtures)
+	assert.NoError(err)
+	assert.NotContains(string(data), "test-token", "credentials should never be recorded")
+
+	// replay with the server shut down, using a different endpoint host
+	replayer := NewClient(context.Background(), "1.0.0", "http://replay.invalid", "other-token", WithRecorder(RecordModeReplay, fixtures))
+
+	peekResp, exists, err = replayer.CachePeekExists(context.Background(), "test-slug", CachePeekReq{Key: "v1-key", Branch: "main"})
+	assert.NoError(err)
+	assert.True(exists)
+	assert.Equal("local_file", peekResp.Store)
+
+	commitResp, err := replayer.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-1"})
+	assert.NoError(err)
+	assert.Equal("committed", commitResp.Message)
+
+	// each interaction is only replayed once
+	_, err = replayer.CacheCommit(context.Background(), "test-slug", CacheCommitReq{UploadID: "upload-1"})
+	assert.ErrorIs(err, ErrNoRecordedInteraction)
+}
+
+func TestRecorder_Env(t *testing.T) {
+	t.Setenv(RecordModeEnv, string(RecordModeReplay))
+	t.Setenv(RecordFixturesEnv, filepath.Join(t.TempDir(), "missing.json"))
+
+	client := NewClient(context.Background(), "1.0.0", "http://replay.invalid", "test-token")
+
+	_, err := client.CacheRegistry(context.Background(), "test-slug")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to configure API recorder")
+}
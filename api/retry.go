@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// RetryPolicy controls how requests to the agent API are retried after
+// transient failures, such as connection errors or 429, 502, 503 and 504 responses.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values
+	// less than 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the initial delay between attempts, doubled after each attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts, including delays requested
+	// using the Retry-After header.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used by NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// WithRetryPolicy sets the policy used to retry requests after transient failures.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(o *clientOptions) {
+		o.retry = policy
+	}
+}
+
+// retryTransport retries requests which fail with transient errors, waiting
+// using exponential backoff with full jitter, or the server's Retry-After.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		res, err := t.attempt(req, attempt)
+
+		if attempt >= t.policy.MaxAttempts || !isRetryable(ctx, res, err) {
+			return res, err
+		}
+
+		// the request body must be replayable to retry
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return res, err
+		}
+
+		delay := t.delay(attempt, res)
+
+		if err != nil {
+			logging.FromContext(ctx).Warn("API request failed, retrying", "method", req.Method, "url", req.URL.String(), "attempt", attempt, "delay", delay, "error", err)
+		} else {
+			logging.FromContext(ctx).Warn("API request failed, retrying", "method", req.Method, "url", req.URL.String(), "attempt", attempt, "delay", delay, "status", res.Status)
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to reset request body: %w", err)
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// attempt performs a single attempt of the request within its own span.
+func (t *retryTransport) attempt(req *http.Request, attempt int) (*http.Response, error) {
+	ctx, span := trace.Start(req.Context(), "Client.Attempt")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Int("http.attempt", attempt),
+		attribute.String("http.method", req.Method),
+	)
+
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "request failed")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+
+	return res, nil
+}
+
+// delay returns how long to wait before the next attempt, preferring the
+// server's Retry-After header when present.
+func (t *retryTransport) delay(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			return min(retryAfter, t.policy.MaxDelay)
+		}
+	}
+
+	backoff := t.policy.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > t.policy.MaxDelay {
+		backoff = t.policy.MaxDelay
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	// full jitter
+	return rand.N(backoff) // #nosec G404 -- jitter doesn't need a secure random source
+}
+
+// isRetryable reports whether a request failed with a transient error.
+func isRetryable(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter parses a Retry-After header value, which is either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
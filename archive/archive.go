@@ -25,6 +25,16 @@ type ArchiveInfo struct {
 	WrittenBytes   int64
 	WrittenEntries int64
 	Duration       time.Duration
+
+	// PathStats is a breakdown of the entries for each cache path.
+	PathStats []PathStats
+
+	// Skipped lists files which already existed and were left untouched
+	// during extraction with ConflictSkip.
+	Skipped []string
+	// Overwritten lists files which already existed and were replaced
+	// during extraction with ConflictOverwrite.
+	Overwritten []string
 }
 
 // isUnderHome checks if the given path is under the user's home directory.
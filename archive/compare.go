@@ -0,0 +1,260 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DiffKind describes how a file on disk differs from the archived version.
+type DiffKind string
+
+const (
+	// DiffModified is a file whose type, size or content differs from the archive.
+	DiffModified DiffKind = "modified"
+	// DiffMissing is an archived file which doesn't exist on disk.
+	DiffMissing DiffKind = "missing"
+	// DiffAdded is a file on disk which isn't in the archive.
+	DiffAdded DiffKind = "added"
+)
+
+// Diff is a file which differs between an archive and the disk.
+type Diff struct {
+	// Path is the location of the file on disk.
+	Path string
+	Kind DiffKind
+}
+
+// CompareOptions controls which files on disk are reported as added by
+// CompareFilesWithOptions and CompareManifestWithOptions, and should match
+// the options the archive was built with.
+type CompareOptions struct {
+	// Ignore lists additional patterns excluded from each path, see
+	// BuildOptions.Ignore.
+	Ignore []string
+
+	// NoDefaultIgnore reports paths matching DefaultIgnorePatterns as added.
+	NoDefaultIgnore bool
+}
+
+// CompareFiles compares the archive against the files on disk at the given
+// paths, without writing anything, returning the differences sorted by path.
+//
+// Archived files are decompressed and compared by content. Files ignored by a
+// .zstashignore file or DefaultIgnorePatterns aren't reported as added.
+// Permissions and modification times aren't compared.
+func CompareFiles(ctx context.Context, zipFile *os.File, zipFileLen int64, paths []string) ([]Diff, error) {
+	return CompareFilesWithOptions(ctx, zipFile, zipFileLen, paths, CompareOptions{})
+}
+
+// CompareFilesWithOptions compares the archive against the files on disk at
+// the given paths, applying the supplied options. See CompareFiles for
+// details.
+func CompareFilesWithOptions(ctx context.Context, zipFile *os.File, zipFileLen int64, paths []string, opts CompareOptions) ([]Diff, error) {
+	ctx, span := trace.Start(ctx, "CompareFiles")
+	defer span.End()
+
+	reader, err := newZipReader(zipFile, zipFileLen)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := mapEntries(reader, paths, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Diff
+
+	archived := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		archived[entry.path] = true
+
+		kind, differs, err := compareEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s: %w", entry.path, err)
+		}
+		if differs {
+			diffs = append(diffs, Diff{Path: entry.path, Kind: kind})
+		}
+	}
+
+	added, err := findAdded(ctx, paths, archived, opts)
+	if err != nil {
+		return nil, err
+	}
+	diffs = append(diffs, added...)
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+
+	span.SetAttributes(
+		attribute.Int("entryCount", len(entries)),
+		attribute.Int("diffCount", len(diffs)),
+	)
+
+	return diffs, nil
+}
+
+// compareEntry compares an archive entry with its destination on disk.
+func compareEntry(entry extractEntry) (DiffKind, bool, error) {
+	info, err := os.Lstat(entry.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DiffMissing, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	mode := entry.file.Mode()
+
+	switch {
+	case mode.IsDir():
+		return DiffModified, !info.IsDir(), nil
+	case mode&os.ModeSymlink != 0:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return DiffModified, true, nil
+		}
+
+		target, err := os.Readlink(entry.path)
+		if err != nil {
+			return "", false, err
+		}
+
+		archivedTarget, err := readEntry(entry)
+		if err != nil {
+			return "", false, err
+		}
+
+		return DiffModified, target != string(archivedTarget), nil
+	default:
+		if !info.Mode().IsRegular() || uint64(info.Size()) != entry.file.UncompressedSize64 { // #nosec G115 -- sizes are never negative
+			return DiffModified, true, nil
+		}
+
+		same, err := sameContent(entry)
+		if err != nil {
+			return "", false, err
+		}
+
+		return DiffModified, !same, nil
+	}
+}
+
+// readEntry reads a small archive entry, such as a symlink target, into memory.
+func readEntry(entry extractEntry) ([]byte, error) {
+	r, err := entry.file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	return io.ReadAll(r)
+}
+
+// sameContent reports whether the archived file has the same content as the
+// file on disk.
+func sameContent(entry extractEntry) (bool, error) {
+	r, err := entry.file.Open()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	archivedHash := sha256.New()
+	if _, err := io.Copy(archivedHash, r); err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	diskHash := sha256.New()
+	if _, err := io.Copy(diskHash, f); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(archivedHash.Sum(nil), diskHash.Sum(nil)), nil
+}
+
+// findAdded walks the paths on disk, returning files and directories which
+// aren't archived, skipping those excluded by ignore files and patterns.
+func findAdded(ctx context.Context, paths []string, archived map[string]bool, opts CompareOptions) ([]Diff, error) {
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	rootIgnore, err := loadIgnoreFile(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ignore file: %w", err)
+	}
+
+	var added []Diff
+
+	for _, mapping := range mappings {
+		if _, err := os.Lstat(mapping.ResolvedPath); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		ignore, err := mappingIgnoreMatchers(rootIgnore, mapping.ResolvedPath, ignorePatterns(opts.Ignore, opts.NoDefaultIgnore))
+		if err != nil {
+			return nil, err
+		}
+
+		absPath, err := filepath.Abs(mapping.ResolvedPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
+		err = filepath.WalkDir(absPath, func(filename string, d fs.DirEntry, walkErr error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if walkErr != nil {
+				return walkErr
+			}
+
+			if len(ignore) > 0 && filename != absPath && ignore.ignored(filename, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if d.Type()&irregularModes == 0 && !archived[filename] {
+				added = append(added, Diff{Path: filename, Kind: DiffAdded})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk path: %s with error: %w", mapping.ResolvedPath, err)
+		}
+	}
+
+	return added, nil
+}
@@ -0,0 +1,43 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareFiles(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, goBuildDir := buildTestArchive(t)
+
+	// restore the archived files, which match the archive
+	_, err := ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+
+	diffs, err := CompareFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Empty(diffs)
+
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache DATA"), 0o600))
+	assert.NoError(os.Remove(filepath.Join(goBuildDir, "other.txt")))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "new.txt"), []byte("new"), 0o600))
+	assert.NoError(os.Symlink("cache.txt", filepath.Join(goBuildDir, "link")))
+
+	// ignored files aren't reported
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, IgnoreFile), []byte("*.log\n"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "build.log"), []byte("log"), 0o600))
+
+	diffs, err = CompareFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal([]Diff{
+		{Path: filepath.Join(goBuildDir, IgnoreFile), Kind: DiffAdded},
+		{Path: filepath.Join(goBuildDir, "cache.txt"), Kind: DiffModified},
+		{Path: filepath.Join(goBuildDir, "link"), Kind: DiffAdded},
+		{Path: filepath.Join(goBuildDir, "new.txt"), Kind: DiffAdded},
+		{Path: filepath.Join(goBuildDir, "other.txt"), Kind: DiffMissing},
+	}, diffs)
+}
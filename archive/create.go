@@ -3,46 +3,170 @@ package archive
 import (
 	"context"
 	"fmt"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/buildkite/zstash/internal/logging"
 	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/zip"
 	"github.com/klauspost/compress/zstd"
 	"github.com/wolfeidau/quickzip"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+// BuildOptions controls how BuildArchiveWithOptions archives files.
+type BuildOptions struct {
+	// PreserveMtimes records the modification time of every entry with
+	// nanosecond precision, which is applied on extraction. By default every
+	// entry gets a fixed modification time, so archives of the same files are
+	// identical.
+	PreserveMtimes bool
+
+	// Precompressed stores entries without compression, for content which is
+	// already compressed. By default entries are compressed with zstd.
+	Precompressed bool
+
+	// Deflate compresses entries with deflate rather than zstd, so the
+	// archive can be extracted by any zip reader. Ignored with Precompressed.
+	Deflate bool
+
+	// Manifest records the size, mode and SHA-256 checksum of every entry,
+	// see ReadManifest, so files on disk can be checked against the archive
+	// without reading its contents. Archived files are read twice to
+	// checksum them.
+	Manifest bool
+
+	// Ignore lists additional patterns excluded from each cached path, in
+	// ignore file syntax with anchored patterns relative to the cached path.
+	// Ignore files take precedence, so can re-include matching paths.
+	Ignore []string
+
+	// NoDefaultIgnore archives paths matching DefaultIgnorePatterns, such as
+	// .git directories, which are otherwise excluded.
+	NoDefaultIgnore bool
+
+	// Format is the archive format, FormatZip, FormatTarGz, FormatTarZst or a
+	// format registered with RegisterFormat. Defaults to FormatZip.
+	// Modification times are recorded in tar archives with nanosecond
+	// precision, so PreserveMtimes doesn't add an entry, and Deflate is
+	// ignored. Manifest is only supported with FormatZip.
+	Format string
+
+	// References records other cache entries holding paths left out of the
+	// archive, see ReadReferences. Only supported with FormatZip.
+	References []Reference
+
+	// ExternalZstd compresses tar.zst archives with the zstd binary using all
+	// cores ("zstd -T0"), which is faster than compressing in process on
+	// agents with many cores. Archives are compressed in process if the
+	// binary isn't installed.
+	ExternalZstd bool
+}
+
+// BuildArchive builds a zip archive of the given paths in a temporary file.
+//
+// The build stops when ctx is cancelled, removing the partially written
+// archive and returning the context error.
 func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo, error) {
-	_, span := trace.Start(ctx, "BuildArchive")
+	return BuildArchiveWithOptions(ctx, paths, key, BuildOptions{})
+}
+
+// BuildArchiveWithOptions builds an archive of the given paths in a
+// temporary file, applying the supplied options.
+func BuildArchiveWithOptions(ctx context.Context, paths []string, key string, opts BuildOptions) (_ *ArchiveInfo, err error) {
+	ctx, span := trace.Start(ctx, "BuildArchive")
 	defer span.End()
 
+	span.SetAttributes(
+		attribute.Bool("preserveMtimes", opts.PreserveMtimes),
+		attribute.Bool("precompressed", opts.Precompressed),
+		attribute.Bool("deflate", opts.Deflate),
+		attribute.Bool("manifest", opts.Manifest),
+		attribute.Int("references", len(opts.References)),
+		attribute.Bool("noDefaultIgnore", opts.NoDefaultIgnore),
+		attribute.String("format", opts.Format),
+	)
+
 	start := time.Now()
 
-	modified, err := time.Parse(time.RFC3339, modifiedEpoch)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse modified epoch: %w", err)
+	format := opts.Format
+	if format == "" {
+		format = FormatZip
+	}
+
+	if !validFormat(format) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	if format != FormatZip && opts.Manifest {
+		return nil, fmt.Errorf("manifests aren't supported in %s archives", format)
 	}
 
-	archiveFile, err := os.CreateTemp("", fmt.Sprintf("%s-*.zip", key))
+	if format != FormatZip && len(opts.References) > 0 {
+		return nil, fmt.Errorf("references aren't supported in %s archives", format)
+	}
+
+	method := uint16(zstd.ZipMethodWinZip)
+	switch {
+	case opts.Precompressed:
+		method = zip.Store
+	case opts.Deflate:
+		method = zip.Deflate
+	}
+
+	archiverOpts := []quickzip.ArchiverOption{
+		quickzip.WithArchiverMethod(method),
+		quickzip.WithArchiverBufferSize(bufferSize),
+		quickzip.WithSkipOwnership(skipOwnership),
+	}
+
+	var (
+		mtimes   *mtimesManifest
+		modified time.Time
+	)
+	if opts.PreserveMtimes {
+		// tar headers record modification times with nanosecond precision
+		if format == FormatZip {
+			mtimes = &mtimesManifest{Mtimes: make(map[string]int64)}
+		}
+	} else {
+		modified, err = time.Parse(time.RFC3339, modifiedEpoch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse modified epoch: %w", err)
+		}
+		archiverOpts = append(archiverOpts, quickzip.WithModifiedEpoch(modified))
+	}
+
+	var manifest *Manifest
+	if opts.Manifest {
+		manifest = &Manifest{Files: make(map[string]ManifestFile)}
+	}
+
+	archiveFile, err := os.CreateTemp("", fmt.Sprintf("%s-*.%s", key, format))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create archive file: %w", err)
 	}
 	defer func() {
 		_ = archiveFile.Close()
+		// remove the partial archive if the build fails or is cancelled
+		if err != nil {
+			_ = os.Remove(archiveFile.Name())
+		}
 	}()
 
 	checksummer := NewChecksumSHA256(archiveFile)
 
 	// wrap the file in an io.Writer which records the sha256sum of the file
-	arc, err := quickzip.NewArchiver(
-		checksummer,
-		quickzip.WithArchiverMethod(zstd.ZipMethodWinZip),
-		quickzip.WithArchiverBufferSize(bufferSize),
-		quickzip.WithModifiedEpoch(modified),
-		quickzip.WithSkipOwnership(skipOwnership),
-	)
+	var arc Archiver
+	switch format {
+	case FormatZip:
+		arc, err = quickzip.NewArchiver(checksummer, archiverOpts...)
+	case FormatTarGz, FormatTarZst:
+		arc, err = newTarArchiver(ctx, checksummer, format, modified, opts.Precompressed, opts.ExternalZstd)
+	default:
+		arc, err = registeredFormat(format).Build(checksummer, opts)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create archiver: %w", err)
 	}
@@ -52,11 +176,23 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 		return nil, fmt.Errorf("failed to get mappings: %w", err)
 	}
 
+	// the ignore file in the working directory applies to all paths
+	rootIgnore, err := loadIgnoreFile(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ignore file: %w", err)
+	}
+
+	stats := newPathStatsCollector()
+
 	for _, mapping := range mappings {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		_, err := os.Stat(mapping.ResolvedPath)
 		if err != nil {
 			if os.IsNotExist(err) {
-				slog.Warn("file does not exist", "path", mapping.ResolvedPath)
+				logging.FromContext(ctx).Warn("file does not exist", "path", mapping.ResolvedPath)
 				continue
 			}
 			return nil, fmt.Errorf("failed to stat file: %w", err)
@@ -67,8 +203,37 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 			return nil, fmt.Errorf("failed directory (%s) outside home directory: %w", mapping.ResolvedPath, err)
 		}
 
+		ignore, err := mappingIgnoreMatchers(rootIgnore, mapping.ResolvedPath, ignorePatterns(opts.Ignore, opts.NoDefaultIgnore))
+		if err != nil {
+			return nil, err
+		}
+
+		absPath, err := filepath.Abs(mapping.ResolvedPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
 		files := make(map[string]os.FileInfo)
 		err = filepath.Walk(mapping.ResolvedPath, func(filename string, fi os.FileInfo, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if len(ignore) > 0 && fi != nil && filename != mapping.ResolvedPath {
+				rel, err := filepath.Rel(mapping.ResolvedPath, filename)
+				if err != nil {
+					return err
+				}
+
+				if ignore.ignored(filepath.Join(absPath, rel), fi.IsDir()) {
+					logging.FromContext(ctx).Debug("ignoring path", "path", filename)
+					if fi.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+
 			files[filename] = fi
 			return nil
 		})
@@ -76,9 +241,27 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 			return nil, fmt.Errorf("failed to walk path: %s with error: %w", mapping.ResolvedPath, err)
 		}
 
-		slog.Debug("chroot", "chroot", mapping.Chroot, "path", mapping.ResolvedPath)
+		for filename, fi := range files {
+			if fi != nil {
+				stats.add(mapping.Path, filename, fi.Size(), fi.Mode().IsRegular())
+			}
+		}
+
+		if mtimes != nil {
+			if err := mtimes.addMtimes(mapping.Chroot, files); err != nil {
+				return nil, err
+			}
+		}
+
+		if manifest != nil {
+			if err := manifest.addFiles(ctx, mapping.Chroot, files); err != nil {
+				return nil, err
+			}
+		}
+
+		logging.FromContext(ctx).Debug("chroot", "chroot", mapping.Chroot, "path", mapping.ResolvedPath)
 
-		err = arc.Archive(context.Background(), mapping.Chroot, files)
+		err = arc.Archive(ctx, mapping.Chroot, files)
 		if err != nil {
 			return nil, fmt.Errorf("failed to archive path: %s with error: %w", mapping.ResolvedPath, err)
 		}
@@ -86,6 +269,48 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 
 	writtenBytes, writtenEntries := arc.Written()
 
+	if mtimes != nil {
+		dir, files, err := mtimes.writeFile()
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+
+		if err := arc.Archive(ctx, dir, files); err != nil {
+			return nil, fmt.Errorf("failed to archive mtimes: %w", err)
+		}
+	}
+
+	if manifest != nil {
+		dir, files, err := manifest.writeFile()
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+
+		if err := arc.Archive(ctx, dir, files); err != nil {
+			return nil, fmt.Errorf("failed to archive manifest: %w", err)
+		}
+	}
+
+	if len(opts.References) > 0 {
+		dir, files, err := writeMetadataFile(referencesEntryName, referencesEntry{References: opts.References})
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+
+		if err := arc.Archive(ctx, dir, files); err != nil {
+			return nil, fmt.Errorf("failed to archive references: %w", err)
+		}
+	}
+
 	err = arc.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to close archive: %w", err)
@@ -108,5 +333,41 @@ func BuildArchive(ctx context.Context, paths []string, key string) (*ArchiveInfo
 		WrittenBytes:   writtenBytes,
 		WrittenEntries: writtenEntries,
 		Duration:       time.Since(start),
+		PathStats:      stats.result(),
 	}, nil
 }
+
+// mappingIgnoreMatchers returns the ignore rules which apply to a cached path,
+// combining the patterns excluded from every cached path with the working
+// directory ignore file and one in the path itself, in increasing precedence.
+func mappingIgnoreMatchers(rootIgnore *ignoreMatcher, resolvedPath string, patterns []string) (ignoreMatchers, error) {
+	var matchers ignoreMatchers
+
+	patternIgnore, err := newPatternMatcher(resolvedPath, patterns)
+	if err != nil {
+		return nil, err
+	}
+	if patternIgnore != nil {
+		matchers = append(matchers, patternIgnore)
+	}
+
+	if rootIgnore != nil {
+		matchers = append(matchers, rootIgnore)
+	}
+
+	fi, err := os.Stat(resolvedPath)
+	if err != nil || !fi.IsDir() {
+		return matchers, nil
+	}
+
+	pathIgnore, err := loadIgnoreFile(resolvedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ignore file: %w", err)
+	}
+
+	if pathIgnore != nil && (rootIgnore == nil || pathIgnore.base != rootIgnore.base) {
+		matchers = append(matchers, pathIgnore)
+	}
+
+	return matchers, nil
+}
@@ -1,12 +1,18 @@
 package archive
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
 )
 
@@ -192,3 +198,330 @@ func TestExtractArchive_MissingPathInArchive(t *testing.T) {
 	_, err = os.Stat(goModDir)
 	assert.True(os.IsNotExist(err), "go/pkg/mod should not exist since it wasn't in the archive")
 }
+
+func TestBuildArchive_IgnoreFile(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	workDir := filepath.Join(home, "project")
+	assert.NoError(os.MkdirAll(filepath.Join(workDir, "node_modules", "pkg", "test"), 0o755))
+	t.Chdir(workDir)
+
+	// shared ignore file in the working directory
+	assert.NoError(os.WriteFile(IgnoreFile, []byte("*.log\n"), 0o600))
+	// ignore file inside the cached path
+	assert.NoError(os.WriteFile(filepath.Join("node_modules", IgnoreFile), []byte("test/\n"), 0o600))
+
+	assert.NoError(os.WriteFile(filepath.Join("node_modules", "pkg", "index.js"), []byte("index"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join("node_modules", "pkg", "debug.log"), []byte("log"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join("node_modules", "pkg", "test", "index.test.js"), []byte("test"), 0o600))
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"node_modules"}, "ignore")
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Contains(entries, "node_modules/pkg/index.js")
+	assert.NotContains(entries, "node_modules/pkg/debug.log")
+	for _, entry := range entries {
+		assert.NotContains(entry, "node_modules/pkg/test")
+	}
+}
+
+// cancelAfterContext is cancelled once Err has been called more than limit
+// times, cancelling deterministically part way through an operation.
+type cancelAfterContext struct {
+	context.Context
+	cancel context.CancelFunc
+	calls  atomic.Int64
+	limit  int64
+}
+
+func newCancelAfterContext(t *testing.T, limit int64) *cancelAfterContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &cancelAfterContext{Context: ctx, cancel: cancel, limit: limit}
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.calls.Add(1) > c.limit {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+func TestBuildArchive_Cancelled(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+	for i := range 20 {
+		assert.NoError(os.WriteFile(filepath.Join(goBuildDir, fmt.Sprintf("file-%d.txt", i)), []byte("build cache data"), 0o600))
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{
+			name: "cancelled before starting",
+			ctx:  newCancelAfterContext(t, 0),
+		},
+		{
+			name: "cancelled while walking",
+			ctx:  newCancelAfterContext(t, 5),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archiveInfo, err := BuildArchive(tt.ctx, []string{"~/.go-build"}, "go-cache")
+			require.ErrorIs(t, err, context.Canceled)
+			require.Nil(t, archiveInfo)
+
+			entries, err := os.ReadDir(tmpDir)
+			require.NoError(t, err)
+			require.Empty(t, entries, "partial archive should be removed")
+		})
+	}
+}
+
+func TestBuildArchiveWithOptions_PreserveMtimes(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	require.NoError(t, err)
+
+	epoch, err := time.Parse(time.RFC3339, modifiedEpoch)
+	require.NoError(t, err)
+
+	mtime := time.Date(2025, 6, 1, 12, 30, 45, 123456789, time.UTC)
+
+	tests := []struct {
+		name      string
+		opts      BuildOptions
+		wantMtime time.Time
+	}{
+		{name: "fixed by default", opts: BuildOptions{}, wantMtime: epoch},
+		{name: "preserved", opts: BuildOptions{PreserveMtimes: true}, wantMtime: mtime},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+
+			goBuildDir := filepath.Join(home, ".go-build")
+			subDir := filepath.Join(goBuildDir, "00")
+			filePath := filepath.Join(subDir, "cache.txt")
+			assert.NoError(os.MkdirAll(subDir, 0o755))
+			assert.NoError(os.WriteFile(filePath, []byte("build cache data"), 0o600))
+			assert.NoError(os.Chtimes(filePath, mtime, mtime))
+			assert.NoError(os.Chtimes(subDir, mtime, mtime))
+
+			archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"~/.go-build"}, "go-cache", tt.opts)
+			assert.NoError(err)
+			defer os.Remove(archiveInfo.ArchivePath)
+			assert.Equal(int64(3), archiveInfo.WrittenEntries)
+
+			assert.NoError(os.RemoveAll(goBuildDir))
+
+			zipFile, err := os.Open(archiveInfo.ArchivePath)
+			assert.NoError(err)
+			defer zipFile.Close()
+
+			entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+			assert.NoError(err)
+			assert.Equal([]string{".go-build/", ".go-build/00/", ".go-build/00/cache.txt"}, entries)
+
+			_, err = ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+			assert.NoError(err)
+
+			for _, path := range []string{filePath, subDir} {
+				info, err := os.Stat(path)
+				assert.NoError(err)
+				assert.True(tt.wantMtime.Equal(info.ModTime()), "%s modified at %s, want %s", path, info.ModTime(), tt.wantMtime)
+			}
+		})
+	}
+}
+
+func TestBuildArchiveWithOptions_Compression(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		opts       BuildOptions
+		wantMethod uint16
+	}{
+		{name: "zstd by default", opts: BuildOptions{}, wantMethod: zstd.ZipMethodWinZip},
+		{name: "stored", opts: BuildOptions{Precompressed: true}, wantMethod: zip.Store},
+		{name: "deflate", opts: BuildOptions{Deflate: true}, wantMethod: zip.Deflate},
+		{name: "stored overrides deflate", opts: BuildOptions{Precompressed: true, Deflate: true}, wantMethod: zip.Store},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+
+			// compressible content, which would be compressed by default
+			content := bytes.Repeat([]byte("gradle cache data "), 1024)
+
+			gradleDir := filepath.Join(home, ".gradle")
+			filePath := filepath.Join(gradleDir, "cache.jar")
+			assert.NoError(os.MkdirAll(gradleDir, 0o755))
+			assert.NoError(os.WriteFile(filePath, content, 0o600))
+
+			archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"~/.gradle"}, "gradle", tt.opts)
+			assert.NoError(err)
+			defer os.Remove(archiveInfo.ArchivePath)
+
+			assert.NoError(os.RemoveAll(gradleDir))
+
+			zipFile, err := os.Open(archiveInfo.ArchivePath)
+			assert.NoError(err)
+			defer zipFile.Close()
+
+			reader, err := zip.NewReader(zipFile, archiveInfo.Size)
+			assert.NoError(err)
+
+			var found bool
+			for _, f := range reader.File {
+				if f.Name == ".gradle/cache.jar" {
+					found = true
+					assert.Equal(tt.wantMethod, f.Method)
+				}
+			}
+			assert.True(found, "archive should contain the file")
+
+			_, err = ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.gradle"})
+			assert.NoError(err)
+
+			got, err := os.ReadFile(filePath)
+			assert.NoError(err)
+			assert.Equal(content, got)
+		})
+	}
+}
+
+func TestBuildArchiveWithOptions_Ignore(t *testing.T) {
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		opts        BuildOptions
+		wantEntries []string
+		skipEntries []string
+	}{
+		{
+			name:        "default patterns",
+			opts:        BuildOptions{},
+			wantEntries: []string{"vendor/pkg/main.go", "vendor/pkg/build.tmp"},
+			skipEntries: []string{"vendor/.git/", "vendor/.git/config", "vendor/pkg/.DS_Store"},
+		},
+		{
+			name:        "additional patterns",
+			opts:        BuildOptions{Ignore: []string{"*.tmp"}},
+			wantEntries: []string{"vendor/pkg/main.go"},
+			skipEntries: []string{"vendor/.git/config", "vendor/pkg/.DS_Store", "vendor/pkg/build.tmp"},
+		},
+		{
+			name:        "defaults disabled",
+			opts:        BuildOptions{NoDefaultIgnore: true},
+			wantEntries: []string{"vendor/pkg/main.go", "vendor/.git/config", "vendor/pkg/.DS_Store"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Chdir(home)
+
+			assert.NoError(os.MkdirAll(filepath.Join("vendor", ".git"), 0o755))
+			assert.NoError(os.MkdirAll(filepath.Join("vendor", "pkg"), 0o755))
+			assert.NoError(os.WriteFile(filepath.Join("vendor", ".git", "config"), []byte("config"), 0o600))
+			assert.NoError(os.WriteFile(filepath.Join("vendor", "pkg", ".DS_Store"), []byte("junk"), 0o600))
+			assert.NoError(os.WriteFile(filepath.Join("vendor", "pkg", "main.go"), []byte("package pkg"), 0o600))
+			assert.NoError(os.WriteFile(filepath.Join("vendor", "pkg", "build.tmp"), []byte("tmp"), 0o600))
+
+			archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"vendor"}, "ignore", tt.opts)
+			assert.NoError(err)
+			defer os.Remove(archiveInfo.ArchivePath)
+
+			zipFile, err := os.Open(archiveInfo.ArchivePath)
+			assert.NoError(err)
+			defer zipFile.Close()
+
+			entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+			assert.NoError(err)
+			for _, entry := range tt.wantEntries {
+				assert.Contains(entries, entry)
+			}
+			for _, entry := range tt.skipEntries {
+				assert.NotContains(entries, entry)
+			}
+
+			// entries which were ignored aren't reported as added
+			diffs, err := CompareFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"vendor"}, CompareOptions{
+				Ignore:          tt.opts.Ignore,
+				NoDefaultIgnore: tt.opts.NoDefaultIgnore,
+			})
+			assert.NoError(err)
+			assert.Empty(diffs)
+		})
+	}
+}
+
+func TestBuildArchive_IgnoreFileNegatesDefault(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(home)
+
+	assert.NoError(os.MkdirAll(filepath.Join("vendor", ".git"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join("vendor", ".git", "config"), []byte("config"), 0o600))
+	assert.NoError(os.WriteFile(IgnoreFile, []byte("!.git\n"), 0o600))
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"vendor"}, "ignore")
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Contains(entries, "vendor/.git/config")
+}
@@ -2,23 +2,127 @@ package archive
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log/slog"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/buildkite/zstash/internal/logging"
 	"github.com/buildkite/zstash/internal/trace"
 	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/wolfeidau/quickzip"
 	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 )
 
-func ListArchive(ctx context.Context, zipFile *os.File, zipFileLen int64) ([]string, error) {
+// irregularModes are file modes which are never extracted from an archive.
+const irregularModes = os.ModeSocket | os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe
+
+// ErrExtractConflict is returned when extraction with ConflictFail would
+// overwrite files which already exist on disk.
+var ErrExtractConflict = errors.New("archive entries conflict with existing files")
+
+// ErrTruncatedEntry is returned when an extracted file is shorter or longer
+// than the size recorded in the archive.
+var ErrTruncatedEntry = errors.New("extracted file size doesn't match archive")
+
+// ConflictPolicy controls what happens when an archive entry would be extracted
+// over a file which already exists on disk.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces existing files with the archived version (default).
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip leaves existing files untouched and skips the archived version.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictFail aborts extraction, before any files are written, if any
+	// archived file already exists on disk.
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// IsValid reports whether p is a supported conflict policy. The empty policy
+// is valid and treated as ConflictOverwrite.
+func (p ConflictPolicy) IsValid() bool {
+	switch p {
+	case "", ConflictOverwrite, ConflictSkip, ConflictFail:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExtractOptions controls how ExtractFilesWithOptions writes archive entries to disk.
+type ExtractOptions struct {
+	// OnConflict is the policy applied to archived files which already exist
+	// on disk. Defaults to ConflictOverwrite.
+	OnConflict ConflictPolicy
+
+	// Include restricts extraction to the entries belonging to these paths,
+	// which must be a subset of the paths passed to ExtractFilesWithOptions.
+	// If empty, entries for all paths are extracted.
+	Include []string
+
+	// Files restricts extraction to the entries at or beneath these files and
+	// directories, which must be beneath the paths passed to
+	// ExtractFilesWithOptions, e.g. "~/.npm/_cacache/index-v5" for a path of
+	// "~/.npm". Their parent directories are created as needed. If empty,
+	// every entry of the included paths is extracted.
+	Files []string
+
+	// Chown changes the owner of every extracted file, directory and symlink.
+	// If nil, extracted entries are owned by the current user.
+	Chown *Ownership
+
+	// Permissions overrides the archived permissions of extracted files and
+	// directories. The zero value applies the archived permissions.
+	Permissions Permissions
+
+	// Root extracts entries beneath this directory instead of into place, see
+	// StagedPath for where each path's entries are written. If empty, entries
+	// are extracted to the paths.
+	Root string
+
+	// ExternalZstd decompresses tar.zst archives with the zstd binary, which
+	// is faster than decompressing in process, if it is installed.
+	ExternalZstd bool
+}
+
+// extractEntry is an archive entry paired with its destination on disk.
+type extractEntry struct {
+	file     *zip.File
+	path     string
+	source   string    // the cache path the entry was mapped from
+	modified time.Time // the modification time applied after extraction
+}
+
+// ListArchive returns the names of the entries in the archive. Only the zip
+// central directory is read, so zipFile may read the archive from a blob
+// store, see store.RangeReader. tar archives are read in full, and
+// archives in a registered format are listed by the format.
+func ListArchive(ctx context.Context, zipFile io.ReaderAt, zipFileLen int64) ([]string, error) {
 	_, span := trace.Start(ctx, "ListArchive")
 	defer span.End()
 
+	format, err := DetectFormat(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	if isTarFormat(format) {
+		return listTar(ctx, zipFile, zipFileLen)
+	}
+	if custom := registeredFormat(format); custom != nil {
+		return custom.List(ctx, zipFile, zipFileLen)
+	}
+
 	reader, err := zip.NewReader(zipFile, zipFileLen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open zip reader: %w", err)
@@ -26,6 +130,9 @@ func ListArchive(ctx context.Context, zipFile *os.File, zipFileLen int64) ([]str
 
 	entries := make([]string, 0, len(reader.File))
 	for _, f := range reader.File {
+		if isMetadataEntry(f.Name) {
+			continue
+		}
 		entries = append(entries, f.Name)
 	}
 
@@ -36,57 +143,672 @@ func ListArchive(ctx context.Context, zipFile *os.File, zipFileLen int64) ([]str
 	return entries, nil
 }
 
+// ListConflicts returns the destination paths of archived files and symlinks
+// which already exist on disk and would be affected by extracting the archive
+// to the given paths. Only opts.Include is used to select the entries checked.
+func ListConflicts(ctx context.Context, zipFile *os.File, zipFileLen int64, paths []string, opts ExtractOptions) ([]string, error) {
+	_, span := trace.Start(ctx, "ListConflicts")
+	defer span.End()
+
+	format, err := DetectFormat(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	if isTarFormat(format) {
+		return listTarConflicts(ctx, zipFile, zipFileLen, paths, opts)
+	}
+	if custom := registeredFormat(format); custom != nil {
+		return listFormatConflicts(ctx, custom, zipFile, zipFileLen, paths, opts)
+	}
+
+	reader, err := newZipReader(zipFile, zipFileLen)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := mapEntries(reader, paths, opts.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opts.Include) > 0 {
+		entries, err = includeEntries(entries, paths, opts.Include)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err = fileEntries(entries, paths, opts.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts, err := findConflicts(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("conflictCount", len(conflicts)),
+	)
+
+	return conflicts, nil
+}
+
 func ExtractFiles(ctx context.Context, zipFile *os.File, zipFileLen int64, paths []string) (*ArchiveInfo, error) {
-	_, span := trace.Start(ctx, "ExtractFiles")
+	return ExtractFilesWithOptions(ctx, zipFile, zipFileLen, paths, ExtractOptions{})
+}
+
+// ExtractFilesWithOptions extracts the zip, tar.gz, tar.zst or registered format
+// archive to the given paths, applying the supplied options. Files skipped or overwritten due to conflicts with existing
+// files are logged and reported in the returned ArchiveInfo.
+//
+// Extraction stops when ctx is cancelled, removing any partially written file
+// and returning an error wrapping the context error. Files which were already
+// fully extracted are left in place.
+func ExtractFilesWithOptions(ctx context.Context, zipFile *os.File, zipFileLen int64, paths []string, opts ExtractOptions) (*ArchiveInfo, error) {
+	ctx, span := trace.Start(ctx, "ExtractFiles")
 	defer span.End()
 
+	if !opts.OnConflict.IsValid() {
+		return nil, fmt.Errorf("invalid conflict policy: %q", opts.OnConflict)
+	}
+
+	onConflict := opts.OnConflict
+	if onConflict == "" {
+		onConflict = ConflictOverwrite
+	}
+
+	format, err := DetectFormat(zipFile)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("format", format))
+
+	if isTarFormat(format) {
+		return extractTar(ctx, zipFile, zipFileLen, paths, opts, onConflict)
+	}
+	if custom := registeredFormat(format); custom != nil {
+		opts.OnConflict = onConflict
+		return custom.Extract(ctx, zipFile, zipFileLen, paths, opts)
+	}
+
+	return extractZip(ctx, zipFile, zipFile.Name(), zipFileLen, paths, opts, onConflict)
+}
+
+// ExtractReader extracts a zip archive read from r to the given paths, see
+// ExtractFilesWithOptions. Only the central directory and the entries
+// extracted are read, so r may read the archive from a blob store without
+// downloading it, see store.RangeReader, which is worthwhile when extracting a
+// few files with ExtractOptions.Files. r must be safe for concurrent use, as
+// files are extracted concurrently. Archives in other formats return
+// ErrUnsupportedFormat, as they can't be read in part.
+func ExtractReader(ctx context.Context, r io.ReaderAt, size int64, paths []string, opts ExtractOptions) (*ArchiveInfo, error) {
+	ctx, span := trace.Start(ctx, "ExtractReader")
+	defer span.End()
+
+	if !opts.OnConflict.IsValid() {
+		return nil, fmt.Errorf("invalid conflict policy: %q", opts.OnConflict)
+	}
+
+	onConflict := opts.OnConflict
+	if onConflict == "" {
+		onConflict = ConflictOverwrite
+	}
+
+	return extractZip(ctx, r, "", size, paths, opts, onConflict)
+}
+
+// extractZip extracts the zip archive read from r, named name, see
+// ExtractFilesWithOptions.
+func extractZip(ctx context.Context, r io.ReaderAt, name string, size int64, paths []string, opts ExtractOptions, onConflict ConflictPolicy) (*ArchiveInfo, error) {
+	span := oteltrace.SpanFromContext(ctx)
+
 	start := time.Now()
 
-	extract, err := quickzip.NewExtractorFromReader(zipFile, zipFileLen)
+	reader, err := newZipReader(r, size)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create extractor: %w", err)
+		return nil, err
 	}
 
-	mappings, err := PathsToMappings(paths)
+	entries, foundPaths, err := mapEntries(reader, paths, opts.Root)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create mappings: %w", err)
+		return nil, err
 	}
 
-	foundPaths := make(map[string]bool)
+	if len(opts.Include) > 0 {
+		entries, err = includeEntries(entries, paths, opts.Include)
+		if err != nil {
+			return nil, err
+		}
+		paths = opts.Include
+	}
 
-	err = extract.ExtractWithPathMapper(ctx, func(file *zip.File) (string, error) {
-		for _, mapping := range mappings {
-			if strings.HasPrefix(file.Name, mapping.RelativePath) {
-				foundPaths[mapping.Path] = true
-				return filepath.Join(mapping.Chroot, file.Name), nil
+	entries, err = fileEntries(entries, paths, opts.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		if !foundPaths[path] {
+			logging.FromContext(ctx).Warn("requested path not found in archive", "path", path)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	conflicts, err := findConflicts(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicting := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		conflicting[conflict] = true
+	}
+
+	var skipped, overwritten []string
+
+	switch onConflict {
+	case ConflictFail:
+		if len(conflicts) > 0 {
+			for _, conflict := range conflicts {
+				logging.FromContext(ctx).Error("extract conflict", "path", conflict, "policy", onConflict)
 			}
+			return nil, fmt.Errorf("%w: %d existing files, including %s", ErrExtractConflict, len(conflicts), conflicts[0])
 		}
+	case ConflictSkip:
+		for _, conflict := range conflicts {
+			logging.FromContext(ctx).Info("extract conflict", "path", conflict, "policy", onConflict, "action", "skipped")
+		}
+		skipped = conflicts
+	case ConflictOverwrite:
+		for _, conflict := range conflicts {
+			logging.FromContext(ctx).Debug("extract conflict", "path", conflict, "policy", onConflict, "action", "overwritten")
+		}
+		overwritten = conflicts
+	}
+
+	if len(conflicts) > 0 {
+		logging.FromContext(ctx).Info("extract conflicts resolved", "policy", onConflict, "skipped", len(skipped), "overwritten", len(overwritten))
+	}
+
+	x := &extractor{chown: opts.Chown, perms: opts.Permissions}
 
-		return "", fmt.Errorf("failed to find path mapping for: %s", file.Name)
+	err = x.extract(ctx, entries, func(entry extractEntry) bool {
+		return onConflict == ConflictSkip && conflicting[entry.path]
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract zip file: %w", err)
 	}
 
-	for _, path := range paths {
-		if !foundPaths[path] {
-			slog.Warn("requested path not found in archive", "path", path)
+	bytesExtracted, countExtracted := x.written.Load(), x.entries.Load()
+
+	stats := newPathStatsCollector()
+	for _, entry := range entries {
+		if onConflict == ConflictSkip && conflicting[entry.path] {
+			continue
 		}
+		stats.add(entry.source, entry.path, int64(entry.file.UncompressedSize64), entry.file.Mode().IsRegular()) // #nosec G115 -- sizes fit in int64
 	}
 
-	bytesExtracted, countExtracted := extract.Written()
-
 	span.SetAttributes(
-		attribute.Int64("zipFileLen", zipFileLen),
+		attribute.Int64("zipFileLen", size),
 		attribute.Int64("fileExtracted", countExtracted),
 		attribute.Int64("bytesExtracted", bytesExtracted),
+		attribute.Int("filesSkipped", len(skipped)),
+		attribute.Int("filesOverwritten", len(overwritten)),
 	)
 
 	return &ArchiveInfo{
-		ArchivePath:    zipFile.Name(),
-		Size:           zipFileLen,
+		ArchivePath:    name,
+		Size:           size,
 		WrittenBytes:   bytesExtracted,
 		WrittenEntries: countExtracted,
 		Duration:       time.Since(start),
+		Skipped:        skipped,
+		Overwritten:    overwritten,
+		PathStats:      stats.result(),
 	}, nil
 }
+
+// newZipReader opens a zip reader with the decompressors used by BuildArchive
+// registered, returning ErrUnsupportedFormat for archives in other formats.
+func newZipReader(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	format, err := DetectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatZip {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create extractor: %w", err)
+	}
+
+	reader.RegisterDecompressor(zip.Deflate, quickzip.FlateDecompressor())
+	reader.RegisterDecompressor(zstd.ZipMethodWinZip, quickzip.ZstdDecompressor())
+
+	return reader, nil
+}
+
+// mapEntries resolves the destination of every supported archive entry using
+// the mappings for the given paths, or beneath root if it isn't empty,
+// returning which of the paths were found. Modification times recorded with
+// PreserveMtimes replace those in the zip headers.
+func mapEntries(reader *zip.Reader, paths []string, root string) ([]extractEntry, map[string]bool, error) {
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	foundPaths := make(map[string]bool)
+	entries := make([]extractEntry, 0, len(reader.File))
+
+	mtimes, err := readMtimes(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, file := range reader.File {
+		if file.Mode()&irregularModes != 0 || isMetadataEntry(file.Name) {
+			continue
+		}
+
+		mapping, ok := findMapping(mappings, file.Name)
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to find path mapping for: %s", file.Name)
+		}
+
+		foundPaths[mapping.Path] = true
+
+		chroot := mapping.Chroot
+		if root != "" {
+			chroot = stagedChroot(root, mapping)
+		}
+
+		path, err := destinationPath(chroot, file.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		modified, ok := mtimes[file.Name]
+		if !ok {
+			modified = file.Modified
+		}
+
+		entries = append(entries, extractEntry{file: file, path: path, source: mapping.Path, modified: modified})
+	}
+
+	return entries, foundPaths, nil
+}
+
+// includeEntries filters entries to those mapped from the included paths,
+// returning an error if an included path isn't one of the available paths.
+func includeEntries(entries []extractEntry, paths []string, include []string) ([]extractEntry, error) {
+	included := make(map[string]bool, len(include))
+	for _, path := range include {
+		if !slices.Contains(paths, path) {
+			return nil, fmt.Errorf("included path %q is not one of the archive paths", path)
+		}
+		included[path] = true
+	}
+
+	filtered := make([]extractEntry, 0, len(entries))
+	for _, entry := range entries {
+		if included[entry.source] {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered, nil
+}
+
+// fileEntries returns the entries at or beneath the files, see
+// ExtractOptions.Files, or all of the entries if there are none.
+func fileEntries(entries []extractEntry, paths []string, files []string) ([]extractEntry, error) {
+	filter, err := newFileFilter(paths, files)
+	if err != nil || filter == nil {
+		return entries, err
+	}
+
+	filtered := make([]extractEntry, 0, len(entries))
+	for _, entry := range entries {
+		if filter.match(entry.file.Name) {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered, nil
+}
+
+// fileFilter matches the names of the entries at or beneath a set of files,
+// see ExtractOptions.Files. A nil filter matches every entry.
+type fileFilter []string
+
+// newFileFilter returns a filter matching the entries at or beneath the files,
+// returning an error if a file isn't beneath one of the paths, or nil if there
+// are no files.
+func newFileFilter(paths, files []string) (fileFilter, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	pathMappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	fileMappings, err := PathsToMappings(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	filter := make(fileFilter, 0, len(fileMappings))
+	for _, mapping := range fileMappings {
+		name := filepath.ToSlash(filepath.Clean(mapping.RelativePath))
+
+		beneath := slices.ContainsFunc(pathMappings, func(path Mapping) bool {
+			return path.Relative == mapping.Relative && isWithin(name, filepath.ToSlash(filepath.Clean(path.RelativePath)))
+		})
+		if !beneath {
+			return nil, fmt.Errorf("file %q is not beneath any of the archive paths", mapping.Path)
+		}
+
+		filter = append(filter, name)
+	}
+
+	return filter, nil
+}
+
+// match reports whether the entry name is at or beneath one of the files.
+func (f fileFilter) match(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	name = strings.TrimSuffix(name, "/")
+
+	return slices.ContainsFunc(f, func(file string) bool {
+		return isWithin(name, file)
+	})
+}
+
+// isWithin reports whether the slash separated name is dir or beneath it.
+func isWithin(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// findMapping returns the mapping which contains the archive entry name.
+func findMapping(mappings []Mapping, name string) (Mapping, bool) {
+	for _, mapping := range mappings {
+		if strings.HasPrefix(name, mapping.RelativePath) {
+			return mapping, true
+		}
+	}
+
+	return Mapping{}, false
+}
+
+// destinationPath joins an archive entry name to its chroot, refusing entries
+// which would be written outside of the chroot.
+func destinationPath(chroot, name string) (string, error) {
+	path := filepath.Join(chroot, name)
+
+	rel, err := filepath.Rel(chroot, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get relative path for %s: %w", name, err)
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s cannot be extracted outside of chroot (%s)", name, chroot)
+	}
+
+	return path, nil
+}
+
+// findConflicts returns the destination paths of files and symlinks which already exist.
+func findConflicts(entries []extractEntry) ([]string, error) {
+	var conflicts []string
+
+	for _, entry := range entries {
+		if entry.file.Mode().IsDir() {
+			continue
+		}
+
+		_, err := os.Lstat(entry.path)
+		if err == nil {
+			conflicts = append(conflicts, entry.path)
+			continue
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to stat %s: %w", entry.path, err)
+		}
+	}
+
+	return conflicts, nil
+}
+
+// extractor writes archive entries to disk, tracking bytes and entries written.
+type extractor struct {
+	written atomic.Int64
+	entries atomic.Int64
+	chown   *Ownership
+	perms   Permissions
+}
+
+// extract writes the entries to disk, regular files are written concurrently.
+//
+// Symlinks are created after all other entries to prevent a traversal
+// vulnerability where a symlink is first created and additional files are then
+// extracted through it. Directory metadata is also applied last, otherwise
+// modification times would be updated by the files written into them.
+func (x *extractor) extract(ctx context.Context, entries []extractEntry, skip func(extractEntry) bool) error {
+	wg, wctx := errgroup.WithContext(ctx)
+	wg.SetLimit(runtime.GOMAXPROCS(0))
+
+	// wait returns the first error from the file workers, falling back to err.
+	wait := func(err error) error {
+		if werr := wg.Wait(); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if wctx.Err() != nil {
+			return wait(ctx.Err())
+		}
+
+		if skip(entry) {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(entry.path), 0o755); err != nil {
+			return wait(err)
+		}
+
+		switch {
+		case entry.file.Mode()&os.ModeSymlink != 0:
+			continue
+		case entry.file.Mode().IsDir():
+			if err := x.createDirectory(entry.path); err != nil {
+				return wait(err)
+			}
+		default:
+			wg.Go(func() error {
+				return x.createFile(wctx, entry)
+			})
+		}
+	}
+
+	if err := wait(nil); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch {
+		case entry.file.Mode()&os.ModeSymlink != 0:
+			if skip(entry) {
+				continue
+			}
+			if err := x.createSymlink(entry); err != nil {
+				return err
+			}
+		case entry.file.Mode().IsDir():
+			if err := x.updateFileMetadata(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (x *extractor) createDirectory(path string) error {
+	err := os.Mkdir(path, 0o755)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	if err := x.updateOwnership(path); err != nil {
+		return err
+	}
+
+	x.entries.Add(1)
+
+	return nil
+}
+
+func (x *extractor) createFile(ctx context.Context, entry extractEntry) (err error) {
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	r, err := entry.file.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	f, err := os.OpenFile(entry.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		// remove partially written files, e.g. when extraction is cancelled
+		if err != nil {
+			_ = os.Remove(entry.path)
+		}
+	}()
+
+	n, err := io.Copy(countWriter{w: f, written: &x.written, ctx: ctx}, r)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", entry.file.Name, err)
+	}
+
+	// the zip reader checks the size when the entry is read to the end, this
+	// guards against silently truncated Zip64 entries larger than 4GiB
+	if uint64(n) != entry.file.UncompressedSize64 { // #nosec G115 -- n is never negative
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrTruncatedEntry, entry.file.Name, n, entry.file.UncompressedSize64)
+	}
+
+	if err := x.updateFileMetadata(entry); err != nil {
+		return err
+	}
+
+	if err := x.updateOwnership(entry.path); err != nil {
+		return err
+	}
+
+	x.entries.Add(1)
+
+	return nil
+}
+
+func (x *extractor) createSymlink(entry extractEntry) error {
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	r, err := entry.file.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	target, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Symlink(string(target), entry.path); err != nil {
+		return err
+	}
+
+	if err := x.updateOwnership(entry.path); err != nil {
+		return err
+	}
+
+	x.entries.Add(1)
+
+	return nil
+}
+
+// updateOwnership changes the owner of an extracted path if requested.
+func (x *extractor) updateOwnership(path string) error {
+	if x.chown == nil {
+		return nil
+	}
+
+	if err := x.chown.chown(path); err != nil {
+		return fmt.Errorf("failed to change ownership of %s to %s: %w", path, x.chown, err)
+	}
+
+	return nil
+}
+
+// updateFileMetadata applies the permissions and the archived modification
+// time.
+func (x *extractor) updateFileMetadata(entry extractEntry) error {
+	if err := os.Chmod(entry.path, x.perms.mode(entry.file.Mode())); err != nil {
+		return err
+	}
+
+	return os.Chtimes(entry.path, time.Now(), entry.modified)
+}
+
+// countWriter counts bytes written and stops writing when ctx is cancelled.
+type countWriter struct {
+	w       io.Writer
+	written *atomic.Int64
+	ctx     context.Context
+}
+
+func (w countWriter) Write(p []byte) (n int, err error) {
+	if err = w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err = w.w.Write(p)
+	w.written.Add(int64(n))
+
+	return n, err
+}
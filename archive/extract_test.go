@@ -0,0 +1,333 @@
+package archive
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestArchive builds an archive of ~/.go-build containing a single file
+// and removes the source directory, returning the open archive.
+func buildTestArchive(t *testing.T) (*os.File, *ArchiveInfo, string) {
+	t.Helper()
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache data"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "other.txt"), []byte("other data"), 0o600))
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"~/.go-build"}, "go-cache")
+	assert.NoError(err)
+	t.Cleanup(func() { _ = os.Remove(archiveInfo.ArchivePath) })
+
+	assert.NoError(os.RemoveAll(goBuildDir))
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	t.Cleanup(func() { _ = zipFile.Close() })
+
+	return zipFile, archiveInfo, goBuildDir
+}
+
+func TestExtractFilesWithOptions_OnConflict(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          ConflictPolicy
+		wantErr         error
+		wantContent     string
+		wantSkipped     int
+		wantOverwritten int
+	}{
+		{
+			name:            "default overwrites existing files",
+			policy:          "",
+			wantContent:     "build cache data",
+			wantOverwritten: 1,
+		},
+		{
+			name:            "overwrite replaces existing files",
+			policy:          ConflictOverwrite,
+			wantContent:     "build cache data",
+			wantOverwritten: 1,
+		},
+		{
+			name:        "skip keeps existing files",
+			policy:      ConflictSkip,
+			wantContent: "local changes",
+			wantSkipped: 1,
+		},
+		{
+			name:        "fail aborts extraction",
+			policy:      ConflictFail,
+			wantErr:     ErrExtractConflict,
+			wantContent: "local changes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			zipFile, archiveInfo, goBuildDir := buildTestArchive(t)
+
+			existing := filepath.Join(goBuildDir, "cache.txt")
+			assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+			assert.NoError(os.WriteFile(existing, []byte("local changes"), 0o600))
+
+			conflicts, err := ListConflicts(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{})
+			assert.NoError(err)
+			assert.Equal([]string{existing}, conflicts)
+
+			extractInfo, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+				OnConflict: tt.policy,
+			})
+			if tt.wantErr != nil {
+				assert.ErrorIs(err, tt.wantErr)
+
+				_, err = os.Stat(filepath.Join(goBuildDir, "other.txt"))
+				assert.True(os.IsNotExist(err), "no files should be written when failing on conflict")
+			} else {
+				assert.NoError(err)
+				assert.Len(extractInfo.Skipped, tt.wantSkipped)
+				assert.Len(extractInfo.Overwritten, tt.wantOverwritten)
+
+				other, err := os.ReadFile(filepath.Join(goBuildDir, "other.txt"))
+				assert.NoError(err)
+				assert.Equal("other data", string(other))
+			}
+
+			content, err := os.ReadFile(existing)
+			assert.NoError(err)
+			assert.Equal(tt.wantContent, string(content))
+		})
+	}
+}
+
+func TestExtractFilesWithOptions_InvalidPolicy(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, _ := buildTestArchive(t)
+
+	_, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		OnConflict: "clobber",
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "invalid conflict policy")
+}
+
+func TestDestinationPath(t *testing.T) {
+	assert := require.New(t)
+
+	chroot := t.TempDir()
+
+	path, err := destinationPath(chroot, "cache/file.txt")
+	assert.NoError(err)
+	assert.Equal(filepath.Join(chroot, "cache", "file.txt"), path)
+
+	_, err = destinationPath(chroot, "../outside.txt")
+	assert.Error(err)
+	assert.Contains(err.Error(), "outside of chroot")
+}
+
+func TestExtractFilesWithOptions_Include(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	goModDir := filepath.Join(home, "go", "pkg", "mod")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+	assert.NoError(os.MkdirAll(goModDir, 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache data"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(goModDir, "module.txt"), []byte("module cache data"), 0o600))
+
+	paths := []string{"~/.go-build", "~/go/pkg/mod"}
+
+	archiveInfo, err := BuildArchive(context.Background(), paths, "go-cache")
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	assert.NoError(os.RemoveAll(goBuildDir))
+	assert.NoError(os.RemoveAll(filepath.Join(home, "go")))
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	_, err = ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, paths, ExtractOptions{
+		Include: []string{"~/unknown"},
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "is not one of the archive paths")
+
+	extractInfo, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, paths, ExtractOptions{
+		Include: []string{"~/go/pkg/mod"},
+	})
+	assert.NoError(err)
+	assert.Greater(extractInfo.WrittenEntries, int64(0))
+
+	moduleContent, err := os.ReadFile(filepath.Join(goModDir, "module.txt"))
+	assert.NoError(err)
+	assert.Equal("module cache data", string(moduleContent))
+
+	_, err = os.Stat(goBuildDir)
+	assert.True(os.IsNotExist(err), ".go-build should not be extracted")
+}
+
+func TestExtractFilesWithOptions_Files(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, goBuildDir := buildTestArchive(t)
+
+	_, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Files: []string{"~/.npm/cache.txt"},
+	})
+	assert.ErrorContains(err, "is not beneath any of the archive paths")
+
+	extractInfo, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Files: []string{"~/.go-build/cache.txt"},
+	})
+	assert.NoError(err)
+	assert.Equal(int64(1), extractInfo.WrittenEntries)
+
+	assert.FileExists(filepath.Join(goBuildDir, "cache.txt"))
+	assert.NoFileExists(filepath.Join(goBuildDir, "other.txt"))
+}
+
+func TestExtractReader(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, goBuildDir := buildTestArchive(t)
+
+	extractInfo, err := ExtractReader(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Files: []string{"~/.go-build/other.txt"},
+	})
+	assert.NoError(err)
+	assert.Equal(int64(1), extractInfo.WrittenEntries)
+	assert.Empty(extractInfo.ArchivePath)
+
+	content, err := os.ReadFile(filepath.Join(goBuildDir, "other.txt"))
+	assert.NoError(err)
+	assert.Equal("other data", string(content))
+	assert.NoFileExists(filepath.Join(goBuildDir, "cache.txt"))
+
+	tarFile, tarInfo, _ := buildTestTarGz(t, BuildOptions{})
+
+	_, err = ExtractReader(context.Background(), tarFile, tarInfo.Size, []string{"~/.go-build"}, ExtractOptions{})
+	assert.ErrorIs(err, ErrUnsupportedFormat)
+}
+
+func TestExtractFilesWithOptions_Root(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, goBuildDir := buildTestArchive(t)
+
+	root := t.TempDir()
+
+	_, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Root: root,
+	})
+	assert.NoError(err)
+
+	mappings, err := PathsToMappings([]string{"~/.go-build"})
+	assert.NoError(err)
+
+	staged := StagedPath(root, mappings[0])
+	assert.Equal(filepath.Join(root, "home", ".go-build"), staged)
+
+	content, err := os.ReadFile(filepath.Join(staged, "cache.txt"))
+	assert.NoError(err)
+	assert.Equal("build cache data", string(content))
+
+	assert.NoDirExists(goBuildDir, "files should only be extracted beneath the root")
+}
+
+func TestExtractFilesWithOptions_Cancelled(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// large incompressible files, so extraction is still running when cancelled
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+	data := make([]byte, 4*1024*1024)
+	for i := range 16 {
+		_, err := rand.Read(data)
+		assert.NoError(err)
+		assert.NoError(os.WriteFile(filepath.Join(goBuildDir, fmt.Sprintf("file-%d.bin", i)), data, 0o600))
+	}
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"~/.go-build"}, "go-cache")
+	assert.NoError(err)
+	t.Cleanup(func() { _ = os.Remove(archiveInfo.ArchivePath) })
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	t.Cleanup(func() { _ = zipFile.Close() })
+
+	t.Run("cancelled before starting", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(goBuildDir))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := ExtractFiles(ctx, zipFile, archiveInfo.Size, []string{"~/.go-build"})
+		require.ErrorIs(t, err, context.Canceled)
+		require.NoDirExists(t, goBuildDir)
+	})
+
+	t.Run("cancelled while extracting", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(goBuildDir))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// cancel once the first file has been created
+		go func() {
+			for ctx.Err() == nil {
+				if entries, _ := os.ReadDir(goBuildDir); len(entries) > 0 {
+					cancel()
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		_, err := ExtractFiles(ctx, zipFile, archiveInfo.Size, []string{"~/.go-build"})
+		if err == nil {
+			t.Skip("extraction completed before it was cancelled")
+		}
+		require.ErrorIs(t, err, context.Canceled)
+
+		// files are either fully extracted or removed
+		entries, err := os.ReadDir(goBuildDir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			info, err := entry.Info()
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), info.Size(), "partially extracted file %s was not removed", entry.Name())
+		}
+	})
+}
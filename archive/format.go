@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Format builds and extracts archives in a format other than zip and tar,
+// such as a filesystem image which can be mounted rather than extracted.
+// Formats are registered with RegisterFormat and selected by name, e.g. with
+// BuildOptions.Format or zstash.Config.Format, and are detected when
+// extracting, so embedders can add proprietary formats.
+//
+// Entries are named as in zip archives, relative to the chroot of the cache
+// path they were archived from with a trailing "/" for directories, see
+// PathsToMappings.
+type Format interface {
+	// ContentType returns the MIME type of archives in the format.
+	ContentType() string
+
+	// Detect reports whether the archive read from r is in the format, e.g.
+	// by its leading bytes. Every registered format is tried before the zip
+	// and tar formats.
+	Detect(r io.ReaderAt) (bool, error)
+
+	// Build returns an Archiver writing an archive to w. The options are those
+	// given to BuildArchiveWithOptions; the ignore options have already been
+	// applied to the files archived.
+	Build(w io.Writer, opts BuildOptions) (Archiver, error)
+
+	// Extract extracts the archive to the given paths, see
+	// ExtractFilesWithOptions, extracting only the entries selected by
+	// ExtractOptions.Include and ExtractOptions.Files.
+	Extract(ctx context.Context, f *os.File, size int64, paths []string, opts ExtractOptions) (*ArchiveInfo, error)
+
+	// List returns the names of the entries in the archive, see ListArchive.
+	List(ctx context.Context, r io.ReaderAt, size int64) ([]string, error)
+}
+
+// Archiver writes files to an archive, see Format.
+type Archiver interface {
+	// Archive archives the files, symlinks and directories beneath chroot.
+	// The files map the path of each file to its info, and are named in the
+	// archive relative to chroot.
+	Archive(ctx context.Context, chroot string, files map[string]os.FileInfo) error
+
+	// Written returns how many bytes of file content and entries have been
+	// archived.
+	Written() (bytes, entries int64)
+
+	// Close finishes writing the archive.
+	Close() error
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{}
+	// formatOrder lists the registered formats in the order they're detected
+	formatOrder []string
+)
+
+// RegisterFormat registers a custom archive format under name, which must not
+// be the name of a built in or already registered format. It's typically
+// called from an init function.
+func RegisterFormat(name string, format Format) error {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid archive format name %q", name)
+	}
+	if format == nil {
+		return fmt.Errorf("archive format %s is nil", name)
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if name == FormatZip || isTarFormat(name) || formats[name] != nil {
+		return fmt.Errorf("archive format %s is already registered", name)
+	}
+
+	formats[name] = format
+	formatOrder = append(formatOrder, name)
+
+	return nil
+}
+
+// Formats returns the names of the supported archive formats, the built in
+// formats followed by those registered with RegisterFormat.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	return append([]string{FormatZip, FormatTarGz, FormatTarZst}, formatOrder...)
+}
+
+// FormatContentType returns the MIME type of archives in the format, or an
+// empty string if the format isn't supported.
+func FormatContentType(name string) string {
+	switch name {
+	case FormatZip:
+		return "application/zip"
+	case FormatTarGz:
+		return "application/gzip"
+	case FormatTarZst:
+		return "application/zstd"
+	}
+
+	if format := registeredFormat(name); format != nil {
+		return format.ContentType()
+	}
+
+	return ""
+}
+
+// registeredFormat returns the custom format registered under name, or nil.
+func registeredFormat(name string) Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	return formats[name]
+}
+
+// detectRegisteredFormat returns the name of the first registered format the
+// archive read from r is in, or an empty string if none match.
+func detectRegisteredFormat(r io.ReaderAt) (string, error) {
+	formatsMu.RLock()
+	names := slices.Clone(formatOrder)
+	formatsMu.RUnlock()
+
+	for _, name := range names {
+		ok, err := registeredFormat(name).Detect(r)
+		if err != nil {
+			return "", fmt.Errorf("failed to detect %s archive: %w", name, err)
+		}
+		if ok {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// listFormatConflicts returns the destination paths of entries listed by a
+// custom format which already exist on disk, see ListConflicts.
+func listFormatConflicts(ctx context.Context, format Format, f io.ReaderAt, size int64, paths []string, opts ExtractOptions) ([]string, error) {
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	included, err := tarIncluded(paths, opts.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := newFileFilter(paths, opts.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := format.List(ctx, f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		mapping, ok := findMapping(mappings, name)
+		if !ok {
+			return nil, fmt.Errorf("failed to find path mapping for: %s", name)
+		}
+		if included != nil && !included[mapping.Path] || !files.match(name) {
+			continue
+		}
+
+		chroot := mapping.Chroot
+		if opts.Root != "" {
+			chroot = stagedChroot(opts.Root, mapping)
+		}
+
+		dest, err := destinationPath(chroot, name)
+		if err != nil {
+			return nil, err
+		}
+
+		exists, err := pathExists(dest)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			conflicts = append(conflicts, dest)
+		}
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,207 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/stretchr/testify/require"
+)
+
+const testFormatMagic = "ZSTASH-TEST\n"
+
+// testFormat is a custom format which lists entry names one per line after a
+// magic header, and extracts by creating empty files.
+type testFormat struct{}
+
+func (testFormat) ContentType() string { return "application/x-zstash-test" }
+
+func (testFormat) Detect(r io.ReaderAt) (bool, error) {
+	magic := make([]byte, len(testFormatMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return false, err
+	}
+	return string(magic) == testFormatMagic, nil
+}
+
+func (testFormat) Build(w io.Writer, opts BuildOptions) (Archiver, error) {
+	if _, err := io.WriteString(w, testFormatMagic); err != nil {
+		return nil, err
+	}
+	return &testArchiver{w: w}, nil
+}
+
+func (f testFormat) Extract(ctx context.Context, file *os.File, size int64, paths []string, opts ExtractOptions) (*ArchiveInfo, error) {
+	names, err := f.List(ctx, file, size)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ArchiveInfo{ArchivePath: file.Name(), Size: size}
+	for _, name := range names {
+		mapping, ok := findMapping(mappings, name)
+		if !ok {
+			return nil, fmt.Errorf("failed to find path mapping for: %s", name)
+		}
+
+		dest := filepath.Join(mapping.Chroot, name)
+		if strings.HasSuffix(name, "/") {
+			err = os.MkdirAll(dest, 0o755)
+		} else {
+			err = os.WriteFile(dest, nil, 0o600)
+		}
+		if err != nil {
+			return nil, err
+		}
+		info.WrittenEntries++
+	}
+
+	return info, nil
+}
+
+func (testFormat) List(ctx context.Context, r io.ReaderAt, size int64) ([]string, error) {
+	scanner := bufio.NewScanner(io.NewSectionReader(r, int64(len(testFormatMagic)), size-int64(len(testFormatMagic))))
+
+	var names []string
+	for scanner.Scan() {
+		names = append(names, scanner.Text())
+	}
+
+	return names, scanner.Err()
+}
+
+type testArchiver struct {
+	w       io.Writer
+	entries int64
+}
+
+func (a *testArchiver) Archive(ctx context.Context, chroot string, files map[string]os.FileInfo) error {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		name, err := filepath.Rel(chroot, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if files[path].IsDir() {
+			name += "/"
+		}
+
+		if _, err := fmt.Fprintln(a.w, name); err != nil {
+			return err
+		}
+		a.entries++
+	}
+
+	return nil
+}
+
+func (a *testArchiver) Written() (int64, int64) { return 0, a.entries }
+
+func (a *testArchiver) Close() error { return nil }
+
+var registerTestFormat = sync.OnceValue(func() error {
+	return RegisterFormat("test", testFormat{})
+})
+
+func TestRegisterFormat(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(registerTestFormat())
+
+	assert.Error(RegisterFormat("test", testFormat{}))
+	assert.Error(RegisterFormat(FormatZip, testFormat{}))
+	assert.Error(RegisterFormat(FormatTarGz, testFormat{}))
+	assert.Error(RegisterFormat(FormatTarZst, testFormat{}))
+	assert.Error(RegisterFormat("", testFormat{}))
+	assert.Error(RegisterFormat("a/b", testFormat{}))
+	assert.Error(RegisterFormat("nil", nil))
+
+	assert.Equal([]string{FormatZip, FormatTarGz, FormatTarZst, "test"}, Formats())
+	assert.Equal("application/zip", FormatContentType(FormatZip))
+	assert.Equal("application/gzip", FormatContentType(FormatTarGz))
+	assert.Equal("application/zstd", FormatContentType(FormatTarZst))
+	assert.Equal("application/x-zstash-test", FormatContentType("test"))
+	assert.Empty(FormatContentType("unknown"))
+}
+
+func TestBuildAndExtractRegisteredFormat(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	assert.NoError(registerTestFormat())
+
+	_, err := trace.NewProvider(ctx, "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(filepath.Join(goBuildDir, "nested"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache data"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "nested", "other.txt"), []byte("other data"), 0o600))
+
+	_, err = BuildArchiveWithOptions(ctx, []string{"~/.go-build"}, "go-cache", BuildOptions{Format: "test", Manifest: true})
+	assert.Error(err)
+
+	archiveInfo, err := BuildArchiveWithOptions(ctx, []string{"~/.go-build"}, "go-cache", BuildOptions{Format: "test"})
+	assert.NoError(err)
+	t.Cleanup(func() { _ = os.Remove(archiveInfo.ArchivePath) })
+
+	assert.Equal(".test", filepath.Ext(archiveInfo.ArchivePath))
+	assert.Equal(int64(4), archiveInfo.WrittenEntries)
+
+	data, err := os.ReadFile(archiveInfo.ArchivePath)
+	assert.NoError(err)
+
+	format, err := DetectFormat(bytes.NewReader(data))
+	assert.NoError(err)
+	assert.Equal("test", format)
+
+	names, err := ListArchive(ctx, bytes.NewReader(data), int64(len(data)))
+	assert.NoError(err)
+	assert.Equal([]string{".go-build/", ".go-build/cache.txt", ".go-build/nested/", ".go-build/nested/other.txt"}, names)
+
+	f, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer f.Close()
+
+	conflicts, err := ListConflicts(ctx, f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{})
+	assert.NoError(err)
+	assert.Equal([]string{filepath.Join(goBuildDir, "cache.txt"), filepath.Join(goBuildDir, "nested", "other.txt")}, conflicts)
+
+	inspected, err := InspectArchive(ctx, archiveInfo.ArchivePath)
+	assert.NoError(err)
+	assert.Equal(int64(4), inspected.WrittenEntries)
+
+	_, err = ReadManifest(ctx, f, archiveInfo.Size)
+	assert.ErrorIs(err, ErrNoManifest)
+
+	assert.NoError(os.RemoveAll(goBuildDir))
+
+	extracted, err := ExtractFiles(ctx, f, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal(int64(4), extracted.WrittenEntries)
+
+	assert.FileExists(filepath.Join(goBuildDir, "nested", "other.txt"))
+}
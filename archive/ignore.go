@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// IgnoreFile is the name of the file listing paths to exclude when building an
+// archive, using gitignore syntax. It is read from the current working directory
+// and from the root of each cached path.
+const IgnoreFile = ".zstashignore"
+
+// DefaultIgnorePatterns are excluded from cached paths when building an
+// archive, unless disabled with NoDefaultIgnore, so VCS metadata and operating
+// system junk files don't bloat archives. Patterns use ignore file syntax and
+// are matched at any depth. An ignore file can re-include them by negating
+// the pattern, e.g. "!.git".
+var DefaultIgnorePatterns = []string{
+	".git",
+	".hg",
+	".svn",
+	".bzr",
+	".DS_Store",
+	"Thumbs.db",
+}
+
+// ignoreRule is a single pattern from an ignore file.
+type ignoreRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreMatcher matches paths against the rules of a single ignore file, with
+// patterns relative to the directory containing the file.
+type ignoreMatcher struct {
+	base  string
+	rules []ignoreRule
+}
+
+// ignoreMatchers combines the rules of multiple ignore files, where later files
+// take precedence over earlier ones.
+type ignoreMatchers []*ignoreMatcher
+
+// loadIgnoreFile reads the ignore file in dir, returning nil if there isn't one.
+func loadIgnoreFile(dir string) (*ignoreMatcher, error) {
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	file, err := os.Open(filepath.Join(base, IgnoreFile)) // #nosec G304 -- ignore file path is built from configured cache paths
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open ignore file: %w", err)
+	}
+	defer file.Close()
+
+	matcher := &ignoreMatcher{base: base}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			matcher.rules = append(matcher.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file: %w", err)
+	}
+
+	return matcher, nil
+}
+
+// newPatternMatcher returns a matcher for patterns in ignore file syntax,
+// relative to dir, returning nil if there are none.
+func newPatternMatcher(dir string, patterns []string) (*ignoreMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	matcher := &ignoreMatcher{base: base}
+	for _, pattern := range patterns {
+		if rule, ok := parseIgnoreRule(pattern); ok {
+			matcher.rules = append(matcher.rules, rule)
+		}
+	}
+
+	return matcher, nil
+}
+
+// ignorePatterns returns the patterns excluded from each cached path, the
+// defaults unless disabled followed by the configured patterns.
+func ignorePatterns(patterns []string, noDefaults bool) []string {
+	if noDefaults {
+		return patterns
+	}
+
+	return append(slices.Clone(DefaultIgnorePatterns), patterns...)
+}
+
+// parseIgnoreRule parses a single line of an ignore file, returning false for
+// blank lines and comments.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// patterns containing a slash are relative to the ignore file, otherwise
+	// they match a name at any depth
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.segments = strings.Split(line, "/")
+
+	return rule, true
+}
+
+// ignored reports whether the absolute path should be excluded from the archive.
+func (m ignoreMatchers) ignored(absPath string, isDir bool) bool {
+	ignored := false
+
+	for _, matcher := range m {
+		rel, err := filepath.Rel(matcher.base, absPath)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+
+		for _, rule := range matcher.rules {
+			if rule.matches(segments, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+func (r ignoreRule) matches(segments []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if !r.anchored {
+		// directories are skipped when walking, so only the name needs to be checked
+		return matchSegments(r.segments, segments[len(segments)-1:])
+	}
+
+	return matchSegments(r.segments, segments)
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches zero or more segments.
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+		return false
+	}
+
+	return matchSegments(pattern[1:], name[1:])
+}
@@ -0,0 +1,63 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreMatchers(t *testing.T) {
+	base := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(base, IgnoreFile), []byte(`# comment
+
+*.log
+tmp/
+/build/output
+docs/**/*.md
+!keep.log
+`), 0o600)
+	require.NoError(t, err)
+
+	matcher, err := loadIgnoreFile(base)
+	require.NoError(t, err)
+	require.NotNil(t, matcher)
+
+	tests := []struct {
+		name    string
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{name: "matches name at root", path: "debug.log", ignored: true},
+		{name: "matches name at any depth", path: "a/b/debug.log", ignored: true},
+		{name: "negated pattern", path: "a/keep.log", ignored: false},
+		{name: "directory only pattern matches directory", path: "a/tmp", isDir: true, ignored: true},
+		{name: "directory only pattern skips files", path: "a/tmp", ignored: false},
+		{name: "anchored pattern", path: "build/output", isDir: true, ignored: true},
+		{name: "anchored pattern does not match nested", path: "a/build/output", isDir: true, ignored: false},
+		{name: "double star matches zero directories", path: "docs/readme.md", ignored: true},
+		{name: "double star matches many directories", path: "docs/a/b/readme.md", ignored: true},
+		{name: "unmatched file", path: "main.go", ignored: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ignoreMatchers{matcher}.ignored(filepath.Join(base, filepath.FromSlash(tt.path)), tt.isDir)
+			require.Equal(t, tt.ignored, got)
+		})
+	}
+
+	t.Run("paths outside base are not matched", func(t *testing.T) {
+		got := ignoreMatchers{matcher}.ignored(filepath.Join(filepath.Dir(base), "debug.log"), false)
+		require.False(t, got)
+	})
+}
+
+func TestLoadIgnoreFile_Missing(t *testing.T) {
+	matcher, err := loadIgnoreFile(t.TempDir())
+	require.NoError(t, err)
+	require.Nil(t, matcher)
+}
@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/zip"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// InspectArchive checks an existing archive file is a valid zip or tar
+// archive, returning its size, SHA-256 checksum and the number of entries and
+// uncompressed bytes of regular files it contains, as BuildArchive does for
+// archives it builds.
+func InspectArchive(ctx context.Context, archivePath string) (*ArchiveInfo, error) {
+	_, span := trace.Start(ctx, "InspectArchive")
+	defer span.End()
+
+	start := time.Now()
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive file: %w", err)
+	}
+
+	info := &ArchiveInfo{
+		ArchivePath: archivePath,
+		Size:        size,
+		Sha256sum:   hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	format, err := DetectFormat(f)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatZip:
+		err = inspectZip(f, size, info)
+	case FormatTarGz, FormatTarZst:
+		err = inspectTar(ctx, f, size, info)
+	default:
+		err = inspectFormat(ctx, registeredFormat(format), f, size, info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Duration = time.Since(start)
+
+	span.SetAttributes(
+		attribute.String("Sha256sum", info.Sha256sum),
+		attribute.Int64("Size", info.Size),
+	)
+
+	return info, nil
+}
+
+// inspectZip counts the entries and uncompressed bytes of regular files in a
+// zip archive.
+func inspectZip(r io.ReaderAt, size int64, info *ArchiveInfo) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip reader: %w", err)
+	}
+
+	for _, file := range reader.File {
+		if isMetadataEntry(file.Name) {
+			continue
+		}
+		info.WrittenEntries++
+		if file.Mode().IsRegular() {
+			info.WrittenBytes += int64(file.UncompressedSize64) // #nosec G115 -- entry sizes fit in an int64
+		}
+	}
+
+	return nil
+}
+
+// inspectFormat counts the entries in an archive in a registered format. The
+// format only lists entries, so the uncompressed bytes aren't counted.
+func inspectFormat(ctx context.Context, format Format, r io.ReaderAt, size int64, info *ArchiveInfo) error {
+	entries, err := format.List(ctx, r, size)
+	if err != nil {
+		return err
+	}
+
+	info.WrittenEntries = int64(len(entries))
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectArchive(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home, err := os.Getwd()
+	assert.NoError(err)
+
+	t.Setenv("HOME", home)
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"testdata"}, "test")
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	info, err := InspectArchive(context.Background(), archiveInfo.ArchivePath)
+	assert.NoError(err)
+	assert.Equal(archiveInfo.ArchivePath, info.ArchivePath)
+	assert.Equal(archiveInfo.Sha256sum, info.Sha256sum)
+	assert.Equal(archiveInfo.Size, info.Size)
+	assert.Equal(archiveInfo.WrittenEntries, info.WrittenEntries)
+	assert.Equal(archiveInfo.WrittenBytes, info.WrittenBytes)
+}
+
+func TestInspectArchive_Invalid(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	notZip := filepath.Join(t.TempDir(), "cache.zip")
+	assert.NoError(os.WriteFile(notZip, []byte("not a zip archive"), 0o600))
+
+	_, err = InspectArchive(context.Background(), notZip)
+	assert.ErrorContains(err, "failed to open zip reader")
+
+	_, err = InspectArchive(context.Background(), filepath.Join(t.TempDir(), "missing.zip"))
+	assert.ErrorIs(err, os.ErrNotExist)
+}
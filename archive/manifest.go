@@ -0,0 +1,267 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/zip"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// manifestEntryName is the name of the archive entry holding the manifest
+// recorded by BuildArchiveWithOptions with Manifest. It isn't extracted to
+// disk.
+const manifestEntryName = ".zstash-manifest.json"
+
+// maxManifestEntrySize limits the size of the manifest entry read into memory.
+const maxManifestEntrySize = 256 << 20
+
+// ErrNoManifest is returned by ReadManifest for archives built without a
+// manifest.
+var ErrNoManifest = errors.New("archive has no manifest")
+
+// ManifestFile describes an archived file, directory or symlink.
+type ManifestFile struct {
+	Size int64       `json:"size"`
+	Mode fs.FileMode `json:"mode"`
+	// SHA256 is the checksum of a file's content or a symlink's target, and
+	// is empty for directories.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Manifest records the size, mode and checksum of every archived file, keyed
+// by archive entry name, so files on disk can be checked against an archive
+// without reading its contents.
+type Manifest struct {
+	Files map[string]ManifestFile `json:"files"`
+}
+
+// addFiles records the files archived from chroot.
+func (m *Manifest) addFiles(ctx context.Context, chroot string, files map[string]os.FileInfo) error {
+	chroot, err := filepath.Abs(chroot)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	for filename, fi := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if fi == nil || fi.Mode()&irregularModes != 0 {
+			continue
+		}
+
+		name, err := entryName(chroot, filename, fi.IsDir())
+		if err != nil {
+			return err
+		}
+
+		file := ManifestFile{Size: fi.Size(), Mode: fi.Mode()}
+		if !fi.IsDir() {
+			file.SHA256, err = checksumPath(filename, fi.Mode())
+			if err != nil {
+				return fmt.Errorf("failed to checksum %s: %w", filename, err)
+			}
+		}
+
+		m.Files[name] = file
+	}
+
+	return nil
+}
+
+// writeFile writes the manifest to a temporary directory, returning the
+// directory to archive it from and the file to archive.
+func (m *Manifest) writeFile() (string, map[string]os.FileInfo, error) {
+	return writeMetadataFile(manifestEntryName, m)
+}
+
+// checksumPath returns the SHA-256 checksum of a file's content, or of a
+// symlink's target.
+func checksumPath(path string, mode fs.FileMode) (string, error) {
+	hash := sha256.New()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		_, _ = io.WriteString(hash, target)
+
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- path is an archived or restored cache file
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ReadManifest returns the manifest recorded in the archive, or an error
+// wrapping ErrNoManifest if the archive was built without one. Only zip
+// archives have a manifest.
+func ReadManifest(ctx context.Context, zipFile *os.File, zipFileLen int64) (*Manifest, error) {
+	_, span := trace.Start(ctx, "ReadManifest")
+	defer span.End()
+
+	format, err := DetectFormat(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatZip {
+		return nil, ErrNoManifest
+	}
+
+	reader, err := newZipReader(zipFile, zipFileLen)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range reader.File {
+		if file.Name == manifestEntryName {
+			return readManifestEntry(file)
+		}
+	}
+
+	return nil, ErrNoManifest
+}
+
+func readManifestEntry(file *zip.File) (*Manifest, error) {
+	if file.UncompressedSize64 > maxManifestEntrySize {
+		return nil, fmt.Errorf("manifest entry is too large: %d bytes", file.UncompressedSize64)
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest entry: %w", err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(r, maxManifestEntrySize)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest entry: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// CompareManifest compares the files on disk at the given paths against the
+// manifest, without reading the archive, returning the differences sorted by
+// path.
+//
+// Files are compared by type, size and checksum. Files ignored by a
+// .zstashignore file or DefaultIgnorePatterns aren't reported as added, and
+// manifest entries outside of the paths are skipped. Permissions and
+// modification times aren't compared.
+func CompareManifest(ctx context.Context, manifest *Manifest, paths []string) ([]Diff, error) {
+	return CompareManifestWithOptions(ctx, manifest, paths, CompareOptions{})
+}
+
+// CompareManifestWithOptions compares the files on disk at the given paths
+// against the manifest, applying the supplied options. See CompareManifest for
+// details.
+func CompareManifestWithOptions(ctx context.Context, manifest *Manifest, paths []string, opts CompareOptions) ([]Diff, error) {
+	ctx, span := trace.Start(ctx, "CompareManifest")
+	defer span.End()
+
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	var diffs []Diff
+
+	archived := make(map[string]bool, len(manifest.Files))
+	for name, file := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		mapping, ok := findMapping(mappings, name)
+		if !ok {
+			continue
+		}
+
+		path, err := destinationPath(mapping.Chroot, name)
+		if err != nil {
+			return nil, err
+		}
+
+		archived[path] = true
+
+		kind, differs, err := compareManifestFile(path, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s: %w", path, err)
+		}
+		if differs {
+			diffs = append(diffs, Diff{Path: path, Kind: kind})
+		}
+	}
+
+	added, err := findAdded(ctx, paths, archived, opts)
+	if err != nil {
+		return nil, err
+	}
+	diffs = append(diffs, added...)
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+
+	span.SetAttributes(
+		attribute.Int("entryCount", len(archived)),
+		attribute.Int("diffCount", len(diffs)),
+	)
+
+	return diffs, nil
+}
+
+// compareManifestFile compares a manifest entry with its destination on disk.
+func compareManifestFile(path string, file ManifestFile) (DiffKind, bool, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DiffMissing, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if info.Mode().Type() != file.Mode.Type() {
+		return DiffModified, true, nil
+	}
+
+	if info.IsDir() {
+		return DiffModified, false, nil
+	}
+
+	if info.Mode().IsRegular() && info.Size() != file.Size {
+		return DiffModified, true, nil
+	}
+
+	sum, err := checksumPath(path, info.Mode())
+	if err != nil {
+		return "", false, err
+	}
+
+	return DiffModified, sum != file.SHA256, nil
+}
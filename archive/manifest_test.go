@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadManifest(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache data"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "other.txt"), []byte("other data"), 0o600))
+	assert.NoError(os.Symlink("cache.txt", filepath.Join(goBuildDir, "link")))
+
+	archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"~/.go-build"}, "go-cache", BuildOptions{Manifest: true})
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	manifest, err := ReadManifest(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Len(manifest.Files, 4)
+	assert.True(manifest.Files[".go-build/"].Mode.IsDir())
+	assert.Empty(manifest.Files[".go-build/"].SHA256)
+	assert.Equal(int64(16), manifest.Files[".go-build/cache.txt"].Size)
+	assert.Equal("cd697df54b97921c6d27ef8089bce474cd09fc7d9de01eff0ec0db9a1170d53a", manifest.Files[".go-build/cache.txt"].SHA256)
+	assert.NotEmpty(manifest.Files[".go-build/link"].SHA256)
+
+	// the manifest isn't listed or extracted
+	entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.NotContains(entries, manifestEntryName)
+
+	// restored files match the manifest
+	assert.NoError(os.RemoveAll(goBuildDir))
+	_, err = ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.NoFileExists(filepath.Join(home, manifestEntryName))
+
+	diffs, err := CompareManifest(context.Background(), manifest, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Empty(diffs)
+
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache DATA"), 0o600))
+	assert.NoError(os.Remove(filepath.Join(goBuildDir, "other.txt")))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "new.txt"), []byte("new"), 0o600))
+	assert.NoError(os.Remove(filepath.Join(goBuildDir, "link")))
+	assert.NoError(os.Symlink("new.txt", filepath.Join(goBuildDir, "link")))
+
+	diffs, err = CompareManifest(context.Background(), manifest, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal([]Diff{
+		{Path: filepath.Join(goBuildDir, "cache.txt"), Kind: DiffModified},
+		{Path: filepath.Join(goBuildDir, "link"), Kind: DiffModified},
+		{Path: filepath.Join(goBuildDir, "new.txt"), Kind: DiffAdded},
+		{Path: filepath.Join(goBuildDir, "other.txt"), Kind: DiffMissing},
+	}, diffs)
+}
+
+func TestReadManifest_NoManifest(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, _ := buildTestArchive(t)
+
+	_, err := ReadManifest(context.Background(), zipFile, archiveInfo.Size)
+	assert.ErrorIs(err, ErrNoManifest)
+}
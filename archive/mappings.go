@@ -82,6 +82,25 @@ func ResolveHomeDir(path string) (string, error) {
 	return path, nil
 }
 
+// StagedPath returns the directory a path's entries are extracted to beneath
+// root with ExtractOptions.Root. Paths under the home directory are staged
+// beneath root/home and paths relative to the working directory beneath
+// root/workdir, keeping their path relative to the chroot, e.g.
+// "~/.go-build" is staged at root/home/.go-build.
+func StagedPath(root string, mapping Mapping) string {
+	return filepath.Join(stagedChroot(root, mapping), mapping.RelativePath)
+}
+
+// stagedChroot returns the directory replacing the mapping's chroot beneath root.
+func stagedChroot(root string, mapping Mapping) string {
+	// matches the chroots chosen by chrootPath
+	if filepath.IsAbs(mapping.ResolvedPath) {
+		return filepath.Join(root, "home")
+	}
+
+	return filepath.Join(root, "workdir")
+}
+
 func chrootPath(path string) (string, error) {
 	if filepath.IsAbs(path) {
 		return os.UserHomeDir()
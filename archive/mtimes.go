@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// mtimesEntryName is the name of the archive entry holding the modification
+// times recorded by BuildArchiveWithOptions with PreserveMtimes. It isn't
+// extracted to disk.
+const mtimesEntryName = ".zstash-mtimes.json"
+
+// maxMtimesEntrySize limits the size of the mtimes entry read into memory.
+const maxMtimesEntrySize = 256 << 20
+
+// mtimesManifest maps archive entry names to their modification time in
+// nanoseconds since the Unix epoch. Zip timestamps only have second
+// precision, which isn't enough for build tools comparing mtimes.
+type mtimesManifest struct {
+	Mtimes map[string]int64 `json:"mtimes"`
+}
+
+// addMtimes records the modification times of files archived from chroot.
+func (m *mtimesManifest) addMtimes(chroot string, files map[string]os.FileInfo) error {
+	chroot, err := filepath.Abs(chroot)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	for filename, fi := range files {
+		if fi == nil {
+			continue
+		}
+
+		name, err := entryName(chroot, filename, fi.IsDir())
+		if err != nil {
+			return err
+		}
+
+		m.Mtimes[name] = fi.ModTime().UnixNano()
+	}
+
+	return nil
+}
+
+// writeFile writes the manifest to a temporary directory, returning the
+// directory to archive it from and the file to archive.
+func (m *mtimesManifest) writeFile() (string, map[string]os.FileInfo, error) {
+	return writeMetadataFile(mtimesEntryName, m)
+}
+
+// isMetadataEntry reports whether an archive entry holds metadata recorded by
+// BuildArchiveWithOptions, rather than an archived file.
+func isMetadataEntry(name string) bool {
+	return name == mtimesEntryName || name == manifestEntryName || name == referencesEntryName
+}
+
+// entryName returns the archive entry name of a file archived from the
+// absolute chroot, matching the names written by the archiver.
+func entryName(chroot, filename string, isDir bool) (string, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	rel, err := filepath.Rel(chroot, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get relative path for %s: %w", filename, err)
+	}
+
+	name := filepath.ToSlash(rel)
+	if isDir {
+		name += "/"
+	}
+
+	return name, nil
+}
+
+// writeMetadataFile writes v as JSON to a temporary directory in a file with
+// the metadata entry name, returning the directory to archive it from and the
+// file to archive.
+func writeMetadataFile(name string, v any) (string, map[string]os.FileInfo, error) {
+	dir, err := os.MkdirTemp("", "zstash-metadata-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create %s directory: %w", name, err)
+	}
+
+	path := filepath.Join(dir, name)
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+
+	return dir, map[string]os.FileInfo{path: fi}, nil
+}
+
+// readMtimes returns the modification times recorded in the archive, or nil
+// if the archive was built without PreserveMtimes.
+func readMtimes(reader *zip.Reader) (map[string]time.Time, error) {
+	for _, file := range reader.File {
+		if file.Name != mtimesEntryName {
+			continue
+		}
+
+		if file.UncompressedSize64 > maxMtimesEntrySize {
+			return nil, fmt.Errorf("mtimes entry is too large: %d bytes", file.UncompressedSize64)
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open mtimes entry: %w", err)
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+
+		var manifest mtimesManifest
+		if err := json.NewDecoder(io.LimitReader(r, maxMtimesEntrySize)).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("failed to decode mtimes entry: %w", err)
+		}
+
+		mtimes := make(map[string]time.Time, len(manifest.Mtimes))
+		for name, nsec := range manifest.Mtimes {
+			mtimes[name] = time.Unix(0, nsec)
+		}
+
+		return mtimes, nil
+	}
+
+	return nil, nil
+}
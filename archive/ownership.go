@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Ownership is the user and group extracted files are changed to.
+type Ownership struct {
+	UID int
+	GID int
+}
+
+// CurrentUserOwnership returns the ownership of the current process, used to
+// take ownership of files archived by another user, such as root in a container.
+func CurrentUserOwnership() Ownership {
+	return Ownership{UID: os.Getuid(), GID: os.Getgid()}
+}
+
+// ParseOwnership parses a numeric "uid:gid" string. If the gid is omitted the
+// uid is also used as the gid.
+func ParseOwnership(s string) (Ownership, error) {
+	uidStr, gidStr, found := strings.Cut(s, ":")
+	if !found {
+		gidStr = uidStr
+	}
+
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil || uid < 0 {
+		return Ownership{}, fmt.Errorf("invalid uid in ownership %q", s)
+	}
+
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil || gid < 0 {
+		return Ownership{}, fmt.Errorf("invalid gid in ownership %q", s)
+	}
+
+	return Ownership{UID: uid, GID: gid}, nil
+}
+
+// String returns the ownership in "uid:gid" form.
+func (o Ownership) String() string {
+	return fmt.Sprintf("%d:%d", o.UID, o.GID)
+}
+
+// chown changes the owner of path, without following symlinks. Ownership isn't
+// supported on windows so this does nothing there.
+func (o Ownership) chown(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	return os.Lchown(path, o.UID, o.GID)
+}
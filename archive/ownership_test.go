@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Ownership
+		wantErr bool
+	}{
+		{name: "uid and gid", input: "1000:1001", want: Ownership{UID: 1000, GID: 1001}},
+		{name: "uid only", input: "1000", want: Ownership{UID: 1000, GID: 1000}},
+		{name: "root", input: "0:0", want: Ownership{UID: 0, GID: 0}},
+		{name: "empty", input: "", wantErr: true},
+		{name: "names are not supported", input: "buildkite-agent:buildkite-agent", wantErr: true},
+		{name: "negative uid", input: "-1:0", wantErr: true},
+		{name: "missing gid", input: "1000:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOwnership(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.want, mustParseOwnership(t, got.String()))
+		})
+	}
+}
+
+func mustParseOwnership(t *testing.T, s string) Ownership {
+	t.Helper()
+	o, err := ParseOwnership(s)
+	require.NoError(t, err)
+	return o
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractFilesWithOptions_Chown(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, goBuildDir := buildTestArchive(t)
+
+	owner := CurrentUserOwnership()
+
+	_, err := ExtractFilesWithOptions(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Chown: &owner,
+	})
+	assert.NoError(err)
+
+	fi, err := os.Lstat(filepath.Join(goBuildDir, "cache.txt"))
+	assert.NoError(err)
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	assert.True(ok)
+	assert.Equal(uint32(owner.UID), stat.Uid)
+	assert.Equal(uint32(owner.GID), stat.Gid)
+}
@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Permissions overrides the archived permissions of extracted files and
+// directories, e.g. for archives created on hosts with a restrictive umask,
+// whose directories can't be read by later steps running as another user.
+// The zero value applies the archived permissions. Symlinks are unaffected.
+type Permissions struct {
+	// DirMode, if non-zero, is the permissions of every extracted directory,
+	// e.g. 0o755.
+	DirMode os.FileMode
+
+	// FileMode, if non-zero, is the permissions of every extracted file, e.g.
+	// 0o644. Archived executables are also executable wherever FileMode
+	// grants read, e.g. 0o755 for 0o644.
+	FileMode os.FileMode
+
+	// Umask, if non-zero, gives files and directories without DirMode or
+	// FileMode the permissions they'd be created with under the umask, e.g.
+	// 0o755 for directories and executables and 0o644 for other files with
+	// 0o022, rather than their archived permissions.
+	Umask os.FileMode
+}
+
+// ParseMode parses octal permissions such as "0755" or "0o755", as used for
+// Permissions in configuration files.
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q, expected octal permissions such as 0755", s)
+	}
+
+	if mode&^0o777 != 0 {
+		return 0, fmt.Errorf("invalid mode %q, expected permissions of at most 0777", s)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// mode returns the permissions applied to an extracted file or directory
+// with the archived mode.
+func (p Permissions) mode(archived os.FileMode) os.FileMode {
+	executable := archived.Perm()&0o111 != 0
+
+	switch {
+	case archived.IsDir() && p.DirMode != 0:
+		return p.DirMode.Perm()
+	case archived.IsDir() && p.Umask != 0:
+		return 0o777 &^ p.Umask.Perm()
+	case archived.IsDir():
+		return archived.Perm()
+	case p.FileMode != 0:
+		mode := p.FileMode.Perm()
+		if executable {
+			mode |= mode & 0o444 >> 2
+		}
+		return mode
+	case p.Umask != 0 && executable:
+		return 0o777 &^ p.Umask.Perm()
+	case p.Umask != 0:
+		return 0o666 &^ p.Umask.Perm()
+	default:
+		return archived.Perm()
+	}
+}
@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{name: "leading zero", input: "0755", want: 0o755},
+		{name: "octal prefix", input: "0o644", want: 0o644},
+		{name: "umask", input: "022", want: 0o022},
+		{name: "empty", input: "", wantErr: true},
+		{name: "not octal", input: "0789", wantErr: true},
+		{name: "symbolic", input: "u+rwx", wantErr: true},
+		{name: "setuid is not supported", input: "4755", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMode(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPermissionsMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		perms    Permissions
+		archived os.FileMode
+		want     os.FileMode
+	}{
+		{name: "archived file", archived: 0o600, want: 0o600},
+		{name: "archived directory", archived: os.ModeDir | 0o700, want: 0o700},
+		{name: "dir mode", perms: Permissions{DirMode: 0o755}, archived: os.ModeDir | 0o700, want: 0o755},
+		{name: "dir mode ignores files", perms: Permissions{DirMode: 0o755}, archived: 0o600, want: 0o600},
+		{name: "file mode", perms: Permissions{FileMode: 0o644}, archived: 0o600, want: 0o644},
+		{name: "file mode executable", perms: Permissions{FileMode: 0o644}, archived: 0o700, want: 0o755},
+		{name: "file mode executable by owner only", perms: Permissions{FileMode: 0o640}, archived: 0o700, want: 0o750},
+		{name: "file mode ignores directories", perms: Permissions{FileMode: 0o644}, archived: os.ModeDir | 0o700, want: 0o700},
+		{name: "umask file", perms: Permissions{Umask: 0o022}, archived: 0o600, want: 0o644},
+		{name: "umask executable", perms: Permissions{Umask: 0o022}, archived: 0o700, want: 0o755},
+		{name: "umask directory", perms: Permissions{Umask: 0o027}, archived: os.ModeDir | 0o700, want: 0o750},
+		{name: "dir mode overrides umask", perms: Permissions{DirMode: 0o700, Umask: 0o022}, archived: os.ModeDir | 0o755, want: 0o700},
+		{name: "file mode overrides umask", perms: Permissions{FileMode: 0o600, Umask: 0o022}, archived: 0o644, want: 0o600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.perms.mode(tt.archived))
+		})
+	}
+}
+
+func TestExtractFilesWithOptions_Permissions(t *testing.T) {
+	for _, format := range []string{FormatZip, FormatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			assert := require.New(t)
+
+			f, archiveInfo, goBuildDir := buildTestTarGz(t, BuildOptions{Format: format})
+
+			_, err := ExtractFilesWithOptions(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+				Permissions: Permissions{DirMode: 0o750, Umask: 0o022},
+			})
+			assert.NoError(err)
+
+			for path, want := range map[string]os.FileMode{
+				goBuildDir:                                    os.ModeDir | 0o750,
+				filepath.Join(goBuildDir, "nested"):           os.ModeDir | 0o750,
+				filepath.Join(goBuildDir, "cache.txt"):        0o644,
+				filepath.Join(goBuildDir, "nested/other.txt"): 0o644,
+			} {
+				info, err := os.Stat(path)
+				assert.NoError(err)
+				assert.Equal(want, info.Mode(), path)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/zip"
+)
+
+// referencesEntryName is the name of the archive entry holding the references
+// recorded by BuildArchiveWithOptions with References. It isn't extracted to
+// disk.
+const referencesEntryName = ".zstash-references.json"
+
+// maxReferencesEntrySize limits the size of the references entry read into
+// memory.
+const maxReferencesEntrySize = 1 << 20
+
+// Reference names another cache entry holding paths left out of an archive,
+// such as paths shared by several caches which are archived once, so they
+// can be restored along with it.
+type Reference struct {
+	// Key is the cache key of the entry holding the paths.
+	Key string `json:"key"`
+	// Paths are the cache paths archived in the entry.
+	Paths []string `json:"paths"`
+}
+
+// referencesEntry is the content of the references entry.
+type referencesEntry struct {
+	References []Reference `json:"references"`
+}
+
+// ReadReferences returns the references recorded in an archive, or nil if it
+// was built without any. Only zip archives record references.
+func ReadReferences(ctx context.Context, zipFile *os.File, zipFileLen int64) ([]Reference, error) {
+	_, span := trace.Start(ctx, "ReadReferences")
+	defer span.End()
+
+	format, err := DetectFormat(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatZip {
+		return nil, nil
+	}
+
+	reader, err := newZipReader(zipFile, zipFileLen)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range reader.File {
+		if file.Name == referencesEntryName {
+			return readReferencesEntry(file)
+		}
+	}
+
+	return nil, nil
+}
+
+func readReferencesEntry(file *zip.File) ([]Reference, error) {
+	if file.UncompressedSize64 > maxReferencesEntrySize {
+		return nil, fmt.Errorf("references entry is too large: %d bytes", file.UncompressedSize64)
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open references entry: %w", err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var entry referencesEntry
+	if err := json.NewDecoder(io.LimitReader(r, maxReferencesEntrySize)).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to decode references entry: %w", err)
+	}
+
+	return entry.References, nil
+}
@@ -0,0 +1,63 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadReferences(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	npmDir := filepath.Join(home, ".npm")
+	assert.NoError(os.MkdirAll(npmDir, 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(npmDir, "index.json"), []byte("{}"), 0o600))
+
+	references := []Reference{{Key: "shared-abc123", Paths: []string{"node_modules"}}}
+
+	archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"~/.npm"}, "npm-cache", BuildOptions{References: references})
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	got, err := ReadReferences(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Equal(references, got)
+
+	// the references aren't listed or extracted
+	entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.NotContains(entries, referencesEntryName)
+
+	assert.NoError(os.RemoveAll(npmDir))
+	_, err = ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.npm"})
+	assert.NoError(err)
+	assert.NoFileExists(filepath.Join(home, referencesEntryName))
+	assert.FileExists(filepath.Join(npmDir, "index.json"))
+
+	_, err = BuildArchiveWithOptions(context.Background(), []string{"~/.npm"}, "npm-cache", BuildOptions{References: references, Format: FormatTarGz})
+	assert.ErrorContains(err, "references aren't supported in tar.gz archives")
+}
+
+func TestReadReferences_NoReferences(t *testing.T) {
+	assert := require.New(t)
+
+	zipFile, archiveInfo, _ := buildTestArchive(t)
+
+	references, err := ReadReferences(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Nil(references)
+}
@@ -0,0 +1,78 @@
+package archive
+
+import "sort"
+
+// largestFilesLimit is the number of largest files recorded for each path.
+const largestFilesLimit = 5
+
+// PathStats is a breakdown of the entries archived or extracted for a single cache path.
+type PathStats struct {
+	// Path is the cache path as configured.
+	Path string
+	// Entries is the number of files, directories and symlinks.
+	Entries int64
+	// Bytes is the uncompressed size of all files.
+	Bytes int64
+	// LargestFiles lists the largest files, largest first.
+	LargestFiles []FileStats
+}
+
+// FileStats is the size of a single file.
+type FileStats struct {
+	Path string
+	Size int64
+}
+
+// pathStatsCollector accumulates PathStats, preserving the order paths were first seen.
+type pathStatsCollector struct {
+	order []string
+	stats map[string]*PathStats
+}
+
+func newPathStatsCollector() *pathStatsCollector {
+	return &pathStatsCollector{stats: make(map[string]*PathStats)}
+}
+
+// add records an entry for the cache path, only regular files contribute to
+// the bytes and largest files.
+func (c *pathStatsCollector) add(path, file string, size int64, regular bool) {
+	stats, ok := c.stats[path]
+	if !ok {
+		stats = &PathStats{Path: path}
+		c.stats[path] = stats
+		c.order = append(c.order, path)
+	}
+
+	stats.Entries++
+
+	if !regular {
+		return
+	}
+
+	stats.Bytes += size
+
+	if len(stats.LargestFiles) == largestFilesLimit && size <= stats.LargestFiles[largestFilesLimit-1].Size {
+		return
+	}
+
+	i := sort.Search(len(stats.LargestFiles), func(i int) bool {
+		return stats.LargestFiles[i].Size < size
+	})
+
+	stats.LargestFiles = append(stats.LargestFiles, FileStats{})
+	copy(stats.LargestFiles[i+1:], stats.LargestFiles[i:])
+	stats.LargestFiles[i] = FileStats{Path: file, Size: size}
+
+	if len(stats.LargestFiles) > largestFilesLimit {
+		stats.LargestFiles = stats.LargestFiles[:largestFilesLimit]
+	}
+}
+
+// result returns the collected stats in the order paths were first seen.
+func (c *pathStatsCollector) result() []PathStats {
+	result := make([]PathStats, 0, len(c.order))
+	for _, path := range c.order {
+		result = append(result, *c.stats[path])
+	}
+	return result
+}
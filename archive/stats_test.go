@@ -0,0 +1,39 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathStatsCollector(t *testing.T) {
+	assert := require.New(t)
+
+	c := newPathStatsCollector()
+
+	c.add("node_modules", "node_modules", 0, false)
+	for i, size := range []int64{10, 70, 30, 50, 20, 60, 40} {
+		c.add("node_modules", string(rune('a'+i)), size, true)
+	}
+	c.add("vendor", "vendor/link", 100, false)
+	c.add("vendor", "vendor/gem", 5, true)
+
+	stats := c.result()
+	assert.Len(stats, 2)
+
+	assert.Equal("node_modules", stats[0].Path)
+	assert.Equal(int64(8), stats[0].Entries)
+	assert.Equal(int64(280), stats[0].Bytes)
+	assert.Equal([]FileStats{
+		{Path: "b", Size: 70},
+		{Path: "f", Size: 60},
+		{Path: "d", Size: 50},
+		{Path: "g", Size: 40},
+		{Path: "c", Size: 30},
+	}, stats[0].LargestFiles)
+
+	assert.Equal("vendor", stats[1].Path)
+	assert.Equal(int64(2), stats[1].Entries)
+	assert.Equal(int64(5), stats[1].Bytes, "only regular files count towards bytes")
+	assert.Equal([]FileStats{{Path: "vendor/gem", Size: 5}}, stats[1].LargestFiles)
+}
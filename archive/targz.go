@@ -0,0 +1,681 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/klauspost/compress/gzip"
+)
+
+// Archive formats built by BuildArchiveWithOptions and extracted by
+// ExtractFilesWithOptions.
+const (
+	// FormatZip is a zip archive, the default.
+	FormatZip = "zip"
+
+	// FormatTarGz is a gzip compressed tar archive, as produced by other
+	// cache tools, for compatibility with their caches.
+	FormatTarGz = "tar.gz"
+
+	// FormatTarZst is a zstd compressed tar archive, which can be compressed
+	// and decompressed by the zstd binary, see BuildOptions.ExternalZstd.
+	FormatTarZst = "tar.zst"
+)
+
+// ErrUnsupportedFormat is returned by operations which only support zip
+// archives, such as ReadManifest and CompareFiles, for tar archives.
+var ErrUnsupportedFormat = errors.New("unsupported archive format")
+
+// gzipMagic are the leading bytes of gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DetectFormat returns the name of the format registered with RegisterFormat
+// which the archive read from r is in, otherwise FormatTarGz if it is gzip
+// compressed, FormatTarZst if it is zstd compressed, and FormatZip otherwise.
+func DetectFormat(r io.ReaderAt) (string, error) {
+	format, err := detectRegisteredFormat(r)
+	if err != nil || format != "" {
+		return format, err
+	}
+
+	magic := make([]byte, len(zstdMagic))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic[:n], gzipMagic):
+		return FormatTarGz, nil
+	case bytes.Equal(magic[:n], zstdMagic):
+		return FormatTarZst, nil
+	}
+
+	return FormatZip, nil
+}
+
+// isTarFormat reports whether format is a compressed tar archive.
+func isTarFormat(format string) bool {
+	return format == FormatTarGz || format == FormatTarZst
+}
+
+// validFormat reports whether format is a supported archive format, including
+// those registered with RegisterFormat. The empty format is valid and treated
+// as FormatZip.
+func validFormat(format string) bool {
+	switch format {
+	case "", FormatZip, FormatTarGz, FormatTarZst:
+		return true
+	default:
+		return registeredFormat(format) != nil
+	}
+}
+
+// tarArchiver writes files to a gzip or zstd compressed tar archive, with the
+// same entry names as quickzip.Archiver. It implements Archiver.
+type tarArchiver struct {
+	cw       io.WriteCloser
+	tw       *tar.Writer
+	modified time.Time // replaces the modification time of every entry if set
+	written  int64
+	entries  int64
+}
+
+// newTarArchiver creates a tarArchiver writing an archive in format, tar.gz
+// or tar.zst, to w. Entries are stored without gzip compression, or with the
+// fastest zstd compression, with precompressed. tar.zst archives are
+// compressed by the zstd binary with external, if it is installed.
+func newTarArchiver(ctx context.Context, w io.Writer, format string, modified time.Time, precompressed, external bool) (*tarArchiver, error) {
+	var (
+		cw  io.WriteCloser
+		err error
+	)
+	if format == FormatTarZst {
+		cw, err = newZstdWriter(ctx, w, precompressed, external)
+	} else {
+		level := gzip.DefaultCompression
+		if precompressed {
+			level = gzip.NoCompression
+		}
+		cw, err = gzip.NewWriterLevel(w, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarArchiver{cw: cw, tw: tar.NewWriter(cw), modified: modified}, nil
+}
+
+// Archive archives the files, symlinks and directories beneath chroot, in
+// name order so archives of the same files are identical.
+func (a *tarArchiver) Archive(ctx context.Context, chroot string, files map[string]os.FileInfo) error {
+	chroot, err := filepath.Abs(chroot)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, filename := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fi := files[filename]
+		if fi == nil || fi.Mode()&irregularModes != 0 {
+			continue
+		}
+
+		name, err := entryName(chroot, filename, fi.IsDir())
+		if err != nil {
+			return err
+		}
+
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%s cannot be archived from outside of chroot (%s)", filename, chroot)
+		}
+
+		if err := a.archiveFile(ctx, filename, name, fi); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", filename, err)
+		}
+	}
+
+	return nil
+}
+
+func (a *tarArchiver) archiveFile(ctx context.Context, filename, name string, fi os.FileInfo) error {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filename)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = name
+	// ownership isn't archived, matching zip archives
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	// PAX records modification times with nanosecond precision
+	hdr.Format = tar.FormatPAX
+	if !a.modified.IsZero() {
+		hdr.ModTime = a.modified
+	}
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag == tar.TypeReg {
+		f, err := os.Open(filename) // #nosec G304 -- filename is a cached file
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		written, err := io.Copy(a.tw, bufio.NewReader(f))
+		a.written += written
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	a.entries++
+
+	return nil
+}
+
+// Written returns how many bytes of file content and entries have been
+// written to the archive.
+func (a *tarArchiver) Written() (bytes, entries int64) {
+	return a.written, a.entries
+}
+
+// Close finishes writing the archive.
+func (a *tarArchiver) Close() error {
+	if err := a.tw.Close(); err != nil {
+		_ = a.cw.Close()
+		return err
+	}
+
+	return a.cw.Close()
+}
+
+// tarEntry is a tar archive entry paired with its destination on disk.
+type tarEntry struct {
+	hdr    *tar.Header
+	path   string
+	source string // the cache path the entry was mapped from
+}
+
+// newTarReader opens a reader for the gzip or zstd compressed tar archive in
+// f. zstd compressed archives are decompressed by the zstd binary with
+// external, if it is installed.
+func newTarReader(ctx context.Context, f io.ReaderAt, size int64, external bool) (*tar.Reader, func() error, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(f, 0, size), bufferSize)
+
+	magic, _ := br.Peek(len(zstdMagic))
+	if bytes.Equal(magic, zstdMagic) {
+		zr, closeReader, err := newZstdReader(ctx, br, external)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zstd reader: %w", err)
+		}
+		return tar.NewReader(zr), closeReader, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open gzip reader: %w", err)
+	}
+
+	return tar.NewReader(gz), gz.Close, nil
+}
+
+// tarEntryName returns the name of a tar entry as it would be named in a zip
+// archive, removing the "./" prefix written by tar when archiving ".". The
+// entry for "." itself has an empty name.
+func tarEntryName(hdr *tar.Header) string {
+	name := strings.TrimPrefix(hdr.Name, "./")
+	if name == "" || name == "." {
+		return ""
+	}
+
+	if hdr.Typeflag == tar.TypeDir && !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+
+	return name
+}
+
+// nextTarEntry reads the next tar entry which maps to one of the included
+// paths and files, returning io.EOF once all entries have been read. Entries which
+// can't be extracted, such as devices and metadata, are skipped.
+func nextTarEntry(tr *tar.Reader, mappings []Mapping, root string, included map[string]bool, files fileFilter) (tarEntry, error) {
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return tarEntry{}, err
+		}
+
+		name := tarEntryName(hdr)
+		if name == "" || isMetadataEntry(name) {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue
+		}
+
+		mapping, ok := findMapping(mappings, name)
+		if !ok {
+			return tarEntry{}, fmt.Errorf("failed to find path mapping for: %s", name)
+		}
+
+		if included != nil && !included[mapping.Path] || !files.match(name) {
+			continue
+		}
+
+		chroot := mapping.Chroot
+		if root != "" {
+			chroot = stagedChroot(root, mapping)
+		}
+
+		dest, err := destinationPath(chroot, name)
+		if err != nil {
+			return tarEntry{}, err
+		}
+
+		return tarEntry{hdr: hdr, path: dest, source: mapping.Path}, nil
+	}
+}
+
+// tarIncluded returns the set of included paths, or nil if all paths are
+// included, returning an error if an included path isn't one of the paths.
+func tarIncluded(paths, include []string) (map[string]bool, error) {
+	if len(include) == 0 {
+		return nil, nil
+	}
+
+	included := make(map[string]bool, len(include))
+	for _, path := range include {
+		if !slices.Contains(paths, path) {
+			return nil, fmt.Errorf("included path %q is not one of the archive paths", path)
+		}
+		included[path] = true
+	}
+
+	return included, nil
+}
+
+// listTarConflicts returns the destination paths of archived files and
+// symlinks which already exist on disk.
+func listTarConflicts(ctx context.Context, f io.ReaderAt, size int64, paths []string, opts ExtractOptions) ([]string, error) {
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	included, err := tarIncluded(paths, opts.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := newFileFilter(paths, opts.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, closeReader, err := newTarReader(ctx, f, size, opts.ExternalZstd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = closeReader()
+	}()
+
+	var conflicts []string
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entry, err := nextTarEntry(tr, mappings, opts.Root, included, files)
+		if errors.Is(err, io.EOF) {
+			return conflicts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		if entry.hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		exists, err := pathExists(entry.path)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			conflicts = append(conflicts, entry.path)
+		}
+	}
+}
+
+// pathExists reports whether a file, directory or symlink exists at path.
+func pathExists(path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("failed to stat %s: %w", path, err)
+}
+
+// extractTar extracts a gzip or zstd compressed tar archive, see
+// ExtractFilesWithOptions. Entries are read sequentially, so files are written
+// one at a time.
+func extractTar(ctx context.Context, f *os.File, size int64, paths []string, opts ExtractOptions, onConflict ConflictPolicy) (*ArchiveInfo, error) {
+	start := time.Now()
+
+	mappings, err := PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	included, err := tarIncluded(paths, opts.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := newFileFilter(paths, opts.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	// conflicts must be found before any files are written
+	if onConflict == ConflictFail {
+		conflicts, err := listTarConflicts(ctx, f, size, paths, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			for _, conflict := range conflicts {
+				logging.FromContext(ctx).Error("extract conflict", "path", conflict, "policy", onConflict)
+			}
+			return nil, fmt.Errorf("%w: %d existing files, including %s", ErrExtractConflict, len(conflicts), conflicts[0])
+		}
+	}
+
+	if len(opts.Include) > 0 {
+		paths = opts.Include
+	}
+
+	tr, closeReader, err := newTarReader(ctx, f, size, opts.ExternalZstd)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = closeReader()
+	}()
+
+	x := &extractor{chown: opts.Chown, perms: opts.Permissions}
+	stats := newPathStatsCollector()
+	foundPaths := make(map[string]bool)
+
+	var (
+		skipped, overwritten []string
+		dirs, symlinks       []tarEntry
+	)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to extract tar file: %w", err)
+		}
+
+		entry, err := nextTarEntry(tr, mappings, opts.Root, included, files)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		foundPaths[entry.source] = true
+
+		if entry.hdr.Typeflag != tar.TypeDir {
+			exists, err := pathExists(entry.path)
+			if err != nil {
+				return nil, err
+			}
+
+			if exists {
+				if onConflict == ConflictSkip {
+					logging.FromContext(ctx).Info("extract conflict", "path", entry.path, "policy", onConflict, "action", "skipped")
+					skipped = append(skipped, entry.path)
+					continue
+				}
+				logging.FromContext(ctx).Debug("extract conflict", "path", entry.path, "policy", onConflict, "action", "overwritten")
+				overwritten = append(overwritten, entry.path)
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(entry.path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to extract tar file: %w", err)
+		}
+
+		stats.add(entry.source, entry.path, entry.hdr.Size, entry.hdr.Typeflag == tar.TypeReg)
+
+		switch entry.hdr.Typeflag {
+		case tar.TypeDir:
+			if err := x.createDirectory(entry.path); err != nil {
+				return nil, fmt.Errorf("failed to extract tar file: %w", err)
+			}
+			dirs = append(dirs, entry)
+		case tar.TypeSymlink:
+			// created last, so files can't be extracted through them
+			symlinks = append(symlinks, entry)
+		default:
+			if err := x.createTarFile(ctx, tr, entry); err != nil {
+				return nil, fmt.Errorf("failed to extract tar file: %w", err)
+			}
+		}
+	}
+
+	for _, entry := range symlinks {
+		if err := x.createTarSymlink(entry); err != nil {
+			return nil, fmt.Errorf("failed to extract tar file: %w", err)
+		}
+	}
+
+	// directory metadata is applied last, otherwise modification times would
+	// be updated by the files written into them
+	for _, entry := range dirs {
+		if err := x.updateTarMetadata(entry); err != nil {
+			return nil, fmt.Errorf("failed to extract tar file: %w", err)
+		}
+	}
+
+	for _, path := range paths {
+		if !foundPaths[path] {
+			logging.FromContext(ctx).Warn("requested path not found in archive", "path", path)
+		}
+	}
+
+	if len(skipped) > 0 || len(overwritten) > 0 {
+		logging.FromContext(ctx).Info("extract conflicts resolved", "policy", onConflict, "skipped", len(skipped), "overwritten", len(overwritten))
+	}
+
+	return &ArchiveInfo{
+		ArchivePath:    f.Name(),
+		Size:           size,
+		WrittenBytes:   x.written.Load(),
+		WrittenEntries: x.entries.Load(),
+		Duration:       time.Since(start),
+		Skipped:        skipped,
+		Overwritten:    overwritten,
+		PathStats:      stats.result(),
+	}, nil
+}
+
+func (x *extractor) createTarFile(ctx context.Context, r io.Reader, entry tarEntry) (err error) {
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(entry.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		// remove partially written files, e.g. when extraction is cancelled
+		if err != nil {
+			_ = os.Remove(entry.path)
+		}
+	}()
+
+	n, err := io.Copy(countWriter{w: f, written: &x.written, ctx: ctx}, r)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", entry.hdr.Name, err)
+	}
+
+	if n != entry.hdr.Size {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrTruncatedEntry, entry.hdr.Name, n, entry.hdr.Size)
+	}
+
+	if err := x.updateTarMetadata(entry); err != nil {
+		return err
+	}
+
+	if err := x.updateOwnership(entry.path); err != nil {
+		return err
+	}
+
+	x.entries.Add(1)
+
+	return nil
+}
+
+func (x *extractor) createTarSymlink(entry tarEntry) error {
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Symlink(entry.hdr.Linkname, entry.path); err != nil {
+		return err
+	}
+
+	if err := x.updateOwnership(entry.path); err != nil {
+		return err
+	}
+
+	x.entries.Add(1)
+
+	return nil
+}
+
+// updateTarMetadata applies the permissions and the archived modification
+// time.
+func (x *extractor) updateTarMetadata(entry tarEntry) error {
+	if err := os.Chmod(entry.path, x.perms.mode(entry.hdr.FileInfo().Mode())); err != nil {
+		return err
+	}
+
+	return os.Chtimes(entry.path, time.Now(), entry.hdr.ModTime)
+}
+
+// listTar returns the names of the entries in a compressed tar archive.
+func listTar(ctx context.Context, r io.ReaderAt, size int64) ([]string, error) {
+	tr, closeReader, err := newTarReader(ctx, r, size, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = closeReader()
+	}()
+
+	var entries []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		name := tarEntryName(hdr)
+		if name == "" || isMetadataEntry(name) {
+			continue
+		}
+		entries = append(entries, name)
+	}
+}
+
+// inspectTar counts the entries and uncompressed bytes of regular files in
+// a compressed tar archive.
+func inspectTar(ctx context.Context, r io.ReaderAt, size int64, info *ArchiveInfo) error {
+	tr, closeReader, err := newTarReader(ctx, r, size, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = closeReader()
+	}()
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		if isMetadataEntry(tarEntryName(hdr)) {
+			continue
+		}
+		info.WrittenEntries++
+		if hdr.Typeflag == tar.TypeReg {
+			info.WrittenBytes += hdr.Size
+		}
+	}
+}
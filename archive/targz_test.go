@@ -0,0 +1,269 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestTarGz builds a tar.gz archive, or a tar archive in opts.Format, of ~/.go-build containing a symlink
+// and two files, one nested, and removes the source directory, returning the
+// open archive.
+func buildTestTarGz(t *testing.T, opts BuildOptions) (*os.File, *ArchiveInfo, string) {
+	t.Helper()
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(filepath.Join(goBuildDir, "nested"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "cache.txt"), []byte("build cache data"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(goBuildDir, "nested", "other.txt"), []byte("other data"), 0o640))
+	assert.NoError(os.Symlink("cache.txt", filepath.Join(goBuildDir, "link.txt")))
+
+	if opts.Format == "" {
+		opts.Format = FormatTarGz
+	}
+
+	archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"~/.go-build"}, "go-cache", opts)
+	assert.NoError(err)
+	t.Cleanup(func() { _ = os.Remove(archiveInfo.ArchivePath) })
+
+	assert.NoError(os.RemoveAll(goBuildDir))
+
+	f, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	return f, archiveInfo, goBuildDir
+}
+
+func TestBuildAndExtractTarGz(t *testing.T) {
+	assert := require.New(t)
+
+	f, archiveInfo, goBuildDir := buildTestTarGz(t, BuildOptions{})
+
+	assert.Equal(".gz", filepath.Ext(archiveInfo.ArchivePath))
+	assert.Equal(int64(5), archiveInfo.WrittenEntries)
+	assert.Equal(int64(len("build cache data")+len("other data")), archiveInfo.WrittenBytes)
+
+	format, err := DetectFormat(f)
+	assert.NoError(err)
+	assert.Equal(FormatTarGz, format)
+
+	entries, err := ListArchive(context.Background(), f, archiveInfo.Size)
+	assert.NoError(err)
+	assert.ElementsMatch([]string{".go-build/", ".go-build/cache.txt", ".go-build/link.txt", ".go-build/nested/", ".go-build/nested/other.txt"}, entries)
+
+	inspected, err := InspectArchive(context.Background(), archiveInfo.ArchivePath)
+	assert.NoError(err)
+	assert.Equal(archiveInfo.Sha256sum, inspected.Sha256sum)
+	assert.Equal(archiveInfo.WrittenEntries, inspected.WrittenEntries)
+	assert.Equal(archiveInfo.WrittenBytes, inspected.WrittenBytes)
+
+	extractInfo, err := ExtractFiles(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal(archiveInfo.WrittenEntries, extractInfo.WrittenEntries)
+	assert.Equal(archiveInfo.WrittenBytes, extractInfo.WrittenBytes)
+
+	content, err := os.ReadFile(filepath.Join(goBuildDir, "nested", "other.txt"))
+	assert.NoError(err)
+	assert.Equal("other data", string(content))
+
+	info, err := os.Stat(filepath.Join(goBuildDir, "nested", "other.txt"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0o640), info.Mode().Perm())
+
+	target, err := os.Readlink(filepath.Join(goBuildDir, "link.txt"))
+	assert.NoError(err)
+	assert.Equal("cache.txt", target)
+
+	modified, err := time.Parse(time.RFC3339, modifiedEpoch)
+	assert.NoError(err)
+	assert.True(info.ModTime().Equal(modified), "modification times should be fixed by default")
+
+	_, err = ReadManifest(context.Background(), f, archiveInfo.Size)
+	assert.ErrorIs(err, ErrNoManifest)
+}
+
+func TestBuildArchiveWithOptions_TarGzPreserveMtimes(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	filePath := filepath.Join(goBuildDir, "cache.txt")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+	assert.NoError(os.WriteFile(filePath, []byte("build cache data"), 0o600))
+
+	mtime := time.Date(2023, 6, 15, 10, 30, 0, 123456789, time.UTC)
+	assert.NoError(os.Chtimes(filePath, mtime, mtime))
+
+	archiveInfo, err := BuildArchiveWithOptions(context.Background(), []string{"~/.go-build"}, "go-cache", BuildOptions{
+		Format:         FormatTarGz,
+		PreserveMtimes: true,
+	})
+	assert.NoError(err)
+	defer os.Remove(archiveInfo.ArchivePath)
+
+	assert.NoError(os.RemoveAll(goBuildDir))
+
+	f, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	defer f.Close()
+
+	_, err = ExtractFiles(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+
+	info, err := os.Stat(filePath)
+	assert.NoError(err)
+	assert.True(info.ModTime().Equal(mtime), "modification times should be preserved")
+}
+
+func TestBuildArchiveWithOptions_InvalidFormat(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := BuildArchiveWithOptions(context.Background(), []string{"testdata"}, "test", BuildOptions{Format: "rar"})
+	assert.ErrorIs(err, ErrUnsupportedFormat)
+
+	_, err = BuildArchiveWithOptions(context.Background(), []string{"testdata"}, "test", BuildOptions{Format: FormatTarGz, Manifest: true})
+	assert.ErrorContains(err, "manifests aren't supported")
+}
+
+func TestExtractTarGz_OnConflict(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          ConflictPolicy
+		wantErr         error
+		wantContent     string
+		wantSkipped     int
+		wantOverwritten int
+	}{
+		{name: "overwrite", policy: ConflictOverwrite, wantContent: "build cache data", wantOverwritten: 1},
+		{name: "skip", policy: ConflictSkip, wantContent: "local changes", wantSkipped: 1},
+		{name: "fail", policy: ConflictFail, wantErr: ErrExtractConflict, wantContent: "local changes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			f, archiveInfo, goBuildDir := buildTestTarGz(t, BuildOptions{})
+
+			existing := filepath.Join(goBuildDir, "cache.txt")
+			assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+			assert.NoError(os.WriteFile(existing, []byte("local changes"), 0o600))
+
+			conflicts, err := ListConflicts(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{})
+			assert.NoError(err)
+			assert.Equal([]string{existing}, conflicts)
+
+			extractInfo, err := ExtractFilesWithOptions(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+				OnConflict: tt.policy,
+			})
+			if tt.wantErr != nil {
+				assert.ErrorIs(err, tt.wantErr)
+				assert.NoFileExists(filepath.Join(goBuildDir, "nested", "other.txt"), "no files should be written when failing on conflict")
+			} else {
+				assert.NoError(err)
+				assert.Len(extractInfo.Skipped, tt.wantSkipped)
+				assert.Len(extractInfo.Overwritten, tt.wantOverwritten)
+				assert.FileExists(filepath.Join(goBuildDir, "nested", "other.txt"))
+			}
+
+			content, err := os.ReadFile(existing)
+			assert.NoError(err)
+			assert.Equal(tt.wantContent, string(content))
+		})
+	}
+}
+
+func TestExtractTarGz_Root(t *testing.T) {
+	assert := require.New(t)
+
+	f, archiveInfo, goBuildDir := buildTestTarGz(t, BuildOptions{})
+
+	root := t.TempDir()
+
+	_, err := ExtractFilesWithOptions(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Root: root,
+	})
+	assert.NoError(err)
+
+	content, err := os.ReadFile(filepath.Join(root, "home", ".go-build", "cache.txt"))
+	assert.NoError(err)
+	assert.Equal("build cache data", string(content))
+
+	assert.NoDirExists(goBuildDir, "files should only be extracted beneath the root")
+}
+
+func TestExtractTarGz_Files(t *testing.T) {
+	assert := require.New(t)
+
+	f, archiveInfo, goBuildDir := buildTestTarGz(t, BuildOptions{})
+
+	_, err := ExtractFilesWithOptions(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{
+		Files: []string{"~/.go-build/nested"},
+	})
+	assert.NoError(err)
+
+	assert.FileExists(filepath.Join(goBuildDir, "nested", "other.txt"))
+	assert.NoFileExists(filepath.Join(goBuildDir, "cache.txt"))
+	assert.NoFileExists(filepath.Join(goBuildDir, "link.txt"))
+}
+
+func TestExtractTarGz_ExternalArchive(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// archives created by tar -C ~ ./.go-build prefix entries with "./", and
+	// may hold entries which can't be extracted
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755}))
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "./.go-build", Typeflag: tar.TypeDir, Mode: 0o755}))
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "./.go-build/cache.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 4}))
+	_, err = tw.Write([]byte("data"))
+	assert.NoError(err)
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: "./.go-build/fifo", Typeflag: tar.TypeFifo, Mode: 0o644}))
+	assert.NoError(tw.Close())
+	assert.NoError(gz.Close())
+
+	archivePath := filepath.Join(t.TempDir(), "cache.tar.gz")
+	assert.NoError(os.WriteFile(archivePath, buf.Bytes(), 0o600))
+
+	f, err := os.Open(archivePath)
+	assert.NoError(err)
+	defer f.Close()
+
+	extractInfo, err := ExtractFiles(context.Background(), f, int64(buf.Len()), []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal(int64(2), extractInfo.WrittenEntries)
+
+	content, err := os.ReadFile(filepath.Join(home, ".go-build", "cache.txt"))
+	assert.NoError(err)
+	assert.Equal("data", string(content))
+	assert.NoFileExists(filepath.Join(home, ".go-build", "fifo"))
+}
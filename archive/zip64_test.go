@@ -0,0 +1,175 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/klauspost/compress/zip"
+	"github.com/stretchr/testify/require"
+)
+
+// skipUnlessZip64Test skips tests which write several GiB to disk or create
+// tens of thousands of files.
+func skipUnlessZip64Test(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv("ZIP64_INTEGRATION_TEST") == "" {
+		t.Skip("Skipping Zip64 integration test (set ZIP64_INTEGRATION_TEST=1 to run)")
+	}
+}
+
+// buildAndOpenArchive builds an archive of ~/.go-build and removes the source
+// directory, returning the open archive.
+func buildAndOpenArchive(t *testing.T, goBuildDir string) (*os.File, *ArchiveInfo) {
+	t.Helper()
+	assert := require.New(t)
+
+	archiveInfo, err := BuildArchive(context.Background(), []string{"~/.go-build"}, "go-cache")
+	assert.NoError(err)
+	t.Cleanup(func() { _ = os.Remove(archiveInfo.ArchivePath) })
+
+	assert.NoError(os.RemoveAll(goBuildDir))
+
+	zipFile, err := os.Open(archiveInfo.ArchivePath)
+	assert.NoError(err)
+	t.Cleanup(func() { _ = zipFile.Close() })
+
+	return zipFile, archiveInfo
+}
+
+func TestBuildAndExtractArchive_Zip64LargeFile(t *testing.T) {
+	skipUnlessZip64Test(t)
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	assert.NoError(os.MkdirAll(goBuildDir, 0o755))
+
+	// a sparse file larger than 4GiB, with a marker at the end to detect truncation
+	const size = math.MaxUint32 + 1<<20
+	marker := []byte("end of large file")
+
+	largePath := filepath.Join(goBuildDir, "model.bin")
+	f, err := os.Create(largePath)
+	assert.NoError(err)
+	assert.NoError(f.Truncate(size))
+	_, err = f.WriteAt(marker, size-int64(len(marker)))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	zipFile, archiveInfo := buildAndOpenArchive(t, goBuildDir)
+
+	reader, err := zip.NewReader(zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Len(reader.File, 2)
+	assert.Equal(uint64(size), reader.File[1].UncompressedSize64)
+
+	extractInfo, err := ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal(int64(size), extractInfo.WrittenBytes)
+
+	info, err := os.Stat(largePath)
+	assert.NoError(err)
+	assert.Equal(int64(size), info.Size())
+
+	f, err = os.Open(largePath)
+	assert.NoError(err)
+	defer f.Close()
+
+	got := make([]byte, len(marker))
+	_, err = f.ReadAt(got, size-int64(len(marker)))
+	assert.NoError(err)
+	assert.Equal(marker, got)
+}
+
+func TestBuildAndExtractArchive_Zip64ManyEntries(t *testing.T) {
+	skipUnlessZip64Test(t)
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// more entries than fit in the 16 bit count of a standard zip archive
+	const dirs, filesPerDir = 70, 1000
+
+	goBuildDir := filepath.Join(home, ".go-build")
+	for i := range dirs {
+		dir := filepath.Join(goBuildDir, fmt.Sprintf("%02x", i))
+		assert.NoError(os.MkdirAll(dir, 0o755))
+		for j := range filesPerDir {
+			assert.NoError(os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.txt", j)), []byte(fmt.Sprintf("%d-%d", i, j)), 0o600))
+		}
+	}
+
+	zipFile, archiveInfo := buildAndOpenArchive(t, goBuildDir)
+
+	// the root directory and each sub directory are entries too
+	wantEntries := 1 + dirs + dirs*filesPerDir
+	assert.Greater(wantEntries, math.MaxUint16)
+
+	entries, err := ListArchive(context.Background(), zipFile, archiveInfo.Size)
+	assert.NoError(err)
+	assert.Len(entries, wantEntries)
+
+	extractInfo, err := ExtractFiles(context.Background(), zipFile, archiveInfo.Size, []string{"~/.go-build"})
+	assert.NoError(err)
+	assert.Equal(int64(wantEntries), extractInfo.WrittenEntries)
+
+	got, err := os.ReadFile(filepath.Join(goBuildDir, "45", "999.txt"))
+	assert.NoError(err)
+	assert.Equal("69-999", string(got))
+}
+
+func TestExtractFiles_TruncatedEntry(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := trace.NewProvider(context.Background(), "noop", "test", "0.0.1")
+	assert.NoError(err)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// an entry whose header records more data than the archive contains
+	archivePath := filepath.Join(t.TempDir(), "truncated.zip")
+	out, err := os.Create(archivePath)
+	assert.NoError(err)
+
+	zw := zip.NewWriter(out)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               ".go-build/cache.txt",
+		Method:             zip.Store,
+		CompressedSize64:   4,
+		UncompressedSize64: 1 << 20,
+	})
+	assert.NoError(err)
+	_, err = io.WriteString(w, "data")
+	assert.NoError(err)
+	assert.NoError(zw.Close())
+	assert.NoError(out.Close())
+
+	zipFile, err := os.Open(archivePath)
+	assert.NoError(err)
+	defer zipFile.Close()
+
+	info, err := zipFile.Stat()
+	assert.NoError(err)
+
+	_, err = ExtractFiles(context.Background(), zipFile, info.Size(), []string{"~/.go-build"})
+	assert.ErrorIs(err, io.ErrUnexpectedEOF)
+	assert.Contains(err.Error(), ".go-build/cache.txt")
+	assert.NoFileExists(filepath.Join(home, ".go-build", "cache.txt"))
+}
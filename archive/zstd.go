@@ -0,0 +1,208 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic are the leading bytes of a zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// zstdBinary is the name of the zstd command line tool used with
+// BuildOptions.ExternalZstd and ExtractOptions.ExternalZstd.
+const zstdBinary = "zstd"
+
+// lookZstd returns the path of the zstd binary if external compression is
+// enabled and it is installed, otherwise an empty string.
+func lookZstd(ctx context.Context, external bool) string {
+	if !external {
+		return ""
+	}
+
+	path, err := exec.LookPath(zstdBinary)
+	if err != nil {
+		logging.FromContext(ctx).Debug("zstd binary not found, compressing in process", "error", err)
+		return ""
+	}
+
+	return path
+}
+
+// newZstdWriter returns a writer compressing to w with zstd, using the zstd
+// binary on all cores if external is set and it is installed. Precompressed
+// content is compressed at the fastest level.
+func newZstdWriter(ctx context.Context, w io.Writer, precompressed, external bool) (io.WriteCloser, error) {
+	if path := lookZstd(ctx, external); path != "" {
+		level := "-3"
+		if precompressed {
+			level = "-1"
+		}
+		return startZstdCommand(ctx, path, w, "-q", "-c", "-T0", level)
+	}
+
+	level := zstd.SpeedDefault
+	if precompressed {
+		level = zstd.SpeedFastest
+	}
+
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+}
+
+// newZstdReader returns a reader decompressing r with zstd, using the zstd
+// binary if external is set and it is installed. The returned function
+// releases the reader.
+func newZstdReader(ctx context.Context, r io.Reader, external bool) (io.Reader, func() error, error) {
+	if path := lookZstd(ctx, external); path != "" {
+		zr, err := startZstdDecompress(ctx, path, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return zr, func() error {
+		zr.Close()
+		return nil
+	}, nil
+}
+
+// zstdCommand compresses the data written to it with the zstd binary.
+type zstdCommand struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+	done   bool
+	err    error
+}
+
+func startZstdCommand(ctx context.Context, path string, w io.Writer, args ...string) (*zstdCommand, error) {
+	cmd := exec.CommandContext(ctx, path, args...) // #nosec G204 -- path is the zstd binary
+	cmd.Stdout = w
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zstd input: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start zstd: %w", err)
+	}
+
+	return &zstdCommand{cmd: cmd, stdin: stdin, stderr: stderr}, nil
+}
+
+func (c *zstdCommand) Write(p []byte) (int, error) {
+	if c.done {
+		return 0, c.wait()
+	}
+
+	n, err := c.stdin.Write(p)
+	if err != nil {
+		// the write fails when zstd exits, so report why it did
+		if werr := c.wait(); werr != nil {
+			return n, werr
+		}
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Close finishes compressing, waiting for zstd to write the compressed data.
+func (c *zstdCommand) Close() error {
+	return c.wait()
+}
+
+// wait closes the input of zstd and waits for it to exit.
+func (c *zstdCommand) wait() error {
+	if !c.done {
+		c.done = true
+		_ = c.stdin.Close()
+		c.err = zstdError(c.cmd.Wait(), c.stderr)
+	}
+
+	return c.err
+}
+
+// zstdDecompressor reads data decompressed by the zstd binary.
+type zstdDecompressor struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func startZstdDecompress(ctx context.Context, path string, r io.Reader) (*zstdDecompressor, error) {
+	cmd := exec.CommandContext(ctx, path, "-q", "-d", "-c") // #nosec G204 -- path is the zstd binary
+	cmd.Stdin = r
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zstd output: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start zstd: %w", err)
+	}
+
+	return &zstdDecompressor{cmd: cmd, stdout: stdout, stderr: stderr}, nil
+}
+
+// Read reads decompressed data, returning an error rather than io.EOF if
+// zstd failed, e.g. for corrupt data.
+func (d *zstdDecompressor) Read(p []byte) (int, error) {
+	n, err := d.stdout.Read(p)
+	if errors.Is(err, io.EOF) && !d.done {
+		d.done = true
+		if werr := zstdError(d.cmd.Wait(), d.stderr); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+// Close stops zstd if the data hasn't been read in full.
+func (d *zstdDecompressor) Close() error {
+	if d.done {
+		return nil
+	}
+	d.done = true
+
+	_ = d.cmd.Process.Kill()
+	_ = d.cmd.Wait()
+
+	return nil
+}
+
+// zstdError describes the failure of the zstd binary with its output.
+func zstdError(err error, stderr *bytes.Buffer) error {
+	if err == nil {
+		return nil
+	}
+
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("zstd failed: %w: %s", err, msg)
+	}
+
+	return fmt.Errorf("zstd failed: %w", err)
+}
@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAndExtractTarZst(t *testing.T) {
+	tests := []struct {
+		name            string
+		externalBuild   bool
+		externalExtract bool
+	}{
+		{name: "in process"},
+		{name: "external", externalBuild: true, externalExtract: true},
+		{name: "external build", externalBuild: true},
+		{name: "external extract", externalExtract: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			if tt.externalBuild || tt.externalExtract {
+				if _, err := exec.LookPath(zstdBinary); err != nil {
+					t.Skip("zstd binary not installed")
+				}
+			}
+
+			f, archiveInfo, goBuildDir := buildTestTarGz(t, BuildOptions{Format: FormatTarZst, ExternalZstd: tt.externalBuild})
+
+			assert.Equal(".zst", filepath.Ext(archiveInfo.ArchivePath))
+			assert.Equal(int64(5), archiveInfo.WrittenEntries)
+
+			format, err := DetectFormat(f)
+			assert.NoError(err)
+			assert.Equal(FormatTarZst, format)
+
+			entries, err := ListArchive(context.Background(), f, archiveInfo.Size)
+			assert.NoError(err)
+			assert.ElementsMatch([]string{".go-build/", ".go-build/cache.txt", ".go-build/link.txt", ".go-build/nested/", ".go-build/nested/other.txt"}, entries)
+
+			inspected, err := InspectArchive(context.Background(), archiveInfo.ArchivePath)
+			assert.NoError(err)
+			assert.Equal(archiveInfo.WrittenBytes, inspected.WrittenBytes)
+
+			extractInfo, err := ExtractFilesWithOptions(context.Background(), f, archiveInfo.Size, []string{"~/.go-build"}, ExtractOptions{ExternalZstd: tt.externalExtract})
+			assert.NoError(err)
+			assert.Equal(archiveInfo.WrittenEntries, extractInfo.WrittenEntries)
+
+			content, err := os.ReadFile(filepath.Join(goBuildDir, "nested", "other.txt"))
+			assert.NoError(err)
+			assert.Equal("other data", string(content))
+
+			_, err = ReadManifest(context.Background(), f, archiveInfo.Size)
+			assert.ErrorIs(err, ErrNoManifest)
+		})
+	}
+}
+
+func TestExtractTarZst_ExternalCorrupt(t *testing.T) {
+	assert := require.New(t)
+
+	if _, err := exec.LookPath(zstdBinary); err != nil {
+		t.Skip("zstd binary not installed")
+	}
+
+	f, archiveInfo, _ := buildTestTarGz(t, BuildOptions{Format: FormatTarZst})
+
+	data, err := os.ReadFile(archiveInfo.ArchivePath)
+	assert.NoError(err)
+
+	// corrupt the compressed data after the frame header
+	for i := len(zstdMagic) + 8; i < len(data); i++ {
+		data[i] ^= 0xff
+	}
+
+	corruptPath := filepath.Join(t.TempDir(), "corrupt.tar.zst")
+	assert.NoError(os.WriteFile(corruptPath, data, 0o600))
+	assert.NoError(f.Close())
+
+	corrupt, err := os.Open(corruptPath)
+	assert.NoError(err)
+	defer corrupt.Close()
+
+	_, err = ExtractFilesWithOptions(context.Background(), corrupt, int64(len(data)), []string{"~/.go-build"}, ExtractOptions{ExternalZstd: true})
+	assert.Error(err)
+}
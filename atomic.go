@@ -0,0 +1,189 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// atomicRestoreSuffix is appended to the name of a cache path to name the
+// temporary sibling directories its entries are extracted to by an atomic
+// restore, e.g. ".node_modules.zstash-restore-123".
+const atomicRestoreSuffix = ".zstash-restore-"
+
+// extractAtomic extracts the entries of each path into a temporary directory
+// beside it, then swaps it into place with renames, so an interrupted restore
+// leaves either the previous or the restored files rather than a partially
+// written path.
+//
+// If a path can't be renamed, e.g. as it is a mount point, its previous files
+// are removed and the entries extracted into place instead.
+func (c *Cache) extractAtomic(ctx context.Context, archiveFile string, archiveSize int64, paths, restorePaths []string, opts archive.ExtractOptions) (*archive.ArchiveInfo, error) {
+	var result *archive.ArchiveInfo
+
+	for _, path := range restorePaths {
+		info, err := c.extractAtomicPath(ctx, archiveFile, archiveSize, paths, path, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = info
+			continue
+		}
+
+		result.WrittenBytes += info.WrittenBytes
+		result.WrittenEntries += info.WrittenEntries
+		result.Duration += info.Duration
+		result.PathStats = append(result.PathStats, info.PathStats...)
+		result.Skipped = append(result.Skipped, info.Skipped...)
+		result.Overwritten = append(result.Overwritten, info.Overwritten...)
+	}
+
+	if result == nil {
+		return &archive.ArchiveInfo{ArchivePath: archiveFile, Size: archiveSize}, nil
+	}
+
+	return result, nil
+}
+
+// extractAtomicPath extracts the entries of a single path and swaps them into
+// place.
+func (c *Cache) extractAtomicPath(ctx context.Context, archiveFile string, archiveSize int64, paths []string, path string, opts archive.ExtractOptions) (*archive.ArchiveInfo, error) {
+	resolvedPath, err := archive.ResolveHomeDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve home dir for %q: %w", path, err)
+	}
+
+	target, err := filepath.Abs(resolvedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create parent directory of %q: %w", target, err)
+	}
+
+	// remove the leftovers of restores which were interrupted
+	removeStaleRestores(ctx, target)
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+atomicRestoreSuffix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer func() {
+		// clean up even if the restore was cancelled
+		if err := cleanPath(context.WithoutCancel(ctx), tmpDir); err != nil {
+			logging.FromContext(ctx).Warn("failed to remove restore directory", "path", tmpDir, "error", err)
+		}
+	}()
+
+	stagedOpts := opts
+	stagedOpts.Root = tmpDir
+	stagedOpts.Include = []string{path}
+
+	info, err := c.extractCache(ctx, archiveFile, archiveSize, paths, stagedOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := archive.PathsToMappings([]string{path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	err = swapPath(archive.StagedPath(tmpDir, mappings[0]), target, filepath.Join(tmpDir, "previous"))
+	if err == nil {
+		return info, nil
+	}
+	if !isCrossDevice(err) {
+		return nil, fmt.Errorf("failed to swap restored path %q into place: %w", target, err)
+	}
+
+	logging.FromContext(ctx).Warn("failed to rename restored path into place, extracting in place instead",
+		"path", target,
+		"error", err,
+	)
+
+	if err := cleanPath(ctx, target); err != nil {
+		return nil, fmt.Errorf("failed to clean path %q: %w", target, err)
+	}
+
+	inPlaceOpts := opts
+	inPlaceOpts.Include = []string{path}
+
+	return c.extractCache(ctx, archiveFile, archiveSize, paths, inPlaceOpts)
+}
+
+// swapPath replaces target with staged, moving target to previous first. If
+// staged doesn't exist, as the archive has no entries for the path, target is
+// only moved. target is moved back if staged can't be renamed into place.
+func swapPath(staged, target, previous string) error {
+	hasStaged := true
+	if _, err := os.Lstat(staged); errors.Is(err, fs.ErrNotExist) {
+		hasStaged = false
+	} else if err != nil {
+		return err
+	}
+
+	hasTarget := true
+	if _, err := os.Lstat(target); errors.Is(err, fs.ErrNotExist) {
+		hasTarget = false
+	} else if err != nil {
+		return err
+	}
+
+	if hasTarget {
+		if err := os.Rename(target, previous); err != nil {
+			return err
+		}
+	}
+
+	if !hasStaged {
+		return nil
+	}
+
+	if err := os.Rename(staged, target); err != nil {
+		if hasTarget {
+			_ = os.Rename(previous, target)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// isCrossDevice reports whether a rename failed as the paths are on different
+// filesystems, or the path is a mount point, which can't be renamed.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EBUSY)
+}
+
+// removeStaleRestores removes the temporary directories of interrupted atomic
+// restores of target. Failures are logged, as they don't affect the restore.
+func removeStaleRestores(ctx context.Context, target string) {
+	entries, err := os.ReadDir(filepath.Dir(target))
+	if err != nil {
+		return
+	}
+
+	prefix := "." + filepath.Base(target) + atomicRestoreSuffix
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+
+		stale := filepath.Join(filepath.Dir(target), entry.Name())
+		if err := cleanPath(ctx, stale); err != nil {
+			logging.FromContext(ctx).Warn("failed to remove interrupted restore", "path", stale, "error", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapPath(t *testing.T) {
+	t.Run("replaces existing path", func(t *testing.T) {
+		dir := t.TempDir()
+		staged := filepath.Join(dir, "staged")
+		target := filepath.Join(dir, "target")
+		previous := filepath.Join(dir, "previous")
+		require.NoError(t, os.MkdirAll(staged, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(staged, "new.txt"), []byte("new"), 0o600))
+		require.NoError(t, os.MkdirAll(target, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(target, "old.txt"), []byte("old"), 0o600))
+
+		require.NoError(t, swapPath(staged, target, previous))
+
+		assert.FileExists(t, filepath.Join(target, "new.txt"))
+		assert.NoFileExists(t, filepath.Join(target, "old.txt"))
+		assert.FileExists(t, filepath.Join(previous, "old.txt"))
+		assert.NoDirExists(t, staged)
+	})
+
+	t.Run("creates missing path", func(t *testing.T) {
+		dir := t.TempDir()
+		staged := filepath.Join(dir, "staged")
+		target := filepath.Join(dir, "target")
+		require.NoError(t, os.MkdirAll(staged, 0o755))
+
+		require.NoError(t, swapPath(staged, target, filepath.Join(dir, "previous")))
+
+		assert.DirExists(t, target)
+	})
+
+	t.Run("removes path missing from archive", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "target")
+		require.NoError(t, os.MkdirAll(target, 0o755))
+
+		require.NoError(t, swapPath(filepath.Join(dir, "staged"), target, filepath.Join(dir, "previous")))
+
+		assert.NoDirExists(t, target)
+	})
+
+	t.Run("restores previous path on failure", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(dir, "target")
+		// staged is moved away with the target, so can't be renamed into place
+		staged := filepath.Join(target, "staged")
+		require.NoError(t, os.MkdirAll(staged, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(target, "old.txt"), []byte("old"), 0o600))
+
+		err := swapPath(staged, target, filepath.Join(dir, "previous"))
+		require.Error(t, err)
+
+		assert.FileExists(t, filepath.Join(target, "old.txt"))
+		assert.NoDirExists(t, filepath.Join(dir, "previous"))
+	})
+}
+
+func TestIsCrossDevice(t *testing.T) {
+	assert.True(t, isCrossDevice(&os.LinkError{Op: "rename", Err: syscall.EXDEV}))
+	assert.True(t, isCrossDevice(fmt.Errorf("wrapped: %w", &os.LinkError{Op: "rename", Err: syscall.EBUSY})))
+	assert.False(t, isCrossDevice(&os.LinkError{Op: "rename", Err: syscall.EACCES}))
+}
+
+func TestRemoveStaleRestores(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "node_modules")
+	stale := filepath.Join(dir, ".node_modules"+atomicRestoreSuffix+"123")
+	other := filepath.Join(dir, ".other"+atomicRestoreSuffix+"123")
+	require.NoError(t, os.MkdirAll(filepath.Join(stale, "workdir"), 0o755))
+	require.NoError(t, os.MkdirAll(other, 0o755))
+	require.NoError(t, os.MkdirAll(target, 0o755))
+
+	removeStaleRestores(context.Background(), target)
+
+	assert.NoDirExists(t, stale)
+	assert.DirExists(t, other)
+	assert.DirExists(t, target)
+}
@@ -0,0 +1,38 @@
+package zstash
+
+import "os"
+
+// RegionEnv is the environment variable used to select a bucket from
+// Config.BucketURLs when Config.Region isn't set.
+const RegionEnv = "AGENT_REGION"
+
+// resolveRegion returns the configured region, falling back to RegionEnv in
+// env, or the OS environment if env is nil.
+func resolveRegion(region string, env map[string]string) string {
+	if region != "" {
+		return region
+	}
+
+	if env != nil {
+		return env[RegionEnv]
+	}
+
+	return os.Getenv(RegionEnv)
+}
+
+// bucketURLFor returns the bucket URL used for a store type, preferring the
+// bucket for the agent's region, then the bucket for the store type, then
+// Config.BucketURL.
+func (c *Cache) bucketURLFor(storeType string) string {
+	if c.region != "" {
+		if bucketURL, ok := c.bucketURLs[c.region]; ok {
+			return bucketURL
+		}
+	}
+
+	if bucketURL, ok := c.bucketURLs[storeType]; ok {
+		return bucketURL
+	}
+
+	return c.bucketURL
+}
@@ -0,0 +1,49 @@
+package zstash
+
+import (
+	"testing"
+
+	"github.com/buildkite/zstash/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_bucketURLFor(t *testing.T) {
+	bucketURLs := map[string]string{
+		"us-east-1":        "s3://cache-us-east-1",
+		"eu-west-1":        "s3://cache-eu-west-1",
+		store.LocalS3Store: "s3://cache-default",
+	}
+
+	tests := []struct {
+		name      string
+		region    string
+		storeType string
+		want      string
+	}{
+		{name: "region", region: "eu-west-1", storeType: store.LocalS3Store, want: "s3://cache-eu-west-1"},
+		{name: "unknown region uses store type", region: "ap-southeast-2", storeType: store.LocalS3Store, want: "s3://cache-default"},
+		{name: "store type", storeType: store.LocalS3Store, want: "s3://cache-default"},
+		{name: "default", storeType: store.LocalHTTPStore, want: "https://artifacts.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cache{
+				bucketURL:  "https://artifacts.example.com",
+				bucketURLs: bucketURLs,
+				region:     tt.region,
+			}
+
+			assert.Equal(t, tt.want, c.bucketURLFor(tt.storeType))
+		})
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	t.Setenv(RegionEnv, "us-east-1")
+
+	assert.Equal(t, "eu-west-1", resolveRegion("eu-west-1", nil))
+	assert.Equal(t, "us-east-1", resolveRegion("", nil))
+	assert.Equal(t, "ap-southeast-2", resolveRegion("", map[string]string{RegionEnv: "ap-southeast-2"}))
+	assert.Empty(t, resolveRegion("", map[string]string{}))
+}
@@ -1,25 +1,40 @@
 package zstash
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/buildkite/zstash/archive"
 	"github.com/buildkite/zstash/cache"
 	"github.com/buildkite/zstash/configuration"
+	"github.com/buildkite/zstash/store"
 )
 
 // NewCache creates and validates a new cache client.
 //
 // The function performs the following steps:
 //  1. Validates the configuration (Client must be provided)
-//  2. Sets defaults for Format (zip) and Platform (runtime.GOOS/runtime.GOARCH)
-//  3. Expands cache templates using cfg.Env if provided, otherwise uses OS environment
+//  2. Sets defaults for Format (zip), Platform (runtime.GOOS/runtime.GOARCH)
+//     and Logger (slog.Default())
+//  3. Expands cache templates using cfg.Env if provided, otherwise uses OS environment,
+//     and cfg.Platform for the platform function
 //  4. Validates all expanded cache configurations
-//  5. Returns a ready-to-use cache client
+//  5. Appends a hash of each cache's definition to its keys if cfg.VersionKeys is set
+//  6. Warns about paths included in more than one cache (see PathOverlaps)
+//  7. Returns a ready-to-use cache client
 //
 // The returned Cache client is safe for concurrent use by multiple goroutines.
 //
 // Returns ErrInvalidConfiguration (wrapped) if:
+//   - Platform contains commas or whitespace
+//   - KeyPrefix is invalid
 //   - Template expansion fails
 //   - Cache validation fails (invalid paths, missing required fields, etc.)
 //
@@ -45,7 +60,7 @@ func NewCache(cfg Config) (*Cache, error) {
 
 	// Set defaults
 	if cfg.Format == "" {
-		cfg.Format = "zip"
+		cfg.Format = archive.FormatZip
 	}
 
 	if cfg.Platform == "" {
@@ -56,26 +71,57 @@ func NewCache(cfg Config) (*Cache, error) {
 		cfg.Registry = "~"
 	}
 
-	var (
-		err error
-		// Expand cache configurations
-		expandedCaches []cache.Cache
-	)
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
 
-	if cfg.Env != nil {
-		// If environment is provided, expand cache templates
-		expandedCaches, err = configuration.ExpandCacheConfigurationWithEnv(cfg.Caches, cfg.Env)
-		if err != nil {
-			return nil, fmt.Errorf("%w: failed to expand cache configuration: %w", ErrInvalidConfiguration, err)
+	// The platform is sent to the API and used in fallback key lists, which
+	// are comma separated
+	if strings.ContainsAny(cfg.Platform, ", \t\r\n") {
+		return nil, fmt.Errorf("%w: platform cannot contain commas or whitespace: %q", ErrInvalidConfiguration, cfg.Platform)
+	}
+
+	if formats := archive.Formats(); !slices.Contains(formats, cfg.Format) {
+		return nil, fmt.Errorf("%w: unsupported archive format %q, expected one of %s", ErrInvalidConfiguration, cfg.Format, strings.Join(formats, ", "))
+	}
+
+	if cfg.KeyPrefix != "" {
+		if err := store.ValidateKeyPrefix(cfg.KeyPrefix); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfiguration, err)
 		}
-	} else {
-		// Use OS environment for expansion
-		expandedCaches, err = configuration.ExpandCacheConfiguration(cfg.Caches)
+	}
+
+	var transport *http.Transport
+	if cfg.Proxy != "" || cfg.CABundle != "" {
+		var err error
+		transport, err = store.NewTransport(store.NetworkOptions{Proxy: cfg.Proxy, CABundle: cfg.CABundle})
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to expand cache configuration: %w", ErrInvalidConfiguration, err)
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfiguration, err)
 		}
 	}
 
+	// Expand cache templates, using the OS environment when cfg.Env is nil
+	expandedCaches, err := configuration.ExpandCacheConfigurationWithOptions(cfg.Caches, configuration.Options{
+		Env:      cfg.Env,
+		Platform: cfg.Platform,
+		Logger:   cfg.Logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to expand cache configuration: %w", ErrInvalidConfiguration, err)
+	}
+
+	if cfg.TransferConcurrency < 0 {
+		return nil, fmt.Errorf("%w: transfer concurrency cannot be negative: %d", ErrInvalidConfiguration, cfg.TransferConcurrency)
+	}
+
+	if !cfg.ProgressLog.IsValid() {
+		return nil, fmt.Errorf("%w: invalid progress log mode: %q", ErrInvalidConfiguration, cfg.ProgressLog)
+	}
+
+	if cfg.MaxArchiveSize < 0 {
+		return nil, fmt.Errorf("%w: max archive size cannot be negative: %d", ErrInvalidConfiguration, cfg.MaxArchiveSize)
+	}
+
 	// Validate all caches
 	for _, c := range expandedCaches {
 		if err := c.Validate(); err != nil {
@@ -83,10 +129,39 @@ func NewCache(cfg Config) (*Cache, error) {
 		}
 	}
 
+	if cfg.VersionKeys {
+		expandedCaches, err = versionKeys(expandedCaches, cfg.Format)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to version cache keys: %w", ErrInvalidConfiguration, err)
+		}
+
+		// versioned keys are longer, so may exceed the API's key limits
+		for _, c := range expandedCaches {
+			if err := c.Validate(); err != nil {
+				return nil, fmt.Errorf("%w: cache validation failed for ID %s: %w", ErrInvalidConfiguration, c.ID, err)
+			}
+		}
+	}
+
+	// Warn about paths archived by more than one cache, as they are uploaded
+	// once for each cache which includes them
+	overlaps := findPathOverlaps(expandedCaches)
+	for _, overlap := range overlaps {
+		cfg.Logger.Warn("cache paths overlap, files will be archived more than once",
+			"cache_id", overlap.CacheID,
+			"path", overlap.Path,
+			"other_cache_id", overlap.OtherCacheID,
+			"other_path", overlap.OtherPath,
+		)
+	}
+
 	return &Cache{
 		client:       cfg.Client,
 		bucketURL:    cfg.BucketURL,
+		bucketURLs:   cfg.BucketURLs,
+		region:       resolveRegion(cfg.Region, cfg.Env),
 		format:       cfg.Format,
+		externalZstd: cfg.ExternalZstd,
 		branch:       cfg.Branch,
 		pipeline:     cfg.Pipeline,
 		organization: cfg.Organization,
@@ -94,11 +169,30 @@ func NewCache(cfg Config) (*Cache, error) {
 		registry:     cfg.Registry,
 		caches:       expandedCaches,
 		onProgress:   cfg.OnProgress,
+		progressLog:  resolveProgressLog(cfg.ProgressLog, cfg.Env),
+		events:       cfg.Events,
+
+		maxArchiveSize:         cfg.MaxArchiveSize,
+		warnOnArchiveSizeLimit: cfg.WarnOnArchiveSizeLimit,
+		chunkedStorage:         cfg.ChunkedStorage,
+		uploadTimeout:          transferTimeout(cfg.UploadTimeout),
+		downloadTimeout:        transferTimeout(cfg.DownloadTimeout),
+		overlaps:               overlaps,
+		reporter:               newReporter(cfg.Reporter, cfg.StatsLedger),
+		keepArchiveDir:         cfg.KeepArchiveDir,
+		keyPrefix:              cfg.KeyPrefix,
+		transferLimiter:        store.NewTransferLimiter(cfg.TransferConcurrency),
+		transport:              transport,
+		registryCacheTTL:       registryCacheTTL(cfg.RegistryCacheTTL),
+		logger:                 cfg.Logger,
 	}, nil
 }
 
-// callProgress safely calls the progress callback if it exists
-func (c *Cache) callProgress(cacheID string, stage string, message string, current int, total int) {
+// callProgress records the stage of the operation, see withStageTimings, and
+// safely calls the progress callback if it exists
+func (c *Cache) callProgress(ctx context.Context, cacheID string, stage string, message string, current int, total int) {
+	stageTimingsFrom(ctx).enter(stage, time.Now())
+
 	if c.onProgress != nil {
 		// Protect against panics in user-provided callback
 		defer func() {
@@ -117,3 +211,73 @@ func (c *Cache) findCache(id string) (*cache.Cache, error) {
 	}
 	return nil, ErrCacheNotFound
 }
+
+// cacheScope holds the scoping fields sent to the cache API for a cache.
+type cacheScope struct {
+	branch       string
+	pipeline     string
+	organization string
+}
+
+// scopeFor returns the scoping fields for a cache, clearing the fields which
+// are narrower than the cache's Scope so entries are shared more widely.
+func (c *Cache) scopeFor(cacheConfig *cache.Cache) cacheScope {
+	scope := cacheScope{
+		branch:       c.branch,
+		pipeline:     c.pipeline,
+		organization: c.organization,
+	}
+
+	switch cacheConfig.Scope {
+	case cache.ScopePipeline:
+		scope.branch = ""
+	case cache.ScopeOrganization:
+		scope.branch = ""
+		scope.pipeline = ""
+	}
+
+	return scope
+}
+
+// extractPermissionsFor returns the permissions applied to the files and
+// directories restored for a cache.
+func extractPermissionsFor(cacheConfig *cache.Cache) archive.Permissions {
+	return archive.Permissions{
+		DirMode:  cacheConfig.ExtractDirMode,
+		FileMode: cacheConfig.ExtractFileMode,
+		Umask:    cacheConfig.ExtractUmask,
+	}
+}
+
+// transferTimeout returns the configured transfer timeout, applying the
+// default when zero. Zero is returned when the limit is disabled.
+func transferTimeout(timeout time.Duration) time.Duration {
+	switch {
+	case timeout == 0:
+		return DefaultTransferTimeout
+	case timeout < 0:
+		return 0
+	default:
+		return timeout
+	}
+}
+
+// withTransferTimeout returns a context limited to timeout, or ctx unchanged
+// if timeout is zero.
+func withTransferTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// transferTimeoutError describes err as a timeout when the transfer context's
+// deadline was exceeded, rather than the parent context being cancelled.
+func transferTimeoutError(ctx, transferCtx context.Context, timeout time.Duration, err error) error {
+	if ctx.Err() == nil && errors.Is(transferCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+
+	return err
+}
@@ -2,10 +2,72 @@ package cache
 
 import (
 	"fmt"
+	"os"
 	"regexp"
 	"strings"
 )
 
+// Scope controls which builds share a cache entry.
+type Scope string
+
+const (
+	// ScopeBranch shares cache entries between builds of the same branch of a
+	// pipeline (default).
+	ScopeBranch Scope = "branch"
+	// ScopePipeline shares cache entries between all branches of a pipeline.
+	ScopePipeline Scope = "pipeline"
+	// ScopeOrganization shares cache entries between all pipelines of an
+	// organization, e.g. for toolchains.
+	ScopeOrganization Scope = "organization"
+)
+
+// IsValid reports whether s is a supported scope. The empty scope is valid and
+// treated as ScopeBranch.
+func (s Scope) IsValid() bool {
+	switch s {
+	case "", ScopeBranch, ScopePipeline, ScopeOrganization:
+		return true
+	default:
+		return false
+	}
+}
+
+// MissingPathsPolicy controls how saves treat cache paths which are missing or
+// are empty directories.
+type MissingPathsPolicy string
+
+const (
+	// MissingPathsError fails the save when a path is missing or empty.
+	MissingPathsError MissingPathsPolicy = "error"
+	// MissingPathsWarn logs a warning for each missing or empty path and
+	// saves the other paths.
+	MissingPathsWarn MissingPathsPolicy = "warn"
+	// MissingPathsSkip saves the other paths without a warning.
+	MissingPathsSkip MissingPathsPolicy = "skip"
+)
+
+// IsValid reports whether p is a supported policy. The empty policy is valid,
+// failing the save when a path is missing but archiving empty directories.
+func (p MissingPathsPolicy) IsValid() bool {
+	switch p {
+	case "", MissingPathsError, MissingPathsWarn, MissingPathsSkip:
+		return true
+	default:
+		return false
+	}
+}
+
+// Limits of the keys accepted by the cache API.
+const (
+	// MaxKeyLength is the length of the longest key or fallback key.
+	MaxKeyLength = 512
+	// MaxFallbackKeys is the most fallback keys a cache can have.
+	MaxFallbackKeys = 20
+	// MaxFallbackKeysLength is the longest the fallback keys can be in total,
+	// joined with commas, as they are sent in a single query parameter.
+	MaxFallbackKeysLength = 4096
+)
+
 type Cache struct {
 	// Template of the cache entry.
 	Template string
@@ -19,6 +81,61 @@ type Cache struct {
 	FallbackKeys []string
 	// Paths to remove.
 	Paths []string
+	// MaxSize is the maximum size of the archive in bytes, overriding the
+	// client's MaxArchiveSize. Zero uses the client's limit.
+	MaxSize int64
+	// Scope controls which builds share the cache entry, defaults to ScopeBranch.
+	Scope Scope
+	// OnHit is a command run after restoring when the exact key was found.
+	OnHit string
+	// OnMiss is a command run after restoring when the exact key wasn't
+	// found, including when a fallback key was restored.
+	OnMiss string
+	// PreserveMtimes restores the modification times of archived files with
+	// nanosecond precision, for build tools which rely on them for
+	// incremental builds. By default archived files get a fixed time.
+	PreserveMtimes bool
+	// Precompressed stores archived files without compression, for paths
+	// holding content which is already compressed such as jars, wheels and
+	// tarballs, where compressing again takes time for little benefit.
+	Precompressed bool
+	// Manifest records the size, mode and checksum of every archived file in
+	// the archive, so restored files can be validated and the working tree
+	// compared with the cache entry cheaply.
+	Manifest bool
+	// StrictPlatform treats cache entries saved on a different platform, as
+	// recorded when they were saved, as a miss rather than restoring them,
+	// e.g. node_modules with native modules built for another OS or arch.
+	StrictPlatform bool
+	// Ignore lists patterns of files excluded from archives of the cache's
+	// paths, in addition to archive.DefaultIgnorePatterns, using .zstashignore
+	// syntax with anchored patterns relative to each path.
+	Ignore []string
+	// NoDefaultIgnore archives files matching archive.DefaultIgnorePatterns,
+	// such as .git directories, which are otherwise excluded.
+	NoDefaultIgnore bool
+	// AtomicRestore extracts each path into a temporary directory beside it
+	// and renames it into place, so an interrupted restore never leaves a
+	// partially restored path, e.g. a half-written node_modules.
+	AtomicRestore bool
+	// ExtractDirMode, if non-zero, is the permissions of restored
+	// directories, e.g. 0o755, rather than their archived permissions.
+	ExtractDirMode os.FileMode
+	// ExtractFileMode, if non-zero, is the permissions of restored files,
+	// e.g. 0o644, with archived executables also executable wherever it
+	// grants read.
+	ExtractFileMode os.FileMode
+	// ExtractUmask, if non-zero, gives restored files and directories
+	// without ExtractFileMode or ExtractDirMode the permissions they'd be
+	// created with under this umask, e.g. 0o022 for directories readable by
+	// other users, for archives saved on hosts with a restrictive umask.
+	ExtractUmask os.FileMode
+	// MissingPaths controls how saves treat paths which are missing or are
+	// empty directories. By default a missing path fails the save and empty
+	// directories are archived. With MissingPathsWarn or MissingPathsSkip
+	// they're left out of the archive, and the save is skipped if every path
+	// is missing or empty.
+	MissingPaths MissingPathsPolicy
 }
 
 // Validate validates the cache configuration and returns an error if invalid.
@@ -35,6 +152,16 @@ func (c Cache) Validate() error {
 	// Key validation: non-empty
 	if strings.TrimSpace(c.Key) == "" {
 		errors = append(errors, "key cannot be empty")
+	} else if len(c.Key) > MaxKeyLength {
+		errors = append(errors, fmt.Sprintf("key is %d characters, longer than the limit of %d", len(c.Key), MaxKeyLength))
+	}
+
+	// FallbackKeys validation: within the API's limits
+	if len(c.FallbackKeys) > MaxFallbackKeys {
+		errors = append(errors, fmt.Sprintf("%d fallback keys is more than the limit of %d", len(c.FallbackKeys), MaxFallbackKeys))
+	}
+	if length := len(strings.Join(c.FallbackKeys, ",")); length > MaxFallbackKeysLength {
+		errors = append(errors, fmt.Sprintf("fallback keys are %d characters in total, longer than the limit of %d", length, MaxFallbackKeysLength))
 	}
 
 	// FallbackKeys validation: no spaces allowed
@@ -43,6 +170,8 @@ func (c Cache) Validate() error {
 			errors = append(errors, fmt.Sprintf("fallback key at index %d cannot be empty", i))
 		} else if strings.Contains(fallbackKey, " ") {
 			errors = append(errors, fmt.Sprintf("fallback key at index %d cannot contain spaces: '%s'", i, fallbackKey))
+		} else if len(fallbackKey) > MaxKeyLength {
+			errors = append(errors, fmt.Sprintf("fallback key at index %d is %d characters, longer than the limit of %d", i, len(fallbackKey), MaxKeyLength))
 		}
 	}
 
@@ -59,6 +188,32 @@ func (c Cache) Validate() error {
 		}
 	}
 
+	// Scope validation: empty means branch scoped
+	if !c.Scope.IsValid() {
+		errors = append(errors, fmt.Sprintf("scope '%s' must be one of branch, pipeline or organization", c.Scope))
+	}
+
+	// MaxSize validation: zero means no per-cache limit
+	if c.MaxSize < 0 {
+		errors = append(errors, fmt.Sprintf("max size cannot be negative: %d", c.MaxSize))
+	}
+
+	// MissingPaths validation: empty means missing paths fail the save
+	if !c.MissingPaths.IsValid() {
+		errors = append(errors, fmt.Sprintf("missing paths '%s' must be one of skip, warn or error", c.MissingPaths))
+	}
+
+	// Extract permissions validation: zero means the archived permissions
+	if c.ExtractDirMode&^os.ModePerm != 0 {
+		errors = append(errors, fmt.Sprintf("extract dir mode %#o must be at most 0777", uint32(c.ExtractDirMode)))
+	}
+	if c.ExtractFileMode&^os.ModePerm != 0 {
+		errors = append(errors, fmt.Sprintf("extract file mode %#o must be at most 0777", uint32(c.ExtractFileMode)))
+	}
+	if c.ExtractUmask&^os.ModePerm != 0 {
+		errors = append(errors, fmt.Sprintf("extract umask %#o must be at most 0777", uint32(c.ExtractUmask)))
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf("cache validation failed for id '%s': %s", c.ID, strings.Join(errors, "; "))
 	}
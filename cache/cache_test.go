@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -32,6 +34,80 @@ func TestCacheValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid cache with max size",
+			cache: Cache{
+				ID:      "build",
+				Key:     "build-key",
+				Paths:   []string{"dist"},
+				MaxSize: 1024 * 1024 * 1024,
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid cache with organization scope",
+			cache: Cache{
+				ID:    "toolchain",
+				Key:   "go-1.25",
+				Paths: []string{"~/sdk"},
+				Scope: ScopeOrganization,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid scope",
+			cache: Cache{
+				ID:    "toolchain",
+				Key:   "go-1.25",
+				Paths: []string{"~/sdk"},
+				Scope: "global",
+			},
+			wantErr: true,
+			errMsg:  "scope 'global' must be one of branch, pipeline or organization",
+		},
+		{
+			name: "negative max size",
+			cache: Cache{
+				ID:      "build",
+				Key:     "build-key",
+				Paths:   []string{"dist"},
+				MaxSize: -1,
+			},
+			wantErr: true,
+			errMsg:  "max size cannot be negative",
+		},
+		{
+			name: "invalid missing paths policy",
+			cache: Cache{
+				ID:           "build",
+				Key:          "build-key",
+				Paths:        []string{"dist"},
+				MissingPaths: "ignore",
+			},
+			wantErr: true,
+			errMsg:  "missing paths 'ignore' must be one of skip, warn or error",
+		},
+		{
+			name: "valid extract permissions",
+			cache: Cache{
+				ID:             "build",
+				Key:            "build-key",
+				Paths:          []string{"dist"},
+				ExtractDirMode: 0o755,
+				ExtractUmask:   0o022,
+			},
+		},
+		{
+			name: "invalid extract file mode",
+			cache: Cache{
+				ID:              "build",
+				Key:             "build-key",
+				Paths:           []string{"dist"},
+				ExtractFileMode: 0o4755,
+			},
+			wantErr: true,
+			errMsg:  "extract file mode 04755 must be at most 0777",
+		},
 		{
 			name: "invalid ID with hyphen",
 			cache: Cache{
@@ -154,6 +230,49 @@ func TestCacheValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "key too long",
+			cache: Cache{
+				ID:    "valid_id",
+				Key:   strings.Repeat("k", MaxKeyLength+1),
+				Paths: []string{"node_modules"},
+			},
+			wantErr: true,
+			errMsg:  "key is 513 characters, longer than the limit of 512",
+		},
+		{
+			name: "fallback key too long",
+			cache: Cache{
+				ID:           "valid_id",
+				Key:          "valid-key",
+				FallbackKeys: []string{"fallback-key", strings.Repeat("k", MaxKeyLength+1)},
+				Paths:        []string{"node_modules"},
+			},
+			wantErr: true,
+			errMsg:  "fallback key at index 1 is 513 characters",
+		},
+		{
+			name: "too many fallback keys",
+			cache: Cache{
+				ID:           "valid_id",
+				Key:          "valid-key",
+				FallbackKeys: slices.Repeat([]string{"fallback-key"}, MaxFallbackKeys+1),
+				Paths:        []string{"node_modules"},
+			},
+			wantErr: true,
+			errMsg:  "21 fallback keys is more than the limit of 20",
+		},
+		{
+			name: "fallback keys too long in total",
+			cache: Cache{
+				ID:           "valid_id",
+				Key:          "valid-key",
+				FallbackKeys: slices.Repeat([]string{strings.Repeat("k", MaxKeyLength)}, 8),
+				Paths:        []string{"node_modules"},
+			},
+			wantErr: true,
+			errMsg:  "fallback keys are 4103 characters in total, longer than the limit of 4096",
+		},
 	}
 
 	for _, tt := range tests {
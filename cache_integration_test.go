@@ -1,31 +1,49 @@
 package zstash
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/archive"
 	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 // mockAPIClient implements api.CacheClient for integration testing
 type mockAPIClient struct {
-	registries map[string]*mockRegistry
+	// mu guards registries and their entries, as saves and restores of
+	// several caches call the client concurrently
+	mu            sync.Mutex
+	registries    map[string]*mockRegistry
+	registryCalls atomic.Int64
+	failCommit    bool
 }
 
 type mockRegistry struct {
-	name  string
-	store string
-	cache map[string]*mockCacheEntry
+	name        string
+	store       string
+	compression []string
+	cache       map[string]*mockCacheEntry
 }
 
 type mockCacheEntry struct {
@@ -62,21 +80,29 @@ func (m *mockAPIClient) Do(req *http.Request) (*http.Response, error) {
 }
 
 func (m *mockAPIClient) CacheRegistry(ctx context.Context, registry string) (api.CacheRegistryResp, error) {
+	m.registryCalls.Add(1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	reg, ok := m.registries[registry]
 	if !ok {
-		return api.CacheRegistryResp{}, fmt.Errorf("registry not found: %s", registry)
+		return api.CacheRegistryResp{}, fmt.Errorf("%w: %s", api.ErrCacheRegistryNotFound, registry)
 	}
 
 	return api.CacheRegistryResp{
-		Name:  reg.name,
-		Store: reg.store,
+		Name:        reg.name,
+		Store:       reg.store,
+		Compression: reg.compression,
 	}, nil
 }
 
 func (m *mockAPIClient) CachePeekExists(ctx context.Context, registry string, req api.CachePeekReq) (api.CachePeekResp, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	reg, ok := m.registries[registry]
 	if !ok {
-		return api.CachePeekResp{}, false, fmt.Errorf("registry not found: %s", registry)
+		return api.CachePeekResp{}, false, fmt.Errorf("%w: %s", api.ErrCacheRegistryNotFound, registry)
 	}
 
 	entry, exists := reg.cache[req.Key]
@@ -105,13 +131,31 @@ func (m *mockAPIClient) CachePeekExists(ctx context.Context, registry string, re
 }
 
 func (m *mockAPIClient) CacheCreate(ctx context.Context, registry string, req api.CacheCreateReq) (api.CacheCreateResp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	reg, ok := m.registries[registry]
 	if !ok {
-		return api.CacheCreateResp{}, fmt.Errorf("registry not found: %s", registry)
+		return api.CacheCreateResp{}, fmt.Errorf("%w: %s", api.ErrCacheRegistryNotFound, registry)
+	}
+
+	// an uncommitted entry is returned again, so an interrupted save can be resumed
+	if entry, exists := reg.cache[req.Key]; exists && !entry.committed {
+		pendingDigest := entry.digest
+		entry.digest = req.Digest
+		entry.fileSize = req.FileSize
+
+		return api.CacheCreateResp{
+			UploadID:        entry.uploadID,
+			StoreObjectName: entry.storeObjectName,
+			Pending:         true,
+			Digest:          pendingDigest,
+		}, nil
 	}
 
 	uploadID := fmt.Sprintf("upload-%d", time.Now().UnixNano())
-	storeObjectName := fmt.Sprintf("%s/%s/%s/%s", req.Organization, req.Pipeline, req.Branch, req.Key)
+	// empty scoping fields are dropped, as caches can be shared between branches and pipelines
+	storeObjectName := path.Join(req.Organization, req.Pipeline, req.Branch, req.Key)
 
 	entry := &mockCacheEntry{
 		key:             req.Key,
@@ -139,9 +183,16 @@ func (m *mockAPIClient) CacheCreate(ctx context.Context, registry string, req ap
 }
 
 func (m *mockAPIClient) CacheCommit(ctx context.Context, registry string, req api.CacheCommitReq) (api.CacheCommitResp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	reg, ok := m.registries[registry]
 	if !ok {
-		return api.CacheCommitResp{}, fmt.Errorf("registry not found: %s", registry)
+		return api.CacheCommitResp{}, fmt.Errorf("%w: %s", api.ErrCacheRegistryNotFound, registry)
+	}
+
+	if m.failCommit {
+		return api.CacheCommitResp{}, fmt.Errorf("commit interrupted")
 	}
 
 	for _, entry := range reg.cache {
@@ -155,13 +206,20 @@ func (m *mockAPIClient) CacheCommit(ctx context.Context, registry string, req ap
 }
 
 func (m *mockAPIClient) CacheRetrieve(ctx context.Context, registry string, req api.CacheRetrieveReq) (api.CacheRetrieveResp, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	reg, ok := m.registries[registry]
 	if !ok {
-		return api.CacheRetrieveResp{}, false, fmt.Errorf("registry not found: %s", registry)
+		return api.CacheRetrieveResp{}, false, fmt.Errorf("%w: %s", api.ErrCacheRegistryNotFound, registry)
 	}
 
+	// an uncommitted entry for the key is reported as pending
+	entry, exists := reg.cache[req.Key]
+	pending := exists && !entry.committed
+
 	// Try exact key match first
-	if entry, exists := reg.cache[req.Key]; exists && entry.committed {
+	if exists && entry.committed {
 		return api.CacheRetrieveResp{
 			Store:           reg.store,
 			Key:             entry.key,
@@ -169,6 +227,7 @@ func (m *mockAPIClient) CacheRetrieve(ctx context.Context, registry string, req
 			StoreObjectName: entry.storeObjectName,
 			ExpiresAt:       entry.expiresAt,
 			CompressionType: entry.compression,
+			FileSize:        entry.fileSize,
 		}, true, nil
 	}
 
@@ -186,12 +245,14 @@ func (m *mockAPIClient) CacheRetrieve(ctx context.Context, registry string, req
 					StoreObjectName: entry.storeObjectName,
 					ExpiresAt:       entry.expiresAt,
 					CompressionType: entry.compression,
+					FileSize:        entry.fileSize,
+					Pending:         pending,
 				}, true, nil
 			}
 		}
 	}
 
-	return api.CacheRetrieveResp{Message: api.CacheEntryNotFound}, false, nil
+	return api.CacheRetrieveResp{Message: api.CacheEntryNotFound, Pending: pending}, false, nil
 }
 
 // createRandomFile creates a file filled with random data
@@ -386,6 +447,30 @@ func TestCacheIntegration_SaveAndRestore(t *testing.T) {
 	})
 }
 
+func TestCacheIntegration_Stages(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	stageNames := func(stages []StageTiming) []string {
+		var names []string
+		for _, stage := range stages {
+			assert.False(t, stage.Start.IsZero())
+			assert.GreaterOrEqual(t, stage.Duration, time.Duration(0))
+			names = append(names, stage.Name)
+		}
+		return names
+	}
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"validating", "checking_exists", "fetching_registry", "building_archive", "creating_entry", "uploading", "committing"}, stageNames(saveResult.Stages))
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"validating", "checking_exists", "downloading", "cleaning", "extracting"}, stageNames(restoreResult.Stages))
+}
+
 func TestCacheIntegration_SaveAlreadyExists(t *testing.T) {
 	ctx := context.Background()
 
@@ -404,6 +489,123 @@ func TestCacheIntegration_SaveAlreadyExists(t *testing.T) {
 	assert.Equal(t, "v1-test-key", result2.Key)
 }
 
+func TestCacheIntegration_Peek(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	// Peek before saving
+	result, err := cacheClient.Peek(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.Exists, "cache should not exist before save")
+	assert.Equal(t, "v1-test-key", result.Key)
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, saveResult.CacheCreated)
+
+	// Peek after saving
+	result, err = cacheClient.Peek(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.Exists, "cache should exist after save")
+	assert.Equal(t, "v1-test-key", result.Key)
+	assert.Equal(t, "local_file", result.Store)
+	assert.Equal(t, "sha256:"+saveResult.Archive.Sha256Sum, result.Digest)
+	assert.Equal(t, saveResult.Archive.Size, result.FileSize)
+	assert.False(t, result.ExpiresAt.IsZero())
+
+	// Peek an unknown cache ID
+	_, err = cacheClient.Peek(ctx, "unknown")
+	require.ErrorIs(t, err, ErrCacheNotFound)
+}
+
+func TestCacheIntegration_PeekFallbackKeys(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	cacheClient.caches[0].Key = "v1-fallback-key"
+	cacheClient.caches[0].FallbackKeys = []string{}
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	cacheClient.caches[0].Key = "v1-test-key"
+	cacheClient.caches[0].FallbackKeys = []string{"v1-other-key", "v1-fallback-key"}
+
+	// Only the exact key is checked by default
+	result, err := cacheClient.Peek(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.Exists)
+
+	result, err = cacheClient.PeekWithOptions(ctx, "test-cache", PeekOptions{FallbackKeys: true})
+	require.NoError(t, err)
+	assert.True(t, result.Exists)
+	assert.True(t, result.Fallback)
+	assert.Equal(t, "v1-test-key", result.Key)
+	assert.Equal(t, "v1-fallback-key", result.MatchedKey)
+	assert.Equal(t, "test-build-id", result.Metadata.BuildID)
+	assert.Equal(t, "test-job-id", result.Metadata.JobID)
+	assert.Equal(t, "test-agent-id", result.Metadata.AgentID)
+}
+
+func TestCacheIntegration_RestoreMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	result, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, result.CacheRestored)
+
+	assert.Equal(t, "linux/amd64", result.Metadata.Platform)
+	assert.Equal(t, "test-org", result.Metadata.Organization)
+	assert.Equal(t, "test-pipeline", result.Metadata.Pipeline)
+	assert.Equal(t, "main", result.Metadata.Branch)
+	assert.Equal(t, "test-build-id", result.Metadata.BuildID)
+	assert.Equal(t, "test-job-id", result.Metadata.JobID)
+	assert.Equal(t, "test-agent-id", result.Metadata.AgentID)
+	assert.False(t, result.Metadata.CreatedAt.IsZero())
+	assert.Contains(t, result.Metadata.String(), "pipeline: test-org/test-pipeline\n")
+}
+
+func TestCacheIntegration_StrictPlatform(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	cacheClient.platform = "darwin/arm64"
+
+	result, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored, "platform isn't checked by default")
+
+	cacheClient.caches[0].StrictPlatform = true
+
+	result, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.CacheRestored)
+	assert.False(t, result.CacheHit)
+	assert.Equal(t, "linux/amd64", result.PlatformMismatch)
+	assert.Equal(t, "v1-test-key", result.Key)
+	assert.Zero(t, result.Metadata)
+
+	_, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{FailOnPlatformMismatch: true})
+	require.ErrorIs(t, err, ErrPlatformMismatch)
+
+	cacheClient.platform = "linux/amd64"
+
+	result, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+	assert.Empty(t, result.PlatformMismatch)
+}
+
 func TestCacheIntegration_RestoreCacheMiss(t *testing.T) {
 	ctx := context.Background()
 
@@ -458,6 +660,40 @@ func TestCacheIntegration_RestoreWithFallback(t *testing.T) {
 	assert.NotEmpty(t, entries, "cache directory should have restored files")
 }
 
+func TestCacheIntegration_DisableFallback(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	cacheClient.caches[0].Key = "v1-fallback-key"
+	cacheClient.caches[0].FallbackKeys = []string{}
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+	require.NoError(t, os.MkdirAll(cacheDir, 0o755))
+
+	cacheClient.caches[0].Key = "v1-test-key"
+	cacheClient.caches[0].FallbackKeys = []string{"v1-fallback-key"}
+
+	for _, strategy := range []FallbackStrategy{FallbackOrdered, FallbackNewest} {
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+			DisableFallback:  true,
+			FallbackStrategy: strategy,
+		})
+		require.NoError(t, err)
+		assert.False(t, result.CacheRestored, "%s: fallback key should not be restored", strategy)
+		assert.False(t, result.FallbackUsed)
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// the cache configuration is left unchanged
+	assert.Equal(t, []string{"v1-fallback-key"}, cacheClient.caches[0].FallbackKeys)
+}
+
 func TestCacheIntegration_LargeFileChecksum(t *testing.T) {
 	ctx := context.Background()
 
@@ -516,3 +752,1958 @@ func TestCacheIntegration_TransferMetrics(t *testing.T) {
 		restoreResult.Transfer.TransferSpeed,
 		restoreResult.Transfer.Duration)
 }
+
+func TestCacheIntegration_PathStats(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	require.Len(t, saveResult.Archive.PathStats, 1)
+	saveStats := saveResult.Archive.PathStats[0]
+	assert.Equal(t, cacheDir, saveStats.Path)
+	assert.Equal(t, int64(3*33*1024*1024), saveStats.Bytes)
+	assert.Len(t, saveStats.LargestFiles, 3)
+	assert.Equal(t, int64(33*1024*1024), saveStats.LargestFiles[0].Size)
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	require.Len(t, restoreResult.Archive.PathStats, 1)
+	restoreStats := restoreResult.Archive.PathStats[0]
+	assert.Equal(t, cacheDir, restoreStats.Path)
+	assert.Equal(t, saveStats.Bytes, restoreStats.Bytes)
+	assert.Len(t, restoreStats.LargestFiles, 3)
+}
+
+func TestCacheIntegration_SaveIfChanged(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	// Without a prior restore, SaveIfChanged behaves like Save
+	result, err := cacheClient.SaveIfChanged(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheCreated, "cache should be created")
+	assert.False(t, result.Unchanged)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+	require.NoError(t, os.MkdirAll(cacheDir, 0o755))
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, restoreResult.CacheHit)
+
+	result, err = cacheClient.SaveIfChanged(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.Unchanged, "paths should be unchanged since restore")
+	assert.False(t, result.CacheCreated)
+	assert.Nil(t, result.Transfer)
+	assert.Equal(t, "v1-test-key", result.Key)
+
+	// Restore from a fallback key, which must still create the exact key
+	cacheClient.caches[0].Key = "v2-test-key"
+	cacheClient.caches[0].FallbackKeys = []string{"v1-test-key"}
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, restoreResult.FallbackUsed)
+
+	result, err = cacheClient.SaveIfChanged(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.Unchanged, "a fallback restore doesn't hold the exact key")
+	assert.True(t, result.CacheCreated)
+	assert.Equal(t, "v2-test-key", result.Key)
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, restoreResult.CacheHit)
+
+	// Modify the restored paths, which should trigger a save, though the
+	// exact key already exists
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "new-file.txt"), []byte("changed"), 0o600))
+
+	result, err = cacheClient.SaveIfChanged(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.Unchanged, "changed paths should be saved")
+	assert.Equal(t, "v2-test-key", result.Key)
+}
+
+func TestCacheIntegration_RestoreOnConflict(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	// Simulate a locally modified file and an untracked file
+	modified, err := filepath.Abs(filepath.Join(cacheDir, "large-file-1.bin"))
+	require.NoError(t, err)
+	untracked := filepath.Join(cacheDir, "untracked.txt")
+	require.NoError(t, os.WriteFile(modified, []byte("local changes"), 0o600))
+	require.NoError(t, os.WriteFile(untracked, []byte("untracked"), 0o600))
+
+	t.Run("fail", func(t *testing.T) {
+		_, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{OnConflict: archive.ConflictFail})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, archive.ErrExtractConflict)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{OnConflict: archive.ConflictSkip})
+		require.NoError(t, err)
+		assert.True(t, result.CacheRestored)
+		assert.Contains(t, result.SkippedFiles, modified)
+		assert.Empty(t, result.OverwrittenFiles)
+
+		content, err := os.ReadFile(modified)
+		require.NoError(t, err)
+		assert.Equal(t, "local changes", string(content))
+		assert.FileExists(t, untracked, "untracked files are kept when skipping")
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		result, err := cacheClient.Restore(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, result.CacheRestored)
+		assert.Contains(t, result.OverwrittenFiles, modified)
+		assert.Empty(t, result.SkippedFiles)
+
+		stat, err := os.Stat(modified)
+		require.NoError(t, err)
+		assert.Equal(t, int64(33*1024*1024), stat.Size())
+		assert.NoFileExists(t, untracked, "paths are cleaned before overwriting")
+	})
+}
+
+func TestCacheIntegration_AtomicRestore(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	cacheClient.caches[0].AtomicRestore = true
+	cacheClient.keepArchiveDir = t.TempDir()
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	untracked := filepath.Join(cacheDir, "untracked.txt")
+	require.NoError(t, os.WriteFile(untracked, []byte("untracked"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "large-file-1.bin"), []byte("local changes"), 0o600))
+
+	// left behind by an interrupted restore
+	stale := filepath.Join(filepath.Dir(cacheDir), ".cache"+atomicRestoreSuffix+"123")
+	require.NoError(t, os.MkdirAll(stale, 0o755))
+
+	result, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+	assert.Equal(t, saveResult.Archive.WrittenEntries, result.Archive.WrittenEntries)
+	assert.NotEmpty(t, result.OverwrittenFiles)
+
+	stat, err := os.Stat(filepath.Join(cacheDir, "large-file-1.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(33*1024*1024), stat.Size())
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	assert.NoFileExists(t, untracked, "the path is replaced by the restored files")
+
+	// only the restored path remains beside it
+	siblings, err := os.ReadDir(filepath.Dir(cacheDir))
+	require.NoError(t, err)
+	for _, sibling := range siblings {
+		assert.NotContains(t, sibling.Name(), atomicRestoreSuffix)
+	}
+
+	_, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{OnConflict: archive.ConflictSkip})
+	require.Error(t, err, "atomic restores replace the paths, so can't skip existing files")
+
+	// staged restores leave the paths untouched, so aren't affected
+	stagedResult, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{Mode: RestoreModeStaged, StagingDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.True(t, stagedResult.CacheRestored)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	archiveResult, err := cacheClient.RestoreFromArchive(ctx, "test-cache", saveResult.KeptArchivePath)
+	require.NoError(t, err)
+	assert.True(t, archiveResult.CacheRestored)
+	assert.Equal(t, saveResult.Archive.WrittenEntries, archiveResult.Archive.WrittenEntries)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+}
+
+func TestCacheIntegration_ValidateManifest(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	// archives without a manifest aren't validated
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{ValidateManifest: true})
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+	assert.False(t, result.ManifestValidated)
+
+	cacheClient.caches[0].Key = "v1-manifest-key"
+	cacheClient.caches[0].Manifest = true
+
+	_, err = cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	result, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{ValidateManifest: true})
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+	assert.True(t, result.ManifestValidated)
+
+	// files skipped due to conflicts aren't validated
+	modified := filepath.Join(cacheDir, "large-file-1.bin")
+	require.NoError(t, os.WriteFile(modified, []byte("local changes"), 0o600))
+
+	result, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+		OnConflict:       archive.ConflictSkip,
+		ValidateManifest: true,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.SkippedFiles)
+	assert.True(t, result.ManifestValidated)
+}
+
+func TestCacheIntegration_StagedRestore(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	local := filepath.Join(cacheDir, "local.txt")
+	require.NoError(t, os.WriteFile(local, []byte("local"), 0o600))
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{Mode: "overlay"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid restore mode")
+
+		_, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{LinkStaged: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "require restore mode")
+	})
+
+	t.Run("staged", func(t *testing.T) {
+		stagingDir := t.TempDir()
+
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+			Mode:       RestoreModeStaged,
+			StagingDir: stagingDir,
+		})
+		require.NoError(t, err)
+		assert.True(t, result.CacheRestored)
+		assert.Equal(t, stagingDir, result.StagingPath)
+
+		staged := result.StagedPaths[cacheDir]
+		assert.Equal(t, filepath.Join(stagingDir, "workdir", cacheDir), staged)
+		assert.FileExists(t, filepath.Join(staged, "nested", "large-file-3.bin"))
+		assert.FileExists(t, local, "cache paths are left untouched")
+	})
+
+	t.Run("linked", func(t *testing.T) {
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+			Mode:       RestoreModeStaged,
+			LinkStaged: true,
+		})
+		require.NoError(t, err)
+		defer os.RemoveAll(result.StagingPath)
+
+		target, err := os.Readlink(cacheDir)
+		require.NoError(t, err)
+		assert.Equal(t, result.StagedPaths[cacheDir], target)
+		assert.FileExists(t, filepath.Join(cacheDir, "large-file-1.bin"))
+		assert.NoFileExists(t, local)
+	})
+}
+
+func TestCacheIntegration_ArchiveSizeLimit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("global limit", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		cacheClient.maxArchiveSize = 1024 * 1024
+
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrArchiveTooLarge)
+
+		var sizeErr *ArchiveSizeError
+		require.ErrorAs(t, err, &sizeErr)
+		assert.Equal(t, "test-cache", sizeErr.CacheID)
+		assert.Equal(t, int64(1024*1024), sizeErr.Limit)
+		assert.Greater(t, sizeErr.Size, sizeErr.Limit)
+		require.Len(t, sizeErr.LargestFiles, 3)
+		assert.Equal(t, int64(33*1024*1024), sizeErr.LargestFiles[0].Size)
+		assert.Contains(t, err.Error(), "large-file-1.bin")
+
+		_, exists, err := cacheClient.client.CachePeekExists(ctx, "~", api.CachePeekReq{Key: "v1-test-key", Branch: "main"})
+		require.NoError(t, err)
+		assert.False(t, exists, "no cache entry should be created")
+	})
+
+	t.Run("cache limit overrides global limit", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		cacheClient.maxArchiveSize = 1024 * 1024
+		cacheClient.caches[0].MaxSize = 1024 * 1024 * 1024
+
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, result.CacheCreated)
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		cacheClient.caches[0].MaxSize = 1024 * 1024
+		cacheClient.warnOnArchiveSizeLimit = true
+
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, result.CacheCreated)
+	})
+}
+
+func TestCacheIntegration_KeepArchive(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("kept after upload", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		cacheClient.keepArchiveDir = t.TempDir()
+
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, result.CacheCreated)
+		assert.Equal(t, filepath.Join(cacheClient.keepArchiveDir, "test-cache.zip"), result.KeptArchivePath)
+
+		info, err := os.Stat(result.KeptArchivePath)
+		require.NoError(t, err)
+		assert.Equal(t, result.Archive.Size, info.Size())
+	})
+
+	t.Run("kept on failure", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		cacheClient.keepArchiveDir = t.TempDir()
+		cacheClient.maxArchiveSize = 1024 * 1024
+
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorIs(t, err, ErrArchiveTooLarge)
+		assert.FileExists(t, result.KeptArchivePath)
+	})
+
+	t.Run("not kept by default", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.Empty(t, result.KeptArchivePath)
+	})
+}
+
+func TestCacheIntegration_RestoreFromArchive(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	cacheClient.keepArchiveDir = t.TempDir()
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	require.NotEmpty(t, saveResult.KeptArchivePath)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	// no API or storage requests are needed
+	cacheClient.client = nil
+
+	result, err := cacheClient.RestoreFromArchive(ctx, "test-cache", saveResult.KeptArchivePath)
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+	assert.False(t, result.CacheHit)
+	assert.Equal(t, saveResult.Archive.WrittenEntries, result.Archive.WrittenEntries)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+
+	_, err = cacheClient.RestoreFromArchive(ctx, "test-cache", filepath.Join(t.TempDir(), "missing.zip"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = cacheClient.RestoreFromArchive(ctx, "missing", saveResult.KeptArchivePath)
+	require.ErrorIs(t, err, ErrCacheNotFound)
+}
+
+func TestCacheIntegration_SaveFromArchive(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	// build the archive with a client which keeps it, as custom tooling would
+	cacheClient.keepArchiveDir = t.TempDir()
+	cacheClient.caches[0].Key = "v1-build-key"
+	built, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	require.NotEmpty(t, built.KeptArchivePath)
+
+	// the cache paths don't need to exist
+	require.NoError(t, os.RemoveAll(cacheDir))
+	cacheClient.keepArchiveDir = ""
+	cacheClient.caches[0].Key = "v1-test-key"
+
+	result, err := cacheClient.SaveFromArchive(ctx, "test-cache", built.KeptArchivePath)
+	require.NoError(t, err)
+	assert.True(t, result.CacheCreated)
+	assert.Equal(t, "v1-test-key", result.Key)
+	assert.Equal(t, built.Archive.Sha256Sum, result.Archive.Sha256Sum)
+	assert.Equal(t, built.Archive.Size, result.Archive.Size)
+	assert.FileExists(t, built.KeptArchivePath, "archive should be left in place")
+
+	entry := cacheClient.client.(*mockAPIClient).registries["~"].cache["v1-test-key"]
+	require.NotNil(t, entry)
+	assert.Equal(t, "sha256:"+built.Archive.Sha256Sum, entry.digest)
+
+	restored, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restored.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+
+	notZip := filepath.Join(t.TempDir(), "cache.zip")
+	require.NoError(t, os.WriteFile(notZip, []byte("not a zip archive"), 0o600))
+	cacheClient.caches[0].Key = "v1-other-key"
+
+	_, err = cacheClient.SaveFromArchive(ctx, "test-cache", notZip)
+	require.ErrorContains(t, err, "failed to inspect archive")
+}
+
+func TestCacheIntegration_KeyPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, storageDir := setupTestCache(t, "local_file")
+	cacheClient.keyPrefix = "test-org/test-pipeline"
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(filepath.Join(storageDir, "test-org", "test-pipeline"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, entries, "objects should be stored under the prefix")
+
+	result, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheHit)
+
+	// objects saved under another prefix can't be read
+	cacheClient.keyPrefix = "other-org"
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.ErrorIs(t, err, ErrDownloadFailed)
+}
+
+func TestCacheIntegration_ResumeInterruptedCommit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("same archive", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		mockClient := cacheClient.client.(*mockAPIClient)
+
+		mockClient.failCommit = true
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorContains(t, err, "commit interrupted")
+
+		mockClient.failCommit = false
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, result.CacheCreated)
+		assert.True(t, result.UploadResumed)
+		require.NotNil(t, result.Transfer)
+		assert.Zero(t, result.Transfer.BytesTransferred)
+
+		restored, err := cacheClient.Restore(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, restored.CacheHit)
+	})
+
+	t.Run("different archive", func(t *testing.T) {
+		cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+		mockClient := cacheClient.client.(*mockAPIClient)
+
+		mockClient.failCommit = true
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.Error(t, err)
+
+		createRandomFile(t, filepath.Join(cacheDir, "large-file-1.bin"), 1024)
+
+		mockClient.failCommit = false
+		result, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.True(t, result.CacheCreated)
+		assert.False(t, result.UploadResumed)
+		assert.Positive(t, result.Transfer.BytesTransferred)
+	})
+}
+
+func TestCacheIntegration_WaitForPending(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("committed while waiting", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		mockClient := cacheClient.client.(*mockAPIClient)
+
+		mockClient.failCommit = true
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorContains(t, err, "commit interrupted")
+
+		// commit the entry as another job would, once the restore is waiting
+		cacheClient.onProgress = func(cacheID, stage, message string, current, total int) {
+			if stage != "waiting_for_pending" {
+				return
+			}
+			mockClient.failCommit = false
+			for _, entry := range mockClient.registries["~"].cache {
+				_, err := mockClient.CacheCommit(ctx, "~", api.CacheCommitReq{UploadID: entry.uploadID})
+				assert.NoError(t, err)
+			}
+		}
+
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+			WaitForPending:      time.Minute,
+			PendingPollInterval: time.Millisecond,
+		})
+		require.NoError(t, err)
+		assert.True(t, result.CacheHit)
+		assert.True(t, result.CacheRestored)
+		assert.Positive(t, result.WaitedForPending)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		mockClient := cacheClient.client.(*mockAPIClient)
+
+		mockClient.failCommit = true
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorContains(t, err, "commit interrupted")
+
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+			WaitForPending:      20 * time.Millisecond,
+			PendingPollInterval: time.Millisecond,
+		})
+		require.NoError(t, err)
+		assert.False(t, result.CacheRestored)
+		assert.GreaterOrEqual(t, result.WaitedForPending, 20*time.Millisecond)
+	})
+
+	t.Run("platform mismatch after waiting", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		mockClient := cacheClient.client.(*mockAPIClient)
+
+		mockClient.failCommit = true
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorContains(t, err, "commit interrupted")
+
+		cacheClient.onProgress = func(cacheID, stage, message string, current, total int) {
+			if stage != "waiting_for_pending" {
+				return
+			}
+			mockClient.failCommit = false
+			for _, entry := range mockClient.registries["~"].cache {
+				_, err := mockClient.CacheCommit(ctx, "~", api.CacheCommitReq{UploadID: entry.uploadID})
+				assert.NoError(t, err)
+			}
+		}
+
+		cacheClient.platform = "darwin/arm64"
+		cacheClient.caches[0].StrictPlatform = true
+
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+			WaitForPending:      time.Minute,
+			PendingPollInterval: time.Millisecond,
+		})
+		require.NoError(t, err)
+		assert.False(t, result.CacheRestored)
+		assert.Equal(t, "linux/amd64", result.PlatformMismatch)
+		assert.Positive(t, result.WaitedForPending, "time spent waiting is reported on the miss")
+		assert.NotEmpty(t, result.Stages)
+	})
+
+	t.Run("not waiting by default", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		mockClient := cacheClient.client.(*mockAPIClient)
+
+		mockClient.failCommit = true
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorContains(t, err, "commit interrupted")
+
+		result, err := cacheClient.Restore(ctx, "test-cache")
+		require.NoError(t, err)
+		assert.False(t, result.CacheRestored)
+		assert.Zero(t, result.WaitedForPending)
+	})
+}
+
+func TestCacheIntegration_Scope(t *testing.T) {
+	tests := []struct {
+		name             string
+		scope            cache.Scope
+		wantBranch       string
+		wantPipeline     string
+		wantOrganization string
+	}{
+		{
+			name:             "default is branch scoped",
+			scope:            "",
+			wantBranch:       "main",
+			wantPipeline:     "test-pipeline",
+			wantOrganization: "test-org",
+		},
+		{
+			name:             "branch",
+			scope:            cache.ScopeBranch,
+			wantBranch:       "main",
+			wantPipeline:     "test-pipeline",
+			wantOrganization: "test-org",
+		},
+		{
+			name:             "pipeline",
+			scope:            cache.ScopePipeline,
+			wantBranch:       "",
+			wantPipeline:     "test-pipeline",
+			wantOrganization: "test-org",
+		},
+		{
+			name:             "organization",
+			scope:            cache.ScopeOrganization,
+			wantBranch:       "",
+			wantPipeline:     "",
+			wantOrganization: "test-org",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			cacheClient, _, _ := setupTestCache(t, "local_file")
+			cacheClient.caches[0].Scope = tt.scope
+
+			_, err := cacheClient.Save(ctx, "test-cache")
+			require.NoError(t, err)
+
+			mockClient := cacheClient.client.(*mockAPIClient)
+			entry := mockClient.registries["~"].cache["v1-test-key"]
+			require.NotNil(t, entry)
+			assert.Equal(t, tt.wantBranch, entry.branch)
+			assert.Equal(t, tt.wantPipeline, entry.pipeline)
+			assert.Equal(t, tt.wantOrganization, entry.organization)
+		})
+	}
+}
+
+func TestCacheIntegration_FallbackStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy FallbackStrategy
+		wantKey  string
+	}{
+		{name: "default is ordered", strategy: "", wantKey: "v1-old"},
+		{name: "ordered", strategy: FallbackOrdered, wantKey: "v1-old"},
+		{name: "newest", strategy: FallbackNewest, wantKey: "v1-new"},
+		{name: "largest", strategy: FallbackLargest, wantKey: "v1-old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			cacheClient, _, _ := setupTestCache(t, "local_file")
+			cacheClient.caches[0].Key = "v1-old"
+
+			_, err := cacheClient.Save(ctx, "test-cache")
+			require.NoError(t, err)
+
+			// add a newer, smaller entry sharing the same archive
+			mockClient := cacheClient.client.(*mockAPIClient)
+			oldEntry := mockClient.registries["~"].cache["v1-old"]
+			require.NotNil(t, oldEntry)
+
+			newEntry := *oldEntry
+			newEntry.key = "v1-new"
+			newEntry.expiresAt = oldEntry.expiresAt.Add(time.Hour)
+			newEntry.fileSize = oldEntry.fileSize - 1
+			mockClient.registries["~"].cache["v1-new"] = &newEntry
+
+			cacheClient.caches[0].Key = "v1-missing"
+			cacheClient.caches[0].FallbackKeys = []string{"v1-old", "v1-new"}
+
+			result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{FallbackStrategy: tt.strategy})
+			require.NoError(t, err)
+			assert.True(t, result.CacheRestored)
+			assert.False(t, result.CacheHit)
+			assert.True(t, result.FallbackUsed)
+			assert.Equal(t, tt.wantKey, result.Key)
+		})
+	}
+
+	t.Run("exact key takes precedence", func(t *testing.T) {
+		ctx := context.Background()
+
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+
+		result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{FallbackStrategy: FallbackNewest})
+		require.NoError(t, err)
+		assert.True(t, result.CacheHit)
+		assert.False(t, result.FallbackUsed)
+		assert.Equal(t, "v1-test-key", result.Key)
+	})
+
+	t.Run("invalid strategy", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+
+		_, err := cacheClient.RestoreWithOptions(context.Background(), "test-cache", RestoreOptions{FallbackStrategy: "oldest"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid fallback strategy")
+	})
+}
+
+func TestCacheIntegration_RestoreAll(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	otherDir := filepath.Join(filepath.Dir(cacheDir), "other")
+	require.NoError(t, os.MkdirAll(otherDir, 0o755))
+	cacheClient.caches = append(cacheClient.caches, cache.Cache{
+		ID:    "other-cache",
+		Key:   "v1-other-key",
+		Paths: []string{otherDir},
+	})
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	t.Run("all caches", func(t *testing.T) {
+		result, err := cacheClient.RestoreAll(ctx, nil, RestoreAllOptions{Concurrency: 2})
+		require.NoError(t, err)
+		require.Len(t, result.Caches, 2)
+
+		assert.Equal(t, "test-cache", result.Caches[0].CacheID)
+		assert.True(t, result.Caches[0].Result.CacheHit)
+		assert.Equal(t, "other-cache", result.Caches[1].CacheID)
+		assert.False(t, result.Caches[1].Result.CacheRestored)
+		assert.Equal(t, "test-cache=hit other-cache=miss", result.Summary())
+	})
+
+	t.Run("selected caches", func(t *testing.T) {
+		result, err := cacheClient.RestoreAll(ctx, []string{"other-cache"}, RestoreAllOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "other-cache=miss", result.Summary())
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			cacheIDs    []string
+			opts        RestoreAllOptions
+			errContains string
+		}{
+			{name: "unknown cache", cacheIDs: []string{"missing"}, errContains: "cache missing"},
+			{name: "duplicate cache", cacheIDs: []string{"test-cache", "test-cache"}, errContains: "more than once"},
+			{name: "negative concurrency", opts: RestoreAllOptions{Concurrency: -1}, errContains: "non-negative"},
+			{name: "paths", opts: RestoreAllOptions{Restore: RestoreOptions{Paths: []string{cacheDir}}}, errContains: "restore paths"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				result, err := cacheClient.RestoreAll(ctx, tt.cacheIDs, tt.opts)
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				assert.Empty(t, result.Caches)
+			})
+		}
+	})
+}
+
+func TestCacheIntegration_SaveAll(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	otherDir := filepath.Join(filepath.Dir(cacheDir), "other")
+	require.NoError(t, os.MkdirAll(otherDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(otherDir, "file.txt"), []byte("other"), 0o600))
+	cacheClient.caches = append(cacheClient.caches, cache.Cache{
+		ID:    "other-cache",
+		Key:   "v1-other-key",
+		Paths: []string{otherDir},
+	})
+
+	_, err := cacheClient.Save(ctx, "other-cache")
+	require.NoError(t, err)
+
+	result, err := cacheClient.SaveAll(ctx, nil, SaveAllOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "test-cache=created other-cache=exists", result.Summary())
+
+	aggregate := result.Aggregate()
+	assert.Equal(t, 2, aggregate.Caches)
+	assert.Equal(t, 1, aggregate.Created)
+	assert.Equal(t, 1, aggregate.Existing)
+	assert.Equal(t, result.Caches[0].Result.Transfer.BytesTransferred, aggregate.BytesUploaded)
+
+	restoreResult, err := cacheClient.RestoreAll(ctx, nil, RestoreAllOptions{})
+	require.NoError(t, err)
+
+	restoreAggregate := restoreResult.Aggregate()
+	assert.Equal(t, 2, restoreAggregate.Hits)
+	assert.InDelta(t, 1.0, restoreAggregate.HitRate(), 0.001)
+	assert.Positive(t, restoreAggregate.BytesDownloaded)
+
+	_, err = cacheClient.SaveAll(ctx, []string{"missing"}, SaveAllOptions{})
+	require.ErrorIs(t, err, ErrCacheNotFound)
+
+	_, err = cacheClient.SaveAll(ctx, nil, SaveAllOptions{BuildConcurrency: -1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "build concurrency must be non-negative")
+}
+
+func TestCacheIntegration_CompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name            string
+		accepted        []string
+		wantCompression string
+	}{
+		{name: "not advertised", accepted: nil, wantCompression: "zip"},
+		{name: "zstd", accepted: []string{CompressionZip, CompressionZstd}, wantCompression: CompressionZstd},
+		{name: "zip fallback", accepted: []string{"brotli"}, wantCompression: CompressionZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+			mockClient := cacheClient.client.(*mockAPIClient)
+			reg := mockClient.registries["~"]
+			reg.compression = tt.accepted
+
+			saveResult, err := cacheClient.Save(ctx, "test-cache")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCompression, saveResult.Compression)
+			assert.Equal(t, tt.wantCompression, reg.cache["v1-test-key"].compression)
+
+			require.NoError(t, os.RemoveAll(cacheDir))
+
+			restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+			require.NoError(t, err)
+			assert.True(t, restoreResult.CacheHit)
+			assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+		})
+	}
+}
+
+func TestCacheIntegration_DryRun(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	mockClient := cacheClient.client.(*mockAPIClient)
+
+	reporter := &recordingReporter{}
+	cacheClient.reporter = reporter
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache", WithDryRun())
+	require.NoError(t, err)
+	assert.True(t, saveResult.WouldSave)
+	assert.False(t, saveResult.CacheCreated)
+	assert.Equal(t, "v1-test-key", saveResult.Key)
+	assert.Nil(t, saveResult.Transfer)
+	assert.Empty(t, mockClient.registries["~"].cache, "nothing should be uploaded")
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache", WithRestoreDryRun())
+	require.NoError(t, err)
+	assert.False(t, restoreResult.CacheHit)
+	assert.False(t, restoreResult.CacheRestored)
+
+	_, err = cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	saveResult, err = cacheClient.Save(ctx, "test-cache", WithDryRun())
+	require.NoError(t, err)
+	assert.False(t, saveResult.WouldSave, "the entry already exists")
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache", WithRestoreDryRun())
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.False(t, restoreResult.CacheRestored)
+	assert.Equal(t, "v1-test-key", restoreResult.Key)
+	assert.Equal(t, "linux/amd64", restoreResult.Metadata.Platform)
+	assert.Zero(t, restoreResult.Transfer.BytesTransferred)
+	assert.NoDirExists(t, cacheDir, "nothing should be downloaded or extracted")
+
+	// fallback keys are reported as they would be restored
+	cacheClient.caches[0].Key = "v2-test-key"
+	cacheClient.caches[0].FallbackKeys = []string{"v1-test-key"}
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache", WithRestoreDryRun())
+	require.NoError(t, err)
+	assert.True(t, restoreResult.FallbackUsed)
+	assert.Equal(t, "v1-test-key", restoreResult.Key)
+	assert.NoDirExists(t, cacheDir)
+
+	// only the save which ran was reported
+	require.Len(t, reporter.events, 1)
+	assert.Equal(t, "save", reporter.events[0].Operation)
+}
+
+func TestCacheIntegration_SaveForce(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, saveResult.CacheCreated)
+
+	repaired := filepath.Join(cacheDir, "nested", "large-file-3.bin")
+	require.NoError(t, os.WriteFile(repaired, []byte("repaired"), 0o600))
+
+	saveResult, err = cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, saveResult.CacheCreated, "existing caches are not saved again without force")
+
+	saveResult, err = cacheClient.SaveWithOptions(ctx, "test-cache", SaveOptions{Force: true})
+	require.NoError(t, err)
+	assert.True(t, saveResult.CacheCreated)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(repaired)
+	require.NoError(t, err)
+	assert.Equal(t, "repaired", string(content))
+}
+
+func TestCacheIntegration_RestoreInsufficientSpace(t *testing.T) {
+	if _, err := freeSpace(t.TempDir()); errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space can't be checked on this platform")
+	}
+
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	// report an archive far larger than any filesystem
+	mockClient := cacheClient.client.(*mockAPIClient)
+	mockClient.registries["~"].cache["v1-test-key"].fileSize = 1 << 60
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	result, err := cacheClient.Restore(ctx, "test-cache")
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+	assert.False(t, result.CacheRestored)
+	assert.NoDirExists(t, cacheDir, "nothing should be downloaded or extracted")
+
+	var spaceErr *DiskSpaceError
+	require.ErrorAs(t, err, &spaceErr)
+	assert.True(t, spaceErr.TempDir)
+	assert.Equal(t, int64(1<<60), spaceErr.Required)
+
+	result, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{SkipSpaceCheck: true})
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+}
+
+func TestCacheIntegration_Streams(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	var stream bytes.Buffer
+	restoreResult, err := cacheClient.RestoreToWriter(ctx, "test-cache", &stream)
+	require.NoError(t, err)
+	assert.False(t, restoreResult.CacheRestored)
+	assert.Zero(t, stream.Len(), "nothing should be written on a miss")
+
+	_, err = cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	restoreResult, err = cacheClient.RestoreToWriter(ctx, "test-cache", &stream)
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheRestored)
+	assert.True(t, restoreResult.CacheHit)
+	assert.Equal(t, int64(stream.Len()), restoreResult.Archive.Size)
+
+	// save the streamed archive again as a new entry
+	mockClient := cacheClient.client.(*mockAPIClient)
+	delete(mockClient.registries["~"].cache, "v1-test-key")
+
+	saveResult, err := cacheClient.SaveFromReader(ctx, "test-cache", &stream)
+	require.NoError(t, err)
+	assert.True(t, saveResult.CacheCreated)
+
+	expected, err := os.ReadFile(filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, content)
+}
+
+// branchScopedClient only finds cache entries saved on the requested branch,
+// as the mock API client ignores branches.
+type branchScopedClient struct {
+	*mockAPIClient
+}
+
+func (m branchScopedClient) onBranch(registry, key, branch string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.registries[registry].cache[key]
+	return exists && entry.branch == branch
+}
+
+func (m branchScopedClient) CachePeekExists(ctx context.Context, registry string, req api.CachePeekReq) (api.CachePeekResp, bool, error) {
+	if !m.onBranch(registry, req.Key, req.Branch) {
+		return api.CachePeekResp{Message: api.CacheEntryNotFound}, false, nil
+	}
+
+	return m.mockAPIClient.CachePeekExists(ctx, registry, req)
+}
+
+func (m branchScopedClient) CacheRetrieve(ctx context.Context, registry string, req api.CacheRetrieveReq) (api.CacheRetrieveResp, bool, error) {
+	if !m.onBranch(registry, req.Key, req.Branch) {
+		return api.CacheRetrieveResp{Message: api.CacheEntryNotFound}, false, nil
+	}
+
+	return m.mockAPIClient.CacheRetrieve(ctx, registry, req)
+}
+
+func TestCacheIntegration_Promote(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	mockClient := cacheClient.client.(*mockAPIClient)
+	cacheClient.client = branchScopedClient{mockClient}
+
+	opts := PromoteOptions{FromBranch: "feature/x", ToBranch: "main"}
+
+	result, err := cacheClient.Promote(ctx, "test-cache", opts)
+	require.NoError(t, err)
+	assert.False(t, result.Found)
+
+	cacheClient.branch = "feature/x"
+	_, err = cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	result, err = cacheClient.Promote(ctx, "test-cache", opts)
+	require.NoError(t, err)
+	assert.True(t, result.Found)
+	assert.True(t, result.Save.CacheCreated)
+	assert.Equal(t, "v1-test-key", result.Key)
+
+	entry := mockClient.registries["~"].cache["v1-test-key"]
+	assert.Equal(t, "main", entry.branch)
+	assert.Contains(t, entry.storeObjectName, "main")
+
+	result, err = cacheClient.Promote(ctx, "test-cache", PromoteOptions{FromBranch: "main", ToBranch: "feature/y"})
+	require.NoError(t, err)
+	assert.True(t, result.Save.CacheCreated, "the promoted entry can be promoted again")
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	cacheClient.branch = "feature/y"
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+}
+
+func TestCacheIntegration_TarGz(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	cacheClient.format = archive.FormatTarGz
+	cacheClient.keepArchiveDir = t.TempDir()
+
+	mockClient := cacheClient.client.(*mockAPIClient)
+	reg := mockClient.registries["~"]
+	// tar.gz archives are saved as configured whatever the registry accepts
+	reg.compression = []string{CompressionZstd}
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.Equal(t, archive.FormatTarGz, saveResult.Compression)
+	assert.Equal(t, archive.FormatTarGz, reg.cache["v1-test-key"].compression)
+	assert.Equal(t, filepath.Join(cacheClient.keepArchiveDir, "test-cache.tar.gz"), saveResult.KeptArchivePath)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "large-file-1.bin"))
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+}
+
+func TestCacheIntegration_TarZstExternal(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	cacheClient.format = archive.FormatTarZst
+	// falls back to compressing in process if zstd isn't installed
+	cacheClient.externalZstd = true
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.Equal(t, archive.FormatTarZst, saveResult.Compression)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.Equal(t, saveResult.Archive.WrittenBytes, restoreResult.Archive.WrittenBytes)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+}
+
+func TestCacheIntegration_ExtractPermissions(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	// saved with a restrictive umask
+	require.NoError(t, os.Chmod(filepath.Join(cacheDir, "nested"), 0o700))
+	require.NoError(t, os.Chmod(filepath.Join(cacheDir, "large-file-1.bin"), 0o600))
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	cacheClient.caches[0].ExtractDirMode = 0o755
+	cacheClient.caches[0].ExtractUmask = 0o022
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	stat, err := os.Stat(filepath.Join(cacheDir, "nested"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), stat.Mode().Perm())
+
+	stat, err = os.Stat(filepath.Join(cacheDir, "large-file-1.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), stat.Mode().Perm())
+}
+
+func TestCacheIntegration_Import(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	// an archive stored by another cache tool
+	oldStoreDir := t.TempDir()
+	archiveInfo, err := archive.BuildArchiveWithOptions(ctx, []string{cacheDir}, "old", archive.BuildOptions{Format: archive.FormatTarGz})
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(oldStoreDir, "caches"), 0o755))
+	require.NoError(t, moveFile(archiveInfo.ArchivePath, filepath.Join(oldStoreDir, "caches", "test-cache.tar.gz")))
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	importResult, err := cacheClient.Import(ctx, ImportOptions{
+		From: "file://" + oldStoreDir,
+		KeyMap: []KeyMapping{
+			{From: "caches/test-cache.tar.gz", Cache: "test-cache", Key: "v1-imported-key"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, importResult.Imports, 1)
+	assert.True(t, importResult.Imports[0].Result.CacheCreated)
+	assert.Equal(t, "v1-imported-key", importResult.Imports[0].Result.Key)
+
+	mockClient := cacheClient.client.(*mockAPIClient)
+	reg := mockClient.registries["~"]
+	require.Contains(t, reg.cache, "v1-imported-key")
+	assert.Equal(t, archive.FormatTarGz, reg.cache["v1-imported-key"].compression)
+
+	cacheClient.caches[0].Key = "v1-imported-key"
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+
+	// archives missing from the old store fail without stopping the import
+	importResult, err = cacheClient.Import(ctx, ImportOptions{
+		From: "file://" + oldStoreDir,
+		KeyMap: []KeyMapping{
+			{From: "caches/missing.tar.gz", Cache: "test-cache", Key: "v1-missing-key"},
+			{From: "caches/test-cache.tar.gz", Cache: "test-cache", Key: "v1-imported-key"},
+		},
+		KeepGoing: true,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to import caches/missing.tar.gz")
+	require.Len(t, importResult.Imports, 2)
+	assert.Error(t, importResult.Imports[0].Err)
+	assert.NoError(t, importResult.Imports[1].Err)
+	assert.False(t, importResult.Imports[1].Result.CacheCreated, "archives already imported should be skipped")
+}
+
+func TestCacheIntegration_Options(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache", WithForce())
+	require.NoError(t, err)
+	assert.True(t, saveResult.CacheCreated, "forced saves overwrite the existing entry")
+
+	targetDir := t.TempDir()
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache", WithTargetDir(targetDir))
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.Equal(t, targetDir, restoreResult.StagingPath)
+	assert.FileExists(t, filepath.Join(restoreResult.StagedPaths[cacheDir], "nested", "large-file-3.bin"))
+
+	// the fallback key matches the saved entry, but isn't tried
+	cacheClient.caches[0].Key = "v1-other-key"
+	cacheClient.caches[0].FallbackKeys = []string{"v1-test-key"}
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache", WithoutFallback())
+	require.NoError(t, err)
+	assert.False(t, restoreResult.CacheRestored)
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.FallbackUsed)
+}
+
+func TestCacheIntegration_MissingPaths(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	missingDir := filepath.Join(filepath.Dir(cacheDir), "missing")
+	emptyDir := filepath.Join(filepath.Dir(cacheDir), "empty")
+	require.NoError(t, os.MkdirAll(emptyDir, 0o755))
+
+	cacheClient.caches[0].Paths = []string{cacheDir, emptyDir, missingDir}
+
+	// by default missing paths fail the save
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.ErrorContains(t, err, "path does not exist")
+
+	cacheClient.caches[0].MissingPaths = cache.MissingPathsError
+	cacheClient.caches[0].Paths = []string{cacheDir, emptyDir}
+
+	_, err = cacheClient.Save(ctx, "test-cache")
+	require.ErrorContains(t, err, "path is empty")
+
+	cacheClient.caches[0].MissingPaths = cache.MissingPathsWarn
+	cacheClient.caches[0].Paths = []string{cacheDir, emptyDir, missingDir}
+
+	result, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheCreated)
+	assert.Equal(t, []string{emptyDir, missingDir}, result.MissingPaths)
+	assert.Equal(t, []string{cacheDir}, result.Archive.Paths)
+
+	// nothing is saved when every path is missing or empty
+	cacheClient.caches[0].MissingPaths = cache.MissingPathsSkip
+	cacheClient.caches[0].Key = "v1-missing-key"
+	cacheClient.caches[0].Paths = []string{emptyDir, missingDir}
+
+	result, err = cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.CacheCreated)
+	assert.True(t, result.PathsMissing)
+	assert.Equal(t, "missing", CacheSaveResult{CacheID: "test-cache", Result: result}.Status())
+
+	_, exists, err := cacheClient.client.CachePeekExists(ctx, "~", api.CachePeekReq{Key: "v1-missing-key", Branch: "main"})
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCacheIntegration_SaveAllKeepGoing(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	missingDir := filepath.Join(filepath.Dir(cacheDir), "missing")
+	cacheClient.caches = append([]cache.Cache{{
+		ID:    "missing-cache",
+		Key:   "v1-missing-key",
+		Paths: []string{missingDir},
+	}}, cacheClient.caches...)
+
+	result, err := cacheClient.SaveAll(ctx, nil, SaveAllOptions{Concurrency: 1, KeepGoing: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to save cache missing-cache")
+	assert.Equal(t, "missing-cache=error test-cache=created", result.Summary())
+	assert.Contains(t, result.ErrorTable(), "missing-cache  invalid cache paths: path does not exist")
+
+	cacheClient.caches[1].AtomicRestore = true
+
+	restoreResult, err := cacheClient.RestoreAll(ctx, nil, RestoreAllOptions{
+		Concurrency: 1,
+		KeepGoing:   true,
+		Restore:     RestoreOptions{OnConflict: archive.ConflictSkip},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to restore cache test-cache")
+	assert.Equal(t, "missing-cache=miss test-cache=error", restoreResult.Summary())
+}
+
+func TestCacheIntegration_SaveAllPipelined(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	otherDir := filepath.Join(filepath.Dir(cacheDir), "other")
+	require.NoError(t, os.MkdirAll(otherDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(otherDir, "file.txt"), []byte("other"), 0o600))
+	cacheClient.caches = append(cacheClient.caches, cache.Cache{
+		ID:    "other-cache",
+		Key:   "v1-other-key",
+		Paths: []string{otherDir},
+	})
+
+	result, err := cacheClient.SaveAll(ctx, nil, SaveAllOptions{
+		Concurrency:      2,
+		BuildConcurrency: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "test-cache=created other-cache=created", result.Summary())
+}
+
+func TestCacheIntegration_Status(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	cacheClient.caches[0].Key = "v1-new-key"
+	cacheClient.caches[0].FallbackKeys = []string{"v1-missing-key", "v1-test-key"}
+
+	result, err := cacheClient.Status(ctx, nil, StatusOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Caches, 1)
+
+	status := result.Caches[0]
+	require.NoError(t, status.Err)
+	assert.Equal(t, "test-cache", status.CacheID)
+	require.Len(t, status.Keys, 3)
+
+	assert.Equal(t, "v1-new-key", status.Keys[0].Key)
+	assert.False(t, status.Keys[0].Fallback)
+	assert.False(t, status.Keys[0].Exists)
+	assert.True(t, status.Keys[1].Fallback)
+	assert.False(t, status.Keys[1].Exists)
+	assert.True(t, status.Keys[2].Exists)
+	assert.Positive(t, status.Keys[2].FileSize)
+	assert.Positive(t, status.Keys[2].Age)
+
+	restores, ok := status.Restores()
+	assert.True(t, ok)
+	assert.Equal(t, "v1-test-key", restores.Key)
+
+	table := result.String()
+	assert.Contains(t, table, "CACHE")
+	assert.Regexp(t, `test-cache\s+v1-new-key\s+key\s+miss`, table)
+	assert.Regexp(t, `test-cache\s+v1-test-key\s+fallback\s+restore`, table)
+
+	_, err = cacheClient.Status(ctx, []string{"missing"}, StatusOptions{})
+	require.ErrorIs(t, err, ErrCacheNotFound)
+}
+
+type recordingReporter struct {
+	mu     sync.Mutex
+	events []UsageEvent
+}
+
+func (r *recordingReporter) Report(_ context.Context, event UsageEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestCacheIntegration_Reporter(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+	cacheClient.pipeline = "test-pipeline"
+	cacheClient.branch = "main"
+
+	reporter := &recordingReporter{}
+	cacheClient.reporter = reporter
+
+	_, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	_, err = cacheClient.Save(ctx, "missing")
+	require.Error(t, err)
+
+	require.Len(t, reporter.events, 4)
+
+	miss := reporter.events[0]
+	assert.Equal(t, "restore", miss.Operation)
+	assert.Equal(t, "miss", miss.Status)
+	assert.False(t, miss.Hit)
+	assert.Equal(t, "test-pipeline", miss.Pipeline)
+	assert.Equal(t, "main", miss.Branch)
+
+	save := reporter.events[1]
+	assert.Equal(t, "save", save.Operation)
+	assert.Equal(t, "created", save.Status)
+	assert.Equal(t, "v1-test-key", save.Key)
+	assert.Equal(t, saveResult.Transfer.BytesTransferred, save.BytesTransferred)
+	assert.Positive(t, save.TotalDuration)
+
+	hit := reporter.events[2]
+	assert.Equal(t, "hit", hit.Status)
+	assert.True(t, hit.Hit)
+	assert.Positive(t, hit.BytesTransferred)
+
+	failed := reporter.events[3]
+	assert.Equal(t, "error", failed.Status)
+	assert.Contains(t, failed.Error, ErrCacheNotFound.Error())
+}
+
+func TestCacheIntegration_Events(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	events := make(chan Event, 100)
+	cacheClient.events = events
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+
+	close(events)
+
+	var types []string
+	for event := range events {
+		assert.Equal(t, "test-cache", event.Info().CacheID)
+		assert.False(t, event.Info().Time.IsZero())
+		types = append(types, fmt.Sprintf("%T", event))
+
+		switch e := event.(type) {
+		case ArchiveBuilt:
+			assert.Equal(t, saveResult.Archive.Size, e.Archive.Size)
+		case Committed:
+			assert.Equal(t, "v1-test-key", e.Key)
+			assert.Equal(t, saveResult.UploadID, e.UploadID)
+		case SaveCompleted:
+			assert.NoError(t, e.Err)
+			assert.True(t, e.Result.CacheCreated)
+		case DownloadStarted:
+			assert.Equal(t, "v1-test-key", e.Key)
+			assert.False(t, e.Fallback)
+		case RestoreCompleted:
+			assert.NoError(t, e.Err)
+			assert.True(t, e.Result.CacheHit)
+		}
+	}
+
+	assert.Equal(t, []string{
+		"zstash.SaveStarted",
+		"zstash.ArchiveStarted",
+		"zstash.ArchiveBuilt",
+		"zstash.UploadStarted",
+		"zstash.Uploaded",
+		"zstash.Committed",
+		"zstash.SaveCompleted",
+		"zstash.RestoreStarted",
+		"zstash.DownloadStarted",
+		"zstash.Downloaded",
+		"zstash.Extracted",
+		"zstash.RestoreCompleted",
+	}, types)
+}
+
+func TestCacheIntegration_List(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	result, err := cacheClient.List(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, result.Exists)
+	assert.Equal(t, "v1-test-key", result.Key)
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	result, err = cacheClient.List(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.Exists)
+	assert.True(t, result.Ranged, "the local file store supports ranged reads")
+	assert.Len(t, result.Entries, 5)
+	assert.Less(t, result.BytesDownloaded, saveResult.Archive.Size, "only the central directory should be read")
+
+	verifyResult, err := cacheClient.Verify(ctx, "test-cache", VerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, verifyResult.Entries, result.Entries)
+
+	_, err = cacheClient.List(ctx, "unknown")
+	require.ErrorIs(t, err, ErrCacheNotFound)
+}
+
+func TestCacheIntegration_RestorePartial(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	_, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{Mode: RestoreModePartial})
+	require.Error(t, err, "a partial restore requires files")
+
+	_, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{Files: []string{cacheDir}})
+	require.Error(t, err, "files require a partial restore")
+
+	result, err := cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+		Mode:  RestoreModePartial,
+		Files: []string{filepath.Join(cacheDir, "nested")},
+	})
+	require.NoError(t, err)
+	assert.True(t, result.CacheRestored)
+	assert.True(t, result.Ranged, "the local file store supports ranged reads")
+	assert.Less(t, result.Transfer.BytesTransferred, saveResult.Archive.Size, "only the restored files should be read")
+
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "large-file-1.bin"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "large-file-2.bin"))
+
+	result, err = cacheClient.RestoreWithOptions(ctx, "test-cache", RestoreOptions{
+		Mode:       RestoreModePartial,
+		Files:      []string{filepath.Join(cacheDir, "nested", "large-file-3.bin")},
+		OnConflict: archive.ConflictSkip,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.SkippedFiles, 1)
+	assert.Equal(t, "large-file-3.bin", filepath.Base(result.SkippedFiles[0]))
+}
+
+func TestCacheIntegration_Logger(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	var buf bytes.Buffer
+	cacheClient.logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	// logs of the store and archive packages are routed to the configured logger
+	assert.Contains(t, buf.String(), `"msg":"configured local file store"`)
+	assert.Contains(t, buf.String(), `"msg":"chroot"`)
+}
+
+func TestCacheIntegration_ProgressLog(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	var buf bytes.Buffer
+	cacheClient.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	cacheClient.progressLog = true
+
+	var uploaded, downloaded int
+	cacheClient.onProgress = func(cacheID, stage, message string, current, total int) {
+		switch stage {
+		case "uploading":
+			uploaded = current
+		case "downloading":
+			downloaded = current
+		}
+	}
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.Equal(t, int(saveResult.Archive.Size), uploaded)
+	assert.Contains(t, buf.String(), `"msg":"Uploading cache 100%"`)
+
+	_, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.Equal(t, int(saveResult.Archive.Size), downloaded)
+	assert.Contains(t, buf.String(), `"msg":"Downloading cache 100%"`)
+}
+
+func TestCacheIntegration_Verify(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+
+	t.Run("missing entry", func(t *testing.T) {
+		result, err := cacheClient.Verify(ctx, "test-cache", VerifyOptions{})
+		require.NoError(t, err)
+		assert.False(t, result.Exists)
+		assert.Equal(t, "v1-test-key", result.Key)
+	})
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	t.Run("unchanged working tree", func(t *testing.T) {
+		result, err := cacheClient.Verify(ctx, "test-cache", VerifyOptions{CompareWorkingTree: true})
+		require.NoError(t, err)
+		assert.True(t, result.Exists)
+		assert.Equal(t, "sha256:"+saveResult.Archive.Sha256Sum, result.ActualDigest)
+		assert.Equal(t, result.Digest, result.ActualDigest)
+		assert.Len(t, result.Entries, 5)
+		assert.Empty(t, result.Drift)
+	})
+
+	t.Run("drifted working tree", func(t *testing.T) {
+		absCacheDir, err := filepath.Abs(cacheDir)
+		require.NoError(t, err)
+
+		modified := filepath.Join(absCacheDir, "large-file-1.bin")
+		missing := filepath.Join(absCacheDir, "nested", "large-file-3.bin")
+		added := filepath.Join(absCacheDir, "added.txt")
+
+		f, err := os.OpenFile(modified, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteAt([]byte("changed"), 0)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, os.Remove(missing))
+		require.NoError(t, os.WriteFile(added, []byte("added"), 0o600))
+
+		result, err := cacheClient.Verify(ctx, "test-cache", VerifyOptions{CompareWorkingTree: true})
+		require.NoError(t, err)
+		assert.Equal(t, []archive.Diff{
+			{Path: added, Kind: archive.DiffAdded},
+			{Path: modified, Kind: archive.DiffModified},
+			{Path: missing, Kind: archive.DiffMissing},
+		}, result.Drift)
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		result, err := cacheClient.Verify(ctx, "test-cache", VerifyOptions{Key: "v1-other-key"})
+		require.NoError(t, err)
+		assert.False(t, result.Exists)
+		assert.Equal(t, "v1-other-key", result.Key)
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		entry := cacheClient.client.(*mockAPIClient).registries["~"].cache["v1-test-key"]
+		entry.digest = "sha256:0000"
+
+		_, err := cacheClient.Verify(ctx, "test-cache", VerifyOptions{})
+		require.ErrorIs(t, err, ErrDigestMismatch)
+	})
+}
+
+func TestCacheIntegration_ErrorTypes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("registry not found", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+		cacheClient.registry = "missing"
+
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.ErrorIs(t, err, ErrRegistryNotFound)
+	})
+
+	t.Run("store unavailable", func(t *testing.T) {
+		cacheClient, _, _ := setupTestCache(t, "local_file")
+
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+
+		cacheClient.bucketURL = "file:///"
+
+		_, err = cacheClient.Restore(ctx, "test-cache")
+		require.ErrorIs(t, err, ErrStoreUnavailable)
+		require.ErrorIs(t, err, ErrDownloadFailed)
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		cacheClient, _, storageDir := setupTestCache(t, "local_file")
+
+		_, err := cacheClient.Save(ctx, "test-cache")
+		require.NoError(t, err)
+
+		mockClient := cacheClient.client.(*mockAPIClient)
+		entry := mockClient.registries["~"].cache["v1-test-key"]
+		require.NoError(t, os.WriteFile(filepath.Join(storageDir, entry.storeObjectName), []byte("corrupt"), 0o600))
+
+		_, err = cacheClient.Restore(ctx, "test-cache")
+		require.ErrorIs(t, err, ErrDigestMismatch)
+		require.ErrorIs(t, err, ErrDownloadFailed)
+	})
+}
+
+func TestCacheIntegration_UploadTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// simulate a hung upload
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(done) })
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+	cacheClient.client = newMockAPIClient(store.LocalHTTPStore)
+	cacheClient.bucketURL = server.URL
+	cacheClient.uploadTimeout = 100 * time.Millisecond
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.ErrorIs(t, err, ErrUploadFailed)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "timed out after 100ms")
+}
+
+func TestCacheIntegration_SharePaths(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	mockClient := cacheClient.client.(*mockAPIClient)
+
+	otherDir := filepath.Join(filepath.Dir(cacheDir), "other")
+	createRandomFile(t, filepath.Join(otherDir, "other.bin"), 1024)
+
+	cacheClient.caches = append(cacheClient.caches, cache.Cache{
+		ID:    "other-cache",
+		Key:   "v1-other-key",
+		Paths: []string{cacheDir, otherDir},
+	})
+
+	result, err := cacheClient.SaveAll(ctx, nil, SaveAllOptions{SharePaths: true})
+	require.NoError(t, err)
+	require.Len(t, result.Shared, 1)
+	assert.Equal(t, "test-cache+other-cache", result.Shared[0].CacheID)
+	assert.True(t, result.Shared[0].Result.CacheCreated)
+
+	sharedKey := result.Shared[0].Result.Key
+	assert.True(t, strings.HasPrefix(sharedKey, sharedKeyPrefix))
+	assert.Equal(t, []string{cacheDir}, mockClient.registries["~"].cache[sharedKey].paths)
+	assert.Empty(t, mockClient.registries["~"].cache[sharedKey].fallbackKeys, "shared entries are only restored by their exact key")
+
+	// the shared path is only archived in the shared entry
+	for _, cacheResult := range result.Caches {
+		assert.True(t, cacheResult.Result.CacheCreated)
+		assert.Equal(t, []string{cacheDir}, cacheResult.Result.SharedPaths)
+		assert.Less(t, cacheResult.Result.Archive.Size, int64(1<<20))
+	}
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+	require.NoError(t, os.RemoveAll(otherDir))
+
+	restoreResult, err := cacheClient.Restore(ctx, "other-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.Empty(t, restoreResult.MissingSharedPaths)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	assert.FileExists(t, filepath.Join(otherDir, "other.bin"))
+	assert.Greater(t, restoreResult.Transfer.BytesTransferred, int64(90<<20))
+
+	// shared paths are swapped into place by atomic restores too
+	cacheClient.caches[0].AtomicRestore = true
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "large-file-1.bin"))
+
+	// paths whose shared entry is gone are left as they are
+	delete(mockClient.registries["~"].cache, sharedKey)
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "local.txt"), []byte("local"), 0o600))
+
+	cacheClient.caches[0].AtomicRestore = false
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheRestored)
+	assert.False(t, restoreResult.CacheHit, "a cache missing shared paths isn't a hit")
+	assert.Equal(t, []string{cacheDir}, restoreResult.MissingSharedPaths)
+	assert.FileExists(t, filepath.Join(cacheDir, "local.txt"))
+}
+
+func TestCacheIntegration_SharePathsMissing(t *testing.T) {
+	ctx := context.Background()
+
+	cacheClient, cacheDir, _ := setupTestCache(t, "local_file")
+	mockClient := cacheClient.client.(*mockAPIClient)
+
+	missingDir := filepath.Join(filepath.Dir(cacheDir), "missing")
+
+	cacheClient.caches[0].Paths = []string{cacheDir, missingDir}
+	cacheClient.caches[0].MissingPaths = cache.MissingPathsWarn
+	cacheClient.caches = append(cacheClient.caches, cache.Cache{
+		ID:           "other-cache",
+		Key:          "v1-other-key",
+		Paths:        []string{cacheDir, missingDir},
+		MissingPaths: cache.MissingPathsWarn,
+	})
+
+	result, err := cacheClient.SaveAll(ctx, nil, SaveAllOptions{SharePaths: true})
+	require.NoError(t, err)
+	require.Len(t, result.Shared, 1)
+	assert.Equal(t, []string{missingDir}, result.Shared[0].Result.MissingPaths)
+
+	// only the archived paths are referenced, so each cache handles the
+	// missing path itself
+	for _, cacheResult := range result.Caches {
+		assert.Equal(t, []string{cacheDir}, cacheResult.Result.SharedPaths)
+		assert.Equal(t, []string{missingDir}, cacheResult.Result.MissingPaths)
+	}
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	restoreResult, err := cacheClient.Restore(ctx, "other-cache")
+	require.NoError(t, err)
+	assert.True(t, restoreResult.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	assert.Len(t, mockClient.registries["~"].cache, 3)
+}
+
+func TestCacheIntegration_RefreshOnRead(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	cacheClient, cacheDir, storageDir := setupTestCache(t, "local_file")
+	mockClient := cacheClient.client.(*mockAPIClient)
+
+	_, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+
+	// serve the saved archive from a fake S3 bucket
+	absStorageDir, err := filepath.Abs(storageDir)
+	require.NoError(t, err)
+	fileBlob, err := store.NewBlobStore(ctx, store.LocalFileStore, "file://"+absStorageDir)
+	require.NoError(t, err)
+
+	entry := mockClient.registries["~"].cache["v1-test-key"]
+	objectFile := filepath.Join(t.TempDir(), "object")
+	_, err = fileBlob.Download(ctx, entry.storeObjectName, objectFile)
+	require.NoError(t, err)
+	object, err := os.ReadFile(objectFile)
+	require.NoError(t, err)
+
+	const objectName = "v1-test-key.zip"
+	entry.storeObjectName = objectName
+
+	var copies atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+			// slow enough for the restore to return first if it didn't wait
+			time.Sleep(200 * time.Millisecond)
+			copies.Add(1)
+			_, _ = fmt.Fprint(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+		case r.Method == http.MethodGet && r.URL.Path == "/test-bucket/"+objectName:
+			http.ServeContent(w, r, objectName, time.Time{}, bytes.NewReader(object))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	mockClient.registries["~"].store = store.LocalS3Store
+	cacheClient.bucketURL = "s3://test-bucket?use_path_style=true&endpoint=" + server.URL
+
+	require.NoError(t, os.RemoveAll(cacheDir))
+
+	result, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.True(t, result.CacheHit)
+	assert.FileExists(t, filepath.Join(cacheDir, "nested", "large-file-3.bin"))
+	assert.Equal(t, int64(1), copies.Load(), "the expiration is refreshed before the restore returns")
+}
+
+func TestCacheIntegration_Metrics(t *testing.T) {
+	ctx := context.Background()
+
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	cacheClient, _, _ := setupTestCache(t, "local_file")
+
+	restoreResult, err := cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	assert.False(t, restoreResult.CacheRestored)
+
+	saveResult, err := cacheClient.Save(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, saveResult.CacheCreated)
+
+	restoreResult, err = cacheClient.Restore(ctx, "test-cache")
+	require.NoError(t, err)
+	require.True(t, restoreResult.CacheHit)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &rm))
+
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+
+	assert.Equal(t, int64(1), metrics["cache.hit"].(metricdata.Sum[int64]).DataPoints[0].Value)
+	assert.Equal(t, int64(1), metrics["cache.miss"].(metricdata.Sum[int64]).DataPoints[0].Value)
+	assert.Equal(t, saveResult.Archive.Size, metrics["archive.bytes"].(metricdata.Histogram[int64]).DataPoints[0].Sum)
+
+	// one upload and one download
+	transferBytes := metrics["transfer.bytes"].(metricdata.Histogram[int64])
+	assert.Len(t, transferBytes.DataPoints, 2)
+	assert.Len(t, metrics["transfer.duration"].(metricdata.Histogram[float64]).DataPoints, 2)
+}
+
+func TestTransferTimeout(t *testing.T) {
+	assert.Equal(t, DefaultTransferTimeout, transferTimeout(0))
+	assert.Equal(t, time.Duration(0), transferTimeout(-1))
+	assert.Equal(t, time.Minute, transferTimeout(time.Minute))
+}
@@ -0,0 +1,41 @@
+package zstash
+
+import (
+	"slices"
+
+	"github.com/buildkite/zstash/api"
+)
+
+// Compression formats negotiated with cache registries which advertise the
+// formats they accept, see api.CacheRegistryResp.
+const (
+	// CompressionZstd is a zip archive of zstd compressed entries.
+	CompressionZstd = "zstd"
+
+	// CompressionZip is a zip archive of deflate compressed entries, which
+	// every client can extract.
+	CompressionZip = "zip"
+)
+
+// supportedCompression lists the compression formats the client can save
+// archives in, most preferred first.
+var supportedCompression = []string{CompressionZstd, CompressionZip}
+
+// negotiateCompression returns the client's most preferred compression format
+// accepted by the registry, falling back to CompressionZip if the registry
+// accepts none of them. An empty string is returned for registries which
+// don't advertise the formats they accept, which are sent archives in
+// Config.Format with zstd compressed entries as before.
+func negotiateCompression(registryResp api.CacheRegistryResp) string {
+	if len(registryResp.Compression) == 0 {
+		return ""
+	}
+
+	for _, format := range supportedCompression {
+		if slices.Contains(registryResp.Compression, format) {
+			return format
+		}
+	}
+
+	return CompressionZip
+}
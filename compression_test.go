@@ -0,0 +1,27 @@
+package zstash
+
+import (
+	"testing"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []string
+		want     string
+	}{
+		{name: "not advertised", accepted: nil, want: ""},
+		{name: "zstd preferred", accepted: []string{CompressionZip, CompressionZstd}, want: CompressionZstd},
+		{name: "zip only", accepted: []string{CompressionZip}, want: CompressionZip},
+		{name: "unsupported formats fall back to zip", accepted: []string{"brotli"}, want: CompressionZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateCompression(api.CacheRegistryResp{Compression: tt.accepted}))
+		})
+	}
+}
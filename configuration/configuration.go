@@ -3,7 +3,9 @@ package configuration
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/buildkite/zstash/cache"
@@ -18,16 +20,16 @@ Takes a list of cache configurations and expands them into a list of cache of re
 
 * Expands cache.Template with the template values from template.json
 
-* Expands cache.Key using templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
+* Expands cache.Key using templatable arguments (such as id, agent.os, agent.arch, platform, env, checksum etc)
 
-* Expands cache.FallbackKeys using templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
+* Expands cache.FallbackKeys using templatable arguments (such as id, agent.os, agent.arch, platform, env, checksum etc)
 
-* Expands cache.Paths using templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
+* Expands cache.Paths using templatable arguments (such as id, agent.os, agent.arch, platform, env, checksum etc)
 
 Uses the OS environment variables for template expansion.
 */
 func ExpandCacheConfiguration(caches []cache.Cache) ([]cache.Cache, error) {
-	return expandCacheConfiguration(caches, nil)
+	return expandCacheConfiguration(caches, key.Options{})
 }
 
 /*
@@ -42,52 +44,223 @@ Parameters:
 Returns the expanded cache configurations or an error if expansion fails.
 */
 func ExpandCacheConfigurationWithEnv(caches []cache.Cache, env map[string]string) ([]cache.Cache, error) {
-	return expandCacheConfiguration(caches, env)
+	return expandCacheConfiguration(caches, key.Options{Env: env})
 }
 
-func expandCacheConfiguration(caches []cache.Cache, env map[string]string) ([]cache.Cache, error) {
+// Options controls how cache configurations are expanded.
+type Options struct {
+	// Env is the environment used for template expansion, when nil the OS environment is used.
+	Env map[string]string
+	// AllowCommands enables the cmdsum template function, which runs commands
+	// and hashes their output. This is disabled by default as configuration
+	// files can then execute arbitrary commands.
+	AllowCommands bool
+	// Platform is the platform tag returned by the platform template function.
+	// Defaults to runtime.GOOS/runtime.GOARCH.
+	Platform string
+	// Logger logs the files and environment variables used by templates.
+	// Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+/*
+ExpandCacheConfigurationWithOptions expands cache configurations using the provided options.
+
+Parameters:
+  - caches: List of cache configurations to expand
+  - opts: Options controlling template expansion
+
+Returns the expanded cache configurations or an error if expansion fails.
+*/
+func ExpandCacheConfigurationWithOptions(caches []cache.Cache, opts Options) ([]cache.Cache, error) {
+	return expandCacheConfiguration(caches, keyOptions(opts))
+}
+
+func keyOptions(opts Options) key.Options {
+	return key.Options{Env: opts.Env, AllowCommands: opts.AllowCommands, Platform: opts.Platform, Logger: opts.Logger}
+}
+
+func expandCacheConfiguration(caches []cache.Cache, opts key.Options) ([]cache.Cache, error) {
 	templatesMap, err := loadTemplates()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load templates: %w", err)
 	}
 
+	// the key, fallback keys and paths of caches commonly checksum the same
+	// patterns, so they're only resolved and hashed once
+	if opts.Memo == nil {
+		opts.Memo = key.NewMemo()
+	}
+
 	for i, cache := range caches {
-		// Replace cache.Template with the template values from template.json
-		if cache.Template != "" {
-			cache, err = augmentTemplateWithCache(templatesMap, cache)
-			if err != nil {
-				return nil, fmt.Errorf("failed to augment template with cache: %w", err)
-			}
+		cache, err = expandCache(templatesMap, cache, opts)
+		if err != nil {
+			return nil, err
 		}
 
-		// Replace cache.Key with the templatable arguments
-		cache.Key, err = key.TemplateWithEnv(cache.ID, cache.Key, env)
+		// Save the modified cache back to the slice
+		caches[i] = cache
+	}
+
+	return caches, nil
+}
+
+/*
+ValidateCacheConfiguration expands and validates every cache configuration, unlike
+ExpandCacheConfiguration which stops at the first invalid entry. This is intended
+for linting configuration before it is used in a job.
+
+Returns the resolved cache configurations, with invalid entries left unexpanded, along
+with an error joining the failures for every invalid entry.
+*/
+func ValidateCacheConfiguration(caches []cache.Cache, opts Options) ([]cache.Cache, error) {
+	templatesMap, err := loadTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+
+	keyOpts := keyOptions(opts)
+	keyOpts.Memo = key.NewMemo()
+
+	resolved := make([]cache.Cache, len(caches))
+
+	var errs []error
+	for i, cache := range caches {
+		expanded, err := expandCache(templatesMap, cache, keyOpts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to expand key: %w", err)
+			errs = append(errs, fmt.Errorf("cache at index %d: %w", i, err))
+			expanded = cache
 		}
 
-		// Replace cache.FallbackKeys with the templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
-		cache.FallbackKeys, err = expandStringsWithEnv(cache.ID, cache.FallbackKeys, env)
+		resolved[i] = expanded
+	}
+
+	return resolved, errors.Join(errs...)
+}
+
+// expandCache expands the template, key, fallback keys and paths of a single cache and validates the result.
+func expandCache(templatesMap map[string]cache.Cache, cache cache.Cache, opts key.Options) (cache.Cache, error) {
+	var err error
+
+	// Replace cache.Template with the template values from template.json
+	if cache.Template != "" {
+		cache, err = augmentTemplateWithCache(templatesMap, cache)
 		if err != nil {
-			return nil, fmt.Errorf("failed to expand fallback keys: %w", err)
+			return cache, fmt.Errorf("failed to augment template with cache: %w", err)
 		}
+	}
+
+	// Replace cache.Key with the templatable arguments
+	cache.Key, err = key.TemplateWithOptions(cache.ID, cache.Key, opts)
+	if err != nil {
+		return cache, fmt.Errorf("failed to expand key: %w", err)
+	}
+
+	// Replace cache.FallbackKeys with the templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
+	cache.FallbackKeys, err = expandStringsWithOptions(cache.ID, cache.FallbackKeys, opts)
+	if err != nil {
+		return cache, fmt.Errorf("failed to expand fallback keys: %w", err)
+	}
+
+	// Replace cache.Paths with the templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
+	cache.Paths, err = expandStringsWithOptions(cache.ID, cache.Paths, opts)
+	if err != nil {
+		return cache, fmt.Errorf("failed to expand paths: %w", err)
+	}
+
+	// Validates the cache object
+	if err := cache.Validate(); err != nil {
+		return cache, fmt.Errorf("cache validation failed for ID %s: %w", cache.ID, err)
+	}
 
-		// Replace cache.Paths with the templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
-		cache.Paths, err = expandStringsWithEnv(cache.ID, cache.Paths, env)
+	return cache, nil
+}
+
+// ChecksumFile is a file which contributed to a checksum in a key template.
+type ChecksumFile struct {
+	// Path is the path of the file, relative to the working directory.
+	Path string
+	// Digest is the sha256 digest of the file contents.
+	Digest string
+}
+
+// KeyResolution describes how a key template was resolved.
+type KeyResolution struct {
+	// Template is the unexpanded key template.
+	Template string
+	// Key is the expanded key.
+	Key string
+	// Files lists every file which contributed to a checksum in the key, in the order they were hashed.
+	Files []ChecksumFile
+}
+
+/*
+ResolveKey expands a single key template, returning the resolved key along with every file
+which contributed to a checksum in the key and its digest. This is intended for debugging
+why a key has changed.
+
+The id is used for the id template function and may be empty.
+*/
+func ResolveKey(id, template string, opts Options) (KeyResolution, error) {
+	resolved, files, err := key.TemplateWithDetails(id, template, keyOptions(opts))
+	if err != nil {
+		return KeyResolution{}, fmt.Errorf("failed to expand key: %w", err)
+	}
+
+	resolution := KeyResolution{Template: template, Key: resolved}
+	for _, file := range files {
+		resolution.Files = append(resolution.Files, ChecksumFile{Path: file.Path, Digest: file.Digest})
+	}
+
+	return resolution, nil
+}
+
+/*
+ResolveCacheKeys resolves the key and each fallback key of a cache configuration, applying
+the cache template first if one is set, for debugging why a key has changed.
+
+Returns the resolution of the key followed by the resolution of each fallback key.
+*/
+func ResolveCacheKeys(c cache.Cache, opts Options) (KeyResolution, []KeyResolution, error) {
+	if c.Template != "" {
+		templatesMap, err := loadTemplates()
 		if err != nil {
-			return nil, fmt.Errorf("failed to expand paths: %w", err)
+			return KeyResolution{}, nil, fmt.Errorf("failed to load templates: %w", err)
 		}
 
-		// Validates the cache object
-		if err := cache.Validate(); err != nil {
-			return nil, fmt.Errorf("cache validation failed for ID %s: %w", cache.ID, err)
+		c, err = augmentTemplateWithCache(templatesMap, c)
+		if err != nil {
+			return KeyResolution{}, nil, fmt.Errorf("failed to augment template with cache: %w", err)
 		}
+	}
 
-		// Save the modified cache back to the slice
-		caches[i] = cache
+	keyResolution, err := ResolveKey(c.ID, c.Key, opts)
+	if err != nil {
+		return KeyResolution{}, nil, err
 	}
 
-	return caches, nil
+	fallbackResolutions := make([]KeyResolution, 0, len(c.FallbackKeys))
+	for _, fallbackKey := range c.FallbackKeys {
+		// trim quotes and whitespace, matching expandStringsWithOptions
+		fallbackKey = strings.Trim(fallbackKey, "\"' \t")
+
+		resolution, err := ResolveKey(c.ID, fallbackKey, opts)
+		if err != nil {
+			return KeyResolution{}, nil, fmt.Errorf("failed to expand fallback keys: %w", err)
+		}
+
+		fallbackResolutions = append(fallbackResolutions, resolution)
+	}
+
+	return keyResolution, fallbackResolutions, nil
+}
+
+/*
+Templates returns the built-in cache templates, keyed by template name, which can be
+referenced using cache.Template. Keys, fallback keys and paths are returned unexpanded.
+*/
+func Templates() (map[string]cache.Cache, error) {
+	return loadTemplates()
 }
 
 /*
@@ -230,6 +403,51 @@ func augmentTemplateWithCache(templatesMap map[string]cache.Cache, cache cache.C
 	if len(cache.Paths) > 0 {
 		template.Paths = cache.Paths
 	}
+	if cache.MaxSize != 0 {
+		template.MaxSize = cache.MaxSize
+	}
+	if cache.Scope != "" {
+		template.Scope = cache.Scope
+	}
+	if cache.OnHit != "" {
+		template.OnHit = cache.OnHit
+	}
+	if cache.OnMiss != "" {
+		template.OnMiss = cache.OnMiss
+	}
+	if cache.PreserveMtimes {
+		template.PreserveMtimes = true
+	}
+	if cache.Precompressed {
+		template.Precompressed = true
+	}
+	if cache.Manifest {
+		template.Manifest = true
+	}
+	if cache.StrictPlatform {
+		template.StrictPlatform = true
+	}
+	if len(cache.Ignore) > 0 {
+		template.Ignore = cache.Ignore
+	}
+	if cache.NoDefaultIgnore {
+		template.NoDefaultIgnore = true
+	}
+	if cache.AtomicRestore {
+		template.AtomicRestore = true
+	}
+	if cache.ExtractDirMode != 0 {
+		template.ExtractDirMode = cache.ExtractDirMode
+	}
+	if cache.ExtractFileMode != 0 {
+		template.ExtractFileMode = cache.ExtractFileMode
+	}
+	if cache.ExtractUmask != 0 {
+		template.ExtractUmask = cache.ExtractUmask
+	}
+	if cache.MissingPaths != "" {
+		template.MissingPaths = cache.MissingPaths
+	}
 
 	return template, nil
 }
@@ -238,7 +456,7 @@ func augmentTemplateWithCache(templatesMap map[string]cache.Cache, cache cache.C
 Expands an array of strings with templatable arguments (such as id, agent.os, agent.arch, env, checksum etc)
 Uses the provided environment map if not nil, otherwise uses OS environment.
 */
-func expandStringsWithEnv(id string, stringsArray []string, env map[string]string) ([]string, error) {
+func expandStringsWithOptions(id string, stringsArray []string, opts key.Options) ([]string, error) {
 	expandedStrings := make([]string, len(stringsArray))
 
 	for n, stringTemplate := range stringsArray {
@@ -246,7 +464,7 @@ func expandStringsWithEnv(id string, stringsArray []string, env map[string]strin
 		// trim quotes and whitespace
 		stringTemplate = strings.Trim(stringTemplate, "\"' \t")
 
-		expandedString, err := key.TemplateWithEnv(id, stringTemplate, env)
+		expandedString, err := key.TemplateWithOptions(id, stringTemplate, opts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to template key: %w", err)
 		}
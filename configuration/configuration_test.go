@@ -74,6 +74,48 @@ func TestLoadTemplateDefaults(t *testing.T) {
 					Paths: []string{"vendor/bundle"},
 				},
 			},
+			{
+				name: "with ruby template and max size",
+				cache: cache.Cache{
+					ID:       "my_ruby",
+					Template: "ruby",
+					Key:      "my-key-overriden",
+					MaxSize:  512 * 1024 * 1024,
+				},
+				expected: cache.Cache{
+					ID:       "my_ruby",
+					Template: "",
+					Registry: "",
+					Key:      "my-key-overriden",
+					FallbackKeys: []string{
+						fmt.Sprintf("my_ruby-%s-%s-", runtime.GOOS, runtime.GOARCH),
+						"my_ruby-",
+					},
+					Paths:   []string{"vendor/bundle"},
+					MaxSize: 512 * 1024 * 1024,
+				},
+			},
+			{
+				name: "with ruby template and hooks",
+				cache: cache.Cache{
+					ID:       "my_ruby",
+					Template: "ruby",
+					Key:      "my-key-overriden",
+					OnMiss:   "bundle install",
+				},
+				expected: cache.Cache{
+					ID:       "my_ruby",
+					Template: "",
+					Registry: "",
+					Key:      "my-key-overriden",
+					FallbackKeys: []string{
+						fmt.Sprintf("my_ruby-%s-%s-", runtime.GOOS, runtime.GOARCH),
+						"my_ruby-",
+					},
+					Paths:  []string{"vendor/bundle"},
+					OnMiss: "bundle install",
+				},
+			},
 			{
 				name: "with node-yarn template",
 				cache: cache.Cache{
@@ -243,3 +285,96 @@ func TestLoadTemplateDefaults(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateCacheConfiguration(t *testing.T) {
+	t.Run("all valid", func(t *testing.T) {
+		assert := require.New(t)
+
+		got, err := ValidateCacheConfiguration([]cache.Cache{
+			{ID: "my_cache", Key: "{{ id }}-{{ env \"BRANCH\" }}", Paths: []string{"vendor"}},
+		}, Options{Env: map[string]string{"BRANCH": "main"}})
+		assert.NoError(err)
+		assert.Len(got, 1)
+		assert.Equal("my_cache-main", got[0].Key)
+	})
+
+	t.Run("reports every invalid entry", func(t *testing.T) {
+		assert := require.New(t)
+
+		caches := []cache.Cache{
+			{ID: "invalid-id", Key: "key", Paths: []string{"vendor"}},
+			{ID: "valid", Key: "{{ id }}", Paths: []string{"vendor"}},
+			{ID: "no_paths", Key: "key"},
+			{ID: "missing_template", Template: "does-not-exist"},
+		}
+
+		got, err := ValidateCacheConfiguration(caches, Options{})
+		assert.Error(err)
+		assert.Contains(err.Error(), "cache at index 0")
+		assert.NotContains(err.Error(), "cache at index 1")
+		assert.Contains(err.Error(), "cache at index 2")
+		assert.Contains(err.Error(), "cache at index 3")
+		assert.Contains(err.Error(), "template 'does-not-exist' not found")
+
+		assert.Len(got, 4)
+		assert.Equal("valid", got[1].Key)
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	assert := require.New(t)
+
+	templates, err := Templates()
+	assert.NoError(err)
+
+	for _, name := range []string{
+		"node-yarn", "node-npm", "node-pnpm", "ruby", "go", "python-pip", "python-poetry",
+		"gradle", "maven", "cargo", "composer", "bazel",
+	} {
+		tpl, ok := templates[name]
+		assert.True(ok, "template %s should exist", name)
+		assert.Contains(tpl.Key, "checksum", "template %s key should be checksum based", name)
+		assert.NotEmpty(tpl.FallbackKeys, "template %s should have fallback keys", name)
+		assert.NotEmpty(tpl.Paths, "template %s should have paths", name)
+	}
+}
+
+func TestResolveCacheKeys(t *testing.T) {
+	assert := require.New(t)
+
+	t.Chdir(t.TempDir())
+	assert.NoError(os.WriteFile("yarn.lock", []byte("test content"), 0600))
+
+	keyResolution, fallbacks, err := ResolveCacheKeys(cache.Cache{
+		ID:       "my_node_yarn",
+		Template: "node-yarn",
+	}, Options{})
+	assert.NoError(err)
+
+	assert.Equal(fmt.Sprintf("my_node_yarn-%s-%s-4b9054a7a40e53c2e310fcd6f696c46c6a40dcdfa5b849785a456756ec512660", runtime.GOOS, runtime.GOARCH), keyResolution.Key)
+	assert.Equal([]ChecksumFile{
+		{Path: "yarn.lock", Digest: "6ae8a75555209fd6c44157c0aed8016e763ff435a19cf186f76863140143ff72"},
+	}, keyResolution.Files)
+
+	assert.Len(fallbacks, 2)
+	assert.Equal(fmt.Sprintf("my_node_yarn-%s-%s-", runtime.GOOS, runtime.GOARCH), fallbacks[0].Key)
+	assert.Equal("my_node_yarn-", fallbacks[1].Key)
+	assert.Empty(fallbacks[0].Files)
+}
+
+func TestExpandCacheConfigurationWithOptions_Platform(t *testing.T) {
+	assert := require.New(t)
+
+	caches, err := ExpandCacheConfigurationWithOptions([]cache.Cache{
+		{
+			ID:           "deps",
+			Key:          "{{ id }}-{{ platform }}-v1",
+			FallbackKeys: []string{"{{ id }}-{{ platform }}-"},
+			Paths:        []string{"vendor"},
+		},
+	}, Options{Platform: "linux/amd64/musl"})
+	assert.NoError(err)
+	assert.Len(caches, 1)
+	assert.Equal("deps-linux/amd64/musl-v1", caches[0].Key)
+	assert.Equal([]string{"deps-linux/amd64/musl-"}, caches[0].FallbackKeys)
+}
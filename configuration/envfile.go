@@ -0,0 +1,140 @@
+package configuration
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+/*
+ParseEnvFile parses KEY=VALUE pairs in dotenv format, as used to stage
+variables computed by earlier CI steps:
+
+	# comments and blank lines are ignored
+	export GO_VERSION=1.24
+	NODE_VERSION="22.1.0" # trailing comments are ignored
+	LOCKFILE='package-lock.json'
+
+Double quoted values support the \n, \t, \" and \\ escapes, single quoted
+values are used literally. Later values override earlier ones.
+*/
+func ParseEnvFile(data []byte) (map[string]string, error) {
+	env := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+
+		name = strings.TrimSpace(name)
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNumber, name)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		env[name] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	return env, nil
+}
+
+// parseEnvValue unquotes a value, removing any trailing comment.
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated quoted value %s", value)
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+
+	return strings.TrimSpace(value), nil
+}
+
+// LoadEnvFile returns the OS environment overridden by the variables in the
+// dotenv file at path, see ParseEnvFile, for use as zstash.Config.Env.
+func LoadEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is configured by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	fileEnv, err := ParseEnvFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid env file %s: %w", path, err)
+	}
+
+	env := osEnv()
+	for name, value := range fileEnv {
+		env[name] = value
+	}
+
+	return env, nil
+}
+
+// osEnv returns the OS environment as a map.
+func osEnv() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,74 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	assert := require.New(t)
+
+	env, err := ParseEnvFile([]byte(`
+# computed by an earlier step
+export GO_VERSION=1.24
+NODE_VERSION="22.1.0" # trailing comment
+LOCKFILE='package-lock.json # not a comment'
+ESCAPED="line one\nline \"two\""
+EMPTY=
+UNQUOTED = value with spaces # comment
+GO_VERSION=1.25
+`))
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"GO_VERSION":   "1.25",
+		"NODE_VERSION": "22.1.0",
+		"LOCKFILE":     "package-lock.json # not a comment",
+		"ESCAPED":      "line one\nline \"two\"",
+		"EMPTY":        "",
+		"UNQUOTED":     "value with spaces",
+	}, env)
+}
+
+func TestParseEnvFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "missing equals", data: "GO_VERSION", wantErr: "line 1: expected KEY=VALUE"},
+		{name: "invalid name", data: "\n1GO=1.24", wantErr: `line 2: invalid variable name "1GO"`},
+		{name: "unterminated double quote", data: `GO="1.24`, wantErr: "unterminated quoted value"},
+		{name: "unterminated single quote", data: `GO='1.24`, wantErr: "unterminated quoted value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := ParseEnvFile([]byte(tt.data))
+			assert.ErrorContains(err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	assert := require.New(t)
+
+	t.Setenv("ZSTASH_TEST_OS_ONLY", "os")
+	t.Setenv("ZSTASH_TEST_OVERRIDDEN", "os")
+
+	path := filepath.Join(t.TempDir(), "cache.env")
+	assert.NoError(os.WriteFile(path, []byte("ZSTASH_TEST_OVERRIDDEN=file\nZSTASH_TEST_FILE_ONLY=file\n"), 0o600))
+
+	env, err := LoadEnvFile(path)
+	assert.NoError(err)
+	assert.Equal("os", env["ZSTASH_TEST_OS_ONLY"])
+	assert.Equal("file", env["ZSTASH_TEST_OVERRIDDEN"])
+	assert.Equal("file", env["ZSTASH_TEST_FILE_ONLY"])
+
+	_, err = LoadEnvFile(filepath.Join(t.TempDir(), "missing.env"))
+	assert.ErrorContains(err, "failed to read env file")
+}
@@ -0,0 +1,162 @@
+package configuration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/zstash/cache"
+)
+
+// maxIncludeSize limits the size of a configuration fetched from a URL.
+const maxIncludeSize = 1 << 20 // 1MiB
+
+/*
+LoadCacheConfiguration reads the cache configuration at location, a file path
+or an http or https URL, see ParseCacheConfiguration. The configuration may
+include other configurations, so caches shared by many pipelines can be
+defined once:
+
+	include:
+	  - ../shared/cache.yml
+	  - https://example.com/buildkite/org-caches.yml
+	caches:
+	  - id: node_modules
+	    template: node-npm
+
+Included configurations are merged in order, followed by the including
+configuration's own caches, with a cache replacing any earlier cache with the
+same ID. Relative includes are resolved against the including configuration,
+and included configurations may themselves include others.
+*/
+func LoadCacheConfiguration(ctx context.Context, location string) ([]cache.Cache, error) {
+	configs, err := loadConfiguration(ctx, location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return toCaches(configs)
+}
+
+// loadConfiguration loads the configuration at location along with its
+// includes. parents lists the configurations which included it, to detect
+// cycles.
+func loadConfiguration(ctx context.Context, location string, parents []string) ([]cacheConfig, error) {
+	chain := append(slices.Clone(parents), location)
+	if slices.Contains(parents, location) {
+		return nil, fmt.Errorf("cache configuration include cycle: %s", strings.Join(chain, " -> "))
+	}
+
+	data, err := readConfiguration(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parseConfiguration(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache configuration %s: %w", location, err)
+	}
+
+	var configs []cacheConfig
+	for _, include := range file.Include {
+		includeLocation, err := resolveInclude(location, include)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %q in cache configuration %s: %w", include, location, err)
+		}
+
+		included, err := loadConfiguration(ctx, includeLocation, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		configs = mergeConfigs(configs, included)
+	}
+
+	return mergeConfigs(configs, file.Caches), nil
+}
+
+// mergeConfigs returns base with the overrides appended, replacing the cache
+// with the same ID in place if there is one.
+func mergeConfigs(base, overrides []cacheConfig) []cacheConfig {
+	for _, override := range overrides {
+		i := slices.IndexFunc(base, func(c cacheConfig) bool { return c.ID == override.ID })
+		if i < 0 {
+			base = append(base, override)
+			continue
+		}
+		base[i] = override
+	}
+
+	return base
+}
+
+// resolveInclude returns the location of an include relative to the location
+// of the configuration including it.
+func resolveInclude(location, include string) (string, error) {
+	if isURL(include) || filepath.IsAbs(include) {
+		return include, nil
+	}
+
+	if isURL(location) {
+		base, err := url.Parse(location)
+		if err != nil {
+			return "", err
+		}
+
+		ref, err := url.Parse(include)
+		if err != nil {
+			return "", err
+		}
+
+		return base.ResolveReference(ref).String(), nil
+	}
+
+	return filepath.Join(filepath.Dir(location), include), nil
+}
+
+// readConfiguration reads the configuration file or URL at location.
+func readConfiguration(ctx context.Context, location string) ([]byte, error) {
+	if !isURL(location) {
+		data, err := os.ReadFile(location) // #nosec G304 -- path is configured by the user
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache configuration: %w", err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for cache configuration: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cache configuration: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch cache configuration %s: %s", location, res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxIncludeSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cache configuration: %w", err)
+	}
+	if len(data) > maxIncludeSize {
+		return nil, fmt.Errorf("cache configuration %s exceeds %d bytes", location, maxIncludeSize)
+	}
+
+	return data, nil
+}
+
+// isURL reports whether location is an http or https URL rather than a path.
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
@@ -0,0 +1,104 @@
+package configuration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, path, data string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+}
+
+func TestLoadCacheConfiguration_Include(t *testing.T) {
+	assert := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/org/caches.yml":
+			_, _ = w.Write([]byte("include: [go.yml]\ncaches:\n  - id: node_modules\n    template: node-npm\n"))
+		case "/org/go.yml":
+			_, _ = w.Write([]byte("- id: go\n  template: golang\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "shared", "cache.yml"), `
+caches:
+  - id: gems
+    template: ruby-bundler
+  - id: go
+    key: '{{ id }}-shared'
+    paths: ["~/go/pkg/mod"]
+`)
+	writeConfig(t, filepath.Join(dir, "pipeline", ".buildkite", "cache.yml"), `
+include:
+  - `+server.URL+`/org/caches.yml
+  - ../../shared/cache.yml
+caches:
+  - id: node_modules
+    template: node-yarn
+`)
+
+	caches, err := LoadCacheConfiguration(context.Background(), filepath.Join(dir, "pipeline", ".buildkite", "cache.yml"))
+	assert.NoError(err)
+	assert.Equal([]cache.Cache{
+		{ID: "go", Key: "{{ id }}-shared", Paths: []string{"~/go/pkg/mod"}},
+		{ID: "node_modules", Template: "node-yarn"},
+		{ID: "gems", Template: "ruby-bundler"},
+	}, caches)
+}
+
+func TestLoadCacheConfiguration_Invalid(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "a.yml"), "include: [b.yml]\ncaches: []\n")
+	writeConfig(t, filepath.Join(dir, "b.yml"), "include: [a.yml]\ncaches: []\n")
+	writeConfig(t, filepath.Join(dir, "missing.yml"), "include: [nothing.yml]\ncaches: []\n")
+	writeConfig(t, filepath.Join(dir, "remote.yml"), "include: ["+server.URL+"/caches.yml]\ncaches: []\n")
+	writeConfig(t, filepath.Join(dir, "typo.yml"), "include: [typo-included.yml]\ncaches: []\n")
+	writeConfig(t, filepath.Join(dir, "typo-included.yml"), "caches:\n  - id: go\n    pths: [vendor]\n")
+
+	tests := []struct {
+		name        string
+		file        string
+		errContains string
+	}{
+		{name: "cycle", file: "a.yml", errContains: "include cycle"},
+		{name: "missing include", file: "missing.yml", errContains: "failed to read cache configuration"},
+		{name: "remote not found", file: "remote.yml", errContains: "404"},
+		{name: "invalid include", file: "typo.yml", errContains: "typo-included.yml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := LoadCacheConfiguration(context.Background(), filepath.Join(dir, tt.file))
+			assert.Error(err)
+			assert.Contains(err.Error(), tt.errContains)
+		})
+	}
+}
+
+func TestParseCacheConfiguration_Include(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := ParseCacheConfiguration([]byte("include: [shared.yml]\ncaches: []\n"))
+	assert.Error(err)
+	assert.Contains(err.Error(), "include is only supported")
+}
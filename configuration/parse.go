@@ -0,0 +1,232 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// InlineConfigEnv is the environment variable containing an inline YAML or
+// JSON cache configuration, allowing plugins and dynamic pipelines to
+// configure caches per step without writing a file into the checkout.
+const InlineConfigEnv = "BUILDKITE_CACHE_CONFIG_INLINE"
+
+// cacheConfig is the YAML and JSON representation of a cache.Cache.
+type cacheConfig struct {
+	ID              string   `yaml:"id" json:"id"`
+	Template        string   `yaml:"template" json:"template"`
+	Registry        string   `yaml:"registry" json:"registry"`
+	Key             string   `yaml:"key" json:"key"`
+	FallbackKeys    []string `yaml:"fallback_keys" json:"fallback_keys"`
+	Paths           []string `yaml:"paths" json:"paths"`
+	MaxSize         int64    `yaml:"max_size" json:"max_size"`
+	Scope           string   `yaml:"scope" json:"scope"`
+	OnHit           string   `yaml:"on_hit" json:"on_hit"`
+	OnMiss          string   `yaml:"on_miss" json:"on_miss"`
+	PreserveMtimes  bool     `yaml:"preserve_mtimes" json:"preserve_mtimes"`
+	Precompressed   bool     `yaml:"precompressed" json:"precompressed"`
+	Manifest        bool     `yaml:"manifest" json:"manifest"`
+	StrictPlatform  bool     `yaml:"strict_platform" json:"strict_platform"`
+	Ignore          []string `yaml:"ignore" json:"ignore"`
+	NoDefaultIgnore bool     `yaml:"no_default_ignore" json:"no_default_ignore"`
+	AtomicRestore   bool     `yaml:"atomic_restore" json:"atomic_restore"`
+	ExtractDirMode  string   `yaml:"extract_dir_mode" json:"extract_dir_mode"`
+	ExtractFileMode string   `yaml:"extract_file_mode" json:"extract_file_mode"`
+	ExtractUmask    string   `yaml:"extract_umask" json:"extract_umask"`
+	MissingPaths    string   `yaml:"missing_paths" json:"missing_paths"`
+}
+
+// cacheConfigFile is the representation of a configuration with a caches list.
+// A configuration which is a list of caches is parsed as a file with only
+// Caches set.
+type cacheConfigFile struct {
+	Include []string      `yaml:"include" json:"include"`
+	Caches  []cacheConfig `yaml:"caches" json:"caches"`
+}
+
+/*
+ParseCacheConfiguration parses a YAML or JSON cache configuration, which is
+either a list of caches or an object with a "caches" list:
+
+	caches:
+	  - id: node_modules
+	    template: node-npm
+	  - id: go
+	    key: '{{ id }}-{{ checksum "go.sum" }}'
+	    paths: ["~/go/pkg/mod"]
+	    on_miss: go mod download
+
+Unknown fields are rejected to catch typos. The returned caches still need to
+be expanded, e.g. by passing them to zstash.NewCache. Configurations with an
+include list must be loaded with LoadCacheConfiguration.
+*/
+func ParseCacheConfiguration(data []byte) ([]cache.Cache, error) {
+	file, err := parseConfiguration(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(file.Include) > 0 {
+		return nil, errors.New("include is only supported when loading a cache configuration file")
+	}
+
+	return toCaches(file.Caches)
+}
+
+// parseConfiguration parses a YAML or JSON cache configuration.
+func parseConfiguration(data []byte) (cacheConfigFile, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return cacheConfigFile{}, errors.New("cache configuration is empty")
+	}
+
+	// JSON is parsed separately, as it isn't always valid YAML, e.g. when
+	// indented with tabs
+	switch trimmed[0] {
+	case '[', '{':
+		return parseJSONConfiguration(trimmed)
+	default:
+		return parseYAMLConfiguration(trimmed)
+	}
+}
+
+// toCaches converts parsed cache configurations to caches.
+func toCaches(configs []cacheConfig) ([]cache.Cache, error) {
+	caches := make([]cache.Cache, 0, len(configs))
+	for _, c := range configs {
+		dirMode, err := parseMode("extract_dir_mode", c.ExtractDirMode)
+		if err != nil {
+			return nil, fmt.Errorf("cache %q: %w", c.ID, err)
+		}
+		fileMode, err := parseMode("extract_file_mode", c.ExtractFileMode)
+		if err != nil {
+			return nil, fmt.Errorf("cache %q: %w", c.ID, err)
+		}
+		umask, err := parseMode("extract_umask", c.ExtractUmask)
+		if err != nil {
+			return nil, fmt.Errorf("cache %q: %w", c.ID, err)
+		}
+
+		caches = append(caches, cache.Cache{
+			ID:              c.ID,
+			Template:        c.Template,
+			Registry:        c.Registry,
+			Key:             c.Key,
+			FallbackKeys:    c.FallbackKeys,
+			Paths:           c.Paths,
+			MaxSize:         c.MaxSize,
+			Scope:           cache.Scope(c.Scope),
+			OnHit:           c.OnHit,
+			OnMiss:          c.OnMiss,
+			PreserveMtimes:  c.PreserveMtimes,
+			Precompressed:   c.Precompressed,
+			Manifest:        c.Manifest,
+			StrictPlatform:  c.StrictPlatform,
+			Ignore:          c.Ignore,
+			NoDefaultIgnore: c.NoDefaultIgnore,
+			AtomicRestore:   c.AtomicRestore,
+			ExtractDirMode:  dirMode,
+			ExtractFileMode: fileMode,
+			ExtractUmask:    umask,
+			MissingPaths:    cache.MissingPathsPolicy(c.MissingPaths),
+		})
+	}
+
+	return caches, nil
+}
+
+// parseMode parses the octal permissions of the named field, which are zero
+// if unset.
+func parseMode(field, value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	mode, err := archive.ParseMode(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", field, err)
+	}
+
+	return mode, nil
+}
+
+func parseJSONConfiguration(data []byte) (cacheConfigFile, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if data[0] == '[' {
+		var configs []cacheConfig
+		if err := decoder.Decode(&configs); err != nil {
+			return cacheConfigFile{}, fmt.Errorf("failed to parse cache configuration: %w", err)
+		}
+		return cacheConfigFile{Caches: configs}, nil
+	}
+
+	var file cacheConfigFile
+	if err := decoder.Decode(&file); err != nil {
+		return cacheConfigFile{}, fmt.Errorf("failed to parse cache configuration: %w", err)
+	}
+
+	return file, nil
+}
+
+func parseYAMLConfiguration(data []byte) (cacheConfigFile, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return cacheConfigFile{}, fmt.Errorf("failed to parse cache configuration: %w", err)
+	}
+
+	if len(root.Content) == 0 {
+		return cacheConfigFile{}, errors.New("cache configuration is empty")
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	switch root.Content[0].Kind {
+	case yaml.SequenceNode:
+		var configs []cacheConfig
+		if err := decoder.Decode(&configs); err != nil {
+			return cacheConfigFile{}, fmt.Errorf("failed to parse cache configuration: %w", err)
+		}
+		return cacheConfigFile{Caches: configs}, nil
+	case yaml.MappingNode:
+		var file cacheConfigFile
+		if err := decoder.Decode(&file); err != nil {
+			return cacheConfigFile{}, fmt.Errorf("failed to parse cache configuration: %w", err)
+		}
+		return file, nil
+	default:
+		return cacheConfigFile{}, errors.New("cache configuration must be a list of caches or an object with a caches list")
+	}
+}
+
+// InlineCacheConfiguration parses the cache configuration in the
+// BUILDKITE_CACHE_CONFIG_INLINE environment variable, returning false if it
+// isn't set. If env is nil the OS environment is used.
+func InlineCacheConfiguration(env map[string]string) ([]cache.Cache, bool, error) {
+	var value string
+	if env != nil {
+		value = env[InlineConfigEnv]
+	} else {
+		value = os.Getenv(InlineConfigEnv)
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return nil, false, nil
+	}
+
+	caches, err := ParseCacheConfiguration([]byte(value))
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid %s: %w", InlineConfigEnv, err)
+	}
+
+	return caches, true, nil
+}
@@ -0,0 +1,181 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCacheConfiguration(t *testing.T) {
+	want := []cache.Cache{
+		{ID: "node_modules", Template: "node-npm", OnMiss: "npm ci"},
+		{
+			ID:              "go",
+			Key:             `{{ id }}-{{ checksum "go.sum" }}`,
+			FallbackKeys:    []string{"{{ id }}-"},
+			Paths:           []string{"~/go/pkg/mod"},
+			MaxSize:         1024,
+			Scope:           cache.ScopePipeline,
+			Registry:        "shared",
+			PreserveMtimes:  true,
+			Precompressed:   true,
+			Manifest:        true,
+			StrictPlatform:  true,
+			Ignore:          []string{"*.tmp"},
+			NoDefaultIgnore: true,
+			AtomicRestore:   true,
+			ExtractDirMode:  0o755,
+			ExtractFileMode: 0o644,
+			ExtractUmask:    0o022,
+			MissingPaths:    cache.MissingPathsWarn,
+		},
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "yaml object",
+			data: `
+caches:
+  - id: node_modules
+    template: node-npm
+    on_miss: npm ci
+  - id: go
+    key: '{{ id }}-{{ checksum "go.sum" }}'
+    fallback_keys: ["{{ id }}-"]
+    paths: ["~/go/pkg/mod"]
+    max_size: 1024
+    scope: pipeline
+    registry: shared
+    preserve_mtimes: true
+    precompressed: true
+    manifest: true
+    strict_platform: true
+    ignore: ["*.tmp"]
+    no_default_ignore: true
+    atomic_restore: true
+    extract_dir_mode: 0755
+    extract_file_mode: "0644"
+    extract_umask: 0o022
+    missing_paths: warn
+`,
+		},
+		{
+			name: "yaml list",
+			data: `
+- id: node_modules
+  template: node-npm
+  on_miss: npm ci
+- id: go
+  key: '{{ id }}-{{ checksum "go.sum" }}'
+  fallback_keys:
+    - "{{ id }}-"
+  paths:
+    - ~/go/pkg/mod
+  max_size: 1024
+  scope: pipeline
+  registry: shared
+  preserve_mtimes: true
+  precompressed: true
+  manifest: true
+  strict_platform: true
+  ignore:
+    - "*.tmp"
+  no_default_ignore: true
+  atomic_restore: true
+  extract_dir_mode: 0755
+  extract_file_mode: 0644
+  extract_umask: "022"
+  missing_paths: warn
+`,
+		},
+		{
+			name: "json",
+			data: `{"caches": [
+				{"id": "node_modules", "template": "node-npm", "on_miss": "npm ci"},
+				{"id": "go", "key": "{{ id }}-{{ checksum \"go.sum\" }}", "fallback_keys": ["{{ id }}-"], "paths": ["~/go/pkg/mod"], "max_size": 1024, "scope": "pipeline", "registry": "shared", "preserve_mtimes": true, "precompressed": true, "manifest": true, "strict_platform": true, "ignore": ["*.tmp"], "no_default_ignore": true, "atomic_restore": true, "extract_dir_mode": "0755", "extract_file_mode": "0644", "extract_umask": "022", "missing_paths": "warn"}
+			]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := ParseCacheConfiguration([]byte(tt.data))
+			assert.NoError(err)
+			assert.Equal(want, got)
+		})
+	}
+}
+
+func TestParseCacheConfiguration_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		errContains string
+	}{
+		{name: "empty", data: "", errContains: "empty"},
+		{name: "scalar", data: "node_modules", errContains: "must be a list of caches"},
+		{name: "unknown field", data: "caches:\n  - id: go\n    pths: [vendor]\n", errContains: "pths"},
+		{name: "invalid yaml", data: "caches: [", errContains: "failed to parse"},
+		{name: "unknown json field", data: `[{"id": "go", "pths": ["vendor"]}]`, errContains: "pths"},
+		{name: "invalid mode", data: "caches:\n  - id: go\n    extract_dir_mode: rwxr-xr-x\n", errContains: `cache "go": invalid extract_dir_mode`},
+		{name: "mode out of range", data: `[{"id": "go", "extract_umask": "7777"}]`, errContains: "invalid extract_umask"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := ParseCacheConfiguration([]byte(tt.data))
+			assert.Error(err)
+			assert.Contains(err.Error(), tt.errContains)
+		})
+	}
+}
+
+func TestInlineCacheConfiguration(t *testing.T) {
+	t.Run("not set", func(t *testing.T) {
+		assert := require.New(t)
+
+		caches, ok, err := InlineCacheConfiguration(map[string]string{})
+		assert.NoError(err)
+		assert.False(ok)
+		assert.Empty(caches)
+	})
+
+	t.Run("from env map", func(t *testing.T) {
+		assert := require.New(t)
+
+		caches, ok, err := InlineCacheConfiguration(map[string]string{
+			InlineConfigEnv: `[{"id": "node_modules", "template": "node-npm"}]`,
+		})
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]cache.Cache{{ID: "node_modules", Template: "node-npm"}}, caches)
+	})
+
+	t.Run("from OS environment", func(t *testing.T) {
+		assert := require.New(t)
+
+		t.Setenv(InlineConfigEnv, "caches:\n  - id: go\n    template: golang\n")
+
+		caches, ok, err := InlineCacheConfiguration(nil)
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]cache.Cache{{ID: "go", Template: "golang"}}, caches)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, ok, err := InlineCacheConfiguration(map[string]string{InlineConfigEnv: "caches: ["})
+		assert.Error(err)
+		assert.True(ok)
+		assert.Contains(err.Error(), InlineConfigEnv)
+	})
+}
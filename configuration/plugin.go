@@ -0,0 +1,212 @@
+package configuration
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/zstash/cache"
+)
+
+// PluginCachesEnvPrefix is the prefix of the environment variables Buildkite
+// sets for the caches list of the cache plugin's configuration.
+const PluginCachesEnvPrefix = "BUILDKITE_PLUGIN_CACHE_CACHES_"
+
+// pluginListFields are the cache fields which are lists, which Buildkite sets
+// either as a single variable for a string or one variable per item.
+var pluginListFields = []string{"FALLBACK_KEYS", "PATHS", "IGNORE"}
+
+/*
+PluginCacheConfiguration builds the caches list from the environment variables
+Buildkite sets for the cache plugin's configuration, returning false if no
+caches are configured. If env is nil the OS environment is used.
+
+Buildkite flattens the plugin's configuration into environment variables, so
+a pipeline step like:
+
+	plugins:
+	  - cache#v1:
+	      caches:
+	        - id: go
+	          key: '{{ id }}-{{ checksum "go.sum" }}'
+	          paths: ["~/go/pkg/mod", "~/.cache/go-build"]
+
+sets BUILDKITE_PLUGIN_CACHE_CACHES_0_ID, BUILDKITE_PLUGIN_CACHE_CACHES_0_KEY,
+BUILDKITE_PLUGIN_CACHE_CACHES_0_PATHS_0 and
+BUILDKITE_PLUGIN_CACHE_CACHES_0_PATHS_1. Cache fields use the same names as
+ParseCacheConfiguration, and unknown fields are rejected to catch typos. The
+returned caches still need to be expanded, e.g. by passing them to
+zstash.NewCache.
+*/
+func PluginCacheConfiguration(env map[string]string) ([]cache.Cache, bool, error) {
+	if env == nil {
+		env = osEnv()
+	}
+
+	fields := map[int]map[string]string{}
+	for name, value := range env {
+		rest, ok := strings.CutPrefix(name, PluginCachesEnvPrefix)
+		if !ok {
+			continue
+		}
+
+		indexStr, field, ok := strings.Cut(rest, "_")
+		index, err := strconv.Atoi(indexStr)
+		if !ok || err != nil || index < 0 {
+			return nil, true, fmt.Errorf("invalid plugin cache variable %s", name)
+		}
+
+		if fields[index] == nil {
+			fields[index] = map[string]string{}
+		}
+		fields[index][field] = value
+	}
+
+	if len(fields) == 0 {
+		return nil, false, nil
+	}
+
+	configs := make([]cacheConfig, 0, len(fields))
+	for _, index := range slices.Sorted(maps.Keys(fields)) {
+		config, err := pluginCacheConfig(fields[index])
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid plugin cache %d: %w", index, err)
+		}
+		configs = append(configs, config)
+	}
+
+	caches, err := toCaches(configs)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return caches, true, nil
+}
+
+// pluginCacheConfig builds a cache configuration from the plugin variables
+// for one cache, keyed by field name without the cache prefix.
+func pluginCacheConfig(fields map[string]string) (cacheConfig, error) {
+	var (
+		config cacheConfig
+		err    error
+	)
+
+	lists := map[string]map[int]string{}
+
+	for field, value := range fields {
+		switch field {
+		case "ID":
+			config.ID = value
+		case "TEMPLATE":
+			config.Template = value
+		case "REGISTRY":
+			config.Registry = value
+		case "KEY":
+			config.Key = value
+		case "SCOPE":
+			config.Scope = value
+		case "ON_HIT":
+			config.OnHit = value
+		case "ON_MISS":
+			config.OnMiss = value
+		case "MAX_SIZE":
+			config.MaxSize, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return config, fmt.Errorf("invalid max_size %q: %w", value, err)
+			}
+		case "PRESERVE_MTIMES":
+			config.PreserveMtimes, err = strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid preserve_mtimes %q: %w", value, err)
+			}
+		case "PRECOMPRESSED":
+			config.Precompressed, err = strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid precompressed %q: %w", value, err)
+			}
+		case "MANIFEST":
+			config.Manifest, err = strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid manifest %q: %w", value, err)
+			}
+		case "STRICT_PLATFORM":
+			config.StrictPlatform, err = strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid strict_platform %q: %w", value, err)
+			}
+		case "NO_DEFAULT_IGNORE":
+			config.NoDefaultIgnore, err = strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid no_default_ignore %q: %w", value, err)
+			}
+		case "ATOMIC_RESTORE":
+			config.AtomicRestore, err = strconv.ParseBool(value)
+			if err != nil {
+				return config, fmt.Errorf("invalid atomic_restore %q: %w", value, err)
+			}
+		case "EXTRACT_DIR_MODE":
+			config.ExtractDirMode = value
+		case "EXTRACT_FILE_MODE":
+			config.ExtractFileMode = value
+		case "EXTRACT_UMASK":
+			config.ExtractUmask = value
+		case "MISSING_PATHS":
+			config.MissingPaths = value
+		default:
+			list, index, err := pluginListItem(field)
+			if err != nil {
+				return config, err
+			}
+			if lists[list] == nil {
+				lists[list] = map[int]string{}
+			}
+			lists[list][index] = value
+		}
+	}
+
+	config.FallbackKeys = pluginList(lists["FALLBACK_KEYS"])
+	config.Paths = pluginList(lists["PATHS"])
+	config.Ignore = pluginList(lists["IGNORE"])
+
+	return config, nil
+}
+
+// pluginListItem parses a list field, either the list name for a single
+// string or the list name and item index.
+func pluginListItem(field string) (string, int, error) {
+	for _, list := range pluginListFields {
+		if field == list {
+			return list, 0, nil
+		}
+
+		indexStr, ok := strings.CutPrefix(field, list+"_")
+		if !ok {
+			continue
+		}
+
+		index, err := strconv.Atoi(indexStr)
+		if err != nil || index < 0 {
+			break
+		}
+
+		return list, index, nil
+	}
+
+	return "", 0, fmt.Errorf("unknown field %s", strings.ToLower(field))
+}
+
+// pluginList returns the list items ordered by index.
+func pluginList(items map[int]string) []string {
+	if len(items) == 0 {
+		return nil
+	}
+
+	list := make([]string, 0, len(items))
+	for _, index := range slices.Sorted(maps.Keys(items)) {
+		list = append(list, items[index])
+	}
+
+	return list
+}
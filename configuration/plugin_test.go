@@ -0,0 +1,153 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginCacheConfiguration(t *testing.T) {
+	t.Run("not set", func(t *testing.T) {
+		assert := require.New(t)
+
+		caches, ok, err := PluginCacheConfiguration(map[string]string{
+			"BUILDKITE_PLUGIN_CACHE_DEBUG": "true",
+		})
+		assert.NoError(err)
+		assert.False(ok)
+		assert.Empty(caches)
+	})
+
+	t.Run("from env map", func(t *testing.T) {
+		assert := require.New(t)
+
+		caches, ok, err := PluginCacheConfiguration(map[string]string{
+			"BUILDKITE_PLUGIN_CACHE_CACHES_0_ID":                "node_modules",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_0_TEMPLATE":          "node-npm",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_0_ON_MISS":           "npm ci",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_ID":                "go",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_KEY":               `{{ id }}-{{ checksum "go.sum" }}`,
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_FALLBACK_KEYS":     "{{ id }}-",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_PATHS_0":           "~/go/pkg/mod",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_PATHS_1":           "~/.cache/go-build",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_MAX_SIZE":          "1024",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_SCOPE":             "pipeline",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_REGISTRY":          "shared",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_PRESERVE_MTIMES":   "true",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_PRECOMPRESSED":     "false",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_MANIFEST":          "true",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_STRICT_PLATFORM":   "true",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_IGNORE_0":          "*.tmp",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_NO_DEFAULT_IGNORE": "true",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_ATOMIC_RESTORE":    "true",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_EXTRACT_DIR_MODE":  "0755",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_EXTRACT_UMASK":     "022",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_1_MISSING_PATHS":     "skip",
+			"BUILDKITE_PLUGIN_CACHE_DEBUG":                      "true",
+		})
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]cache.Cache{
+			{ID: "node_modules", Template: "node-npm", OnMiss: "npm ci"},
+			{
+				ID:              "go",
+				Key:             `{{ id }}-{{ checksum "go.sum" }}`,
+				FallbackKeys:    []string{"{{ id }}-"},
+				Paths:           []string{"~/go/pkg/mod", "~/.cache/go-build"},
+				MaxSize:         1024,
+				Scope:           cache.ScopePipeline,
+				Registry:        "shared",
+				PreserveMtimes:  true,
+				Manifest:        true,
+				StrictPlatform:  true,
+				Ignore:          []string{"*.tmp"},
+				NoDefaultIgnore: true,
+				AtomicRestore:   true,
+				ExtractDirMode:  0o755,
+				ExtractUmask:    0o022,
+				MissingPaths:    cache.MissingPathsSkip,
+			},
+		}, caches)
+	})
+
+	t.Run("orders caches and list items by index", func(t *testing.T) {
+		assert := require.New(t)
+
+		caches, ok, err := PluginCacheConfiguration(map[string]string{
+			"BUILDKITE_PLUGIN_CACHE_CACHES_10_ID":     "second",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_2_ID":      "first",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_2_PATHS_0": "a",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_2_PATHS_2": "c",
+			"BUILDKITE_PLUGIN_CACHE_CACHES_2_PATHS_1": "b",
+		})
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]cache.Cache{
+			{ID: "first", Paths: []string{"a", "b", "c"}},
+			{ID: "second"},
+		}, caches)
+	})
+
+	t.Run("from OS environment", func(t *testing.T) {
+		assert := require.New(t)
+
+		t.Setenv("BUILDKITE_PLUGIN_CACHE_CACHES_0_ID", "go")
+		t.Setenv("BUILDKITE_PLUGIN_CACHE_CACHES_0_TEMPLATE", "golang")
+
+		caches, ok, err := PluginCacheConfiguration(nil)
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal([]cache.Cache{{ID: "go", Template: "golang"}}, caches)
+	})
+}
+
+func TestPluginCacheConfiguration_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		errContains string
+	}{
+		{
+			name:        "unknown field",
+			env:         map[string]string{"BUILDKITE_PLUGIN_CACHE_CACHES_0_PATH": "node_modules"},
+			errContains: "invalid plugin cache 0: unknown field path",
+		},
+		{
+			name:        "invalid index",
+			env:         map[string]string{"BUILDKITE_PLUGIN_CACHE_CACHES_X_ID": "go"},
+			errContains: "invalid plugin cache variable BUILDKITE_PLUGIN_CACHE_CACHES_X_ID",
+		},
+		{
+			name:        "invalid list index",
+			env:         map[string]string{"BUILDKITE_PLUGIN_CACHE_CACHES_0_PATHS_X": "go"},
+			errContains: "unknown field paths_x",
+		},
+		{
+			name:        "invalid max size",
+			env:         map[string]string{"BUILDKITE_PLUGIN_CACHE_CACHES_0_MAX_SIZE": "1GB"},
+			errContains: `invalid max_size "1GB"`,
+		},
+		{
+			name:        "invalid bool",
+			env:         map[string]string{"BUILDKITE_PLUGIN_CACHE_CACHES_0_PRESERVE_MTIMES": "yes"},
+			errContains: `invalid preserve_mtimes "yes"`,
+		},
+		{
+			name:        "invalid mode",
+			env:         map[string]string{"BUILDKITE_PLUGIN_CACHE_CACHES_0_EXTRACT_FILE_MODE": "644x"},
+			errContains: `invalid extract_file_mode: invalid mode "644x"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, ok, err := PluginCacheConfiguration(tt.env)
+			assert.True(ok)
+			assert.Error(err)
+			assert.Contains(err.Error(), tt.errContains)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package configuration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultConfigurationPath is where a repository's cache configuration is
+// written by InitCacheConfiguration, relative to the repository root.
+const DefaultConfigurationPath = ".buildkite/cache.yml"
+
+var (
+	// ErrConfigurationExists is returned by InitCacheConfiguration when the
+	// configuration file already exists and InitOptions.Force isn't set.
+	ErrConfigurationExists = errors.New("cache configuration already exists")
+
+	// ErrNoTemplatesDetected is returned by InitCacheConfiguration when none
+	// of the files used by the built-in templates are in the repository.
+	ErrNoTemplatesDetected = errors.New("no files matching a built-in template found")
+)
+
+// templateDetector lists the files in a repository root which indicate a
+// built-in template applies.
+type templateDetector struct {
+	template string
+	patterns []string
+}
+
+// templateDetectors are checked in order, so the generated configuration is
+// stable.
+var templateDetectors = []templateDetector{
+	{template: "node-yarn", patterns: []string{"yarn.lock"}},
+	{template: "node-npm", patterns: []string{"package-lock.json"}},
+	{template: "node-pnpm", patterns: []string{"pnpm-lock.yaml"}},
+	{template: "ruby", patterns: []string{"Gemfile.lock"}},
+	{template: "go", patterns: []string{"go.sum"}},
+	{template: "python-pip", patterns: []string{"requirements*.txt"}},
+	{template: "python-poetry", patterns: []string{"poetry.lock"}},
+	{template: "gradle", patterns: []string{"*.gradle", "*.gradle.kts", "gradle/wrapper/gradle-wrapper.properties"}},
+	{template: "maven", patterns: []string{"pom.xml"}},
+	{template: "cargo", patterns: []string{"Cargo.lock"}},
+	{template: "composer", patterns: []string{"composer.lock"}},
+	{template: "bazel", patterns: []string{"MODULE.bazel", "WORKSPACE", "WORKSPACE.bazel"}},
+}
+
+// DetectedTemplate is a built-in template which applies to a repository.
+type DetectedTemplate struct {
+	// Template is the name of the built-in template, e.g. "node-yarn".
+	Template string
+
+	// Files are the files in the repository root which matched, e.g.
+	// "yarn.lock".
+	Files []string
+}
+
+// DetectTemplates returns the built-in templates which apply to the
+// repository at dir, based on the lockfiles and build files in its root.
+func DetectTemplates(dir string) ([]DetectedTemplate, error) {
+	templates, err := loadTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+
+	var detected []DetectedTemplate
+	for _, detector := range templateDetectors {
+		if _, ok := templates[detector.template]; !ok {
+			return nil, fmt.Errorf("unknown template %s", detector.template)
+		}
+
+		var files []string
+		for _, pattern := range detector.patterns {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("failed to match %s: %w", pattern, err)
+			}
+
+			for _, match := range matches {
+				rel, err := filepath.Rel(dir, match)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, filepath.ToSlash(rel))
+			}
+		}
+
+		if len(files) > 0 {
+			detected = append(detected, DetectedTemplate{Template: detector.template, Files: files})
+		}
+	}
+
+	return detected, nil
+}
+
+// InitOptions configures InitCacheConfiguration.
+type InitOptions struct {
+	// Path is where the configuration is written, relative to the repository.
+	// Defaults to DefaultConfigurationPath.
+	Path string
+
+	// Force overwrites an existing configuration file.
+	Force bool
+
+	// DryRun generates the configuration without writing it.
+	DryRun bool
+}
+
+// InitResult describes the configuration generated by InitCacheConfiguration.
+type InitResult struct {
+	// Path is the configuration file, which is only written if Written is set.
+	Path string
+
+	// Templates are the built-in templates detected in the repository, with
+	// a cache in the configuration for each.
+	Templates []DetectedTemplate
+
+	// Content is the generated YAML configuration.
+	Content []byte
+
+	// Written reports whether the configuration file was written, which it
+	// isn't for a dry run.
+	Written bool
+}
+
+/*
+InitCacheConfiguration generates a starter cache configuration for the
+repository at dir, with a cache using the built-in template for each of the
+lockfiles and build files found in its root, and writes it to
+.buildkite/cache.yml:
+
+	caches:
+	  - id: node_yarn
+	    template: node-yarn # yarn.lock
+	  - id: go
+	    template: go # go.sum
+
+ErrConfigurationExists is returned if the file exists, unless opts.Force is
+set, and ErrNoTemplatesDetected if no templates apply.
+*/
+func InitCacheConfiguration(dir string, opts InitOptions) (InitResult, error) {
+	path := opts.Path
+	if path == "" {
+		path = DefaultConfigurationPath
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	result := InitResult{Path: path}
+
+	if !opts.Force {
+		if _, err := os.Lstat(path); err == nil {
+			return result, fmt.Errorf("%w: %s", ErrConfigurationExists, path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return result, fmt.Errorf("failed to check cache configuration: %w", err)
+		}
+	}
+
+	detected, err := DetectTemplates(dir)
+	if err != nil {
+		return result, err
+	}
+	if len(detected) == 0 {
+		return result, ErrNoTemplatesDetected
+	}
+
+	result.Templates = detected
+	result.Content = starterConfiguration(detected)
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return result, fmt.Errorf("failed to create cache configuration directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, result.Content, 0o644); err != nil { // #nosec G306 -- configuration is committed to the repository
+		return result, fmt.Errorf("failed to write cache configuration: %w", err)
+	}
+
+	result.Written = true
+
+	return result, nil
+}
+
+// starterConfiguration renders the YAML configuration for the detected
+// templates. Cache IDs are the template names, which are unique, with
+// underscores as IDs can't contain hyphens.
+func starterConfiguration(detected []DetectedTemplate) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Caches using the built-in templates for the files in this repository.\n")
+	buf.WriteString("# Set key, fallback_keys or paths on a cache to override its template.\n")
+	buf.WriteString("caches:\n")
+
+	for _, d := range detected {
+		fmt.Fprintf(&buf, "  - id: %s\n", strings.ReplaceAll(d.Template, "-", "_"))
+		fmt.Fprintf(&buf, "    template: %s # %s\n", d.Template, strings.Join(d.Files, ", "))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,101 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectTemplates(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+	for _, name := range []string{"go.sum", "yarn.lock", "Gemfile.lock", "requirements.txt", "requirements-dev.txt", "build.gradle.kts", "README.md"} {
+		writeConfig(t, filepath.Join(dir, name), "")
+	}
+
+	detected, err := DetectTemplates(dir)
+	assert.NoError(err)
+	assert.Equal([]DetectedTemplate{
+		{Template: "node-yarn", Files: []string{"yarn.lock"}},
+		{Template: "ruby", Files: []string{"Gemfile.lock"}},
+		{Template: "go", Files: []string{"go.sum"}},
+		{Template: "python-pip", Files: []string{"requirements-dev.txt", "requirements.txt"}},
+		{Template: "gradle", Files: []string{"build.gradle.kts"}},
+	}, detected)
+}
+
+func TestInitCacheConfiguration(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "go.sum"), "")
+	writeConfig(t, filepath.Join(dir, "package-lock.json"), "{}")
+
+	path := filepath.Join(dir, DefaultConfigurationPath)
+
+	dryRun, err := InitCacheConfiguration(dir, InitOptions{DryRun: true})
+	assert.NoError(err)
+	assert.Equal(path, dryRun.Path)
+	assert.False(dryRun.Written)
+	assert.NoFileExists(path)
+
+	result, err := InitCacheConfiguration(dir, InitOptions{})
+	assert.NoError(err)
+	assert.True(result.Written)
+	assert.Equal(dryRun.Content, result.Content)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(result.Content, data)
+
+	caches, err := ParseCacheConfiguration(data)
+	assert.NoError(err)
+	assert.Equal([]cache.Cache{
+		{ID: "node_npm", Template: "node-npm"},
+		{ID: "go", Template: "go"},
+	}, caches)
+
+	// the generated caches expand using the templates
+	expanded, err := ExpandCacheConfiguration(caches)
+	assert.NoError(err)
+	for _, c := range expanded {
+		assert.NoError(c.Validate())
+	}
+
+	_, err = InitCacheConfiguration(dir, InitOptions{})
+	assert.ErrorIs(err, ErrConfigurationExists)
+
+	_, err = InitCacheConfiguration(dir, InitOptions{DryRun: true})
+	assert.ErrorIs(err, ErrConfigurationExists)
+
+	forced, err := InitCacheConfiguration(dir, InitOptions{Force: true})
+	assert.NoError(err)
+	assert.True(forced.Written)
+}
+
+func TestInitCacheConfiguration_Path(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "Cargo.lock"), "")
+
+	result, err := InitCacheConfiguration(dir, InitOptions{Path: "ci/caches.yml"})
+	assert.NoError(err)
+	assert.Equal(filepath.Join(dir, "ci", "caches.yml"), result.Path)
+	assert.FileExists(result.Path)
+}
+
+func TestInitCacheConfiguration_NoTemplates(t *testing.T) {
+	assert := require.New(t)
+
+	dir := t.TempDir()
+	writeConfig(t, filepath.Join(dir, "README.md"), "")
+
+	_, err := InitCacheConfiguration(dir, InitOptions{})
+	assert.ErrorIs(err, ErrNoTemplatesDetected)
+	assert.NoFileExists(filepath.Join(dir, DefaultConfigurationPath))
+}
@@ -0,0 +1,114 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// checkRestoreSpace returns a *DiskSpaceError if the temp directory the archive
+// is downloaded to, or the filesystem of any of the targets it is extracted
+// to, has less free space than the archive. The archive size is only a lower
+// bound of the space needed to extract it, as its uncompressed size isn't
+// known until it's downloaded, but catches restores which are certain to run
+// out of space before anything is downloaded.
+//
+// If reclaimed is set the targets are removed before extraction, so the files
+// they hold count towards the free space.
+func checkRestoreSpace(ctx context.Context, cacheID string, archiveSize int64, targets []string, reclaimed bool) error {
+	if archiveSize <= 0 {
+		return nil
+	}
+
+	if err := checkFreeSpace(ctx, cacheID, archiveSize, os.TempDir(), 0, true); err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		target, err := archive.ResolveHomeDir(target)
+		if err != nil {
+			return fmt.Errorf("failed to resolve home dir for %q: %w", target, err)
+		}
+
+		var reclaimable uint64
+		if reclaimed {
+			reclaimable = usedSpace(target)
+		}
+
+		if err := checkFreeSpace(ctx, cacheID, archiveSize, target, reclaimable, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkFreeSpace checks the filesystem of path, or of its nearest existing
+// parent if it doesn't exist yet, has room for required bytes. Failing to
+// check the free space isn't an error, as the restore may still succeed.
+func checkFreeSpace(ctx context.Context, cacheID string, required int64, path string, reclaimable uint64, tempDir bool) error {
+	dir := existingParent(path)
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			logging.FromContext(ctx).Debug("failed to check free space", "cache_id", cacheID, "path", dir, "error", err)
+		}
+		return nil
+	}
+
+	available := free + reclaimable
+	if available >= uint64(required) { //nolint:gosec // required is positive
+		return nil
+	}
+
+	return &DiskSpaceError{
+		CacheID:   cacheID,
+		Path:      dir,
+		Required:  required,
+		Available: available,
+		TempDir:   tempDir,
+	}
+}
+
+// existingParent returns path if it exists, otherwise its nearest parent
+// which does.
+func existingParent(path string) string {
+	dir := filepath.Clean(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// usedSpace returns the size of the regular files beneath path, ignoring any
+// which can't be read.
+func usedSpace(path string) uint64 {
+	var used uint64
+
+	_ = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil {
+			used += uint64(info.Size()) //nolint:gosec // file sizes are positive
+		}
+		return nil
+	})
+
+	return used
+}
@@ -0,0 +1,46 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRestoreSpace(t *testing.T) {
+	ctx := context.Background()
+
+	target := t.TempDir()
+	free, err := freeSpace(target)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space can't be checked on this platform")
+	}
+	require.NoError(t, err)
+
+	missing := filepath.Join(target, "missing", "path")
+
+	assert.NoError(t, checkRestoreSpace(ctx, "test-cache", 0, []string{target}, false), "unknown sizes aren't checked")
+	assert.NoError(t, checkRestoreSpace(ctx, "test-cache", 1024, []string{target, missing}, false))
+
+	err = checkRestoreSpace(ctx, "test-cache", 1<<60, []string{missing}, false)
+	require.ErrorIs(t, err, ErrInsufficientSpace)
+	assert.ErrorContains(t, err, "set TMPDIR")
+
+	// the existing files in a target which is removed count as free space
+	require.NoError(t, os.WriteFile(filepath.Join(target, "cache.bin"), make([]byte, 4096), 0o600))
+	assert.Equal(t, uint64(4096), usedSpace(target))
+
+	var spaceErr *DiskSpaceError
+	err = checkFreeSpace(ctx, "test-cache", int64(free)+(1<<40), target, usedSpace(target), false) //nolint:gosec // free space is below the int64 limit
+	require.ErrorAs(t, err, &spaceErr)
+	assert.False(t, spaceErr.TempDir)
+	assert.Equal(t, target, spaceErr.Path)
+
+	err = checkFreeSpace(ctx, "test-cache", 1<<60, missing, 0, false)
+	require.ErrorAs(t, err, &spaceErr)
+	assert.Equal(t, target, spaceErr.Path, "missing paths are checked on their nearest existing parent")
+}
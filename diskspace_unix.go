@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package zstash
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem containing dir.
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil //nolint:gosec // block size is positive
+}
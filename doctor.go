@@ -0,0 +1,242 @@
+package zstash
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// minTempFreeSpace is the free space in the temp directory below which
+// Diagnose warns, as archives are built and downloaded there.
+const minTempFreeSpace = 1 << 30 // 1GiB
+
+// diagnosticPrefix is the key prefix for objects written by the store round trip check.
+const diagnosticPrefix = "zstash-doctor"
+
+// DiagnosticStatus is the outcome of a diagnostic check.
+type DiagnosticStatus string
+
+const (
+	DiagnosticPass DiagnosticStatus = "pass"
+	DiagnosticWarn DiagnosticStatus = "warn"
+	DiagnosticFail DiagnosticStatus = "fail"
+	// DiagnosticSkip indicates the check couldn't run, as an earlier check failed
+	// or it doesn't apply to the configured store.
+	DiagnosticSkip DiagnosticStatus = "skip"
+)
+
+// DiagnosticCheck is the result of a single diagnostic check.
+type DiagnosticCheck struct {
+	// Name identifies the check, e.g. "registry" or "store_round_trip".
+	Name string
+
+	Status DiagnosticStatus
+
+	// Message describes the outcome for display.
+	Message string
+
+	// Err is the error which caused the check to fail or warn, if any.
+	Err error
+}
+
+// DiagnosticReport contains the results of Diagnose, in the order the checks ran.
+type DiagnosticReport struct {
+	Checks []DiagnosticCheck
+}
+
+// Failed reports whether any check failed.
+func (r DiagnosticReport) Failed() bool {
+	for _, check := range r.Checks {
+		if check.Status == DiagnosticFail {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *DiagnosticReport) add(name string, status DiagnosticStatus, message string, err error) {
+	r.Checks = append(r.Checks, DiagnosticCheck{Name: name, Status: status, Message: message, Err: err})
+}
+
+// Diagnose checks the client's environment is able to save and restore
+// caches, returning a report of each check rather than stopping at the first
+// failure. It checks:
+//   - the registry can be fetched from the cache API, verifying the token
+//   - the bucket URL is valid for the registry's store
+//   - the nsc CLI is available, when using the hosted agents store
+//   - a small object can be written, read back and deleted from the store
+//   - the temp directory has enough free space for archives
+//
+// The round trip writes an object under the "zstash-doctor/" prefix, which
+// is left behind if the store doesn't support deleting objects.
+//
+// Example:
+//
+//	report := cacheClient.Diagnose(ctx)
+//	for _, check := range report.Checks {
+//	    fmt.Printf("%-20s %-4s %s\n", check.Name, check.Status, check.Message)
+//	}
+//	if report.Failed() {
+//	    os.Exit(1)
+//	}
+func (c *Cache) Diagnose(ctx context.Context) DiagnosticReport {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.Diagnose")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx = store.WithTransport(ctx, c.transport)
+
+	var report DiagnosticReport
+
+	defer func() {
+		span.SetAttributes(attribute.Bool("diagnose.failed", report.Failed()))
+	}()
+
+	registryResp, err := c.client.CacheRegistry(ctx, c.registry)
+	if err != nil {
+		report.add("registry", DiagnosticFail, "failed to fetch cache registry, check the agent token and registry", err)
+		report.add("bucket_url", DiagnosticSkip, "registry unavailable", nil)
+		report.add("store_round_trip", DiagnosticSkip, "registry unavailable", nil)
+		report.checkTempSpace()
+		return report
+	}
+	report.add("registry", DiagnosticPass, fmt.Sprintf("registry %q uses store %s", registryResp.Name, registryResp.Store), nil)
+
+	if err := validateCacheStore(registryResp.Store, c.bucketURLFor(registryResp.Store)); err != nil {
+		report.add("bucket_url", DiagnosticFail, "bucket URL is invalid for the store", err)
+		report.add("store_round_trip", DiagnosticSkip, "bucket URL invalid", nil)
+		report.checkTempSpace()
+		return report
+	}
+	report.add("bucket_url", DiagnosticPass, "bucket URL is valid", nil)
+
+	if registryResp.Store == store.LocalHostedAgents {
+		report.checkNsc(ctx)
+	}
+
+	if registryResp.Store == store.BuildkiteHostedStore {
+		report.add("store_round_trip", DiagnosticSkip, "objects in the Buildkite hosted store are only transferred for cache entries", nil)
+	} else {
+		report.checkRoundTrip(ctx, registryResp.Store, c.bucketURLFor(registryResp.Store))
+	}
+	report.checkTempSpace()
+
+	return report
+}
+
+// checkNsc checks the nsc CLI used by the hosted agents store can be found.
+func (r *DiagnosticReport) checkNsc(ctx context.Context) {
+	nscStore, err := store.NewNscStore()
+	if err == nil {
+		err = nscStore.CheckHealth(ctx)
+	}
+	if err != nil {
+		r.add("nsc", DiagnosticFail, "nsc CLI unavailable", err)
+		return
+	}
+
+	r.add("nsc", DiagnosticPass, fmt.Sprintf("found nsc CLI %q", nscStore.Binary()), nil)
+}
+
+// checkRoundTrip uploads, downloads and deletes a small object in the store.
+func (r *DiagnosticReport) checkRoundTrip(ctx context.Context, storeType, bucketURL string) {
+	const name = "store_round_trip"
+
+	blobStore, err := store.NewBlobStore(ctx, storeType, bucketURL)
+	if err != nil {
+		r.add(name, DiagnosticFail, "failed to create blob store", err)
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "zstash-doctor")
+	if err != nil {
+		r.add(name, DiagnosticFail, "failed to create temp directory", err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	key := path.Join(diagnosticPrefix, hex.EncodeToString(id))
+	content := []byte("zstash doctor " + key)
+
+	srcPath := filepath.Join(tmpDir, "upload")
+	if err := os.WriteFile(srcPath, content, 0o600); err != nil {
+		r.add(name, DiagnosticFail, "failed to write test object", err)
+		return
+	}
+
+	if _, err := blobStore.Upload(ctx, srcPath, key); err != nil {
+		r.add(name, DiagnosticFail, "failed to upload test object", err)
+		return
+	}
+
+	destPath := filepath.Join(tmpDir, "download")
+	if _, err := blobStore.Download(ctx, key, destPath); err != nil {
+		r.add(name, DiagnosticFail, "failed to download test object", err)
+		return
+	}
+
+	downloaded, err := os.ReadFile(destPath) // #nosec G304 -- path is within the temp directory
+	if err != nil {
+		r.add(name, DiagnosticFail, "failed to read downloaded test object", err)
+		return
+	}
+	if !bytes.Equal(downloaded, content) {
+		r.add(name, DiagnosticFail, "downloaded test object doesn't match", errors.New("content mismatch"))
+		return
+	}
+
+	deleteStore, ok := blobStore.(store.DeleteBlob)
+	if !ok {
+		r.add(name, DiagnosticWarn, fmt.Sprintf("upload and download succeeded, store can't delete test object %s", key), nil)
+		return
+	}
+
+	if err := deleteStore.Delete(ctx, key); err != nil {
+		r.add(name, DiagnosticWarn, fmt.Sprintf("upload and download succeeded, failed to delete test object %s", key), err)
+		return
+	}
+
+	r.add(name, DiagnosticPass, "upload, download and delete succeeded", nil)
+}
+
+// checkTempSpace checks the temp directory has enough free space.
+func (r *DiagnosticReport) checkTempSpace() {
+	const name = "temp_space"
+
+	tmpDir := os.TempDir()
+
+	free, err := freeSpace(tmpDir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		r.add(name, DiagnosticSkip, "free space can't be checked on this platform", nil)
+		return
+	}
+	if err != nil {
+		r.add(name, DiagnosticFail, fmt.Sprintf("failed to check free space in %s", tmpDir), err)
+		return
+	}
+
+	message := fmt.Sprintf("%.1fGiB free in %s", float64(free)/(1<<30), tmpDir)
+	if free < minTempFreeSpace {
+		r.add(name, DiagnosticWarn, message, nil)
+		return
+	}
+
+	r.add(name, DiagnosticPass, message, nil)
+}
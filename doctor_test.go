@@ -0,0 +1,97 @@
+package zstash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkStatuses returns the status of each check by name.
+func checkStatuses(report DiagnosticReport) map[string]DiagnosticStatus {
+	statuses := make(map[string]DiagnosticStatus, len(report.Checks))
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+
+	return statuses
+}
+
+func TestCache_Diagnose(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("healthy file store", func(t *testing.T) {
+		storageDir := t.TempDir()
+
+		cacheClient := &Cache{
+			client:    newMockAPIClient(store.LocalFileStore),
+			bucketURL: "file://" + storageDir,
+			registry:  "~",
+		}
+
+		report := cacheClient.Diagnose(ctx)
+		assert.False(t, report.Failed())
+
+		statuses := checkStatuses(report)
+		assert.Equal(t, DiagnosticPass, statuses["registry"])
+		assert.Equal(t, DiagnosticPass, statuses["bucket_url"])
+		assert.Equal(t, DiagnosticPass, statuses["store_round_trip"])
+		assert.Contains(t, statuses, "temp_space")
+		assert.NotContains(t, statuses, "nsc")
+
+		// the test object is removed
+		entries, err := os.ReadDir(filepath.Join(storageDir, diagnosticPrefix))
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("unknown registry", func(t *testing.T) {
+		cacheClient := &Cache{
+			client:    newMockAPIClient(store.LocalFileStore),
+			bucketURL: "file://" + t.TempDir(),
+			registry:  "missing",
+		}
+
+		report := cacheClient.Diagnose(ctx)
+		assert.True(t, report.Failed())
+
+		statuses := checkStatuses(report)
+		assert.Equal(t, DiagnosticFail, statuses["registry"])
+		assert.Equal(t, DiagnosticSkip, statuses["store_round_trip"])
+		assert.Contains(t, statuses, "temp_space")
+	})
+
+	t.Run("missing bucket URL", func(t *testing.T) {
+		cacheClient := &Cache{
+			client:   newMockAPIClient(store.LocalS3Store),
+			registry: "~",
+		}
+
+		report := cacheClient.Diagnose(ctx)
+		assert.True(t, report.Failed())
+
+		statuses := checkStatuses(report)
+		assert.Equal(t, DiagnosticFail, statuses["bucket_url"])
+		assert.Equal(t, DiagnosticSkip, statuses["store_round_trip"])
+	})
+
+	t.Run("missing nsc CLI", func(t *testing.T) {
+		t.Setenv(store.NscBinaryEnv, filepath.Join(t.TempDir(), "nsc"))
+
+		cacheClient := &Cache{
+			client:   newMockAPIClient(store.LocalHostedAgents),
+			registry: "~",
+		}
+
+		report := cacheClient.Diagnose(ctx)
+		assert.True(t, report.Failed())
+
+		statuses := checkStatuses(report)
+		assert.Equal(t, DiagnosticFail, statuses["nsc"])
+		assert.Equal(t, DiagnosticFail, statuses["store_round_trip"])
+	})
+}
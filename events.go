@@ -0,0 +1,138 @@
+package zstash
+
+import (
+	"context"
+	"time"
+)
+
+// Event is a typed progress or lifecycle event emitted during Save and Restore
+// operations, see Config.Events. Use a type switch to handle the events of
+// interest:
+//
+//	for event := range events {
+//	    switch e := event.(type) {
+//	    case zstash.ArchiveBuilt:
+//	        log.Printf("%s: archived %d bytes", e.CacheID, e.Archive.Size)
+//	    case zstash.SaveCompleted:
+//	        log.Printf("%s: saved, created=%t", e.CacheID, e.Result.CacheCreated)
+//	    }
+//	}
+type Event interface {
+	// Info returns the fields common to all events.
+	Info() EventInfo
+}
+
+// EventInfo contains the fields common to all events.
+type EventInfo struct {
+	// CacheID is the ID of the cache the event is for.
+	CacheID string
+
+	// Time is when the event occurred.
+	Time time.Time
+}
+
+// Info returns the fields common to all events.
+func (e EventInfo) Info() EventInfo {
+	return e
+}
+
+func newEventInfo(cacheID string) EventInfo {
+	return EventInfo{CacheID: cacheID, Time: time.Now()}
+}
+
+// SaveStarted is emitted when Save or SaveIfChanged starts.
+type SaveStarted struct {
+	EventInfo
+}
+
+// SaveCompleted is emitted when Save or SaveIfChanged returns, including when
+// the cache already exists or the save failed.
+type SaveCompleted struct {
+	EventInfo
+	Result SaveResult
+	Err    error
+}
+
+// ArchiveStarted is emitted before the cache paths are archived.
+type ArchiveStarted struct {
+	EventInfo
+	Paths []string
+}
+
+// ArchiveBuilt is emitted once the cache paths are archived.
+type ArchiveBuilt struct {
+	EventInfo
+	Archive ArchiveMetrics
+}
+
+// UploadStarted is emitted before the archive is uploaded.
+type UploadStarted struct {
+	EventInfo
+	// Size is the size of the archive in bytes.
+	Size int64
+}
+
+// Uploaded is emitted once the archive is uploaded.
+type Uploaded struct {
+	EventInfo
+	Transfer TransferMetrics
+}
+
+// Committed is emitted once the cache entry is committed and available to
+// restore.
+type Committed struct {
+	EventInfo
+	Key      string
+	UploadID string
+}
+
+// RestoreStarted is emitted when Restore or RestoreWithOptions starts.
+type RestoreStarted struct {
+	EventInfo
+}
+
+// RestoreCompleted is emitted when Restore or RestoreWithOptions returns,
+// including on a cache miss or when the restore failed.
+type RestoreCompleted struct {
+	EventInfo
+	Result RestoreResult
+	Err    error
+}
+
+// DownloadStarted is emitted before the archive is downloaded.
+type DownloadStarted struct {
+	EventInfo
+	// Key is the matched cache key, which is a fallback key if Fallback is true.
+	Key      string
+	Fallback bool
+}
+
+// Downloaded is emitted once the archive is downloaded.
+type Downloaded struct {
+	EventInfo
+	Transfer TransferMetrics
+}
+
+// Extracted is emitted once the archive is extracted to the cache paths.
+type Extracted struct {
+	EventInfo
+	Archive ArchiveMetrics
+}
+
+// emit sends the event to the events channel if configured, waiting for it to
+// be received unless ctx is cancelled.
+func (c *Cache) emit(ctx context.Context, event Event) {
+	if c.events == nil {
+		return
+	}
+
+	// Protect against sends on a channel closed while operations are running
+	defer func() {
+		_ = recover()
+	}()
+
+	select {
+	case c.events <- event:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,115 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/cache"
+)
+
+// FallbackStrategy controls which entry is restored when the cache key misses
+// and more than one fallback key matches an entry.
+type FallbackStrategy string
+
+const (
+	// FallbackOrdered restores the first matching fallback key in the order
+	// configured, as chosen by the server. This is the default.
+	FallbackOrdered FallbackStrategy = "ordered"
+	// FallbackNewest restores the most recently created matching entry.
+	FallbackNewest FallbackStrategy = "newest"
+	// FallbackLargest restores the largest matching entry.
+	FallbackLargest FallbackStrategy = "largest"
+)
+
+// IsValid reports whether s is a known fallback strategy, or empty to use the default.
+func (s FallbackStrategy) IsValid() bool {
+	switch s {
+	case "", FallbackOrdered, FallbackNewest, FallbackLargest:
+		return true
+	default:
+		return false
+	}
+}
+
+// retrieveCache finds the entry to restore for the cache, trying the exact key
+// and then the fallback keys.
+//
+// With the ordered strategy the server chooses between the fallback keys. Other
+// strategies peek every fallback key and retrieve the best matching entry.
+func (c *Cache) retrieveCache(ctx context.Context, cacheConfig *cache.Cache, strategy FallbackStrategy) (api.CacheRetrieveResp, bool, error) {
+	branch := c.scopeFor(cacheConfig).branch
+
+	if strategy == "" || strategy == FallbackOrdered || len(cacheConfig.FallbackKeys) == 0 {
+		return c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
+			Key:          cacheConfig.Key,
+			Branch:       branch,
+			FallbackKeys: strings.Join(cacheConfig.FallbackKeys, ","),
+		})
+	}
+
+	retrieveResp, exists, err := c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
+		Key:    cacheConfig.Key,
+		Branch: branch,
+	})
+	if err != nil || exists {
+		return retrieveResp, exists, err
+	}
+
+	// the key's pending entry is reported along with the fallback entry
+	pending := retrieveResp.Pending
+
+	var best *api.CachePeekResp
+	for _, fallbackKey := range cacheConfig.FallbackKeys {
+		peekResp, exists, err := c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+			Key:    fallbackKey,
+			Branch: branch,
+		})
+		if err != nil {
+			return api.CacheRetrieveResp{}, false, fmt.Errorf("failed to check fallback key %s: %w", fallbackKey, err)
+		}
+		if !exists {
+			continue
+		}
+
+		if peekResp.Key == "" {
+			peekResp.Key = fallbackKey
+		}
+
+		// ties are broken by the configured order
+		if best == nil || betterFallback(strategy, peekResp, *best) {
+			best = &peekResp
+		}
+	}
+
+	if best == nil {
+		return api.CacheRetrieveResp{Message: api.CacheEntryNotFound, Pending: pending}, false, nil
+	}
+
+	retrieveResp, exists, err = c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
+		Key:    best.Key,
+		Branch: branch,
+	})
+	if err != nil || !exists {
+		return retrieveResp, exists, err
+	}
+
+	// the entry was retrieved by its own key, but is still a fallback for this cache
+	retrieveResp.Fallback = true
+	retrieveResp.Pending = pending
+
+	return retrieveResp, true, nil
+}
+
+// betterFallback reports whether candidate should be restored in preference to best.
+func betterFallback(strategy FallbackStrategy, candidate, best api.CachePeekResp) bool {
+	switch strategy {
+	case FallbackNewest:
+		return candidate.CreatedAt.After(best.CreatedAt)
+	case FallbackLargest:
+		return candidate.FileSize > best.FileSize
+	default:
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+package zstash
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/buildkite/zstash/archive"
+)
+
+// pathsFingerprint records the state of a cache's paths immediately after a
+// successful restore, so a later save can detect that nothing has changed.
+type pathsFingerprint struct {
+	key string
+	sum string
+}
+
+// fingerprintPaths computes a cheap fingerprint of the given cache paths based
+// on file names, sizes, modes and modification times. File contents are not
+// read, which keeps this much faster than building an archive.
+//
+// Paths which don't exist are included in the fingerprint as missing, so a path
+// appearing or disappearing is detected as a change.
+func fingerprintPaths(ctx context.Context, paths []string) (string, error) {
+	hash := sha256.New()
+
+	for _, path := range paths {
+		resolvedPath, err := archive.ResolveHomeDir(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve home dir for %q: %w", path, err)
+		}
+
+		if _, err := os.Lstat(resolvedPath); errors.Is(err, fs.ErrNotExist) {
+			_, _ = fmt.Fprintf(hash, "%s\x00missing\n", path)
+			continue
+		}
+
+		var entries []string
+		err = filepath.WalkDir(resolvedPath, func(filename string, d fs.DirEntry, walkErr error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if walkErr != nil {
+				return walkErr
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(resolvedPath, filename)
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%d",
+				path, filepath.ToSlash(rel), info.Size(), info.Mode(), info.ModTime().UnixNano()))
+
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to walk path %q: %w", resolvedPath, err)
+		}
+
+		sort.Strings(entries)
+		for _, entry := range entries {
+			_, _ = fmt.Fprintln(hash, entry)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recordFingerprint stores the fingerprint of a cache's paths after restore.
+func (c *Cache) recordFingerprint(cacheID string, fp pathsFingerprint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fingerprints == nil {
+		c.fingerprints = make(map[string]pathsFingerprint)
+	}
+	c.fingerprints[cacheID] = fp
+}
+
+// restoredFingerprint returns the fingerprint recorded for a cache ID, if any.
+func (c *Cache) restoredFingerprint(cacheID string) (pathsFingerprint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fp, ok := c.fingerprints[cacheID]
+	return fp, ok
+}
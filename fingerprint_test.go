@@ -0,0 +1,82 @@
+package zstash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprintPaths(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "file.txt"), []byte("test"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "nested", "nested.txt"), []byte("nested"), 0o600))
+
+	paths := []string{cacheDir}
+
+	first, err := fingerprintPaths(ctx, paths)
+	require.NoError(t, err)
+	assert.NotEmpty(t, first)
+
+	t.Run("stable when unchanged", func(t *testing.T) {
+		second, err := fingerprintPaths(ctx, paths)
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("changes when a file is modified", func(t *testing.T) {
+		file := filepath.Join(cacheDir, "file.txt")
+		require.NoError(t, os.WriteFile(file, []byte("modified"), 0o600))
+		t.Cleanup(func() {
+			require.NoError(t, os.WriteFile(file, []byte("test"), 0o600))
+		})
+
+		changed, err := fingerprintPaths(ctx, paths)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, changed)
+	})
+
+	t.Run("changes when mtime is modified", func(t *testing.T) {
+		file := filepath.Join(cacheDir, "nested", "nested.txt")
+		stat, err := os.Stat(file)
+		require.NoError(t, err)
+		require.NoError(t, os.Chtimes(file, time.Now(), stat.ModTime().Add(time.Hour)))
+		t.Cleanup(func() {
+			require.NoError(t, os.Chtimes(file, time.Now(), stat.ModTime()))
+		})
+
+		changed, err := fingerprintPaths(ctx, paths)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, changed)
+	})
+
+	t.Run("missing paths are fingerprinted", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing")
+
+		withMissing, err := fingerprintPaths(ctx, []string{cacheDir, missing})
+		require.NoError(t, err)
+		assert.NotEqual(t, first, withMissing)
+
+		require.NoError(t, os.MkdirAll(missing, 0o755))
+		withCreated, err := fingerprintPaths(ctx, []string{cacheDir, missing})
+		require.NoError(t, err)
+		assert.NotEqual(t, withMissing, withCreated)
+	})
+
+	t.Run("respects context cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := fingerprintPaths(ctx, paths)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,100 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Environment variables describing the restore result, set for OnHit and OnMiss commands.
+const (
+	HookCacheIDEnv  = "BUILDKITE_ZSTASH_CACHE_ID"
+	HookKeyEnv      = "BUILDKITE_ZSTASH_CACHE_KEY"
+	HookHitEnv      = "BUILDKITE_ZSTASH_CACHE_HIT"
+	HookRestoredEnv = "BUILDKITE_ZSTASH_CACHE_RESTORED"
+	HookFallbackEnv = "BUILDKITE_ZSTASH_CACHE_FALLBACK"
+)
+
+// RunRestoreHook runs the cache's OnHit command when result is an exact key
+// hit, or its OnMiss command otherwise, which includes fallback restores. It
+// returns false if the cache has no command for the result.
+//
+// Commands are run with the shell (sh -c, or cmd /C on Windows) in the
+// current directory, with the OS environment and the BUILDKITE_ZSTASH_CACHE_*
+// variables describing the result. Output is written to stdout and stderr,
+// which may be nil to discard it.
+//
+// Hooks are only run by calling RunRestoreHook, typically after Restore:
+//
+//	result, err := cacheClient.Restore(ctx, "node_modules")
+//	if err != nil {
+//	    log.Fatalf("Cache restore failed: %v", err)
+//	}
+//	if _, err := cacheClient.RunRestoreHook(ctx, "node_modules", result, os.Stdout, os.Stderr); err != nil {
+//	    log.Fatalf("Cache hook failed: %v", err)
+//	}
+func (c *Cache) RunRestoreHook(ctx context.Context, cacheID string, result RestoreResult, stdout, stderr io.Writer) (bool, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.RunRestoreHook")
+	defer span.End()
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return false, err
+	}
+
+	hook, command := "on_miss", cacheConfig.OnMiss
+	if result.CacheHit {
+		hook, command = "on_hit", cacheConfig.OnHit
+	}
+
+	span.SetAttributes(
+		attribute.String("cache.id", cacheID),
+		attribute.String("cache.hook", hook),
+	)
+
+	if command == "" {
+		return false, nil
+	}
+
+	cmd := shellCommand(ctx, command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(),
+		HookCacheIDEnv+"="+cacheID,
+		HookKeyEnv+"="+result.Key,
+		HookHitEnv+"="+strconv.FormatBool(result.CacheHit),
+		HookRestoredEnv+"="+strconv.FormatBool(result.CacheRestored),
+		HookFallbackEnv+"="+strconv.FormatBool(result.FallbackUsed),
+	)
+
+	c.callProgress(ctx, cacheID, "hook", fmt.Sprintf("Running %s command", hook), 0, 0)
+
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("%s command for cache %s failed: %w", hook, cacheID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "hook command failed")
+		return true, err
+	}
+
+	return true, nil
+}
+
+// shellCommand returns a command running command with the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command) // #nosec G204 -- hooks are commands from the cache configuration
+	}
+
+	return exec.CommandContext(ctx, "sh", "-c", command) // #nosec G204 -- hooks are commands from the cache configuration
+}
@@ -0,0 +1,94 @@
+package zstash
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_RunRestoreHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands use sh")
+	}
+
+	cacheClient := &Cache{
+		caches: []cache.Cache{
+			{
+				ID:     "node_modules",
+				OnHit:  `echo "hit $BUILDKITE_ZSTASH_CACHE_ID $BUILDKITE_ZSTASH_CACHE_KEY"`,
+				OnMiss: `echo "miss restored=$BUILDKITE_ZSTASH_CACHE_RESTORED fallback=$BUILDKITE_ZSTASH_CACHE_FALLBACK"`,
+			},
+			{ID: "no_hooks"},
+			{ID: "failing", OnMiss: "exit 3"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		cacheID string
+		result  RestoreResult
+		wantRan bool
+		wantOut string
+		wantErr string
+	}{
+		{
+			name:    "hit",
+			cacheID: "node_modules",
+			result:  RestoreResult{CacheHit: true, CacheRestored: true, Key: "v1-abc"},
+			wantRan: true,
+			wantOut: "hit node_modules v1-abc\n",
+		},
+		{
+			name:    "fallback runs on miss",
+			cacheID: "node_modules",
+			result:  RestoreResult{CacheRestored: true, FallbackUsed: true, Key: "v1-"},
+			wantRan: true,
+			wantOut: "miss restored=true fallback=true\n",
+		},
+		{
+			name:    "miss",
+			cacheID: "node_modules",
+			result:  RestoreResult{},
+			wantRan: true,
+			wantOut: "miss restored=false fallback=false\n",
+		},
+		{
+			name:    "no command",
+			cacheID: "no_hooks",
+			result:  RestoreResult{},
+		},
+		{
+			name:    "failing command",
+			cacheID: "failing",
+			result:  RestoreResult{},
+			wantRan: true,
+			wantErr: "on_miss command for cache failing failed",
+		},
+		{
+			name:    "unknown cache",
+			cacheID: "missing",
+			wantErr: ErrCacheNotFound.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+
+			ran, err := cacheClient.RunRestoreHook(context.Background(), tt.cacheID, tt.result, &stdout, nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantRan, ran)
+			assert.Equal(t, tt.wantOut, stdout.String())
+		})
+	}
+}
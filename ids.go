@@ -0,0 +1,73 @@
+package zstash
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// MatchCacheIDs returns the IDs of the client's caches matching any of the
+// patterns and none of the excludes, so large configurations can be managed by
+// name, e.g. "node_*". Patterns use path.Match syntax; a pattern without
+// wildcards matches the cache with that ID. IDs are returned in the order of
+// the patterns which first match them, and caches matched by a pattern in the
+// order they are configured. If patterns is empty, all of the client's caches
+// match.
+//
+// Returns an error wrapping ErrCacheNotFound if a pattern matches no caches,
+// or an error if a pattern is listed more than once.
+func (c *Cache) MatchCacheIDs(patterns, excludes []string) ([]string, error) {
+	for _, pattern := range slices.Concat(patterns, excludes) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid cache ID pattern %q: %w", pattern, err)
+		}
+	}
+
+	all := len(patterns) == 0
+	if all {
+		patterns = []string{"*"}
+	}
+
+	var ids []string
+	for i, pattern := range patterns {
+		if slices.Contains(patterns[:i], pattern) {
+			return nil, fmt.Errorf("cache %s is listed more than once", pattern)
+		}
+
+		matched := false
+		for _, cacheItem := range c.caches {
+			if !matchCacheID(pattern, cacheItem.ID) {
+				continue
+			}
+			matched = true
+
+			excluded := slices.ContainsFunc(excludes, func(exclude string) bool {
+				return matchCacheID(exclude, cacheItem.ID)
+			})
+			if !excluded && !slices.Contains(ids, cacheItem.ID) {
+				ids = append(ids, cacheItem.ID)
+			}
+		}
+
+		if !matched && !all {
+			if isCacheIDPattern(pattern) {
+				return nil, fmt.Errorf("no caches match %q: %w", pattern, ErrCacheNotFound)
+			}
+			return nil, fmt.Errorf("cache %s: %w", pattern, ErrCacheNotFound)
+		}
+	}
+
+	return ids, nil
+}
+
+// matchCacheID reports whether the cache ID matches the validated pattern.
+func matchCacheID(pattern, id string) bool {
+	matched, _ := path.Match(pattern, id)
+	return matched
+}
+
+// isCacheIDPattern reports whether the pattern contains wildcards.
+func isCacheIDPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
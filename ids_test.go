@@ -0,0 +1,53 @@
+package zstash
+
+import (
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchCacheIDs(t *testing.T) {
+	cacheClient := &Cache{caches: []cache.Cache{
+		{ID: "node_modules"},
+		{ID: "node_npm"},
+		{ID: "go_mod"},
+		{ID: "go_build"},
+	}}
+
+	tests := []struct {
+		name        string
+		patterns    []string
+		excludes    []string
+		want        []string
+		errContains string
+	}{
+		{name: "all", want: []string{"node_modules", "node_npm", "go_mod", "go_build"}},
+		{name: "exact", patterns: []string{"go_mod", "node_npm"}, want: []string{"go_mod", "node_npm"}},
+		{name: "glob", patterns: []string{"node_*"}, want: []string{"node_modules", "node_npm"}},
+		{name: "overlapping patterns", patterns: []string{"go_build", "go_*"}, want: []string{"go_build", "go_mod"}},
+		{name: "exclude", excludes: []string{"*_npm", "go_build"}, want: []string{"node_modules", "go_mod"}},
+		{name: "glob with exclude", patterns: []string{"go_*"}, excludes: []string{"go_build"}, want: []string{"go_mod"}},
+		{name: "duplicate", patterns: []string{"go_mod", "go_mod"}, errContains: "cache go_mod is listed more than once"},
+		{name: "unknown ID", patterns: []string{"rust"}, errContains: "cache rust: cache not found"},
+		{name: "glob matches nothing", patterns: []string{"rust_*"}, errContains: `no caches match "rust_*"`},
+		{name: "invalid pattern", patterns: []string{"node_["}, errContains: `invalid cache ID pattern "node_["`},
+		{name: "invalid exclude", excludes: []string{"["}, errContains: `invalid cache ID pattern "["`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got, err := cacheClient.MatchCacheIDs(tt.patterns, tt.excludes)
+			if tt.errContains != "" {
+				assert.Error(err)
+				assert.Contains(err.Error(), tt.errContains)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// KeyMapping maps an archive stored by another cache tool to the cache and
+// key it is imported as, see Import.
+type KeyMapping struct {
+	// From is the object key of the archive, relative to ImportOptions.From.
+	From string `yaml:"from" json:"from"`
+
+	// Cache is the ID of the configured cache the archive is imported into.
+	// Its entries are restored relative to the cache's paths.
+	Cache string `yaml:"cache" json:"cache"`
+
+	// Key is the cache key the archive is saved under. Defaults to the
+	// cache's configured key.
+	Key string `yaml:"key" json:"key"`
+}
+
+// LoadKeyMap reads the key mappings for Import from a YAML file holding a list
+// of mappings, each with the from, cache and key fields of KeyMapping.
+func LoadKeyMap(path string) ([]KeyMapping, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is configured by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key map: %w", err)
+	}
+
+	var mappings []KeyMapping
+	if err := yaml.Unmarshal(data, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to parse key map: %w", err)
+	}
+
+	return mappings, nil
+}
+
+// ImportOptions controls the behaviour of Import.
+type ImportOptions struct {
+	// From is the URL of the store the archives are read from, such as
+	// "s3://old-bucket/prefix", "file:///mnt/caches" or
+	// "https://artifacts.example.com/caches".
+	From string
+
+	// KeyMap lists the archives to import, see LoadKeyMap.
+	KeyMap []KeyMapping
+
+	// KeepGoing continues importing the remaining archives when one fails,
+	// rather than stopping. The errors of every failed import are joined and
+	// returned.
+	KeepGoing bool
+}
+
+// CacheImportResult is the outcome of importing one archive.
+type CacheImportResult struct {
+	// Mapping is the key mapping of the imported archive.
+	Mapping KeyMapping
+
+	// Result contains the save metrics, valid when Err is nil.
+	Result SaveResult
+
+	// Err is the error which caused the import to fail, or nil.
+	Err error
+}
+
+// ImportResult contains the results of Import.
+type ImportResult struct {
+	// Imports holds a result for each key mapping, in order. Mappings which
+	// weren't attempted because an earlier import failed are omitted, unless
+	// ImportOptions.KeepGoing is set.
+	Imports []CacheImportResult
+}
+
+// Err returns the errors of the archives which failed to import joined
+// together, each naming its archive, or nil if none failed.
+func (r ImportResult) Err() error {
+	var errs []error
+	for _, result := range r.Imports {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to import %s: %w", result.Mapping.From, result.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Import migrates caches stored by another cache tool, so builds don't start
+// from a cold cache. Each archive in ImportOptions.KeyMap is downloaded from
+// ImportOptions.From and saved as with SaveFromArchive, registering it with
+// the cache API under its mapped key and uploading it to the cache's store.
+// Archives which already exist under their mapped key are skipped.
+//
+// The archives must be zip or tar.gz archives with entries relative to the
+// mapped cache's paths, as built by Save, such as tarballs created with
+// "tar -czf cache.tar.gz -C ~ .npm" for a cache of "~/.npm".
+//
+// Example:
+//
+//	keyMap, err := zstash.LoadKeyMap("key-map.yml")
+//	if err != nil {
+//	    log.Fatalf("Failed to load key map: %v", err)
+//	}
+//	result, err := cacheClient.Import(ctx, zstash.ImportOptions{
+//	    From:   "s3://old-bucket/prefix",
+//	    KeyMap: keyMap,
+//	})
+//	if err != nil {
+//	    log.Fatalf("Cache import failed: %v", err)
+//	}
+func (c *Cache) Import(ctx context.Context, opts ImportOptions) (ImportResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.Import")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	span.SetAttributes(
+		attribute.String("import.from", opts.From),
+		attribute.Int("import.mappings_count", len(opts.KeyMap)),
+	)
+
+	for _, mapping := range opts.KeyMap {
+		if mapping.From == "" {
+			err := fmt.Errorf("%w: key mapping for cache %q has no archive", ErrInvalidConfiguration, mapping.Cache)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid key map")
+			return ImportResult{}, err
+		}
+		if _, err := c.findCache(mapping.Cache); err != nil {
+			err = fmt.Errorf("invalid key mapping for %s: %w: %s", mapping.From, err, mapping.Cache)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid key map")
+			return ImportResult{}, err
+		}
+	}
+
+	source, err := newImportStore(store.WithTransport(ctx, c.transport), opts.From)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create import store")
+		return ImportResult{}, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "zstash-import")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create temporary directory")
+		return ImportResult{}, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var importResult ImportResult
+	for _, mapping := range opts.KeyMap {
+		if err := ctx.Err(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "import cancelled")
+			return importResult, err
+		}
+
+		result, err := c.importArchive(ctx, source, tmpDir, mapping)
+		importResult.Imports = append(importResult.Imports, CacheImportResult{Mapping: mapping, Result: result, Err: err})
+		if err != nil && !opts.KeepGoing {
+			err = fmt.Errorf("failed to import %s: %w", mapping.From, err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to import caches")
+			return importResult, err
+		}
+	}
+
+	if err := importResult.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to import caches")
+		return importResult, err
+	}
+
+	span.SetStatus(codes.Ok, "caches imported")
+
+	return importResult, nil
+}
+
+// importArchive downloads the archive of a key mapping and saves it.
+func (c *Cache) importArchive(ctx context.Context, source store.Blob, tmpDir string, mapping KeyMapping) (SaveResult, error) {
+	archivePath := filepath.Join(tmpDir, "archive")
+	defer os.Remove(archivePath)
+
+	if _, err := source.Download(ctx, mapping.From, archivePath); err != nil {
+		return SaveResult{}, fmt.Errorf("failed to download archive: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("importing cache archive", "cache_id", mapping.Cache, "from", mapping.From, "key", mapping.Key)
+
+	c.emit(ctx, SaveStarted{EventInfo: newEventInfo(mapping.Cache)})
+
+	result, err := c.save(ctx, mapping.Cache, archivePath, saveTarget{key: mapping.Key}, SaveOptions{})
+	c.emit(ctx, SaveCompleted{EventInfo: newEventInfo(mapping.Cache), Result: result, Err: err})
+	c.reportSave(ctx, mapping.Cache, result, err)
+
+	return result, err
+}
+
+// newImportStore returns the store archives are imported from, choosing the
+// store type from the scheme of the URL.
+func newImportStore(ctx context.Context, from string) (store.Blob, error) {
+	u, err := url.Parse(from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid import URL: %w", ErrInvalidConfiguration, err)
+	}
+
+	var storeType string
+	switch u.Scheme {
+	case "s3":
+		storeType = store.LocalS3Store
+	case "file":
+		storeType = store.LocalFileStore
+	case "http", "https":
+		storeType = store.LocalHTTPStore
+	default:
+		return nil, fmt.Errorf("%w: unsupported import URL scheme %q, expected s3, file, http or https", ErrInvalidConfiguration, u.Scheme)
+	}
+
+	source, err := store.NewBlobStore(ctx, storeType, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create import store: %w", err)
+	}
+
+	return source, nil
+}
@@ -0,0 +1,69 @@
+package zstash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKeyMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key-map.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+- from: node_modules/1f2e3d.tar.gz
+  cache: node_modules
+  key: v1-node_modules-1f2e3d
+- from: gems/abc.tar.gz
+  cache: gems
+`), 0o600))
+
+	mappings, err := LoadKeyMap(path)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyMapping{
+		{From: "node_modules/1f2e3d.tar.gz", Cache: "node_modules", Key: "v1-node_modules-1f2e3d"},
+		{From: "gems/abc.tar.gz", Cache: "gems"},
+	}, mappings)
+
+	require.NoError(t, os.WriteFile(path, []byte("from: not-a-list"), 0o600))
+
+	_, err = LoadKeyMap(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse key map")
+}
+
+func TestImport_InvalidOptions(t *testing.T) {
+	cacheClient := &Cache{caches: []cache.Cache{{ID: "node_modules"}}}
+
+	tests := []struct {
+		name    string
+		opts    ImportOptions
+		wantErr error
+	}{
+		{
+			name:    "unknown cache",
+			opts:    ImportOptions{From: "file:///tmp", KeyMap: []KeyMapping{{From: "a.tar.gz", Cache: "gems"}}},
+			wantErr: ErrCacheNotFound,
+		},
+		{
+			name:    "missing archive",
+			opts:    ImportOptions{From: "file:///tmp", KeyMap: []KeyMapping{{Cache: "node_modules"}}},
+			wantErr: ErrInvalidConfiguration,
+		},
+		{
+			name:    "unsupported scheme",
+			opts:    ImportOptions{From: "ftp://old-server/caches", KeyMap: []KeyMapping{{From: "a.tar.gz", Cache: "node_modules"}}},
+			wantErr: ErrInvalidConfiguration,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cacheClient.Import(context.Background(), tt.opts)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
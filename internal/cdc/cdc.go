@@ -0,0 +1,170 @@
+// Package cdc implements FastCDC content-defined chunking, splitting a stream
+// into variable sized chunks whose boundaries depend on the content rather than
+// the offset. Inserting or removing bytes only changes the chunks around the
+// edit, so unchanged content produces identical chunks which can be deduplicated.
+package cdc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Options controls the size of chunks.
+type Options struct {
+	// MinSize is the minimum chunk size, except for the final chunk.
+	MinSize int
+	// AvgSize is the target average chunk size, which must be a power of two.
+	AvgSize int
+	// MaxSize is the maximum chunk size.
+	MaxSize int
+}
+
+// DefaultOptions produces chunks averaging 4MiB, between 1MiB and 16MiB.
+var DefaultOptions = Options{
+	MinSize: 1 << 20,
+	AvgSize: 4 << 20,
+	MaxSize: 16 << 20,
+}
+
+// Validate returns an error if the options can't be used for chunking.
+func (o Options) Validate() error {
+	if o.MinSize <= 0 || o.AvgSize <= 0 || o.MaxSize <= 0 {
+		return errors.New("chunk sizes must be positive")
+	}
+
+	if o.AvgSize&(o.AvgSize-1) != 0 {
+		return fmt.Errorf("average chunk size must be a power of two, got %d", o.AvgSize)
+	}
+
+	if o.MinSize >= o.AvgSize || o.AvgSize >= o.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy min < avg < max, got %d, %d, %d", o.MinSize, o.AvgSize, o.MaxSize)
+	}
+
+	return nil
+}
+
+// Chunker splits a stream into content-defined chunks.
+type Chunker struct {
+	r    io.Reader
+	opts Options
+
+	// masks used before and after the average size, normalising chunk sizes
+	// towards the average
+	maskS uint64
+	maskL uint64
+
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+// NewChunker creates a Chunker reading from r.
+func NewChunker(r io.Reader, opts Options) (*Chunker, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	// the gear hash shifts left, so the high bits depend on the most recent
+	// 64 bytes and are used for the masks
+	avgBits := bits.TrailingZeros(uint(opts.AvgSize))
+
+	return &Chunker{
+		r:     r,
+		opts:  opts,
+		maskS: ^uint64(0) << (64 - (avgBits + 1)),
+		maskL: ^uint64(0) << (64 - (avgBits - 1)),
+		buf:   make([]byte, opts.MaxSize*2),
+	}, nil
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted. The
+// returned slice is only valid until the following call to Next.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.opts.MaxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+
+	return chunk, nil
+}
+
+// fill moves unconsumed data to the start of the buffer and reads until the
+// buffer is full or the stream is exhausted.
+func (c *Chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		c.eof = true
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to read: %w", err)
+	}
+
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	if n > c.opts.MaxSize {
+		n = c.opts.MaxSize
+	}
+
+	normal := min(c.opts.AvgSize, n)
+
+	var h uint64
+	i := c.opts.MinSize
+
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+// gear is the table of random values used by the rolling hash. It's generated
+// from a fixed seed so chunk boundaries are stable between releases.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+
+	// splitmix64
+	state := uint64(0x7a73746173686364) // "zstashcd"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}()
@@ -0,0 +1,142 @@
+package cdc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOptions = Options{
+	MinSize: 4 << 10,
+	AvgSize: 16 << 10,
+	MaxSize: 64 << 10,
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	_, err := rand.NewChaCha8([32]byte{}).Read(data)
+	require.NoError(t, err)
+
+	return data
+}
+
+// chunkDigests splits data into chunks, returning the digest of each chunk.
+func chunkDigests(t *testing.T, data []byte, opts Options) [][32]byte {
+	t.Helper()
+
+	chunker, err := NewChunker(bytes.NewReader(data), opts)
+	require.NoError(t, err)
+
+	var digests [][32]byte
+	for {
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			return digests
+		}
+		require.NoError(t, err)
+		digests = append(digests, sha256.Sum256(chunk))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	data := randomData(t, 2<<20)
+
+	chunker, err := NewChunker(bytes.NewReader(data), testOptions)
+	require.NoError(t, err)
+
+	var reassembled []byte
+	var sizes []int
+	for {
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		reassembled = append(reassembled, chunk...)
+		sizes = append(sizes, len(chunk))
+	}
+
+	assert.Equal(t, data, reassembled)
+	require.Greater(t, len(sizes), 1)
+
+	for i, size := range sizes {
+		assert.LessOrEqual(t, size, testOptions.MaxSize)
+		if i < len(sizes)-1 {
+			assert.GreaterOrEqual(t, size, testOptions.MinSize)
+		}
+	}
+
+	avg := len(data) / len(sizes)
+	assert.InDelta(t, testOptions.AvgSize, avg, float64(testOptions.AvgSize), "average chunk size should be near the target")
+}
+
+func TestChunker_Deterministic(t *testing.T) {
+	data := randomData(t, 1<<20)
+
+	assert.Equal(t, chunkDigests(t, data, testOptions), chunkDigests(t, data, testOptions))
+}
+
+func TestChunker_InsertionOnlyChangesNearbyChunks(t *testing.T) {
+	data := randomData(t, 2<<20)
+
+	original := chunkDigests(t, data, testOptions)
+
+	edited := append([]byte("inserted bytes at the start of the stream"), data...)
+	changed := chunkDigests(t, edited, testOptions)
+
+	seen := make(map[[32]byte]bool, len(original))
+	for _, digest := range original {
+		seen[digest] = true
+	}
+
+	shared := 0
+	for _, digest := range changed {
+		if seen[digest] {
+			shared++
+		}
+	}
+
+	assert.GreaterOrEqual(t, shared, len(original)-2, "only the chunks around the insertion should change")
+}
+
+func TestChunker_Empty(t *testing.T) {
+	chunker, err := NewChunker(bytes.NewReader(nil), testOptions)
+	require.NoError(t, err)
+
+	_, err = chunker.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{name: "default", opts: DefaultOptions},
+		{name: "zero", opts: Options{}, wantErr: "must be positive"},
+		{name: "average not a power of two", opts: Options{MinSize: 1, AvgSize: 3, MaxSize: 8}, wantErr: "power of two"},
+		{name: "min above average", opts: Options{MinSize: 32, AvgSize: 16, MaxSize: 64}, wantErr: "min < avg < max"},
+		{name: "max below average", opts: Options{MinSize: 4, AvgSize: 16, MaxSize: 8}, wantErr: "min < avg < max"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
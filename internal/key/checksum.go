@@ -0,0 +1,87 @@
+package key
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// digestKey identifies the content of a file by its path, size and
+// modification time, so a file which hasn't changed isn't hashed again.
+type digestKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// digestCache holds the digests of files hashed by the checksum function, as
+// keys of several caches commonly checksum the same lockfiles.
+var digestCache sync.Map // map[digestKey]string
+
+// checksumFiles returns the digest of each file, in the same order, hashing
+// them concurrently.
+func checksumFiles(files []string) ([]string, error) {
+	sums := make([]string, len(files))
+
+	var wg errgroup.Group
+	wg.SetLimit(runtime.GOMAXPROCS(0))
+
+	for i, file := range files {
+		wg.Go(func() error {
+			sum, err := checksumFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to checksum %s: %w", file, err)
+			}
+			sums[i] = sum
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	return sums, nil
+}
+
+// checksumFile returns the digest of a file's content, from digestCache if
+// the file hasn't changed since it was last hashed.
+func checksumFile(file string) (string, error) {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- path is matched by a checksum pattern
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	key := digestKey{path: path, size: info.Size(), modTime: info.ModTime()}
+	if sum, ok := digestCache.Load(key); ok {
+		return sum.(string), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	sum := fmt.Sprintf("%x", hash.Sum(nil))
+	digestCache.Store(key, sum)
+
+	return sum, nil
+}
@@ -0,0 +1,63 @@
+package key
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumFiles(t *testing.T) {
+	assert := require.New(t)
+
+	tmpDir := t.TempDir()
+
+	var files, want []string
+	for i := range 100 {
+		file := filepath.Join(tmpDir, fmt.Sprintf("file-%03d.lock", i))
+		content := []byte(fmt.Sprintf("content %d", i))
+		assert.NoError(os.WriteFile(file, content, 0o600))
+
+		files = append(files, file)
+		want = append(want, checksum(content))
+	}
+
+	// digests are returned in the order of the files
+	sums, err := checksumFiles(files)
+	assert.NoError(err)
+	assert.Equal(want, sums)
+
+	_, err = checksumFiles([]string{filepath.Join(tmpDir, "missing.lock")})
+	assert.Error(err)
+}
+
+func TestChecksumFile_Cache(t *testing.T) {
+	assert := require.New(t)
+
+	file := filepath.Join(t.TempDir(), "package-lock.json")
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.NoError(os.WriteFile(file, []byte("original"), 0o600))
+	assert.NoError(os.Chtimes(file, modTime, modTime))
+
+	sum, err := checksumFile(file)
+	assert.NoError(err)
+	assert.Equal(checksum([]byte("original")), sum)
+
+	// a file with the same size and modification time isn't hashed again
+	assert.NoError(os.WriteFile(file, []byte("modified"), 0o600))
+	assert.NoError(os.Chtimes(file, modTime, modTime))
+
+	sum, err = checksumFile(file)
+	assert.NoError(err)
+	assert.Equal(checksum([]byte("original")), sum)
+
+	assert.NoError(os.Chtimes(file, modTime.Add(time.Second), modTime.Add(time.Second)))
+
+	sum, err = checksumFile(file)
+	assert.NoError(err)
+	assert.Equal(checksum([]byte("modified")), sum)
+}
@@ -0,0 +1,127 @@
+package key
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Modes of the dirsum function, trading accuracy for speed.
+const (
+	// DirsumMetadata hashes the names, sizes and modification times of the
+	// files in a directory tree without reading them. It is fast enough for
+	// enormous trees, but changes whenever files are touched, such as by a
+	// fresh checkout.
+	DirsumMetadata = "metadata"
+	// DirsumContent hashes the names and content of the files in a directory
+	// tree, like checksum.
+	DirsumContent = "content"
+)
+
+// checksumDirs returns a template function which hashes directory trees, e.g.
+// {{ dirsum "metadata" "vendor" }}. The mode is required so the trade off
+// between accuracy and speed is explicit in the key.
+func checksumDirs(record func(ChecksumFile)) func(mode string, dirs ...string) (string, error) {
+	return func(mode string, dirs ...string) (string, error) {
+		if mode != DirsumMetadata && mode != DirsumContent {
+			return "", fmt.Errorf("invalid dirsum mode %q: must be %q or %q", mode, DirsumMetadata, DirsumContent)
+		}
+
+		if len(dirs) == 0 {
+			return "", fmt.Errorf("dirsum requires at least one directory")
+		}
+
+		hash := sha256.New()
+		for _, dir := range dirs {
+			sum, err := checksumDir(dir, mode)
+			if err != nil {
+				return "", fmt.Errorf("failed to checksum directory %s: %w", dir, err)
+			}
+			_, _ = hash.Write([]byte(sum))
+
+			if record != nil {
+				record(ChecksumFile{Path: dir, Digest: sum})
+			}
+		}
+
+		return fmt.Sprintf("%x", hash.Sum(nil)), nil
+	}
+}
+
+// checksumDir hashes a line describing each entry of a directory tree, in
+// lexical order. Entries in ignoreFiles are skipped.
+func checksumDir(dir, mode string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("not a directory")
+	}
+
+	hash := sha256.New()
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path != dir && slices.Contains(ignoreFiles, d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		switch {
+		case d.IsDir():
+			_, _ = fmt.Fprintf(hash, "d %q\n", name)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(hash, "l %q %q\n", name, target)
+		case d.Type().IsRegular():
+			line, err := fileLine(path, name, mode)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(hash, line)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// fileLine describes a regular file by its metadata or content digest.
+func fileLine(path, name, mode string) (string, error) {
+	if mode == DirsumContent {
+		sum, err := checksumFile(path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("f %q %s", name, sum), nil
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("f %q %d %d", name, info.Size(), info.ModTime().UnixNano()), nil
+}
@@ -0,0 +1,84 @@
+package key
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirsum(t *testing.T) {
+	assert := require.New(t)
+
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(os.MkdirAll(filepath.Join("vendor", "lib"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join("vendor", "lib", "a.go"), []byte("package lib"), 0o600))
+	assert.NoError(os.Chtimes(filepath.Join("vendor", "lib", "a.go"), modTime, modTime))
+
+	metadata, err := Template("", `{{ dirsum "metadata" "vendor" }}`)
+	assert.NoError(err)
+	content, err := Template("", `{{ dirsum "content" "vendor" }}`)
+	assert.NoError(err)
+	assert.NotEqual(metadata, content)
+
+	// ignored files don't contribute
+	assert.NoError(os.WriteFile(filepath.Join("vendor", ".DS_Store"), []byte("finder"), 0o600))
+
+	got, err := Template("", `{{ dirsum "metadata" "vendor" }}`)
+	assert.NoError(err)
+	assert.Equal(metadata, got)
+
+	// touching a file only changes the metadata sum
+	touched := modTime.Add(time.Hour)
+	assert.NoError(os.Chtimes(filepath.Join("vendor", "lib", "a.go"), touched, touched))
+
+	got, err = Template("", `{{ dirsum "metadata" "vendor" }}`)
+	assert.NoError(err)
+	assert.NotEqual(metadata, got)
+
+	got, err = Template("", `{{ dirsum "content" "vendor" }}`)
+	assert.NoError(err)
+	assert.Equal(content, got)
+
+	// new files change both sums
+	assert.NoError(os.WriteFile(filepath.Join("vendor", "b.go"), []byte("package vendor"), 0o600))
+
+	got, err = Template("", `{{ dirsum "content" "vendor" }}`)
+	assert.NoError(err)
+	assert.NotEqual(content, got)
+
+	_, files, err := TemplateWithDetails("", `{{ dirsum "content" "vendor" }}`, Options{})
+	assert.NoError(err)
+	assert.Len(files, 1)
+	assert.Equal("vendor", files[0].Path)
+}
+
+func TestDirsum_Errors(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	require.NoError(t, os.WriteFile("file.txt", []byte("data"), 0o600))
+
+	tests := []struct {
+		name        string
+		key         string
+		errContains string
+	}{
+		{name: "invalid mode", key: `{{ dirsum "fast" "vendor" }}`, errContains: `invalid dirsum mode "fast"`},
+		{name: "no directories", key: `{{ dirsum "metadata" }}`, errContains: "requires at least one directory"},
+		{name: "missing directory", key: `{{ dirsum "metadata" "missing" }}`, errContains: "failed to checksum directory missing"},
+		{name: "not a directory", key: `{{ dirsum "content" "file.txt" }}`, errContains: "not a directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Template("", tt.key)
+			require.ErrorContains(t, err, tt.errContains)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"sort"
@@ -27,16 +28,82 @@ var ignoreFiles = []string{
 	".keep",
 }
 
+// Options controls how key templates are expanded.
+type Options struct {
+	// Env is used by the env and Buildkite metadata functions, when nil the OS
+	// environment is used.
+	Env map[string]string
+	// AllowCommands enables the cmdsum function, which runs commands while
+	// expanding a template so is disabled by default.
+	AllowCommands bool
+	// Platform is returned by the platform function, e.g. "linux/amd64/musl".
+	// Defaults to runtime.GOOS/runtime.GOARCH.
+	Platform string
+	// Logger logs the files and environment variables used by templates.
+	// Defaults to slog.Default().
+	Logger *slog.Logger
+	// Memo reuses the files resolved and hashed for checksum patterns by
+	// earlier expansions sharing it, see Memo. When nil every expansion
+	// resolves its patterns again.
+	Memo *Memo
+}
+
 func Template(id, key string) (string, error) {
 	return TemplateWithEnv(id, key, nil)
 }
 
 func TemplateWithEnv(id, key string, env map[string]string) (string, error) {
+	return TemplateWithOptions(id, key, Options{Env: env})
+}
+
+// ChecksumFile is a file which was hashed by the checksum function while
+// expanding a key template.
+type ChecksumFile struct {
+	Path   string
+	Digest string
+}
+
+func TemplateWithOptions(id, key string, opts Options) (string, error) {
+	return expand(id, key, opts, nil)
+}
+
+// TemplateWithDetails expands a key template like TemplateWithOptions, also
+// returning every file which contributed to a checksum along with its digest.
+// This is useful when debugging why a key has changed.
+func TemplateWithDetails(id, key string, opts Options) (string, []ChecksumFile, error) {
+	var files []ChecksumFile
+	key, err := expand(id, key, opts, func(file ChecksumFile) {
+		files = append(files, file)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, files, nil
+}
+
+func expand(id, key string, opts Options, record func(ChecksumFile)) (string, error) {
+	env := opts.Env
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	tpl := template.New("key").Option("missingkey=zero").Funcs(template.FuncMap{
-		"id":       getID(id),
-		"checksum": checksumPaths(),
-		"env":      getEnvWithMap(env),
+		"id":       getID(id, logger),
+		"checksum": checksumPaths(record, opts.Memo, logger),
+		"dirsum":   checksumDirs(record),
+		"cmdsum":   checksumCommand(opts.AllowCommands, logger),
+		"env":      getEnvWithMap(env, logger),
 		"agent":    getAgent,
+		"platform": getPlatform(opts.Platform),
+
+		// Buildkite job metadata
+		"pipeline":     getEnvValue(env, logger, "BUILDKITE_PIPELINE_SLUG"),
+		"branch":       getEnvValue(env, logger, "BUILDKITE_BRANCH"),
+		"build_number": getEnvValue(env, logger, "BUILDKITE_BUILD_NUMBER"),
+		"step_key":     getEnvValue(env, logger, "BUILDKITE_STEP_KEY"),
 	})
 	tpl, err := tpl.Parse(key)
 	if err != nil {
@@ -55,9 +122,9 @@ func TemplateWithEnv(id, key string, env map[string]string) (string, error) {
 	return key, nil
 }
 
-func getID(id string) func() string {
+func getID(id string, logger *slog.Logger) func() string {
 	return func() string {
-		slog.Debug("getID", "id", id)
+		logger.Debug("getID", "id", id)
 		if id == "" {
 			return ""
 		}
@@ -74,9 +141,18 @@ func getAgent() map[string]string {
 	}
 }
 
-func getEnvWithMap(envMap map[string]string) func(string) string {
+func getPlatform(platform string) func() string {
+	return func() string {
+		if platform == "" {
+			return runtime.GOOS + "/" + runtime.GOARCH
+		}
+		return platform
+	}
+}
+
+func getEnvWithMap(envMap map[string]string, logger *slog.Logger) func(string) string {
 	return func(key string) string {
-		slog.Info("getEnv", "key", key)
+		logger.Info("getEnv", "key", key)
 
 		var env string
 		if envMap != nil {
@@ -98,38 +174,58 @@ func getEnvWithMap(envMap map[string]string) func(string) string {
 	}
 }
 
-func checksumPaths() func(files ...string) string {
+// getEnvValue returns a template function which looks up a fixed environment
+// variable, used to expose Buildkite job metadata without wiring up env calls.
+func getEnvValue(envMap map[string]string, logger *slog.Logger, key string) func() string {
+	getEnv := getEnvWithMap(envMap, logger)
+	return func() string {
+		return getEnv(key)
+	}
+}
+
+func checksumPaths(record func(ChecksumFile), memo *Memo, logger *slog.Logger) func(files ...string) string {
 	return func(patterns ...string) string {
-		slog.Debug("checksumPaths", "files", patterns)
+		logger.Debug("checksumPaths", "files", patterns)
 
 		if len(patterns) == 0 {
 			return ""
 		}
 
-		// Resolve all patterns to actual file paths
-		files, err := resolveFiles(patterns)
-		if err != nil {
-			slog.Error("error resolving files", "error", err)
-			return ""
-		}
+		entry, ok := memo.load(patterns)
+		if ok {
+			logger.Debug("reusing memoized checksum", "patterns", patterns, "files", len(entry.files))
+		} else {
+			// Resolve all patterns to actual file paths
+			files, err := resolveFiles(patterns, logger)
+			if err != nil {
+				logger.Error("error resolving files", "error", err)
+				return ""
+			}
 
-		if len(files) == 0 {
-			slog.Warn("no files found for patterns", "patterns", patterns)
-			return ""
-		}
+			if len(files) == 0 {
+				logger.Warn("no files found for patterns", "patterns", patterns)
+				return ""
+			}
 
-		slog.Debug("resolved files for checksumming", "files", len(files))
+			logger.Debug("resolved files for checksumming", "files", len(files))
 
-		// Calculate individual checksums and combine (for backward compatibility)
-		var sums []string
-		for _, file := range files {
-			data, err := os.ReadFile(file)
+			// Calculate individual checksums and combine (for backward compatibility)
+			sums, err := checksumFiles(files)
 			if err != nil {
-				slog.Error("error reading file", "error", err, "file", file)
+				logger.Error("error checksumming files", "error", err)
 				return ""
 			}
-			sums = append(sums, checksum(data))
-			slog.Debug("checksummed file", "file", file)
+
+			entry = memoEntry{files: files, sums: sums}
+			memo.store(patterns, entry)
+		}
+
+		files, sums := entry.files, entry.sums
+
+		if record != nil {
+			for i, file := range files {
+				record(ChecksumFile{Path: file, Digest: sums[i]})
+			}
 		}
 
 		// Combine the sums into a single string and hash (matches original behavior)
@@ -138,20 +234,45 @@ func checksumPaths() func(files ...string) string {
 	}
 }
 
+// checksumCommand returns a template function which runs a command and hashes
+// its stdout, e.g. {{ cmdsum "node --version" }}. The command is split on
+// whitespace and run directly, without a shell.
+func checksumCommand(allowed bool, logger *slog.Logger) func(command string) (string, error) {
+	return func(command string) (string, error) {
+		logger.Debug("checksumCommand", "command", command)
+
+		if !allowed {
+			return "", fmt.Errorf("cmdsum is disabled, command execution must be enabled to run %q", command)
+		}
+
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return "", fmt.Errorf("cmdsum requires a command")
+		}
+
+		output, err := exec.Command(args[0], args[1:]...).Output() // #nosec G204 -- command execution is opt-in
+		if err != nil {
+			return "", fmt.Errorf("failed to run command %q: %w", command, err)
+		}
+
+		return checksum(output), nil
+	}
+}
+
 // resolveFiles returns all files that match any of the supplied glob patterns.
 // Uses zzglob for full glob pattern support including **, *, ?, [], {a,b}.
 // Maintains backward compatibility with existing patterns while adding standard glob capabilities.
-func resolveFiles(patterns []string) ([]string, error) {
+func resolveFiles(patterns []string, logger *slog.Logger) ([]string, error) {
 	seen := make(map[string]struct{})
 	var result []string
 
 	for _, patternStr := range patterns {
-		slog.Debug("processing glob pattern", "pattern", patternStr)
+		logger.Debug("processing glob pattern", "pattern", patternStr)
 
 		// Parse the pattern using zzglob
 		pattern, err := zzglob.Parse(patternStr)
 		if err != nil {
-			slog.Error("glob pattern parse failed", "error", err, "pattern", patternStr)
+			logger.Error("glob pattern parse failed", "error", err, "pattern", patternStr)
 			return nil, err
 		}
 
@@ -174,7 +295,7 @@ func resolveFiles(patterns []string) ([]string, error) {
 			for _, ignore := range ignoreFiles {
 				if strings.HasSuffix(match, ignore) {
 					ignored = true
-					slog.Debug("ignoring file", "path", match, "ignore", ignore)
+					logger.Debug("ignoring file", "path", match, "ignore", ignore)
 					break
 				}
 			}
@@ -184,7 +305,7 @@ func resolveFiles(patterns []string) ([]string, error) {
 				if _, exists := seen[match]; !exists {
 					seen[match] = struct{}{}
 					result = append(result, match)
-					slog.Debug("file matched", "path", match, "pattern", patternStr)
+					logger.Debug("file matched", "path", match, "pattern", patternStr)
 				}
 			}
 
@@ -192,14 +313,14 @@ func resolveFiles(patterns []string) ([]string, error) {
 		})
 
 		if err != nil {
-			slog.Error("glob pattern failed", "error", err, "pattern", patternStr)
+			logger.Error("glob pattern failed", "error", err, "pattern", patternStr)
 			return nil, err
 		}
 	}
 
 	// Sort for deterministic output
 	sort.Strings(result)
-	slog.Debug("files resolved", "count", len(result))
+	logger.Debug("files resolved", "count", len(result))
 
 	return result, nil
 }
@@ -1,7 +1,9 @@
 package key
 
 import (
+	"bytes"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -43,6 +45,98 @@ func TestTemplate(t *testing.T) {
 		}
 	})
 
+	t.Run("buildkite metadata templates", func(t *testing.T) {
+		env := map[string]string{
+			"BUILDKITE_PIPELINE_SLUG": "my-pipeline",
+			"BUILDKITE_BRANCH":        " main ",
+			"BUILDKITE_BUILD_NUMBER":  "42",
+			"BUILDKITE_STEP_KEY":      "test",
+		}
+
+		tests := []struct {
+			name     string
+			key      string
+			expected string
+		}{
+			{
+				name:     "pipeline",
+				key:      "{{ pipeline }}",
+				expected: "my-pipeline",
+			},
+			{
+				name:     "branch trims whitespace",
+				key:      "{{ branch }}",
+				expected: "main",
+			},
+			{
+				name:     "build number",
+				key:      "{{ build_number }}",
+				expected: "42",
+			},
+			{
+				name:     "step key",
+				key:      "{{ step_key }}",
+				expected: "test",
+			},
+			{
+				name:     "combined",
+				key:      "{{ pipeline }}-{{ branch }}-{{ step_key }}-{{ build_number }}",
+				expected: "my-pipeline-main-test-42",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := TemplateWithEnv("", tt.key, env)
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, got)
+			})
+		}
+
+		t.Run("missing metadata", func(t *testing.T) {
+			got, err := TemplateWithEnv("", "{{ pipeline }}-{{ step_key }}", map[string]string{})
+			require.NoError(t, err)
+			require.Equal(t, "-", got)
+		})
+	})
+
+	t.Run("platform template", func(t *testing.T) {
+		got, err := Template("", "deps-{{ platform }}")
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("deps-%s/%s", runtime.GOOS, runtime.GOARCH), got)
+
+		got, err = TemplateWithOptions("", "deps-{{ platform }}", Options{Platform: "linux/amd64/musl"})
+		require.NoError(t, err)
+		require.Equal(t, "deps-linux/amd64/musl", got)
+	})
+
+	t.Run("cmdsum templates", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("echo is not an executable on windows")
+		}
+
+		t.Run("disabled by default", func(t *testing.T) {
+			_, err := Template("", `{{ cmdsum "echo hello" }}`)
+			require.ErrorContains(t, err, "cmdsum is disabled")
+		})
+
+		t.Run("hashes command output", func(t *testing.T) {
+			got, err := TemplateWithOptions("", `tools-{{ cmdsum "echo hello" }}`, Options{AllowCommands: true})
+			require.NoError(t, err)
+			require.Equal(t, "tools-"+checksum([]byte("hello\n")), got)
+		})
+
+		t.Run("empty command", func(t *testing.T) {
+			_, err := TemplateWithOptions("", `{{ cmdsum "  " }}`, Options{AllowCommands: true})
+			require.ErrorContains(t, err, "cmdsum requires a command")
+		})
+
+		t.Run("failing command", func(t *testing.T) {
+			_, err := TemplateWithOptions("", `{{ cmdsum "false" }}`, Options{AllowCommands: true})
+			require.ErrorContains(t, err, "failed to run command")
+		})
+	})
+
 	t.Run("checksum templates", func(t *testing.T) {
 		tests := []struct {
 			name     string
@@ -221,3 +315,41 @@ func TestTemplate(t *testing.T) {
 		}
 	})
 }
+
+func TestTemplateWithDetails(t *testing.T) {
+	assert := require.New(t)
+
+	tmpDir := t.TempDir()
+	t.Chdir(tmpDir)
+
+	assert.NoError(os.WriteFile("go.mod", []byte("test content"), 0600))
+	assert.NoError(os.WriteFile("go.sum", []byte("nested content"), 0600))
+
+	got, files, err := TemplateWithDetails("", `go-{{ checksum "go.*" }}`, Options{})
+	assert.NoError(err)
+	assert.Equal("go-f2684b75ab846895bcc1d50f4511edeb8fcd86167a8e6e64aeee46afc1576d9c", got)
+	assert.Equal([]ChecksumFile{
+		{Path: "go.mod", Digest: checksum([]byte("test content"))},
+		{Path: "go.sum", Digest: checksum([]byte("nested content"))},
+	}, files)
+
+	got, files, err = TemplateWithDetails("my_id", `{{ id }}`, Options{})
+	assert.NoError(err)
+	assert.Equal("my_id", got)
+	assert.Empty(files)
+}
+
+func TestTemplateWithOptions_Logger(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	got, err := TemplateWithOptions("", `{{ env "NODE_VERSION" }}`, Options{
+		Env:    map[string]string{"NODE_VERSION": "22"},
+		Logger: logger,
+	})
+	assert.NoError(err)
+	assert.Equal("22", got)
+	assert.Contains(buf.String(), `"msg":"getEnv","key":"NODE_VERSION"`)
+}
@@ -0,0 +1,62 @@
+package key
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// Memo shares the results of the checksum function between the expansions
+// using it, such as a cache's key and fallback keys, which commonly checksum
+// the same patterns. Each set of patterns is resolved and its files hashed
+// once, rather than walking the tree again for every expansion. A Memo should
+// only be shared by expansions run together, as files added or changed after
+// the patterns were first checksummed aren't seen.
+type Memo struct {
+	mu      sync.Mutex
+	entries map[string]memoEntry
+}
+
+// memoEntry is the files resolved for a set of patterns and their digests.
+type memoEntry struct {
+	files []string
+	sums  []string
+}
+
+// NewMemo returns an empty Memo.
+func NewMemo() *Memo {
+	return &Memo{entries: make(map[string]memoEntry)}
+}
+
+// memoKey identifies patterns resolved from the working directory, as they're
+// relative to it.
+func memoKey(patterns []string) string {
+	wd, _ := os.Getwd()
+	return wd + "\x00" + strings.Join(patterns, "\x00")
+}
+
+// load returns the files and digests checksummed for the patterns, if any.
+// A nil Memo never has an entry.
+func (m *Memo) load(patterns []string) (memoEntry, bool) {
+	if m == nil {
+		return memoEntry{}, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[memoKey(patterns)]
+	return entry, ok
+}
+
+// store records the files and digests checksummed for the patterns.
+func (m *Memo) store(patterns []string, entry memoEntry) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[memoKey(patterns)] = entry
+}
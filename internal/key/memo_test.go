@@ -0,0 +1,59 @@
+package key
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateWithOptions_Memo(t *testing.T) {
+	assert := require.New(t)
+
+	t.Chdir(t.TempDir())
+
+	assert.NoError(os.WriteFile("a.lock", []byte("a"), 0600))
+
+	memo := NewMemo()
+
+	got, err := TemplateWithOptions("", `v1-{{ checksum "*.lock" }}`, Options{Memo: memo})
+	assert.NoError(err)
+	want := "v1-" + checksum([]byte(checksum([]byte("a"))))
+	assert.Equal(want, got)
+
+	// files matched after the patterns were memoized aren't seen
+	assert.NoError(os.WriteFile("b.lock", []byte("b"), 0600))
+
+	got, files, err := TemplateWithDetails("", `v1-{{ checksum "*.lock" }}`, Options{Memo: memo})
+	assert.NoError(err)
+	assert.Equal(want, got)
+	assert.Equal([]ChecksumFile{{Path: "a.lock", Digest: checksum([]byte("a"))}}, files, "memoized files should still be recorded")
+
+	// other patterns are resolved separately
+	got, err = TemplateWithOptions("", `v1-{{ checksum "b.lock" }}`, Options{Memo: memo})
+	assert.NoError(err)
+	assert.Equal("v1-"+checksum([]byte(checksum([]byte("b")))), got)
+
+	got, err = TemplateWithOptions("", `v1-{{ checksum "*.lock" }}`, Options{Memo: NewMemo()})
+	assert.NoError(err)
+	assert.NotEqual(want, got)
+}
+
+func TestTemplateWithOptions_MemoMissingFiles(t *testing.T) {
+	assert := require.New(t)
+
+	t.Chdir(t.TempDir())
+
+	memo := NewMemo()
+
+	got, err := TemplateWithOptions("", `v1-{{ checksum "*.lock" }}`, Options{Memo: memo})
+	assert.NoError(err)
+	assert.Equal("v1-", got)
+
+	// patterns which matched nothing are resolved again
+	assert.NoError(os.WriteFile("a.lock", []byte("a"), 0600))
+
+	got, err = TemplateWithOptions("", `v1-{{ checksum "*.lock" }}`, Options{Memo: memo})
+	assert.NoError(err)
+	assert.Equal("v1-"+checksum([]byte(checksum([]byte("a")))), got)
+}
@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLogger(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	ctx := WithLogger(context.Background(), logger)
+	assert.Same(logger, FromContext(ctx))
+
+	FromContext(ctx).Info("hello", "cache_id", "node")
+	assert.Contains(buf.String(), `"msg":"hello"`)
+	assert.Contains(buf.String(), `"cache_id":"node"`)
+}
+
+func TestFromContext_Default(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := context.Background()
+	assert.Same(slog.Default(), FromContext(ctx))
+	assert.Equal(ctx, WithLogger(ctx, nil))
+}
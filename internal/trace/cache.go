@@ -0,0 +1,101 @@
+package trace
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cacheBaggagePrefix is the prefix of baggage members which are added as
+// attributes to every span started with Start.
+const cacheBaggagePrefix = "cache."
+
+// WithCache returns a context carrying the cache ID, key and registry as
+// baggage, which Start adds as the "cache.id", "cache.key" and
+// "cache.registry" attributes of spans started with the context, including
+// those in the api and store packages, so traces can be filtered per cache.
+//
+// The context also records the spans of API calls made with it, see
+// RecordLink and StartLinked.
+func WithCache(ctx context.Context, cacheID, key, registry string) context.Context {
+	bag := baggage.FromContext(ctx)
+
+	for name, value := range map[string]string{
+		"id":       cacheID,
+		"key":      key,
+		"registry": registry,
+	} {
+		if value == "" {
+			continue
+		}
+
+		member, err := baggage.NewMemberRaw(cacheBaggagePrefix+name, value)
+		if err != nil {
+			continue
+		}
+
+		if updated, err := bag.SetMember(member); err == nil {
+			bag = updated
+		}
+	}
+
+	ctx = baggage.ContextWithBaggage(ctx, bag)
+
+	return context.WithValue(ctx, linksKey{}, &links{})
+}
+
+// cacheAttributes returns the cache baggage members of ctx as attributes.
+func cacheAttributes(ctx context.Context) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+
+	for _, member := range baggage.FromContext(ctx).Members() {
+		if strings.HasPrefix(member.Key(), cacheBaggagePrefix) {
+			attrs = append(attrs, attribute.String(member.Key(), member.Value()))
+		}
+	}
+
+	return attrs
+}
+
+type linksKey struct{}
+
+// links collects the span contexts of API calls made for a cache.
+type links struct {
+	mu    sync.Mutex
+	links []trace.Link
+}
+
+// RecordLink records the span of an API call made with a context returned by
+// WithCache, so spans later started with StartLinked link to it. It does
+// nothing for other contexts.
+func RecordLink(ctx context.Context, span trace.Span) {
+	l, ok := ctx.Value(linksKey{}).(*links)
+	if !ok || !span.SpanContext().IsValid() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.links = append(l.links, trace.Link{SpanContext: span.SpanContext()})
+}
+
+// StartLinked starts a span as Start does, linking it to the API call spans
+// recorded with RecordLink, e.g. so a store upload links to the API call which
+// created the upload.
+func StartLinked(ctx context.Context, name string) (context.Context, trace.Span) {
+	l, ok := ctx.Value(linksKey{}).(*links)
+	if !ok {
+		return Start(ctx, name)
+	}
+
+	l.mu.Lock()
+	recorded := append([]trace.Link(nil), l.links...)
+	l.mu.Unlock()
+
+	return Start(ctx, name, trace.WithLinks(recorded...))
+}
@@ -0,0 +1,52 @@
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithCache(t *testing.T) {
+	assert := require.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx := WithCache(context.Background(), "node_modules", "v1-node-abc123", "my-registry")
+
+	_, apiSpan := Start(ctx, "Client.CacheCreate")
+	RecordLink(ctx, apiSpan)
+	apiSpan.End()
+
+	_, blobSpan := StartLinked(ctx, "S3Blob.Upload")
+	blobSpan.End()
+
+	_, plainSpan := StartLinked(context.Background(), "S3Blob.Download")
+	plainSpan.End()
+
+	spans := recorder.Ended()
+	assert.Len(spans, 3)
+
+	wantAttrs := []attribute.KeyValue{
+		attribute.String("cache.id", "node_modules"),
+		attribute.String("cache.key", "v1-node-abc123"),
+		attribute.String("cache.registry", "my-registry"),
+	}
+
+	assert.ElementsMatch(wantAttrs, spans[0].Attributes())
+	assert.Empty(spans[0].Links())
+
+	assert.ElementsMatch(wantAttrs, spans[1].Attributes())
+	assert.Len(spans[1].Links(), 1)
+	assert.Equal(spans[0].SpanContext(), spans[1].Links()[0].SpanContext)
+
+	assert.Empty(spans[2].Attributes())
+	assert.Empty(spans[2].Links())
+}
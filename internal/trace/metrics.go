@@ -0,0 +1,198 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+var meterName = "github.com/buildkite/zstash"
+
+const (
+	// MetricsFileEnv is the environment variable used to configure the path
+	// metrics are written to by the "file" exporter.
+	MetricsFileEnv = "BUILDKITE_ZSTASH_METRICS_FILE"
+
+	defaultMetricsFile = "zstash-metrics.json"
+)
+
+// Transfer directions recorded by RecordTransfer.
+const (
+	TransferUpload   = "upload"
+	TransferDownload = "download"
+)
+
+// NewMeterProvider creates and registers a global meter provider using the
+// named exporter, configured the same way as the exporters of NewProvider:
+//
+//   - "grpc": OTLP over gRPC, configured using the standard OTEL_EXPORTER_OTLP_* env vars
+//   - "http": OTLP over HTTP/protobuf, configured using the standard OTEL_EXPORTER_OTLP_* env vars
+//   - "file": metrics written as JSON to the file named by BUILDKITE_ZSTASH_METRICS_FILE
+//     (defaults to zstash-metrics.json in the current directory)
+//
+// Any other value, including "noop", discards metrics. Metrics are exported
+// periodically and when the provider is shut down.
+func NewMeterProvider(ctx context.Context, exporter, name, version string) (*sdkmetric.MeterProvider, error) {
+	res, err := newResource(ctx, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	options := []sdkmetric.Option{sdkmetric.WithResource(res)}
+
+	var exp sdkmetric.Exporter
+	switch exporter {
+	case "grpc":
+		exp, err = otlpmetricgrpc.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exporter: %w", err)
+		}
+	case "http":
+		exp, err = otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exporter: %w", err)
+		}
+	case "file":
+		exp, err = newMetricsFileExporter(metricsFilePath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exporter: %w", err)
+		}
+	}
+
+	// without an exporter metrics are aggregated but never read
+	if exp != nil {
+		options = append(options, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)))
+	}
+
+	mp := sdkmetric.NewMeterProvider(options...)
+
+	otel.SetMeterProvider(mp)
+
+	meterName = name
+
+	return mp, nil
+}
+
+// metricsFilePath returns the path metrics are written to by the file exporter.
+func metricsFilePath() string {
+	if path := os.Getenv(MetricsFileEnv); path != "" {
+		return path
+	}
+
+	return defaultMetricsFile
+}
+
+// metricsFileExporter writes metrics as JSON to a file which is closed when
+// the exporter is shut down.
+type metricsFileExporter struct {
+	sdkmetric.Exporter
+	file *os.File
+}
+
+func newMetricsFileExporter(path string) (*metricsFileExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644) // #nosec G302 G304 -- path is configured by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metrics file: %w", err)
+	}
+
+	exp, err := stdoutmetric.New(stdoutmetric.WithWriter(file))
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &metricsFileExporter{Exporter: exp, file: file}, nil
+}
+
+func (e *metricsFileExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.file.Close())
+}
+
+// RecordCacheHit counts a restore which found an entry in the "cache.hit"
+// counter, noting whether it matched a fallback key.
+func RecordCacheHit(ctx context.Context, fallback bool) {
+	counter, err := meter().Int64Counter("cache.hit",
+		metric.WithDescription("Restores which found a cache entry"),
+	)
+	if err != nil {
+		return
+	}
+
+	counter.Add(ctx, 1, metric.WithAttributes(append(metricAttributes(ctx), attribute.Bool("cache.fallback", fallback))...))
+}
+
+// RecordCacheMiss counts a restore which didn't find an entry in the
+// "cache.miss" counter.
+func RecordCacheMiss(ctx context.Context) {
+	counter, err := meter().Int64Counter("cache.miss",
+		metric.WithDescription("Restores which didn't find a cache entry"),
+	)
+	if err != nil {
+		return
+	}
+
+	counter.Add(ctx, 1, metric.WithAttributes(metricAttributes(ctx)...))
+}
+
+// RecordArchiveBytes records the size of an archive built to save a cache in
+// the "archive.bytes" histogram.
+func RecordArchiveBytes(ctx context.Context, size int64) {
+	histogram, err := meter().Int64Histogram("archive.bytes",
+		metric.WithDescription("Size of the archives built to save caches"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return
+	}
+
+	histogram.Record(ctx, size, metric.WithAttributes(metricAttributes(ctx)...))
+}
+
+// RecordTransfer records the bytes and duration of an archive upload or
+// download in the "transfer.bytes" and "transfer.duration" histograms, where
+// direction is TransferUpload or TransferDownload.
+func RecordTransfer(ctx context.Context, direction string, bytes int64, duration time.Duration) {
+	attrs := metric.WithAttributes(append(metricAttributes(ctx), attribute.String("transfer.direction", direction))...)
+
+	if histogram, err := meter().Int64Histogram("transfer.bytes",
+		metric.WithDescription("Bytes transferred uploading or downloading cache archives"),
+		metric.WithUnit("By"),
+	); err == nil {
+		histogram.Record(ctx, bytes, attrs)
+	}
+
+	if histogram, err := meter().Float64Histogram("transfer.duration",
+		metric.WithDescription("Duration of cache archive uploads and downloads"),
+		metric.WithUnit("s"),
+	); err == nil {
+		histogram.Record(ctx, duration.Seconds(), attrs)
+	}
+}
+
+func meter() metric.Meter {
+	return otel.GetMeterProvider().Meter(meterName)
+}
+
+// metricAttributes returns the cache attributes carried by ctx which are
+// added to metrics, leaving out the key as every key would be a new series.
+func metricAttributes(ctx context.Context) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+
+	for _, attr := range cacheAttributes(ctx) {
+		if attr.Key != cacheBaggagePrefix+"key" {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRecordMetrics(t *testing.T) {
+	assert := require.New(t)
+
+	reader := sdkmetric.NewManualReader()
+	previous := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	ctx := WithCache(context.Background(), "node_modules", "v1-node-abc123", "my-registry")
+
+	RecordCacheHit(ctx, false)
+	RecordCacheHit(ctx, true)
+	RecordCacheMiss(ctx)
+	RecordArchiveBytes(ctx, 1024)
+	RecordTransfer(ctx, TransferUpload, 1024, 2*time.Second)
+
+	var rm metricdata.ResourceMetrics
+	assert.NoError(reader.Collect(context.Background(), &rm))
+	assert.Len(rm.ScopeMetrics, 1)
+
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+
+	cacheAttrs := []attribute.KeyValue{
+		attribute.String("cache.id", "node_modules"),
+		attribute.String("cache.registry", "my-registry"),
+	}
+
+	hits := metrics["cache.hit"].(metricdata.Sum[int64])
+	assert.Len(hits.DataPoints, 2)
+	for _, point := range hits.DataPoints {
+		assert.Equal(int64(1), point.Value)
+		_, ok := point.Attributes.Value("cache.fallback")
+		assert.True(ok)
+	}
+
+	misses := metrics["cache.miss"].(metricdata.Sum[int64])
+	assert.Len(misses.DataPoints, 1)
+	assert.ElementsMatch(cacheAttrs, misses.DataPoints[0].Attributes.ToSlice(), "the key shouldn't be a metric attribute")
+
+	archiveBytes := metrics["archive.bytes"].(metricdata.Histogram[int64])
+	assert.Len(archiveBytes.DataPoints, 1)
+	assert.Equal(int64(1024), archiveBytes.DataPoints[0].Sum)
+
+	transferBytes := metrics["transfer.bytes"].(metricdata.Histogram[int64])
+	assert.Len(transferBytes.DataPoints, 1)
+	assert.Equal(int64(1024), transferBytes.DataPoints[0].Sum)
+	direction, _ := transferBytes.DataPoints[0].Attributes.Value("transfer.direction")
+	assert.Equal(TransferUpload, direction.AsString())
+
+	transferDuration := metrics["transfer.duration"].(metricdata.Histogram[float64])
+	assert.Len(transferDuration.DataPoints, 1)
+	assert.Equal(2.0, transferDuration.DataPoints[0].Sum)
+}
+
+func TestNewMeterProvider_FileExporter(t *testing.T) {
+	assert := require.New(t)
+
+	previous := otel.GetMeterProvider()
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	metricsFile := filepath.Join(t.TempDir(), "metrics.json")
+	t.Setenv(MetricsFileEnv, metricsFile)
+
+	mp, err := NewMeterProvider(context.Background(), "file", "test", "0.0.1")
+	assert.NoError(err)
+
+	RecordCacheMiss(context.Background())
+
+	assert.NoError(mp.Shutdown(context.Background()))
+
+	data, err := os.ReadFile(metricsFile)
+	assert.NoError(err)
+	assert.Contains(string(data), `"Name":"cache.miss"`)
+}
@@ -2,11 +2,15 @@ package trace
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
@@ -17,6 +21,22 @@ import (
 
 var tracerName = "github.com/buildkite/zstash"
 
+const (
+	// TraceFileEnv is the environment variable used to configure the path
+	// spans are written to by the "file" exporter.
+	TraceFileEnv = "BUILDKITE_ZSTASH_TRACE_FILE"
+
+	defaultTraceFile = "zstash-traces.json"
+)
+
+// NewProvider creates and registers a global tracer provider using the named exporter:
+//
+//   - "grpc": OTLP over gRPC, configured using the standard OTEL_EXPORTER_OTLP_* env vars
+//   - "http": OTLP over HTTP/protobuf, configured using the standard OTEL_EXPORTER_OTLP_* env vars
+//   - "file": spans written as JSON to the file named by BUILDKITE_ZSTASH_TRACE_FILE
+//     (defaults to zstash-traces.json in the current directory)
+//
+// Any other value, including "noop", discards spans.
 func NewProvider(ctx context.Context, exporter, name, version string) (*sdktrace.TracerProvider, error) {
 	res, err := newResource(ctx, name, version)
 	if err != nil {
@@ -30,6 +50,18 @@ func NewProvider(ctx context.Context, exporter, name, version string) (*sdktrace
 		if err != nil {
 			return nil, fmt.Errorf("failed to create exporter: %w", err)
 		}
+	case "http":
+		// endpoint, headers and TLS are configured using the standard OTEL_EXPORTER_OTLP_* env vars,
+		// proxies are configured using HTTPS_PROXY
+		exp, err = otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exporter: %w", err)
+		}
+	case "file":
+		exp, err = newFileExporter(traceFilePath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exporter: %w", err)
+		}
 	default:
 		// a null exporter is used for testing
 		exp = tracetest.NewNoopExporter()
@@ -54,8 +86,49 @@ func NewProvider(ctx context.Context, exporter, name, version string) (*sdktrace
 	return tp, nil
 }
 
-func Start(ctx context.Context, name string) (context.Context, trace.Span) {
-	return otel.GetTracerProvider().Tracer(tracerName).Start(ctx, name)
+// traceFilePath returns the path spans are written to by the file exporter.
+func traceFilePath() string {
+	if path := os.Getenv(TraceFileEnv); path != "" {
+		return path
+	}
+
+	return defaultTraceFile
+}
+
+// fileExporter writes spans as JSON, one span per line, to a file which is
+// closed when the exporter is shut down.
+type fileExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644) // #nosec G302 G304 -- path is configured by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &fileExporter{Exporter: exp, file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.file.Close())
+}
+
+// Start starts a span, adding the cache attributes carried by ctx, see
+// WithCache.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if attrs := cacheAttributes(ctx); len(attrs) > 0 {
+		opts = append(opts, trace.WithAttributes(attrs...))
+	}
+
+	return otel.GetTracerProvider().Tracer(tracerName).Start(ctx, name, opts...)
 }
 
 func newResource(cxt context.Context, name, version string) (*resource.Resource, error) {
@@ -0,0 +1,29 @@
+package trace
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProvider_FileExporter(t *testing.T) {
+	assert := require.New(t)
+
+	traceFile := filepath.Join(t.TempDir(), "traces.json")
+	t.Setenv(TraceFileEnv, traceFile)
+
+	tp, err := NewProvider(context.Background(), "file", "test", "0.0.1")
+	assert.NoError(err)
+
+	_, span := Start(context.Background(), "TestSpan")
+	span.End()
+
+	assert.NoError(tp.Shutdown(context.Background()))
+
+	data, err := os.ReadFile(traceFile)
+	assert.NoError(err)
+	assert.Contains(string(data), `"Name":"TestSpan"`)
+}
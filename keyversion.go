@@ -0,0 +1,78 @@
+package zstash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+)
+
+// keyVersionLength is the number of hex characters of the definition hash
+// appended to keys by Config.VersionKeys.
+const keyVersionLength = 8
+
+// definitionHash returns a hash of the parts of a cache's definition which
+// determine the contents of its archives: its paths, the working directory's
+// ignore file, the archive format and the archive and ignore options.
+func definitionHash(c cache.Cache, format string, ignore []byte) string {
+	if format == "" {
+		format = "zip"
+	}
+
+	// path order doesn't change the archived files
+	paths := slices.Clone(c.Paths)
+	slices.Sort(paths)
+
+	hash := sha256.New()
+	for _, path := range paths {
+		_, _ = fmt.Fprintf(hash, "path\x00%s\n", path)
+	}
+	_, _ = fmt.Fprintf(hash, "ignore\x00%s\n", strconv.Quote(string(ignore)))
+	_, _ = fmt.Fprintf(hash, "format\x00%s\n", format)
+	_, _ = fmt.Fprintf(hash, "preserve_mtimes\x00%t\n", c.PreserveMtimes)
+	_, _ = fmt.Fprintf(hash, "precompressed\x00%t\n", c.Precompressed)
+	// only hashed when configured, so keys of caches which don't use them
+	// are unchanged
+	for _, pattern := range c.Ignore {
+		_, _ = fmt.Fprintf(hash, "ignore_pattern\x00%s\n", strconv.Quote(pattern))
+	}
+	if c.NoDefaultIgnore {
+		_, _ = fmt.Fprintf(hash, "no_default_ignore\x00%t\n", c.NoDefaultIgnore)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:keyVersionLength]
+}
+
+// versionKeys appends the definition hash of each cache to its key and
+// fallback keys, so entries saved with a different definition aren't
+// restored.
+func versionKeys(caches []cache.Cache, format string) ([]cache.Cache, error) {
+	ignore, err := os.ReadFile(archive.IgnoreFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", archive.IgnoreFile, err)
+	}
+
+	versioned := make([]cache.Cache, 0, len(caches))
+	for _, c := range caches {
+		version := definitionHash(c, format, ignore)
+
+		c.Key = c.Key + "-" + version
+
+		fallbackKeys := make([]string, 0, len(c.FallbackKeys))
+		for _, fallbackKey := range c.FallbackKeys {
+			fallbackKeys = append(fallbackKeys, fallbackKey+"-"+version)
+		}
+		c.FallbackKeys = fallbackKeys
+
+		versioned = append(versioned, c)
+	}
+
+	return versioned, nil
+}
@@ -0,0 +1,83 @@
+package zstash
+
+import (
+	"os"
+	"testing"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefinitionHash(t *testing.T) {
+	base := cache.Cache{ID: "node", Key: "v1-node", Paths: []string{"node_modules", ".npm"}}
+	baseHash := definitionHash(base, "zip", nil)
+
+	tests := []struct {
+		name     string
+		cache    cache.Cache
+		format   string
+		ignore   []byte
+		wantSame bool
+	}{
+		{name: "same definition", cache: base, format: "zip", wantSame: true},
+		{name: "default format", cache: base, format: "", wantSame: true},
+		{name: "key is ignored", cache: cache.Cache{ID: "node", Key: "v2-node", Paths: []string{"node_modules", ".npm"}}, format: "zip", wantSame: true},
+		{name: "path order is ignored", cache: cache.Cache{ID: "node", Key: "v1-node", Paths: []string{".npm", "node_modules"}}, format: "zip", wantSame: true},
+		{name: "paths changed", cache: cache.Cache{ID: "node", Key: "v1-node", Paths: []string{"node_modules"}}, format: "zip"},
+		{name: "ignore file changed", cache: base, format: "zip", ignore: []byte("*.log\n")},
+		{name: "format changed", cache: base, format: "tar"},
+		{name: "preserve mtimes", cache: cache.Cache{ID: "node", Key: "v1-node", Paths: []string{"node_modules", ".npm"}, PreserveMtimes: true}, format: "zip"},
+		{name: "precompressed", cache: cache.Cache{ID: "node", Key: "v1-node", Paths: []string{"node_modules", ".npm"}, Precompressed: true}, format: "zip"},
+		{name: "ignore patterns", cache: cache.Cache{ID: "node", Key: "v1-node", Paths: []string{"node_modules", ".npm"}, Ignore: []string{"*.tmp"}}, format: "zip"},
+		{name: "default ignore disabled", cache: cache.Cache{ID: "node", Key: "v1-node", Paths: []string{"node_modules", ".npm"}, NoDefaultIgnore: true}, format: "zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			got := definitionHash(tt.cache, tt.format, tt.ignore)
+			assert.Len(got, keyVersionLength)
+			if tt.wantSame {
+				assert.Equal(baseHash, got)
+			} else {
+				assert.NotEqual(baseHash, got)
+			}
+		})
+	}
+}
+
+func TestVersionKeys(t *testing.T) {
+	assert := require.New(t)
+
+	t.Chdir(t.TempDir())
+
+	caches := []cache.Cache{
+		{ID: "node", Key: "v1-node-abc", FallbackKeys: []string{"v1-node-main"}, Paths: []string{"node_modules"}},
+		{ID: "go", Key: "v1-go-def", Paths: []string{"~/go/pkg/mod"}},
+	}
+
+	versioned, err := versionKeys(caches, "zip")
+	assert.NoError(err)
+
+	nodeVersion := definitionHash(caches[0], "zip", nil)
+	goVersion := definitionHash(caches[1], "zip", nil)
+
+	assert.Equal("v1-node-abc-"+nodeVersion, versioned[0].Key)
+	assert.Equal([]string{"v1-node-main-" + nodeVersion}, versioned[0].FallbackKeys)
+	assert.Equal("v1-go-def-"+goVersion, versioned[1].Key)
+	assert.Empty(versioned[1].FallbackKeys)
+
+	// the original caches aren't modified
+	assert.Equal("v1-node-abc", caches[0].Key)
+	assert.Equal([]string{"v1-node-main"}, caches[0].FallbackKeys)
+
+	// the working directory's ignore file changes the version
+	assert.NoError(os.WriteFile(archive.IgnoreFile, []byte("*.log\n"), 0o600))
+
+	versioned, err = versionKeys(caches, "zip")
+	assert.NoError(err)
+	assert.Equal("v1-node-abc-"+definitionHash(caches[0], "zip", []byte("*.log\n")), versioned[0].Key)
+	assert.NotEqual("v1-node-abc-"+nodeVersion, versioned[0].Key)
+}
@@ -0,0 +1,196 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
+	"github.com/buildkite/zstash/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ListResult contains the entries of a cache archive listed by List.
+type ListResult struct {
+	// Exists indicates whether the cache entry was found. The remaining
+	// fields other than Key are only populated when it exists.
+	Exists bool
+
+	// Key is the cache key that was listed.
+	Key string
+
+	// Entries lists the names of the files and directories in the archive.
+	Entries []string
+
+	// Ranged indicates the entries were read from the archive's zip central
+	// directory using ranged reads, rather than by downloading the archive.
+	Ranged bool
+
+	// BytesDownloaded is the number of bytes read from the blob store.
+	BytesDownloaded int64
+
+	// TotalDuration is the end-to-end duration of the listing.
+	TotalDuration time.Duration
+}
+
+// List returns the entries of the archive for a cache's key without
+// restoring it, for quick checks of the contents of large caches.
+//
+// When the blob store supports ranged reads (see store.RangeBlob), only the
+// zip central directory at the end of the archive is read. Otherwise, or if
+// the archive can't be read this way, e.g. as it was saved with chunked
+// storage, the archive is downloaded to a temporary file and listed.
+//
+// Only the exact key is listed, fallback keys are not considered. A missing
+// cache entry is not an error.
+//
+// Example:
+//
+//	result, err := cacheClient.List(ctx, "node_modules")
+//	if err != nil {
+//	    log.Fatalf("Cache list failed: %v", err)
+//	}
+//	for _, entry := range result.Entries {
+//	    fmt.Println(entry)
+//	}
+func (c *Cache) List(ctx context.Context, cacheID string) (ListResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.List")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	span.SetAttributes(attribute.String("cache.id", cacheID))
+
+	startTime := time.Now()
+	result := ListResult{}
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return result, err
+	}
+
+	result.Key = cacheConfig.Key
+
+	span.SetAttributes(attribute.String("cache.key", result.Key))
+
+	ctx = trace.WithCache(ctx, cacheID, result.Key, c.registry)
+
+	c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache exists", 0, 0)
+
+	retrieveResp, exists, err := c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
+		Key:    result.Key,
+		Branch: c.scopeFor(cacheConfig).branch,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to retrieve cache")
+		return result, fmt.Errorf("failed to retrieve cache: %w", err)
+	}
+
+	if !exists {
+		result.TotalDuration = time.Since(startTime)
+		span.SetStatus(codes.Ok, "cache not found")
+		c.callProgress(ctx, cacheID, "complete", "Cache not found", 0, 0)
+		return result, nil
+	}
+
+	result.Exists = true
+
+	c.callProgress(ctx, cacheID, "listing", "Listing cache archive", 0, 0)
+
+	if c.listRanged(ctx, cacheID, retrieveResp, &result) {
+		result.Ranged = true
+	} else {
+		c.callProgress(ctx, cacheID, "downloading", "Downloading cache archive", 0, 0)
+
+		if err := c.listDownloaded(ctx, cacheID, retrieveResp, &result); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to list cache")
+			return result, err
+		}
+	}
+
+	result.TotalDuration = time.Since(startTime)
+
+	span.SetAttributes(
+		attribute.Int("cache.entries", len(result.Entries)),
+		attribute.Bool("cache.ranged", result.Ranged),
+		attribute.Int64("cache.transfer_bytes", result.BytesDownloaded),
+	)
+	span.SetStatus(codes.Ok, "cache listed")
+	c.callProgress(ctx, cacheID, "complete", "Cache listed", 0, 0)
+
+	return result, nil
+}
+
+// listRanged lists the archive using ranged reads of its zip central
+// directory, returning false if the store or archive doesn't support it.
+func (c *Cache) listRanged(ctx context.Context, cacheID string, retrieveResp api.CacheRetrieveResp, result *ListResult) bool {
+	blobStore, err := c.retrieveBlobStore(ctx, retrieveResp)
+	if err != nil {
+		logging.FromContext(ctx).Debug("failed to create blob store, downloading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+
+	rangeStore, ok := blobStore.(store.RangeBlob)
+	if !ok {
+		return false
+	}
+
+	reader, err := store.NewRangeReader(store.WithTransferLimiter(ctx, c.transferLimiter), rangeStore, retrieveResp.StoreObjectName)
+	if err != nil {
+		logging.FromContext(ctx).Debug("failed to read archive ranges, downloading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+
+	entries, err := archive.ListArchive(ctx, reader, reader.Size())
+	result.BytesDownloaded += reader.BytesRead()
+	if err != nil {
+		logging.FromContext(ctx).Debug("failed to list archive ranges, downloading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+
+	result.Entries = entries
+
+	return true
+}
+
+// listDownloaded downloads the archive to a temporary file and lists it.
+func (c *Cache) listDownloaded(ctx context.Context, cacheID string, retrieveResp api.CacheRetrieveResp, result *ListResult) error {
+	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	result.BytesDownloaded += transferInfo.BytesTransferred
+
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat archive file: %w", err)
+	}
+
+	result.Entries, err = archive.ListArchive(ctx, f, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// maxManifestMismatchesReported is the number of files listed in the error
+// when restored files don't match the manifest.
+const maxManifestMismatchesReported = 5
+
+// validateManifest checks the restored files at paths against the manifest
+// recorded in the archive, ignoring files skipped during extraction. Archives
+// without a manifest are logged and not validated, returning false.
+func (c *Cache) validateManifest(ctx context.Context, cacheID, archiveFile string, size int64, paths, skipped []string) (bool, error) {
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+
+	manifest, err := archive.ReadManifest(ctx, f, size)
+	if errors.Is(err, archive.ErrNoManifest) {
+		logging.FromContext(ctx).Warn("cache archive has no manifest, restored files were not validated", "cache_id", cacheID)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	diffs, err := archive.CompareManifest(ctx, manifest, paths)
+	if err != nil {
+		return false, fmt.Errorf("failed to compare manifest: %w", err)
+	}
+
+	var mismatched []string
+	for _, diff := range diffs {
+		// files which weren't in the archive or weren't extracted aren't validated
+		if diff.Kind == archive.DiffAdded || slices.Contains(skipped, diff.Path) {
+			continue
+		}
+		mismatched = append(mismatched, fmt.Sprintf("%s (%s)", diff.Path, diff.Kind))
+	}
+
+	if len(mismatched) > 0 {
+		reported := mismatched[:min(len(mismatched), maxManifestMismatchesReported)]
+		return true, fmt.Errorf("%w: %d files differ, including %s", ErrManifestMismatch, len(mismatched), strings.Join(reported, ", "))
+	}
+
+	return true, nil
+}
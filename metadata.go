@@ -0,0 +1,117 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// EntryMetadata describes where and when a cache entry was saved, so a bad
+// cache can be traced back to the job which created it.
+type EntryMetadata struct {
+	// CreatedAt indicates when the cache entry was created.
+	CreatedAt time.Time
+
+	// Platform is the OS/arch string the cache entry was saved on (e.g., "linux/amd64").
+	Platform string
+
+	// Organization, Pipeline and Branch identify where the cache entry was saved.
+	Organization string
+	Pipeline     string
+	Branch       string
+
+	// BuildID, JobID and AgentID identify the build, job and agent which
+	// saved the cache entry.
+	BuildID string
+	JobID   string
+	AgentID string
+}
+
+// String renders the metadata with a "name: value" line for each field which
+// is set, for verbose output:
+//
+//	created_at: 2025-06-01T12:30:45Z
+//	platform: linux/amd64
+//	pipeline: my-org/my-pipeline
+//	branch: main
+//	build_id: 0190...
+func (m EntryMetadata) String() string {
+	var b strings.Builder
+
+	field := func(name, value string) {
+		if value != "" {
+			_, _ = fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+
+	if !m.CreatedAt.IsZero() {
+		field("created_at", m.CreatedAt.Format(time.RFC3339))
+	}
+	field("platform", m.Platform)
+
+	pipeline := m.Pipeline
+	if m.Organization != "" && pipeline != "" {
+		pipeline = m.Organization + "/" + pipeline
+	}
+	field("pipeline", pipeline)
+	field("branch", m.Branch)
+	field("build_id", m.BuildID)
+	field("job_id", m.JobID)
+	field("agent_id", m.AgentID)
+
+	return b.String()
+}
+
+// entryMetadata peeks the restored cache entry for its metadata. The restore
+// doesn't depend on it, so failures are logged and empty metadata returned.
+func (c *Cache) entryMetadata(ctx context.Context, cacheID, key, branch string) EntryMetadata {
+	peekResp, exists, err := c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+		Key:    key,
+		Branch: branch,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to fetch cache entry metadata", "cache_id", cacheID, "key", key, "error", err)
+		return EntryMetadata{}
+	}
+	if !exists {
+		return EntryMetadata{}
+	}
+
+	return entryMetadataFrom(peekResp)
+}
+
+// entryMetadataFrom returns the metadata of a cache entry found by peeking it.
+func entryMetadataFrom(peekResp api.CachePeekResp) EntryMetadata {
+	return EntryMetadata{
+		CreatedAt:    peekResp.CreatedAt,
+		Platform:     peekResp.Platform,
+		Organization: peekResp.Owner,
+		Pipeline:     peekResp.Pipeline,
+		Branch:       peekResp.Branch,
+		BuildID:      peekResp.BuildID,
+		JobID:        peekResp.JobID,
+		AgentID:      peekResp.AgentID,
+	}
+}
+
+// platformMismatch reports whether an entry was saved on a platform other than
+// the client's. Entries without a recorded platform, such as when the metadata
+// couldn't be fetched, are assumed to match.
+func (c *Cache) platformMismatch(ctx context.Context, cacheID, key, platform string) bool {
+	if platform == "" || c.platform == "" {
+		logging.FromContext(ctx).Warn("cache entry platform unknown, restoring without checking", "cache_id", cacheID, "key", key)
+		return false
+	}
+
+	if platform == c.platform {
+		return false
+	}
+
+	logging.FromContext(ctx).Warn("cache entry was saved on a different platform", "cache_id", cacheID, "key", key, "platform", platform, "want", c.platform)
+
+	return true
+}
@@ -0,0 +1,27 @@
+package zstash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryMetadata_String(t *testing.T) {
+	metadata := EntryMetadata{
+		CreatedAt:    time.Date(2025, 6, 1, 12, 30, 45, 0, time.UTC),
+		Platform:     "linux/amd64",
+		Organization: "my-org",
+		Pipeline:     "my-pipeline",
+		Branch:       "main",
+		JobID:        "job-123",
+	}
+
+	assert.Equal(t, `created_at: 2025-06-01T12:30:45Z
+platform: linux/amd64
+pipeline: my-org/my-pipeline
+branch: main
+job_id: job-123
+`, metadata.String())
+	assert.Empty(t, EntryMetadata{}.String())
+}
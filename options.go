@@ -0,0 +1,99 @@
+package zstash
+
+import (
+	"time"
+
+	"github.com/buildkite/zstash/archive"
+)
+
+// SaveOption sets a SaveOptions field for a single call to Save.
+type SaveOption func(*SaveOptions)
+
+// RestoreOption sets a RestoreOptions field for a single call to Restore.
+type RestoreOption func(*RestoreOptions)
+
+// WithForce overwrites any existing cache entry for the key, see
+// SaveOptions.Force.
+func WithForce() SaveOption {
+	return func(o *SaveOptions) {
+		o.Force = true
+	}
+}
+
+// WithDryRun checks whether the cache would be saved without archiving or
+// uploading it, see SaveOptions.DryRun. WithRestoreDryRun is the equivalent
+// for Restore.
+func WithDryRun() SaveOption {
+	return func(o *SaveOptions) {
+		o.DryRun = true
+	}
+}
+
+// WithoutFallback only restores the exact key, see
+// RestoreOptions.DisableFallback.
+func WithoutFallback() RestoreOption {
+	return func(o *RestoreOptions) {
+		o.DisableFallback = true
+	}
+}
+
+// WithTargetDir restores files into dir rather than the cache paths, as a
+// staged restore, see RestoreModeStaged.
+func WithTargetDir(dir string) RestoreOption {
+	return func(o *RestoreOptions) {
+		o.Mode = RestoreModeStaged
+		o.StagingDir = dir
+	}
+}
+
+// WithOnConflict sets the policy for files which already exist, see
+// RestoreOptions.OnConflict.
+func WithOnConflict(policy archive.ConflictPolicy) RestoreOption {
+	return func(o *RestoreOptions) {
+		o.OnConflict = policy
+	}
+}
+
+// WithFallbackStrategy sets how a fallback key is chosen on a miss, see
+// RestoreOptions.FallbackStrategy.
+func WithFallbackStrategy(strategy FallbackStrategy) RestoreOption {
+	return func(o *RestoreOptions) {
+		o.FallbackStrategy = strategy
+	}
+}
+
+// WithWaitForPending waits up to timeout for an entry another job is saving
+// under the key, see RestoreOptions.WaitForPending.
+func WithWaitForPending(timeout time.Duration) RestoreOption {
+	return func(o *RestoreOptions) {
+		o.WaitForPending = timeout
+	}
+}
+
+// WithRestoreDryRun looks up the entry which would be restored without
+// downloading it, see RestoreOptions.DryRun.
+func WithRestoreDryRun() RestoreOption {
+	return func(o *RestoreOptions) {
+		o.DryRun = true
+	}
+}
+
+// newSaveOptions applies the options to the default SaveOptions.
+func newSaveOptions(opts []SaveOption) SaveOptions {
+	var options SaveOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+// newRestoreOptions applies the options to the default RestoreOptions.
+func newRestoreOptions(opts []RestoreOption) RestoreOptions {
+	var options RestoreOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
@@ -0,0 +1,37 @@
+package zstash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveOptions(t *testing.T) {
+	assert.Equal(t, SaveOptions{}, newSaveOptions(nil))
+	assert.Equal(t, SaveOptions{Force: true, DryRun: true}, newSaveOptions([]SaveOption{WithForce(), WithDryRun()}))
+}
+
+func TestRestoreOptions(t *testing.T) {
+	assert.Equal(t, RestoreOptions{}, newRestoreOptions(nil))
+
+	got := newRestoreOptions([]RestoreOption{
+		WithoutFallback(),
+		WithTargetDir("/tmp/staging"),
+		WithOnConflict(archive.ConflictSkip),
+		WithFallbackStrategy(FallbackNewest),
+		WithWaitForPending(time.Minute),
+		WithRestoreDryRun(),
+	})
+
+	assert.Equal(t, RestoreOptions{
+		DisableFallback:  true,
+		Mode:             RestoreModeStaged,
+		StagingDir:       "/tmp/staging",
+		OnConflict:       archive.ConflictSkip,
+		FallbackStrategy: FallbackNewest,
+		WaitForPending:   time.Minute,
+		DryRun:           true,
+	}, got)
+}
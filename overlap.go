@@ -0,0 +1,96 @@
+package zstash
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+)
+
+// PathOverlap describes a path which is archived by more than one cache, as
+// the paths are equal or one contains the other.
+type PathOverlap struct {
+	// CacheID and Path identify the first cache and its configured path.
+	CacheID string
+	Path    string
+	// OtherCacheID and OtherPath identify the overlapping cache and its configured path.
+	OtherCacheID string
+	OtherPath    string
+}
+
+// PathOverlaps returns the paths which are archived by more than one of the
+// client's caches, detected during NewCache. Saving each of these caches
+// archives and uploads the overlapping files again, unless paths configured
+// identically are shared with SaveAllOptions.SharePaths.
+func (c *Cache) PathOverlaps() []PathOverlap {
+	return c.overlaps
+}
+
+// findPathOverlaps compares the paths of every pair of caches, returning the
+// pairs which are equal or nested once resolved to absolute paths.
+func findPathOverlaps(caches []cache.Cache) []PathOverlap {
+	type resolvedPath struct {
+		cacheID  string
+		path     string
+		resolved string
+	}
+
+	var resolved []resolvedPath
+	for _, cacheItem := range caches {
+		for _, path := range cacheItem.Paths {
+			abs, err := resolveOverlapPath(path)
+			if err != nil {
+				// invalid paths are reported when saving
+				continue
+			}
+			resolved = append(resolved, resolvedPath{cacheID: cacheItem.ID, path: path, resolved: abs})
+		}
+	}
+
+	var overlaps []PathOverlap
+	for i, a := range resolved {
+		for _, b := range resolved[i+1:] {
+			if a.cacheID == b.cacheID || !pathsOverlap(a.resolved, b.resolved) {
+				continue
+			}
+			overlaps = append(overlaps, PathOverlap{
+				CacheID:      a.cacheID,
+				Path:         a.path,
+				OtherCacheID: b.cacheID,
+				OtherPath:    b.path,
+			})
+		}
+	}
+
+	return overlaps
+}
+
+// resolveOverlapPath resolves a configured cache path to a clean absolute path.
+func resolveOverlapPath(path string) (string, error) {
+	resolved, err := archive.ResolveHomeDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(resolved)
+}
+
+// pathsOverlap reports whether the absolute paths are equal or one contains the other.
+func pathsOverlap(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	return isSubPath(a, b) || isSubPath(b, a)
+}
+
+// isSubPath reports whether path is inside dir.
+func isSubPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,63 @@
+package zstash
+
+import (
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindPathOverlaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		caches []cache.Cache
+		want   []PathOverlap
+	}{
+		{
+			name: "no overlap",
+			caches: []cache.Cache{
+				{ID: "node", Paths: []string{"node_modules"}},
+				{ID: "go", Paths: []string{"vendor", "~/go/pkg/mod"}},
+			},
+		},
+		{
+			name: "same path",
+			caches: []cache.Cache{
+				{ID: "node", Paths: []string{"node_modules"}},
+				{ID: "deps", Paths: []string{"./node_modules"}},
+			},
+			want: []PathOverlap{
+				{CacheID: "node", Path: "node_modules", OtherCacheID: "deps", OtherPath: "./node_modules"},
+			},
+		},
+		{
+			name: "nested path",
+			caches: []cache.Cache{
+				{ID: "gomod", Paths: []string{"~/go/pkg/mod"}},
+				{ID: "gopath", Paths: []string{"~/go"}},
+			},
+			want: []PathOverlap{
+				{CacheID: "gomod", Path: "~/go/pkg/mod", OtherCacheID: "gopath", OtherPath: "~/go"},
+			},
+		},
+		{
+			name: "sibling with common prefix",
+			caches: []cache.Cache{
+				{ID: "build", Paths: []string{"build"}},
+				{ID: "buildcache", Paths: []string{"build-cache"}},
+			},
+		},
+		{
+			name: "paths within the same cache are ignored",
+			caches: []cache.Cache{
+				{ID: "go", Paths: []string{"~/go", "~/go/pkg/mod"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, findPathOverlaps(tt.caches))
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/store"
+)
+
+// restorePartial extracts RestoreOptions.Files from the archive of a
+// retrieved cache entry, with RestoreModePartial. Zip archives in stores
+// which support ranged reads are extracted without downloading them, reading
+// only the central directory and the entries extracted. Other archives are
+// downloaded and extracted as usual. The cache paths aren't cleaned, so
+// OnConflict applies to each restored file.
+func (c *Cache) restorePartial(ctx context.Context, cacheID string, retrieveResp api.CacheRetrieveResp, cacheConfig *cache.Cache, restorePaths []string, opts RestoreOptions, onConflict archive.ConflictPolicy, result *RestoreResult) error {
+	extractOpts := archive.ExtractOptions{
+		OnConflict:  onConflict,
+		Include:     opts.Paths,
+		Files:       opts.Files,
+		Chown:       opts.Chown,
+		Permissions: extractPermissionsFor(cacheConfig),
+	}
+
+	c.callProgress(ctx, cacheID, "downloading", "Reading cache archive", 0, 0)
+	c.emit(ctx, DownloadStarted{EventInfo: newEventInfo(cacheID), Key: result.Key, Fallback: result.FallbackUsed})
+
+	archiveInfo, err := c.extractRanged(ctx, cacheID, retrieveResp, cacheConfig.Paths, extractOpts, result)
+	if err != nil {
+		return fmt.Errorf("failed to extract cache: %w", err)
+	}
+
+	if !result.Ranged {
+		archiveInfo, err = c.extractDownloaded(ctx, cacheID, retrieveResp, cacheConfig.Paths, extractOpts, opts.SkipSpaceCheck, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	result.OverwrittenFiles = archiveInfo.Overwritten
+	result.SkippedFiles = archiveInfo.Skipped
+
+	result.Archive = ArchiveMetrics{
+		Size:           archiveInfo.Size,
+		WrittenBytes:   archiveInfo.WrittenBytes,
+		WrittenEntries: archiveInfo.WrittenEntries,
+		Duration:       archiveInfo.Duration,
+		Paths:          restorePaths,
+		PathStats:      archiveInfo.PathStats,
+	}
+
+	c.emit(ctx, Extracted{EventInfo: newEventInfo(cacheID), Archive: result.Archive})
+
+	return nil
+}
+
+// extractRanged extracts the files from the archive using ranged reads,
+// setting RestoreResult.Ranged if it did. Nothing is extracted if the store
+// doesn't support ranged reads or the archive isn't a zip archive, such as an
+// entry saved with chunked storage.
+func (c *Cache) extractRanged(ctx context.Context, cacheID string, retrieveResp api.CacheRetrieveResp, paths []string, opts archive.ExtractOptions, result *RestoreResult) (*archive.ArchiveInfo, error) {
+	blobStore, err := c.retrieveBlobStore(ctx, retrieveResp)
+	if err != nil {
+		logging.FromContext(ctx).Debug("failed to create blob store, downloading archive", "cache_id", cacheID, "error", err)
+		return nil, nil
+	}
+
+	rangeStore, ok := blobStore.(store.RangeBlob)
+	if !ok {
+		return nil, nil
+	}
+
+	start := time.Now()
+
+	reader, err := store.NewRangeReader(store.WithTransferLimiter(ctx, c.transferLimiter), rangeStore, retrieveResp.StoreObjectName)
+	if err != nil {
+		logging.FromContext(ctx).Debug("failed to read archive ranges, downloading archive", "cache_id", cacheID, "error", err)
+		return nil, nil
+	}
+
+	// nothing is written until the central directory has been read, so
+	// archives which can't be read in part fall back to downloading
+	format, err := archive.DetectFormat(reader)
+	if err == nil && format == archive.FormatZip {
+		_, err = archive.ListArchive(ctx, reader, reader.Size())
+	}
+	if err != nil || format != archive.FormatZip {
+		logging.FromContext(ctx).Debug("archive can't be read in part, downloading archive", "cache_id", cacheID, "format", format, "error", err)
+		return nil, nil
+	}
+
+	c.callProgress(ctx, cacheID, "extracting", "Extracting files from cache", 0, int(reader.Size()))
+
+	archiveInfo, err := archive.ExtractReader(ctx, reader, reader.Size(), paths, opts)
+
+	duration := time.Since(start)
+	result.Ranged = true
+	result.Transfer = TransferMetrics{
+		BytesTransferred: reader.BytesRead(),
+		TransferSpeed:    float64(reader.BytesRead()) / duration.Seconds() / 1000 / 1000,
+		Duration:         duration,
+	}
+
+	c.emit(ctx, Downloaded{EventInfo: newEventInfo(cacheID), Transfer: result.Transfer})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return archiveInfo, nil
+}
+
+// extractDownloaded downloads the archive and extracts the files from it.
+func (c *Cache) extractDownloaded(ctx context.Context, cacheID string, retrieveResp api.CacheRetrieveResp, paths []string, opts archive.ExtractOptions, skipSpaceCheck bool, result *RestoreResult) (*archive.ArchiveInfo, error) {
+	// only the temp directory needs space for the whole archive
+	if !skipSpaceCheck {
+		if err := checkRestoreSpace(ctx, cacheID, int64(retrieveResp.FileSize), nil, false); err != nil {
+			return nil, err
+		}
+	}
+
+	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	result.Transfer = TransferMetrics{
+		BytesTransferred: transferInfo.BytesTransferred,
+		TransferSpeed:    transferInfo.TransferSpeed,
+		Duration:         transferInfo.Duration,
+		RequestID:        transferInfo.RequestID,
+		PartCount:        transferInfo.PartCount,
+		Concurrency:      transferInfo.Concurrency,
+		ChunkCount:       transferInfo.ChunkCount,
+	}
+
+	c.emit(ctx, Downloaded{EventInfo: newEventInfo(cacheID), Transfer: result.Transfer})
+
+	c.callProgress(ctx, cacheID, "extracting", "Extracting files from cache", 0, int(transferInfo.BytesTransferred))
+
+	archiveInfo, err := c.extractCache(ctx, archiveFile, transferInfo.BytesTransferred, paths, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract cache: %w", err)
+	}
+
+	return archiveInfo, nil
+}
@@ -0,0 +1,147 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Peek checks whether a cache entry exists for the cache's key, without
+// downloading or restoring it.
+//
+// Only the exact key is checked, fallback keys are not considered. This is a
+// cheap way for pipelines to skip work when a cache already exists. Use
+// PeekWithOptions to also check the fallback keys.
+//
+// Returns PeekResult with the entry metadata when found, or an error if the
+// check failed. A missing cache entry is not an error.
+//
+// Example:
+//
+//	result, err := cacheClient.Peek(ctx, "node_modules")
+//	if err != nil {
+//	    log.Fatalf("Cache peek failed: %v", err)
+//	}
+//	if result.Exists {
+//	    log.Printf("Cache exists for key: %s (expires %s)", result.Key, result.ExpiresAt)
+//	}
+func (c *Cache) Peek(ctx context.Context, cacheID string) (PeekResult, error) {
+	return c.PeekWithOptions(ctx, cacheID, PeekOptions{})
+}
+
+// PeekWithOptions checks whether a cache entry exists for the cache's key,
+// applying the supplied options. See Peek for details.
+//
+// With PeekOptions.FallbackKeys, PeekResult.MatchedKey and PeekResult.Fallback
+// report which key matched, so integrators such as agent hooks can predict
+// what a restore would do.
+//
+// Example:
+//
+//	result, err := cacheClient.PeekWithOptions(ctx, "node_modules", zstash.PeekOptions{
+//	    FallbackKeys: true,
+//	})
+//	if err != nil {
+//	    log.Fatalf("Cache peek failed: %v", err)
+//	}
+//	if result.Fallback {
+//	    log.Printf("Fallback key %s would be restored", result.MatchedKey)
+//	}
+func (c *Cache) PeekWithOptions(ctx context.Context, cacheID string, opts PeekOptions) (PeekResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.Peek")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	span.SetAttributes(
+		attribute.String("cache.id", cacheID),
+		attribute.String("cache.branch", c.branch),
+		attribute.Bool("cache.peek_fallback_keys", opts.FallbackKeys),
+	)
+
+	result := PeekResult{}
+
+	// Find the cache configuration
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return result, err
+	}
+
+	result.Key = cacheConfig.Key
+
+	span.SetAttributes(
+		attribute.String("cache.key", cacheConfig.Key),
+		attribute.String("cache.registry", c.registry),
+	)
+
+	c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache exists", 0, 0)
+
+	keys := []string{cacheConfig.Key}
+	if opts.FallbackKeys {
+		keys = append(keys, cacheConfig.FallbackKeys...)
+	}
+
+	var (
+		peekResp api.CachePeekResp
+		exists   bool
+	)
+	for i, key := range keys {
+		peekResp, exists, err = c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+			Key:    key,
+			Branch: c.scopeFor(cacheConfig).branch,
+		})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to check cache existence")
+			return result, fmt.Errorf("failed to check cache existence: %w", err)
+		}
+
+		if exists {
+			result.MatchedKey = peekResp.Key
+			if result.MatchedKey == "" {
+				result.MatchedKey = key
+			}
+			result.Fallback = i > 0
+			break
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("cache.exists", exists))
+
+	if !exists {
+		span.SetStatus(codes.Ok, "cache not found")
+		c.callProgress(ctx, cacheID, "complete", "Cache not found", 0, 0)
+		return result, nil
+	}
+
+	span.SetAttributes(
+		attribute.String("cache.matched_key", result.MatchedKey),
+		attribute.Bool("cache.fallback_used", result.Fallback),
+	)
+
+	result.Exists = true
+	result.Store = peekResp.Store
+	result.Digest = peekResp.Digest
+	result.Compression = peekResp.Compression
+	result.FileSize = int64(peekResp.FileSize)
+	result.Paths = peekResp.Paths
+	result.Pipeline = peekResp.Pipeline
+	result.Branch = peekResp.Branch
+	result.Platform = peekResp.Platform
+	result.CreatedAt = peekResp.CreatedAt
+	result.ExpiresAt = peekResp.ExpiresAt
+	result.Metadata = entryMetadataFrom(peekResp)
+
+	span.SetStatus(codes.Ok, "cache exists")
+	c.callProgress(ctx, cacheID, "complete", "Cache exists", 0, 0)
+
+	return result, nil
+}
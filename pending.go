@@ -0,0 +1,64 @@
+package zstash
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// DefaultPendingPollInterval is how often a pending cache entry is checked
+// with RestoreOptions.WaitForPending when RestoreOptions.PendingPollInterval
+// isn't set.
+const DefaultPendingPollInterval = 5 * time.Second
+
+// isPending reports whether the cache key has an entry which hasn't been
+// committed yet, and would otherwise be reported as a miss or restored from a
+// fallback key.
+func isPending(retrieveResp api.CacheRetrieveResp, exists bool) bool {
+	return retrieveResp.Pending && (!exists || retrieveResp.Fallback)
+}
+
+// waitForPending polls for the pending entry of the cache key to be committed
+// by the job saving it, until it is committed or abandoned, or
+// RestoreOptions.WaitForPending elapses. Returns the last retrieved entry, so a
+// restore which times out continues with the miss or fallback it found.
+func (c *Cache) waitForPending(ctx context.Context, cacheID string, cacheConfig *cache.Cache, opts RestoreOptions, retrieveResp api.CacheRetrieveResp, exists bool) (api.CacheRetrieveResp, bool, error) {
+	interval := opts.PendingPollInterval
+	if interval <= 0 {
+		interval = DefaultPendingPollInterval
+	}
+
+	c.callProgress(ctx, cacheID, "waiting_for_pending", "Waiting for cache entry to be committed", 0, 0)
+
+	timeout := time.NewTimer(opts.WaitForPending)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for isPending(retrieveResp, exists) {
+		select {
+		case <-ctx.Done():
+			return api.CacheRetrieveResp{}, false, ctx.Err()
+		case <-timeout.C:
+			logging.FromContext(ctx).Info("timed out waiting for cache entry to be committed",
+				"cache_id", cacheID,
+				"key", cacheConfig.Key,
+				"timeout", opts.WaitForPending,
+			)
+			return retrieveResp, exists, nil
+		case <-ticker.C:
+		}
+
+		var err error
+		retrieveResp, exists, err = c.retrieveCache(ctx, cacheConfig, opts.FallbackStrategy)
+		if err != nil {
+			return api.CacheRetrieveResp{}, false, err
+		}
+	}
+
+	return retrieveResp, exists, nil
+}
@@ -0,0 +1,172 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buildkite/zstash/store"
+)
+
+// BuildkiteEnv is the environment variable set in Buildkite jobs, which
+// enables ProgressLogAuto.
+const BuildkiteEnv = "BUILDKITE"
+
+// ProgressLogMode controls whether the progress of uploads and downloads is
+// logged, see Config.ProgressLog.
+type ProgressLogMode string
+
+const (
+	// ProgressLogAuto logs progress in Buildkite jobs, when BuildkiteEnv is
+	// set. This is the default.
+	ProgressLogAuto ProgressLogMode = "auto"
+	// ProgressLogAlways logs progress whatever the environment.
+	ProgressLogAlways ProgressLogMode = "always"
+	// ProgressLogNever doesn't log progress.
+	ProgressLogNever ProgressLogMode = "never"
+)
+
+// progressInterval is how often the progress of a transfer is checked.
+const progressInterval = time.Second
+
+// IsValid reports whether m is a known progress log mode, or empty to use the
+// default.
+func (m ProgressLogMode) IsValid() bool {
+	switch m {
+	case "", ProgressLogAuto, ProgressLogAlways, ProgressLogNever:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveProgressLog reports whether transfer progress is logged in the mode,
+// reading BuildkiteEnv from env, or the OS environment if env is nil.
+func resolveProgressLog(mode ProgressLogMode, env map[string]string) bool {
+	switch mode {
+	case ProgressLogAlways:
+		return true
+	case ProgressLogNever:
+		return false
+	}
+
+	if env != nil {
+		return env[BuildkiteEnv] != ""
+	}
+
+	return os.Getenv(BuildkiteEnv) != ""
+}
+
+// transferProgressLog logs a line each time a transfer passes another 10% of
+// its total, with its speed and the estimated time remaining, so a long
+// transfer doesn't look hung in a job log which isn't a terminal.
+type transferProgressLog struct {
+	logger    *slog.Logger
+	cacheID   string
+	direction string
+	total     int64
+	start     time.Time
+
+	// logged is the last multiple of 10% logged
+	logged int64
+}
+
+// update logs the progress of the transfer if it has passed another 10% of
+// its total since the last line was logged.
+func (l *transferProgressLog) update(transferred int64, now time.Time) {
+	if l.total <= 0 {
+		return
+	}
+
+	percent := min(transferred*100/l.total, 100) / 10 * 10
+	if percent <= l.logged {
+		return
+	}
+	l.logged = percent
+
+	elapsed := now.Sub(l.start)
+
+	var speed float64
+	eta := "unknown"
+	if elapsed > 0 && transferred > 0 {
+		speed = float64(transferred) / elapsed.Seconds()
+		remaining := time.Duration(float64(max(l.total-transferred, 0)) / speed * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	l.logger.Info(fmt.Sprintf("%s cache %d%%", l.direction, percent),
+		"cache_id", l.cacheID,
+		"bytes", transferred,
+		"total_bytes", l.total,
+		"speed", fmt.Sprintf("%.2fMB/s", speed/1000/1000),
+		"eta", eta,
+	)
+}
+
+// watchTransfer returns a context counting the bytes transferred with it,
+// which are reported to the progress callback for the stage, "uploading" or
+// "downloading", and logged in 10% increments if enabled by
+// Config.ProgressLog. The returned function stops watching the transfer, and
+// must be called once it finishes; a transfer which succeeded is reported as
+// complete, as chunked uploads skip chunks which are already stored.
+func (c *Cache) watchTransfer(ctx context.Context, cacheID, stage string, total int64) (context.Context, func(success bool)) {
+	if c.onProgress == nil && !c.progressLog {
+		return ctx, func(bool) {}
+	}
+
+	progress := &store.TransferProgress{}
+
+	var log *transferProgressLog
+	if c.progressLog {
+		direction := "Downloading"
+		if stage == "uploading" {
+			direction = "Uploading"
+		}
+		log = &transferProgressLog{logger: c.logger, cacheID: cacheID, direction: direction, total: total, start: time.Now()}
+	}
+
+	message := "Downloading cache archive"
+	if stage == "uploading" {
+		message = "Uploading cache archive"
+	}
+
+	report := func(transferred int64) {
+		// requests retried by S3 are counted again
+		if total > 0 {
+			transferred = min(transferred, total)
+		}
+
+		c.callProgress(ctx, cacheID, stage, message, int(transferred), int(total))
+		if log != nil {
+			log.update(transferred, time.Now())
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report(progress.Bytes())
+			}
+		}
+	})
+
+	return store.WithTransferProgress(ctx, progress), func(success bool) {
+		close(done)
+		wg.Wait()
+
+		if success && total > 0 {
+			report(total)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package zstash
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProgressLog(t *testing.T) {
+	tests := []struct {
+		name string
+		mode ProgressLogMode
+		env  map[string]string
+		want bool
+	}{
+		{name: "default in buildkite", env: map[string]string{BuildkiteEnv: "true"}, want: true},
+		{name: "auto in buildkite", mode: ProgressLogAuto, env: map[string]string{BuildkiteEnv: "true"}, want: true},
+		{name: "auto outside buildkite", mode: ProgressLogAuto, env: map[string]string{}, want: false},
+		{name: "always", mode: ProgressLogAlways, env: map[string]string{}, want: true},
+		{name: "never", mode: ProgressLogNever, env: map[string]string{BuildkiteEnv: "true"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resolveProgressLog(tt.mode, tt.env))
+		})
+	}
+
+	t.Setenv(BuildkiteEnv, "true")
+	assert.True(t, resolveProgressLog(ProgressLogAuto, nil))
+
+	assert.False(t, ProgressLogMode("sometimes").IsValid())
+}
+
+func TestTransferProgressLog(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Now()
+
+	log := &transferProgressLog{
+		logger:    slog.New(slog.NewJSONHandler(&buf, nil)),
+		cacheID:   "deps",
+		direction: "Uploading",
+		total:     1000,
+		start:     start,
+	}
+
+	log.update(50, start.Add(time.Second))
+	log.update(100, start.Add(2*time.Second))
+	log.update(150, start.Add(3*time.Second))
+	log.update(350, start.Add(4*time.Second))
+	log.update(1000, start.Add(10*time.Second))
+	log.update(1000, start.Add(11*time.Second))
+
+	var lines []map[string]any
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var line map[string]any
+		require.NoError(t, decoder.Decode(&line))
+		lines = append(lines, line)
+	}
+
+	// a line for each 10% passed, skipping increments passed between updates
+	require.Len(t, lines, 3)
+	assert.Equal(t, "Uploading cache 10%", lines[0]["msg"])
+	assert.Equal(t, "deps", lines[0]["cache_id"])
+	assert.Equal(t, "0.00MB/s", lines[0]["speed"])
+	assert.Equal(t, "18s", lines[0]["eta"])
+	assert.Equal(t, "Uploading cache 30%", lines[1]["msg"])
+	assert.Equal(t, "Uploading cache 100%", lines[2]["msg"])
+	assert.Equal(t, "0s", lines[2]["eta"])
+
+	// transfers of unknown size aren't logged
+	buf.Reset()
+	log = &transferProgressLog{logger: slog.New(slog.NewJSONHandler(&buf, nil)), start: start}
+	log.update(100, start.Add(time.Second))
+	assert.Zero(t, buf.Len())
+}
@@ -0,0 +1,220 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// PromoteOptions controls the behaviour of Promote.
+type PromoteOptions struct {
+	// FromBranch is the branch the cache entry is copied from. Required.
+	FromBranch string
+
+	// ToBranch is the branch the cache entry is copied to. Required.
+	ToBranch string
+
+	// Key is the key of the cache entry to copy. Defaults to the cache's
+	// configured key.
+	Key string
+
+	// Force copies the cache entry even if one already exists for the key on
+	// ToBranch, replacing it, see SaveOptions.Force.
+	Force bool
+}
+
+// PromoteResult contains the results of Promote.
+type PromoteResult struct {
+	// Key is the key of the cache entry which was promoted.
+	Key string
+
+	// Found indicates whether a cache entry exists for the key on
+	// PromoteOptions.FromBranch. Nothing is promoted if it doesn't.
+	Found bool
+
+	// Exists indicates the cache entry already existed on
+	// PromoteOptions.ToBranch, so it wasn't promoted.
+	Exists bool
+
+	// Save contains the metrics of saving the archive on the target branch,
+	// valid when the entry was promoted.
+	Save SaveResult
+
+	// Transfer contains information about downloading the archive.
+	Transfer TransferMetrics
+
+	// TotalDuration is the end-to-end duration of the promotion.
+	TotalDuration time.Duration
+}
+
+// Promote copies a cache entry saved on one branch to another, so the warm
+// caches of a long-lived feature branch seed the branch it is merged into,
+// rather than it starting from a cold cache.
+//
+// The entry for the exact key is downloaded from PromoteOptions.FromBranch
+// and saved under the same key on PromoteOptions.ToBranch, as the cache API
+// doesn't copy entries between branches itself. Fallback keys are not
+// considered. Nothing is promoted if the entry doesn't exist on FromBranch,
+// or already exists on ToBranch unless PromoteOptions.Force is set; neither
+// is an error condition.
+//
+// Only caches scoped to a branch can be promoted, as caches scoped to a
+// pipeline or organization are already shared between branches.
+//
+// Example:
+//
+//	result, err := cacheClient.Promote(ctx, "deps", zstash.PromoteOptions{
+//	    FromBranch: "feature/x",
+//	    ToBranch:   "main",
+//	})
+//	if err != nil {
+//	    log.Fatalf("Cache promotion failed: %v", err)
+//	}
+//	if !result.Found {
+//	    log.Printf("No cache for %s on feature/x", result.Key)
+//	}
+func (c *Cache) Promote(ctx context.Context, cacheID string, opts PromoteOptions) (PromoteResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.Promote")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	span.SetAttributes(
+		attribute.String("cache.id", cacheID),
+		attribute.String("promote.from_branch", opts.FromBranch),
+		attribute.String("promote.to_branch", opts.ToBranch),
+		attribute.Bool("cache.force", opts.Force),
+	)
+
+	startTime := time.Now()
+	result := PromoteResult{}
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return result, err
+	}
+
+	if err := validatePromote(cacheConfig, opts); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid promote options")
+		return result, err
+	}
+
+	result.Key = cacheConfig.Key
+	if opts.Key != "" {
+		result.Key = opts.Key
+	}
+
+	span.SetAttributes(attribute.String("cache.key", result.Key))
+
+	ctx = trace.WithCache(ctx, cacheID, result.Key, c.registry)
+
+	if !opts.Force {
+		c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache exists on target branch", 0, 0)
+
+		_, result.Exists, err = c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+			Key:    result.Key,
+			Branch: opts.ToBranch,
+		})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to check cache existence")
+			return result, fmt.Errorf("failed to check cache existence: %w", err)
+		}
+	}
+
+	retrieveResp, found, err := c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
+		Key:    result.Key,
+		Branch: opts.FromBranch,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to retrieve cache")
+		return result, fmt.Errorf("failed to retrieve cache: %w", err)
+	}
+	result.Found = found
+
+	if !result.Found || result.Exists {
+		result.TotalDuration = time.Since(startTime)
+		span.SetAttributes(
+			attribute.Bool("promote.found", result.Found),
+			attribute.Bool("cache.already_exists", result.Exists),
+		)
+		span.SetStatus(codes.Ok, "nothing to promote")
+		c.callProgress(ctx, cacheID, "complete", "Nothing to promote", 0, 0)
+		return result, nil
+	}
+
+	c.callProgress(ctx, cacheID, "downloading", "Downloading cache archive", 0, 0)
+
+	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to download cache")
+		return result, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	result.Transfer = TransferMetrics{
+		BytesTransferred: transferInfo.BytesTransferred,
+		TransferSpeed:    transferInfo.TransferSpeed,
+		Duration:         transferInfo.Duration,
+		RequestID:        transferInfo.RequestID,
+		PartCount:        transferInfo.PartCount,
+		Concurrency:      transferInfo.Concurrency,
+		ChunkCount:       transferInfo.ChunkCount,
+	}
+
+	logging.FromContext(ctx).Info("promoting cache", "cache_id", cacheID, "key", result.Key, "from_branch", opts.FromBranch, "to_branch", opts.ToBranch)
+
+	c.emit(ctx, SaveStarted{EventInfo: newEventInfo(cacheID)})
+
+	result.Save, err = c.save(ctx, cacheID, archiveFile, saveTarget{key: result.Key, branch: opts.ToBranch}, SaveOptions{Force: opts.Force})
+	c.emit(ctx, SaveCompleted{EventInfo: newEventInfo(cacheID), Result: result.Save, Err: err})
+	c.reportSave(ctx, cacheID, result.Save, err)
+
+	result.TotalDuration = time.Since(startTime)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to save cache on target branch")
+		return result, fmt.Errorf("failed to save cache on target branch: %w", err)
+	}
+
+	result.Exists = !result.Save.CacheCreated
+
+	span.SetAttributes(attribute.Bool("cache.created", result.Save.CacheCreated))
+	span.SetStatus(codes.Ok, "cache promoted")
+
+	return result, nil
+}
+
+// validatePromote checks the options of Promote are valid for the cache.
+func validatePromote(cacheConfig *cache.Cache, opts PromoteOptions) error {
+	if opts.FromBranch == "" || opts.ToBranch == "" {
+		return fmt.Errorf("%w: promoting cache %s requires a source and target branch", ErrInvalidConfiguration, cacheConfig.ID)
+	}
+
+	if opts.FromBranch == opts.ToBranch {
+		return fmt.Errorf("%w: cannot promote cache %s from branch %s to itself", ErrInvalidConfiguration, cacheConfig.ID, opts.FromBranch)
+	}
+
+	if cacheConfig.Scope == cache.ScopePipeline || cacheConfig.Scope == cache.ScopeOrganization {
+		return fmt.Errorf("%w: cache %s is shared between branches by its %s scope", ErrInvalidConfiguration, cacheConfig.ID, cacheConfig.Scope)
+	}
+
+	return nil
+}
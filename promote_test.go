@@ -0,0 +1,35 @@
+package zstash
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildkite/zstash/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromote_InvalidOptions(t *testing.T) {
+	cacheClient := &Cache{caches: []cache.Cache{
+		{ID: "deps", Key: "v1-deps"},
+		{ID: "shared", Key: "v1-shared", Scope: cache.ScopePipeline},
+	}}
+
+	tests := []struct {
+		name    string
+		cacheID string
+		opts    PromoteOptions
+		wantErr error
+	}{
+		{name: "unknown cache", cacheID: "missing", opts: PromoteOptions{FromBranch: "feature/x", ToBranch: "main"}, wantErr: ErrCacheNotFound},
+		{name: "missing branch", cacheID: "deps", opts: PromoteOptions{FromBranch: "feature/x"}, wantErr: ErrInvalidConfiguration},
+		{name: "same branch", cacheID: "deps", opts: PromoteOptions{FromBranch: "main", ToBranch: "main"}, wantErr: ErrInvalidConfiguration},
+		{name: "shared between branches", cacheID: "shared", opts: PromoteOptions{FromBranch: "feature/x", ToBranch: "main"}, wantErr: ErrInvalidConfiguration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cacheClient.Promote(context.Background(), tt.cacheID, tt.opts)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package zstash
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// DefaultRegistryCacheTTL is how long a cache registry response is reused when
+// Config.RegistryCacheTTL isn't set.
+const DefaultRegistryCacheTTL = 5 * time.Minute
+
+// cachedRegistry is a cache registry response and when it was fetched.
+type cachedRegistry struct {
+	resp      api.CacheRegistryResp
+	fetchedAt time.Time
+}
+
+// WarmRegistry fetches the cache registry so later saves don't wait for it.
+// The response is reused for Config.RegistryCacheTTL.
+//
+// Example:
+//
+//	if err := cacheClient.WarmRegistry(ctx); err != nil {
+//	    log.Fatalf("Cache registry unavailable: %v", err)
+//	}
+func (c *Cache) WarmRegistry(ctx context.Context) error {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.WarmRegistry")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	span.SetAttributes(attribute.String("cache.registry", c.registry))
+
+	if _, err := c.cacheRegistry(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to fetch cache registry")
+		return err
+	}
+
+	span.SetStatus(codes.Ok, "cache registry fetched")
+
+	return nil
+}
+
+// cacheRegistry returns the client's cache registry, reusing the last
+// response until it is older than the TTL. Errors aren't cached.
+//
+// The lock is held while fetching, so concurrent saves wait for a single
+// request rather than each fetching the registry.
+func (c *Cache) cacheRegistry(ctx context.Context) (api.CacheRegistryResp, error) {
+	if c.registryCacheTTL == 0 {
+		return c.client.CacheRegistry(ctx, c.registry)
+	}
+
+	c.registryMu.Lock()
+	defer c.registryMu.Unlock()
+
+	if cached, ok := c.registries[c.registry]; ok && time.Since(cached.fetchedAt) < c.registryCacheTTL {
+		return cached.resp, nil
+	}
+
+	resp, err := c.client.CacheRegistry(ctx, c.registry)
+	if err != nil {
+		return resp, err
+	}
+
+	if c.registries == nil {
+		c.registries = make(map[string]cachedRegistry)
+	}
+	c.registries[c.registry] = cachedRegistry{resp: resp, fetchedAt: time.Now()}
+
+	return resp, nil
+}
+
+// registryCacheTTL returns the configured registry cache TTL, applying the
+// default when zero. Zero is returned when caching is disabled.
+func registryCacheTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl == 0:
+		return DefaultRegistryCacheTTL
+	case ttl < 0:
+		return 0
+	default:
+		return ttl
+	}
+}
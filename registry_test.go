@@ -0,0 +1,72 @@
+package zstash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildkite/zstash/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_cacheRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reused within TTL", func(t *testing.T) {
+		client := newMockAPIClient(store.LocalFileStore)
+		cacheClient := &Cache{client: client, registry: "~", registryCacheTTL: time.Minute}
+
+		require.NoError(t, cacheClient.WarmRegistry(ctx))
+
+		for range 3 {
+			resp, err := cacheClient.cacheRegistry(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, store.LocalFileStore, resp.Store)
+		}
+
+		assert.Equal(t, int64(1), client.registryCalls.Load())
+	})
+
+	t.Run("refetched after TTL", func(t *testing.T) {
+		client := newMockAPIClient(store.LocalFileStore)
+		cacheClient := &Cache{client: client, registry: "~", registryCacheTTL: time.Minute}
+
+		require.NoError(t, cacheClient.WarmRegistry(ctx))
+
+		cached := cacheClient.registries["~"]
+		cached.fetchedAt = time.Now().Add(-2 * time.Minute)
+		cacheClient.registries["~"] = cached
+
+		_, err := cacheClient.cacheRegistry(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), client.registryCalls.Load())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		client := newMockAPIClient(store.LocalFileStore)
+		cacheClient := &Cache{client: client, registry: "~"}
+
+		for range 2 {
+			_, err := cacheClient.cacheRegistry(ctx)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, int64(2), client.registryCalls.Load())
+	})
+
+	t.Run("errors aren't cached", func(t *testing.T) {
+		client := newMockAPIClient(store.LocalFileStore)
+		cacheClient := &Cache{client: client, registry: "missing", registryCacheTTL: time.Minute}
+
+		require.ErrorIs(t, cacheClient.WarmRegistry(ctx), ErrRegistryNotFound)
+		require.ErrorIs(t, cacheClient.WarmRegistry(ctx), ErrRegistryNotFound)
+		assert.Equal(t, int64(2), client.registryCalls.Load())
+	})
+}
+
+func TestRegistryCacheTTL(t *testing.T) {
+	assert.Equal(t, DefaultRegistryCacheTTL, registryCacheTTL(0))
+	assert.Equal(t, time.Duration(0), registryCacheTTL(-1))
+	assert.Equal(t, time.Second, registryCacheTTL(time.Second))
+}
@@ -0,0 +1,205 @@
+package zstash
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// WebhookSignatureHeader is the header holding the HMAC-SHA256 signature of
+// the request body sent by WebhookReporter, formatted as "sha256=<hex>".
+const WebhookSignatureHeader = "X-Zstash-Signature"
+
+// DefaultWebhookTimeout is the timeout for each request sent by WebhookReporter.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// UsageEvent describes a single save or restore, sent to the Reporter.
+type UsageEvent struct {
+	// Operation is "save" or "restore".
+	Operation string `json:"operation"`
+
+	// CacheID is the ID of the cache.
+	CacheID string `json:"cache_id"`
+
+	// Key is the cache key saved, or the key restored (which may be a
+	// fallback key).
+	Key string `json:"key"`
+
+	// Status is the outcome, as returned by CacheSaveResult.Status or
+	// CacheRestoreResult.Status.
+	Status string `json:"status"`
+
+	// Hit indicates whether a restore matched the exact cache key.
+	Hit bool `json:"hit"`
+
+	// Error is the error message if the operation failed.
+	Error string `json:"error,omitempty"`
+
+	// ArchiveSize is the size of the archive in bytes.
+	ArchiveSize int64 `json:"archive_size"`
+
+	// BytesTransferred is the number of bytes uploaded or downloaded.
+	BytesTransferred int64 `json:"bytes_transferred"`
+
+	// ArchiveDuration is the time spent building or extracting the archive.
+	ArchiveDuration time.Duration `json:"archive_duration_ns"`
+
+	// TransferDuration is the time spent uploading or downloading.
+	TransferDuration time.Duration `json:"transfer_duration_ns"`
+
+	// TotalDuration is the end-to-end duration of the operation.
+	TotalDuration time.Duration `json:"total_duration_ns"`
+
+	// Organization, Pipeline, Branch and Platform are the client's
+	// configuration, identifying the build.
+	Organization string `json:"organization"`
+	Pipeline     string `json:"pipeline"`
+	Branch       string `json:"branch"`
+	Platform     string `json:"platform"`
+
+	// Timestamp is when the operation finished.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Reporter receives a UsageEvent after each save and restore, to collect
+// cache analytics. Report may be called from multiple goroutines.
+type Reporter interface {
+	Report(ctx context.Context, event UsageEvent) error
+}
+
+// WebhookReporter is a Reporter which POSTs each event as JSON to a webhook URL.
+type WebhookReporter struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhookReporter creates a WebhookReporter sending events to url. If
+// secret isn't empty, each request is signed with it using HMAC-SHA256 in the
+// X-Zstash-Signature header.
+func NewWebhookReporter(url, secret string) *WebhookReporter {
+	return &WebhookReporter{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+// Report sends the event to the webhook, returning an error if the webhook
+// doesn't respond with a 2xx status.
+func (w *WebhookReporter) Report(ctx context.Context, event UsageEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// SignWebhookPayload returns the X-Zstash-Signature header value for body,
+// for webhook receivers to verify requests with hmac.Equal.
+func SignWebhookPayload(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// reportSave sends a usage event for a save to the reporter, if configured.
+func (c *Cache) reportSave(ctx context.Context, cacheID string, result SaveResult, err error) {
+	if c.reporter == nil {
+		return
+	}
+
+	event := c.usageEvent("save", cacheID, err)
+	event.Key = result.Key
+	event.Status = CacheSaveResult{Result: result, Err: err}.Status()
+	event.ArchiveSize = result.Archive.Size
+	event.ArchiveDuration = result.Archive.Duration
+	event.TotalDuration = result.TotalDuration
+	if result.Transfer != nil {
+		event.BytesTransferred = result.Transfer.BytesTransferred
+		event.TransferDuration = result.Transfer.Duration
+	}
+
+	c.report(ctx, event)
+}
+
+// reportRestore sends a usage event for a restore to the reporter, if configured.
+func (c *Cache) reportRestore(ctx context.Context, cacheID string, result RestoreResult, err error) {
+	if c.reporter == nil {
+		return
+	}
+
+	event := c.usageEvent("restore", cacheID, err)
+	event.Key = result.Key
+	event.Status = CacheRestoreResult{Result: result, Err: err}.Status()
+	event.Hit = err == nil && result.CacheHit
+	event.ArchiveSize = result.Archive.Size
+	event.BytesTransferred = result.Transfer.BytesTransferred
+	event.ArchiveDuration = result.Archive.Duration
+	event.TransferDuration = result.Transfer.Duration
+	event.TotalDuration = result.TotalDuration
+
+	c.report(ctx, event)
+}
+
+func (c *Cache) usageEvent(operation, cacheID string, err error) UsageEvent {
+	event := UsageEvent{
+		Operation:    operation,
+		CacheID:      cacheID,
+		Organization: c.organization,
+		Pipeline:     c.pipeline,
+		Branch:       c.branch,
+		Platform:     c.platform,
+		Timestamp:    time.Now().UTC(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	return event
+}
+
+// report sends the event, logging rather than returning failures so reporting
+// never fails a save or restore. The event is still sent if ctx was cancelled.
+func (c *Cache) report(ctx context.Context, event UsageEvent) {
+	if err := c.reporter.Report(context.WithoutCancel(ctx), event); err != nil {
+		logging.FromContext(ctx).Warn("failed to report cache usage",
+			"cache_id", event.CacheID,
+			"operation", event.Operation,
+			"error", err,
+		)
+	}
+}
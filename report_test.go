@@ -0,0 +1,84 @@
+package zstash
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookReporter_Report(t *testing.T) {
+	event := UsageEvent{
+		Operation:        "restore",
+		CacheID:          "node_modules",
+		Key:              "v1-abc",
+		Status:           "hit",
+		Hit:              true,
+		BytesTransferred: 1024,
+		TotalDuration:    time.Second,
+		Pipeline:         "my-pipeline",
+		Branch:           "main",
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		var (
+			gotEvent     UsageEvent
+			gotSignature string
+			gotBody      []byte
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+			gotSignature = r.Header.Get(WebhookSignatureHeader)
+			gotBody, _ = io.ReadAll(r.Body)
+			assert.NoError(t, json.Unmarshal(gotBody, &gotEvent))
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		err := NewWebhookReporter(server.URL, "secret").Report(context.Background(), event)
+		require.NoError(t, err)
+
+		assert.Equal(t, event, gotEvent)
+		assert.Equal(t, SignWebhookPayload([]byte("secret"), gotBody), gotSignature)
+		assert.NotEqual(t, SignWebhookPayload([]byte("other"), gotBody), gotSignature)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get(WebhookSignatureHeader))
+		}))
+		defer server.Close()
+
+		err := NewWebhookReporter(server.URL, "").Report(context.Background(), event)
+		require.NoError(t, err)
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		err := NewWebhookReporter(server.URL, "secret").Report(context.Background(), event)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "500")
+	})
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t,
+		"sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13",
+		SignWebhookPayload([]byte("secret"), []byte("{}")),
+	)
+}
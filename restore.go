@@ -1,19 +1,21 @@
 package zstash
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io/fs"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/buildkite/zstash/api"
 	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
 	"github.com/buildkite/zstash/store"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -57,7 +59,50 @@ import (
 //	} else {
 //	    log.Printf("Cache hit: %s (%.2f MB)", result.Key, float64(result.Archive.Size)/(1024*1024))
 //	}
-func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, error) {
+//
+// Options such as WithoutFallback and WithTargetDir change the behaviour of a
+// single restore:
+//
+//	result, err := cacheClient.Restore(ctx, "node_modules", zstash.WithoutFallback())
+func (c *Cache) Restore(ctx context.Context, cacheID string, opts ...RestoreOption) (RestoreResult, error) {
+	return c.RestoreWithOptions(ctx, cacheID, newRestoreOptions(opts))
+}
+
+// RestoreWithOptions restores a cache from storage by ID, applying the supplied
+// options. See Restore for details of the restore workflow.
+//
+// Example:
+//
+//	result, err := cacheClient.RestoreWithOptions(ctx, "node_modules", zstash.RestoreOptions{
+//	    OnConflict: archive.ConflictSkip,
+//	})
+//	if err != nil {
+//	    log.Fatalf("Cache restore failed: %v", err)
+//	}
+//	for _, path := range result.SkippedFiles {
+//	    log.Printf("Kept existing file: %s", path)
+//	}
+func (c *Cache) RestoreWithOptions(ctx context.Context, cacheID string, opts RestoreOptions) (RestoreResult, error) {
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	c.emit(ctx, RestoreStarted{EventInfo: newEventInfo(cacheID)})
+
+	// work the store continues after downloading, such as refreshing the
+	// entry's expiration, overlaps extraction but must finish before returning
+	var background store.BackgroundWork
+	result, err := c.restoreWithOptions(store.WithBackgroundWork(ctx, &background), cacheID, opts)
+	background.Wait()
+	result.Stages = stages.finish(time.Now())
+	c.emit(ctx, RestoreCompleted{EventInfo: newEventInfo(cacheID), Result: result, Err: err})
+	if !opts.DryRun {
+		c.reportRestore(ctx, cacheID, result, err)
+	}
+
+	return result, err
+}
+
+func (c *Cache) restoreWithOptions(ctx context.Context, cacheID string, opts RestoreOptions) (RestoreResult, error) {
 	tracer := otel.Tracer("github.com/buildkite/zstash")
 	ctx, span := tracer.Start(ctx, "Cache.Restore")
 	defer span.End()
@@ -88,24 +133,107 @@ func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, err
 		attribute.String("cache.registry", c.registry),
 		attribute.StringSlice("cache.fallback_keys", cacheConfig.FallbackKeys),
 		attribute.Int("cache.paths_count", len(cacheConfig.Paths)),
+		attribute.String("cache.scope", string(cacheConfig.Scope)),
+	)
+
+	ctx = trace.WithCache(ctx, cacheID, cacheConfig.Key, c.registry)
+
+	c.callProgress(ctx, cacheID, "validating", "Validating cache configuration", 0, 0)
+
+	if !opts.OnConflict.IsValid() {
+		err := fmt.Errorf("invalid conflict policy: %q", opts.OnConflict)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid restore options")
+		return result, err
+	}
+
+	if !opts.FallbackStrategy.IsValid() {
+		err := fmt.Errorf("invalid fallback strategy: %q", opts.FallbackStrategy)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid restore options")
+		return result, err
+	}
+
+	if err := validateRestoreMode(opts); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid restore options")
+		return result, err
+	}
+
+	staged := opts.Mode == RestoreModeStaged
+	partial := opts.Mode == RestoreModePartial
+
+	onConflict := opts.OnConflict
+	if onConflict == "" {
+		onConflict = archive.ConflictOverwrite
+	}
+
+	// staged and partial restores don't replace the cache paths, so don't
+	// need to swap them into place
+	atomic := cacheConfig.AtomicRestore && !staged && !partial
+	if atomic && onConflict != archive.ConflictOverwrite {
+		err := fmt.Errorf("atomic restore of cache %s requires conflict policy %q", cacheID, archive.ConflictOverwrite)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid restore options")
+		return result, err
+	}
+
+	restorePaths := cacheConfig.Paths
+	if len(opts.Paths) > 0 {
+		for _, path := range opts.Paths {
+			if !slices.Contains(cacheConfig.Paths, path) {
+				err := fmt.Errorf("restore path %q is not configured for cache %s", path, cacheID)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "invalid restore options")
+				return result, err
+			}
+		}
+		restorePaths = opts.Paths
+	}
+
+	span.SetAttributes(
+		attribute.String("cache.on_conflict", string(onConflict)),
+		attribute.String("cache.fallback_strategy", string(opts.FallbackStrategy)),
+		attribute.Bool("cache.disable_fallback", opts.DisableFallback),
+		attribute.Bool("cache.validate_manifest", opts.ValidateManifest),
+		attribute.String("cache.restore_mode", string(opts.Mode)),
+		attribute.Bool("cache.atomic_restore", atomic),
+		attribute.StringSlice("cache.restore_paths", restorePaths),
+		attribute.Bool("cache.dry_run", opts.DryRun),
 	)
 
-	c.callProgress(cacheID, "validating", "Validating cache configuration", 0, 0)
+	c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache exists", 0, 0)
 
-	c.callProgress(cacheID, "checking_exists", "Checking if cache exists", 0, 0)
+	retrieveConfig := cacheConfig
+	if opts.DisableFallback {
+		exactOnly := *cacheConfig
+		exactOnly.FallbackKeys = nil
+		retrieveConfig = &exactOnly
+	}
 
 	// Check if cache exists
-	retrieveResp, exists, err := c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
-		Key:          cacheConfig.Key,
-		Branch:       c.branch,
-		FallbackKeys: strings.Join(cacheConfig.FallbackKeys, ","),
-	})
+	retrieveResp, exists, err := c.retrieveCache(ctx, retrieveConfig, opts.FallbackStrategy)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to retrieve cache")
 		return result, fmt.Errorf("failed to retrieve cache: %w", err)
 	}
 
+	// Wait for another job to commit the cache key
+	if opts.WaitForPending > 0 && !opts.DryRun && isPending(retrieveResp, exists) {
+		waitStart := time.Now()
+		retrieveResp, exists, err = c.waitForPending(ctx, cacheID, retrieveConfig, opts, retrieveResp, exists)
+		result.WaitedForPending = time.Since(waitStart)
+
+		span.SetAttributes(attribute.Int64("cache.waited_for_pending_ms", result.WaitedForPending.Milliseconds()))
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to retrieve cache")
+			return result, fmt.Errorf("failed to retrieve cache: %w", err)
+		}
+	}
+
 	if !exists {
 		// Cache miss
 		result.CacheHit = false
@@ -117,7 +245,10 @@ func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, err
 			attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
 		)
 		span.SetStatus(codes.Ok, "cache miss")
-		c.callProgress(cacheID, "complete", "Cache miss", 0, 0)
+		if !opts.DryRun {
+			trace.RecordCacheMiss(ctx)
+		}
+		c.callProgress(ctx, cacheID, "complete", "Cache miss", 0, 0)
 		return result, nil
 	}
 
@@ -126,20 +257,111 @@ func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, err
 	result.FallbackUsed = retrieveResp.Fallback
 	result.CacheHit = !retrieveResp.Fallback
 	result.ExpiresAt = retrieveResp.ExpiresAt
+	result.Metadata = c.entryMetadata(ctx, cacheID, result.Key, c.scopeFor(cacheConfig).branch)
 
 	span.SetAttributes(
 		attribute.Bool("cache.fallback_used", result.FallbackUsed),
 		attribute.String("cache.matched_key", result.Key),
+		attribute.String("cache.created_by_job", result.Metadata.JobID),
 	)
 
-	c.callProgress(cacheID, "downloading", "Downloading cache archive", 0, 0)
+	if cacheConfig.StrictPlatform && c.platformMismatch(ctx, cacheID, result.Key, result.Metadata.Platform) {
+		span.SetAttributes(attribute.String("cache.platform_mismatch", result.Metadata.Platform))
+
+		if opts.FailOnPlatformMismatch {
+			err := fmt.Errorf("%w: %s was saved on %s, not %s", ErrPlatformMismatch, result.Key, result.Metadata.Platform, c.platform)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "platform mismatch")
+			return result, err
+		}
+
+		// Treated as a cache miss, still reporting any time spent waiting
+		result.PlatformMismatch = result.Metadata.Platform
+		result.Key = cacheConfig.Key
+		result.CacheHit = false
+		result.FallbackUsed = false
+		result.ExpiresAt = time.Time{}
+		result.Metadata = EntryMetadata{}
+		result.TotalDuration = time.Since(startTime)
+		span.SetAttributes(
+			attribute.Bool("cache.hit", false),
+			attribute.Bool("cache.restored", false),
+			attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
+		)
+		span.SetStatus(codes.Ok, "cache miss")
+		if !opts.DryRun {
+			trace.RecordCacheMiss(ctx)
+		}
+		c.callProgress(ctx, cacheID, "complete", "Cache miss, platform mismatch", 0, 0)
+		return result, nil
+	}
+
+	// a dry run reports the entry which would be restored, without
+	// downloading or extracting it
+	if opts.DryRun {
+		result.TotalDuration = time.Since(startTime)
+		span.SetAttributes(
+			attribute.Bool("cache.hit", result.CacheHit),
+			attribute.Bool("cache.restored", false),
+			attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
+		)
+		span.SetStatus(codes.Ok, "dry run")
+		c.callProgress(ctx, cacheID, "complete", "Dry run, cache would be restored", 0, 0)
+		return result, nil
+	}
+
+	if partial {
+		if err := c.restorePartial(ctx, cacheID, retrieveResp, cacheConfig, restorePaths, opts, onConflict, &result); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to restore files")
+			return result, err
+		}
+
+		result.CacheRestored = true
+		result.TotalDuration = time.Since(startTime)
+
+		span.SetAttributes(
+			attribute.Bool("cache.hit", result.CacheHit),
+			attribute.Bool("cache.restored", result.CacheRestored),
+			attribute.Bool("cache.ranged", result.Ranged),
+			attribute.Int64("cache.written_entries", result.Archive.WrittenEntries),
+			attribute.Int64("cache.transfer_bytes", result.Transfer.BytesTransferred),
+			attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
+		)
+		span.SetStatus(codes.Ok, "cache files restored successfully")
+		trace.RecordCacheHit(ctx, result.FallbackUsed)
+
+		c.callProgress(ctx, cacheID, "complete", "Cache files restored successfully", 0, 0)
+
+		return result, nil
+	}
+
+	if !opts.SkipSpaceCheck {
+		targets := restorePaths
+		if staged {
+			targets = []string{cmp.Or(opts.StagingDir, os.TempDir())}
+		}
+
+		// the cache paths are removed before extraction, unless the files
+		// are extracted alongside them and swapped into place
+		reclaimed := onConflict == archive.ConflictOverwrite && !staged && !atomic
+
+		if err := checkRestoreSpace(ctx, cacheID, int64(retrieveResp.FileSize), targets, reclaimed); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "insufficient disk space")
+			return result, err
+		}
+	}
+
+	c.callProgress(ctx, cacheID, "downloading", "Downloading cache archive", 0, 0)
+	c.emit(ctx, DownloadStarted{EventInfo: newEventInfo(cacheID), Key: result.Key, Fallback: result.FallbackUsed})
 
 	// Download cache
-	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, retrieveResp, c.bucketURL)
+	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to download cache")
-		return result, fmt.Errorf("failed to download cache: %w", err)
+		return result, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
 	}
 	defer func() {
 		_ = os.RemoveAll(tmpDir)
@@ -153,37 +375,114 @@ func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, err
 		RequestID:        transferInfo.RequestID,
 		PartCount:        transferInfo.PartCount,
 		Concurrency:      transferInfo.Concurrency,
+		ChunkCount:       transferInfo.ChunkCount,
 	}
 
-	c.callProgress(cacheID, "cleaning", "Cleaning paths", 0, 0)
+	c.emit(ctx, Downloaded{EventInfo: newEventInfo(cacheID), Transfer: result.Transfer})
 
-	for _, path := range cacheConfig.Paths {
-		extractedPath, err := archive.ResolveHomeDir(path)
+	// paths shared with other caches are restored from the entries
+	// referenced by the archive
+	shared, err := c.downloadSharedArchives(ctx, cacheID, cacheConfig, archiveFile, transferInfo.BytesTransferred, restorePaths)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to download shared entries")
+		return result, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer shared.remove()
+
+	result.Transfer.BytesTransferred += shared.bytesTransferred
+	result.MissingSharedPaths = shared.missing
+
+	// without its shared paths the cache is only partly restored, so isn't
+	// reported as a hit
+	if len(shared.missing) > 0 {
+		result.CacheHit = false
+	}
+
+	span.SetAttributes(attribute.Int("cache.shared_entries", len(shared.archives)))
+
+	// staged restores leave the cache paths untouched
+	if onConflict == archive.ConflictOverwrite && !staged {
+		c.callProgress(ctx, cacheID, "cleaning", "Cleaning paths", 0, 0)
+
+		// Record which existing files are about to be replaced before the
+		// paths are cleaned, so they can be reported to the caller.
+		result.OverwrittenFiles, err = c.listConflicts(ctx, archiveFile, transferInfo.BytesTransferred, cacheConfig.Paths, archive.ExtractOptions{
+			Include: opts.Paths,
+		})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to list conflicts")
+			return result, fmt.Errorf("failed to list conflicts: %w", err)
+		}
+
+		sharedConflicts, err := c.listSharedConflicts(ctx, shared)
 		if err != nil {
 			span.RecordError(err)
-			span.SetStatus(codes.Error, "failed to resolve home dir")
-			return result, fmt.Errorf("failed to resolve home dir for %q: %w", path, err)
+			span.SetStatus(codes.Error, "failed to list conflicts")
+			return result, fmt.Errorf("failed to list conflicts: %w", err)
+		}
+		result.OverwrittenFiles = append(result.OverwrittenFiles, sharedConflicts...)
+
+		// atomic restores replace the paths once extracted instead, and paths
+		// whose shared entry wasn't found are left as they are
+		if !atomic {
+			if err := c.cleanPaths(ctx, withoutPaths(restorePaths, shared.missing)); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "failed to clean path")
+				return result, err
+			}
 		}
+	}
 
-		slog.Debug("cleaning path", "path", path, "extractedPath", extractedPath)
+	extractOpts := archive.ExtractOptions{
+		OnConflict:  onConflict,
+		Include:     opts.Paths,
+		Chown:       opts.Chown,
+		Permissions: extractPermissionsFor(cacheConfig),
+	}
 
-		if err := cleanPath(ctx, extractedPath); err != nil {
+	if staged {
+		extractOpts.Root, err = stagingDir(opts.StagingDir)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to create staging directory")
+			return result, err
+		}
+
+		result.StagingPath = extractOpts.Root
+		result.StagedPaths, err = stagedPaths(extractOpts.Root, restorePaths)
+		if err != nil {
 			span.RecordError(err)
-			span.SetStatus(codes.Error, "failed to clean path")
-			return result, fmt.Errorf("failed to clean path %q: %w", extractedPath, err)
+			span.SetStatus(codes.Error, "failed to find staged paths")
+			return result, err
 		}
+
+		span.SetAttributes(attribute.String("cache.staging_path", result.StagingPath))
 	}
 
-	c.callProgress(cacheID, "extracting", "Extracting files from cache", 0, int(transferInfo.BytesTransferred))
+	c.callProgress(ctx, cacheID, "extracting", "Extracting files from cache", 0, int(transferInfo.BytesTransferred))
 
 	// Extract files
-	archiveInfo, err := c.extractCache(ctx, archiveFile, transferInfo.BytesTransferred, cacheConfig.Paths)
+	var archiveInfo *archive.ArchiveInfo
+	if atomic {
+		// shared paths aren't in the archive, so are swapped in from their
+		// shared entries
+		archiveInfo, err = c.extractAtomic(ctx, archiveFile, transferInfo.BytesTransferred, cacheConfig.Paths, withoutPaths(restorePaths, shared.paths), extractOpts)
+	} else {
+		archiveInfo, err = c.extractCache(ctx, archiveFile, transferInfo.BytesTransferred, cacheConfig.Paths, extractOpts)
+	}
+	if err == nil {
+		err = c.extractSharedArchives(ctx, shared, atomic, extractOpts, archiveInfo)
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to extract cache")
 		return result, fmt.Errorf("failed to extract cache: %w", err)
 	}
 
+	result.SkippedFiles = archiveInfo.Skipped
+
 	// Populate archive metrics
 	result.Archive = ArchiveMetrics{
 		Size:             archiveInfo.Size,
@@ -191,7 +490,46 @@ func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, err
 		WrittenEntries:   archiveInfo.WrittenEntries,
 		CompressionRatio: float64(archiveInfo.WrittenBytes) / float64(archiveInfo.Size),
 		Duration:         archiveInfo.Duration,
-		Paths:            cacheConfig.Paths,
+		Paths:            restorePaths,
+		PathStats:        archiveInfo.PathStats,
+	}
+
+	if opts.ValidateManifest {
+		c.callProgress(ctx, cacheID, "validating_manifest", "Validating restored files", 0, 0)
+
+		result.ManifestValidated, err = c.validateManifest(ctx, cacheID, archiveFile, transferInfo.BytesTransferred, restorePaths, result.SkippedFiles)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to validate restored files")
+			return result, err
+		}
+	}
+
+	if opts.LinkStaged {
+		c.callProgress(ctx, cacheID, "linking", "Linking staged paths", 0, 0)
+
+		skipped, err := c.linkStaged(ctx, restorePaths, result.StagedPaths, onConflict)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to link staged paths")
+			return result, fmt.Errorf("failed to link staged paths: %w", err)
+		}
+		result.SkippedFiles = append(result.SkippedFiles, skipped...)
+	}
+
+	c.emit(ctx, Extracted{EventInfo: newEventInfo(cacheID), Archive: result.Archive})
+
+	// Record the state of the restored paths so SaveIfChanged can skip
+	// re-archiving them if the build doesn't modify them. A partial or
+	// staged restore doesn't reflect the full archive in the cache paths so
+	// it is never recorded.
+	if len(opts.Paths) == 0 && !staged {
+		fingerprint, err := fingerprintPaths(ctx, cacheConfig.Paths)
+		if err != nil {
+			logging.FromContext(ctx).Warn("failed to fingerprint restored paths", "cache_id", cacheID, "error", err)
+		} else {
+			c.recordFingerprint(cacheID, pathsFingerprint{key: result.Key, sum: fingerprint})
+		}
 	}
 
 	result.CacheRestored = true
@@ -207,17 +545,20 @@ func (c *Cache) Restore(ctx context.Context, cacheID string) (RestoreResult, err
 		attribute.Float64("cache.compression_ratio", result.Archive.CompressionRatio),
 		attribute.Int64("cache.transfer_bytes", result.Transfer.BytesTransferred),
 		attribute.Float64("cache.transfer_speed_mbps", result.Transfer.TransferSpeed),
+		attribute.Int("cache.files_overwritten", len(result.OverwrittenFiles)),
+		attribute.Int("cache.files_skipped", len(result.SkippedFiles)),
 		attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
 	)
 	span.SetStatus(codes.Ok, "cache restored successfully")
+	trace.RecordCacheHit(ctx, result.FallbackUsed)
 
-	c.callProgress(cacheID, "complete", "Cache restored successfully", 0, 0)
+	c.callProgress(ctx, cacheID, "complete", "Cache restored successfully", 0, 0)
 
 	return result, nil
 }
 
 // downloadCache downloads a cache archive from storage
-func (c *Cache) downloadCache(ctx context.Context, retrieveResp api.CacheRetrieveResp, bucketURL string) (tmpDir string, archiveFile string, transferInfo *store.TransferInfo, err error) {
+func (c *Cache) downloadCache(ctx context.Context, cacheID string, retrieveResp api.CacheRetrieveResp) (tmpDir string, archiveFile string, transferInfo *store.TransferInfo, err error) {
 	tracer := otel.Tracer("github.com/buildkite/zstash")
 	ctx, span := tracer.Start(ctx, "Cache.downloadCache")
 	defer span.End()
@@ -228,13 +569,17 @@ func (c *Cache) downloadCache(ctx context.Context, retrieveResp api.CacheRetriev
 	)
 
 	// Create blob store
-	blobStore, err := store.NewBlobStore(ctx, retrieveResp.Store, bucketURL)
+	blobStore, err := c.retrieveBlobStore(ctx, retrieveResp)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to create blob store")
-		return "", "", nil, fmt.Errorf("failed to create blob store: %w", err)
+		return "", "", nil, err
 	}
 
+	// Entries saved with chunked storage hold a manifest rather than the
+	// archive, which is detected and reassembled when downloading
+	blobStore = store.NewChunkedBlob(blobStore)
+
 	// Create temporary directory
 	tmpDir, err = os.MkdirTemp("", "zstash-restore")
 	if err != nil {
@@ -246,13 +591,20 @@ func (c *Cache) downloadCache(ctx context.Context, retrieveResp api.CacheRetriev
 	archiveFile = filepath.Join(tmpDir, retrieveResp.StoreObjectName)
 
 	// Download archive
-	transferInfo, err = blobStore.Download(ctx, retrieveResp.StoreObjectName, archiveFile)
+	downloadCtx, cancelDownload := withTransferTimeout(store.WithTransferLimiter(ctx, c.transferLimiter), c.downloadTimeout)
+	defer cancelDownload()
+
+	downloadCtx, stopWatching := c.watchTransfer(downloadCtx, cacheID, "downloading", int64(retrieveResp.FileSize))
+
+	transferInfo, err = blobStore.Download(downloadCtx, retrieveResp.StoreObjectName, archiveFile)
+	stopWatching(err == nil)
 	if err != nil {
+		err = transferTimeoutError(ctx, downloadCtx, c.downloadTimeout, err)
 		// Clean up temporary directory on failure
 		_ = os.RemoveAll(tmpDir)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to download from blob store")
-		return "", "", nil, fmt.Errorf("failed to download cache: %w", err)
+		return "", "", nil, fmt.Errorf("failed to download from blob store: %w", err)
 	}
 
 	span.SetAttributes(
@@ -261,12 +613,61 @@ func (c *Cache) downloadCache(ctx context.Context, retrieveResp api.CacheRetriev
 		attribute.String("cache.request_id", transferInfo.RequestID),
 	)
 	span.SetStatus(codes.Ok, "download completed")
+	trace.RecordTransfer(ctx, trace.TransferDownload, transferInfo.BytesTransferred, transferInfo.Duration)
 
 	return tmpDir, archiveFile, transferInfo, nil
 }
 
+// retrieveBlobStore creates the blob store holding a retrieved cache entry.
+func (c *Cache) retrieveBlobStore(ctx context.Context, retrieveResp api.CacheRetrieveResp) (store.Blob, error) {
+	blobStore, err := c.newBlobStore(ctx, retrieveResp.Store, store.PresignedURLs{
+		Download: presignedURL(retrieveResp.DownloadInstructions),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob store: %w: %w", ErrStoreUnavailable, err)
+	}
+
+	blobStore, err = c.withKeyPrefix(blobStore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob store: %w", err)
+	}
+
+	return blobStore, nil
+}
+
+// cleanPaths removes the configured cache paths prior to extraction
+func (c *Cache) cleanPaths(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		extractedPath, err := archive.ResolveHomeDir(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve home dir for %q: %w", path, err)
+		}
+
+		logging.FromContext(ctx).Debug("cleaning path", "path", path, "extractedPath", extractedPath)
+
+		if err := cleanPath(ctx, extractedPath); err != nil {
+			return fmt.Errorf("failed to clean path %q: %w", extractedPath, err)
+		}
+	}
+
+	return nil
+}
+
+// listConflicts lists existing files which would be replaced by extracting a cache archive
+func (c *Cache) listConflicts(ctx context.Context, archiveFile string, archiveSize int64, paths []string, opts archive.ExtractOptions) ([]string, error) {
+	archiveFileHandle, err := os.Open(archiveFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer archiveFileHandle.Close()
+
+	opts.ExternalZstd = c.externalZstd
+
+	return archive.ListConflicts(ctx, archiveFileHandle, archiveSize, paths, opts)
+}
+
 // extractCache extracts files from a cache archive
-func (c *Cache) extractCache(ctx context.Context, archiveFile string, archiveSize int64, paths []string) (*archive.ArchiveInfo, error) {
+func (c *Cache) extractCache(ctx context.Context, archiveFile string, archiveSize int64, paths []string, opts archive.ExtractOptions) (*archive.ArchiveInfo, error) {
 	tracer := otel.Tracer("github.com/buildkite/zstash")
 	ctx, span := tracer.Start(ctx, "Cache.extractCache")
 	defer span.End()
@@ -287,7 +688,8 @@ func (c *Cache) extractCache(ctx context.Context, archiveFile string, archiveSiz
 	defer archiveFileHandle.Close()
 
 	// Extract files
-	archiveInfo, err := archive.ExtractFiles(ctx, archiveFileHandle, archiveSize, paths)
+	opts.ExternalZstd = c.externalZstd
+	archiveInfo, err := archive.ExtractFilesWithOptions(ctx, archiveFileHandle, archiveSize, paths, opts)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to extract archive")
@@ -350,7 +752,7 @@ func cleanPath(ctx context.Context, dir string) error {
 		}
 
 		if walkErr != nil {
-			slog.Debug("cleanPath: error walking path", "path", relPath, "err", walkErr)
+			logging.FromContext(ctx).Debug("cleanPath: error walking path", "path", relPath, "err", walkErr)
 			return nil
 		}
 
@@ -0,0 +1,270 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultRestoreConcurrency is the number of caches restored concurrently by
+// RestoreAll when RestoreAllOptions.Concurrency isn't set.
+const DefaultRestoreConcurrency = 4
+
+// RestoreAllOptions controls the behaviour of RestoreAll.
+type RestoreAllOptions struct {
+	// Concurrency is the maximum number of caches restored at once. Defaults
+	// to DefaultRestoreConcurrency.
+	Concurrency int
+
+	// ExcludeIDs are patterns of cache IDs which aren't restored, even if they
+	// match the requested IDs.
+	ExcludeIDs []string
+
+	// KeepGoing continues restoring the remaining caches when one fails, rather
+	// than cancelling them. The errors of every failed cache are joined and
+	// returned, and listed by ErrorTable.
+	KeepGoing bool
+
+	// Restore is applied to each cache. Restore.Paths can't be set, as the
+	// paths differ between caches.
+	Restore RestoreOptions
+}
+
+// CacheRestoreResult is the outcome of restoring one cache in RestoreAll.
+type CacheRestoreResult struct {
+	// CacheID is the ID of the restored cache.
+	CacheID string
+
+	// Result contains the restore metrics, valid when Err is nil.
+	Result RestoreResult
+
+	// Err is the error which caused the restore to fail, or nil.
+	Err error
+}
+
+// Status returns "hit" when the exact key was restored, "fallback" when a
+// fallback key was restored, "miss" when nothing was restored and "error"
+// when the restore failed.
+func (r CacheRestoreResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return "error"
+	case r.Result.CacheHit:
+		return "hit"
+	case r.Result.CacheRestored:
+		return "fallback"
+	default:
+		return "miss"
+	}
+}
+
+// RestoreAllResult contains the results of RestoreAll.
+type RestoreAllResult struct {
+	// Caches holds a result for each cache, in the order requested. Caches
+	// which weren't attempted because an earlier restore failed are omitted,
+	// unless RestoreAllOptions.KeepGoing is set.
+	Caches []CacheRestoreResult
+}
+
+// Summary returns a single line listing the status of each cache, such as
+// "node_modules=hit gems=fallback go=miss", for scripts to parse.
+func (r RestoreAllResult) Summary() string {
+	fields := make([]string, 0, len(r.Caches))
+	for _, result := range r.Caches {
+		fields = append(fields, result.CacheID+"="+result.Status())
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// Err returns the errors of the caches which failed joined together, each
+// naming its cache, or nil if none failed.
+func (r RestoreAllResult) Err() error {
+	var errs []error
+	for _, result := range r.Caches {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore cache %s: %w", result.CacheID, result.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ErrorTable renders the caches which failed as a table with a row for each
+// cache and its error, or returns an empty string if none failed:
+//
+//	CACHE  ERROR
+//	gems   failed to download cache: connection reset
+func (r RestoreAllResult) ErrorTable() string {
+	var failed []cacheError
+	for _, result := range r.Caches {
+		if result.Err != nil {
+			failed = append(failed, cacheError{cacheID: result.CacheID, err: result.Err})
+		}
+	}
+
+	return renderErrorTable(failed)
+}
+
+// RestoreAll restores several caches concurrently. cacheIDs may be patterns
+// such as "node_*", see MatchCacheIDs. If cacheIDs is empty, all of the
+// client's caches are restored, other than those matching
+// RestoreAllOptions.ExcludeIDs.
+//
+// Each cache is restored as with RestoreWithOptions. The first failure
+// cancels the remaining restores, and is returned along with the results of
+// the caches restored so far. With RestoreAllOptions.KeepGoing, the remaining
+// caches are still restored, and the errors of all of the failed caches are
+// returned, see RestoreAllResult.ErrorTable.
+//
+// Example:
+//
+//	results, err := cacheClient.RestoreAll(ctx, nil, zstash.RestoreAllOptions{})
+//	if err != nil {
+//	    log.Fatalf("Cache restore failed: %v", err)
+//	}
+//	fmt.Println(results.Summary())
+func (c *Cache) RestoreAll(ctx context.Context, cacheIDs []string, opts RestoreAllOptions) (RestoreAllResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.RestoreAll")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	cacheIDs, err := c.MatchCacheIDs(cacheIDs, opts.ExcludeIDs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid restore options")
+		return RestoreAllResult{}, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultRestoreConcurrency
+	}
+
+	span.SetAttributes(
+		attribute.StringSlice("cache.ids", cacheIDs),
+		attribute.Int("cache.concurrency", concurrency),
+	)
+
+	if err := c.validateRestoreAll(cacheIDs, opts); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid restore options")
+		return RestoreAllResult{}, err
+	}
+
+	results := make([]CacheRestoreResult, len(cacheIDs))
+	attempted := make([]bool, len(cacheIDs))
+
+	// with KeepGoing, failures don't cancel the remaining restores
+	wg, wctx := &errgroup.Group{}, ctx
+	if !opts.KeepGoing {
+		wg, wctx = errgroup.WithContext(ctx)
+	}
+	wg.SetLimit(concurrency)
+
+	for i, cacheID := range cacheIDs {
+		if wctx.Err() != nil {
+			break
+		}
+
+		attempted[i] = true
+
+		wg.Go(func() error {
+			result, err := c.RestoreWithOptions(wctx, cacheID, opts.Restore)
+			results[i] = CacheRestoreResult{CacheID: cacheID, Result: result, Err: err}
+			if err != nil {
+				return fmt.Errorf("failed to restore cache %s: %w", cacheID, err)
+			}
+			return nil
+		})
+	}
+
+	err = wg.Wait()
+
+	var allResult RestoreAllResult
+	for i, result := range results {
+		if attempted[i] {
+			allResult.Caches = append(allResult.Caches, result)
+		}
+	}
+
+	if opts.KeepGoing {
+		err = allResult.Err()
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restore caches")
+		return allResult, err
+	}
+
+	span.SetStatus(codes.Ok, "caches restored")
+
+	return allResult, nil
+}
+
+// validateRestoreAll checks the options before any caches are restored.
+func (c *Cache) validateRestoreAll(cacheIDs []string, opts RestoreAllOptions) error {
+	if len(opts.Restore.Paths) > 0 {
+		return errors.New("restore paths can't be set when restoring several caches")
+	}
+
+	return c.validateCacheIDs(cacheIDs, opts.Concurrency)
+}
+
+// validateCacheIDs checks the caches and concurrency of RestoreAll and SaveAll.
+func (c *Cache) validateCacheIDs(cacheIDs []string, concurrency int) error {
+	if concurrency < 0 {
+		return fmt.Errorf("concurrency must be non-negative, got %d", concurrency)
+	}
+
+	seen := make(map[string]bool, len(cacheIDs))
+	for _, cacheID := range cacheIDs {
+		if _, err := c.findCache(cacheID); err != nil {
+			return fmt.Errorf("cache %s: %w", cacheID, err)
+		}
+		if seen[cacheID] {
+			return fmt.Errorf("cache %s is listed more than once", cacheID)
+		}
+		seen[cacheID] = true
+	}
+
+	return nil
+}
+
+// cacheError is the error of a cache which failed in RestoreAll or SaveAll.
+type cacheError struct {
+	cacheID string
+	err     error
+}
+
+// renderErrorTable renders the errors of failed caches as a table, or returns
+// an empty string if there are none.
+func renderErrorTable(failed []cacheError) string {
+	if len(failed) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CACHE\tERROR")
+
+	for _, f := range failed {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", f.cacheID, f.err)
+	}
+
+	_ = w.Flush()
+
+	return b.String()
+}
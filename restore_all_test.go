@@ -0,0 +1,41 @@
+package zstash
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreAllResult_Summary(t *testing.T) {
+	result := RestoreAllResult{
+		Caches: []CacheRestoreResult{
+			{CacheID: "node_modules", Result: RestoreResult{CacheHit: true, CacheRestored: true}},
+			{CacheID: "gems", Result: RestoreResult{CacheRestored: true, FallbackUsed: true}},
+			{CacheID: "go", Result: RestoreResult{}},
+			{CacheID: "pip", Err: errors.New("download failed")},
+		},
+	}
+
+	assert.Equal(t, "node_modules=hit gems=fallback go=miss pip=error", result.Summary())
+	assert.Empty(t, RestoreAllResult{}.Summary())
+}
+
+func TestRestoreAllResult_ErrorTable(t *testing.T) {
+	result := RestoreAllResult{
+		Caches: []CacheRestoreResult{
+			{CacheID: "node_modules", Result: RestoreResult{CacheHit: true, CacheRestored: true}},
+			{CacheID: "pip", Err: errors.New("download failed")},
+			{CacheID: "go", Err: errors.New("permission denied")},
+		},
+	}
+
+	assert.Equal(t, "CACHE  ERROR\npip    download failed\ngo     permission denied\n", result.ErrorTable())
+	assert.Empty(t, RestoreAllResult{}.ErrorTable())
+
+	err := result.Err()
+	require.Error(t, err)
+	assert.Equal(t, "failed to restore cache pip: download failed\nfailed to restore cache go: permission denied", err.Error())
+	assert.NoError(t, RestoreAllResult{}.Err())
+}
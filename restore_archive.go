@@ -0,0 +1,122 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// RestoreFromArchive extracts a local archive file to a cache's configured
+// paths, without using the cache API or storage, e.g. to debug a cache in an
+// air-gapped environment or seed it from a build artifact such as an archive
+// kept using Config.KeepArchiveDir.
+//
+// As with Restore, the cache paths are removed before extraction so the
+// restored files exactly match the archive. The archive doesn't record its
+// cache key, so Key, CacheHit and Transfer are not populated in the result.
+//
+// Example:
+//
+//	result, err := cacheClient.RestoreFromArchive(ctx, "node_modules", "artifacts/node_modules.zip")
+//	if err != nil {
+//	    log.Fatalf("Cache restore failed: %v", err)
+//	}
+//	log.Printf("Restored %d files", result.Archive.WrittenEntries)
+func (c *Cache) RestoreFromArchive(ctx context.Context, cacheID, archivePath string) (RestoreResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.RestoreFromArchive")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	span.SetAttributes(
+		attribute.String("cache.id", cacheID),
+		attribute.String("cache.archive_file", archivePath),
+	)
+
+	startTime := time.Now()
+	result := RestoreResult{}
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return result, err
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to stat archive file")
+		return result, fmt.Errorf("failed to stat archive file: %w", err)
+	}
+
+	c.callProgress(ctx, cacheID, "cleaning", "Cleaning paths", 0, 0)
+
+	result.OverwrittenFiles, err = c.listConflicts(ctx, archivePath, info.Size(), cacheConfig.Paths, archive.ExtractOptions{})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to list conflicts")
+		return result, fmt.Errorf("failed to list conflicts: %w", err)
+	}
+
+	// atomic restores replace the paths once extracted instead
+	if !cacheConfig.AtomicRestore {
+		if err := c.cleanPaths(ctx, cacheConfig.Paths); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to clean path")
+			return result, err
+		}
+	}
+
+	c.callProgress(ctx, cacheID, "extracting", "Extracting files from archive", 0, int(info.Size()))
+
+	extractOpts := archive.ExtractOptions{
+		OnConflict:  archive.ConflictOverwrite,
+		Permissions: extractPermissionsFor(cacheConfig),
+	}
+
+	var archiveInfo *archive.ArchiveInfo
+	if cacheConfig.AtomicRestore {
+		archiveInfo, err = c.extractAtomic(ctx, archivePath, info.Size(), cacheConfig.Paths, cacheConfig.Paths, extractOpts)
+	} else {
+		archiveInfo, err = c.extractCache(ctx, archivePath, info.Size(), cacheConfig.Paths, extractOpts)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to extract archive")
+		return result, fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	result.Archive = ArchiveMetrics{
+		Size:             archiveInfo.Size,
+		WrittenBytes:     archiveInfo.WrittenBytes,
+		WrittenEntries:   archiveInfo.WrittenEntries,
+		CompressionRatio: float64(archiveInfo.WrittenBytes) / float64(archiveInfo.Size),
+		Duration:         archiveInfo.Duration,
+		Paths:            cacheConfig.Paths,
+		PathStats:        archiveInfo.PathStats,
+	}
+
+	result.CacheRestored = true
+	result.TotalDuration = time.Since(startTime)
+
+	span.SetAttributes(
+		attribute.Int64("cache.written_bytes", result.Archive.WrittenBytes),
+		attribute.Int64("cache.written_entries", result.Archive.WrittenEntries),
+	)
+	span.SetStatus(codes.Ok, "archive restored successfully")
+
+	c.callProgress(ctx, cacheID, "complete", "Archive restored successfully", 0, 0)
+	result.Stages = stages.finish(time.Now())
+
+	return result, nil
+}
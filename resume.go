@@ -0,0 +1,77 @@
+package zstash
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// pendingUploadMatches reports whether a pending cache entry, left by an
+// earlier save which was interrupted after uploading but before committing,
+// holds the same archive so it can be committed without uploading it again.
+//
+// The uploaded object is downloaded to verify its digest, as it may have been
+// partially written. Any failure is logged and treated as a mismatch, so the
+// archive is uploaded as usual.
+func (c *Cache) pendingUploadMatches(ctx context.Context, cacheID string, blobStore store.Blob, createResp api.CacheCreateResp, sha256sum string) bool {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.pendingUploadMatches")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("cache.upload_id", createResp.UploadID),
+		attribute.String("cache.object_name", createResp.StoreObjectName),
+	)
+
+	// The pending entry was created for a different archive
+	if !strings.EqualFold(createResp.Digest, "sha256:"+sha256sum) {
+		return false
+	}
+
+	tmpDir, err := os.MkdirTemp("", "zstash-resume")
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to verify pending upload, uploading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	objectPath := filepath.Join(tmpDir, "archive")
+	if _, err := blobStore.Download(ctx, createResp.StoreObjectName, objectPath); err != nil {
+		logging.FromContext(ctx).Debug("pending upload not found, uploading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+
+	f, err := os.Open(objectPath)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to verify pending upload, uploading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		logging.FromContext(ctx).Warn("failed to verify pending upload, uploading archive", "cache_id", cacheID, "error", err)
+		return false
+	}
+
+	matches := hex.EncodeToString(hash.Sum(nil)) == sha256sum
+	span.SetAttributes(attribute.Bool("cache.pending_upload_matches", matches))
+
+	if !matches {
+		logging.FromContext(ctx).Info("pending upload doesn't match archive, uploading archive", "cache_id", cacheID)
+	}
+
+	return matches
+}
@@ -1,19 +1,32 @@
 package zstash
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/buildkite/zstash/api"
 	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
 	"github.com/buildkite/zstash/store"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 )
 
+// largestFilesReported is the number of files reported when an archive exceeds its size limit.
+const largestFilesReported = 5
+
 // Save saves a cache to storage by ID.
 //
 // The function performs the following workflow:
@@ -47,7 +60,84 @@ import (
 //	} else {
 //	    log.Printf("Cache saved: %s (%.2f MB)", result.Key, float64(result.Archive.Size)/(1024*1024))
 //	}
-func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
+//
+// Options such as WithForce change the behaviour of a single save:
+//
+//	result, err := cacheClient.Save(ctx, "node_modules", zstash.WithForce())
+func (c *Cache) Save(ctx context.Context, cacheID string, opts ...SaveOption) (SaveResult, error) {
+	return c.SaveWithOptions(ctx, cacheID, newSaveOptions(opts))
+}
+
+// SaveOptions controls the behaviour of SaveWithOptions, and of Save using
+// SaveOption functions.
+type SaveOptions struct {
+	// Force skips checking whether the cache already exists, uploading and
+	// committing the archive even if an entry exists for the key, which the
+	// cache API replaces where permitted. This repairs a key whose content is
+	// known to be corrupted, such as one produced by a buggy toolchain.
+	Force bool
+
+	// DryRun resolves the key and checks the cache paths and whether an entry
+	// exists, as a save would, without archiving or uploading anything. The
+	// result reports WouldSave when the cache would be saved. Dry runs aren't
+	// reported to Config.Reporter.
+	DryRun bool
+}
+
+// SaveWithOptions saves a cache to storage by ID, applying the supplied
+// options. See Save for details of the save workflow.
+//
+// Example:
+//
+//	result, err := cacheClient.SaveWithOptions(ctx, "node_modules", zstash.SaveOptions{
+//	    Force: true,
+//	})
+//	if err != nil {
+//	    log.Fatalf("Cache save failed: %v", err)
+//	}
+func (c *Cache) SaveWithOptions(ctx context.Context, cacheID string, opts SaveOptions) (SaveResult, error) {
+	return c.saveWithTarget(ctx, cacheID, saveTarget{}, opts)
+}
+
+// saveWithTarget saves a cache as SaveWithOptions does, under the key, branch
+// and paths of target.
+func (c *Cache) saveWithTarget(ctx context.Context, cacheID string, target saveTarget, opts SaveOptions) (SaveResult, error) {
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	c.emit(ctx, SaveStarted{EventInfo: newEventInfo(cacheID)})
+
+	result, err := c.save(ctx, cacheID, "", target, opts)
+	result.Stages = stages.finish(time.Now())
+	c.emit(ctx, SaveCompleted{EventInfo: newEventInfo(cacheID), Result: result, Err: err})
+	if !opts.DryRun {
+		c.reportSave(ctx, cacheID, result, err)
+	}
+
+	return result, err
+}
+
+// saveTarget overrides where a cache is saved, e.g. for archives imported from
+// another cache tool or promoted from another branch. Empty fields use the
+// cache's configuration.
+type saveTarget struct {
+	// key replaces the cache's configured key.
+	key string
+	// branch replaces the current branch, for caches scoped to a branch.
+	branch string
+	// paths replaces the cache's configured paths when not nil, e.g. to
+	// archive the paths shared by several caches once.
+	paths []string
+	// references records the entries holding paths left out of the archive.
+	references []archive.Reference
+	// shared saves an entry holding paths shared by several caches, which
+	// is only restored by its exact key, so without fallback keys.
+	shared bool
+}
+
+// save saves a cache, building an archive of its paths unless archivePath is
+// an existing archive to upload instead, under the key and branch of target.
+func (c *Cache) save(ctx context.Context, cacheID, archivePath string, target saveTarget, opts SaveOptions) (result SaveResult, err error) {
 	tracer := otel.Tracer("github.com/buildkite/zstash")
 	ctx, span := tracer.Start(ctx, "Cache.Save")
 	defer span.End()
@@ -59,10 +149,10 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 		attribute.String("cache.organization", c.organization),
 		attribute.String("cache.platform", c.platform),
 		attribute.String("cache.format", c.format),
+		attribute.Bool("cache.force", opts.Force),
 	)
 
 	startTime := time.Now()
-	result := SaveResult{}
 
 	// Find the cache configuration
 	cacheConfig, err := c.findCache(cacheID)
@@ -72,35 +162,104 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 		return result, err
 	}
 
+	if target.key != "" {
+		keyed := *cacheConfig
+		keyed.Key = target.key
+		cacheConfig = &keyed
+	}
+
+	if target.paths != nil {
+		pathed := *cacheConfig
+		pathed.Paths = target.paths
+		cacheConfig = &pathed
+	}
+
+	if target.shared {
+		exact := *cacheConfig
+		exact.FallbackKeys = nil
+		cacheConfig = &exact
+	}
+
 	result.Key = cacheConfig.Key
+	result.SharedPaths = referencedPaths(target.references)
 
 	span.SetAttributes(
 		attribute.String("cache.key", cacheConfig.Key),
 		attribute.String("cache.registry", c.registry),
 		attribute.Int("cache.paths_count", len(cacheConfig.Paths)),
 		attribute.Int("cache.fallback_keys_count", len(cacheConfig.FallbackKeys)),
+		attribute.String("cache.scope", string(cacheConfig.Scope)),
 	)
 
-	c.callProgress(cacheID, "validating", "Validating cache configuration", 0, 0)
+	ctx = trace.WithCache(ctx, cacheID, cacheConfig.Key, c.registry)
 
-	// Validate cache paths exist
-	if err := checkPathsExist(cacheConfig.Paths); err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "invalid cache paths")
-		return result, fmt.Errorf("invalid cache paths: %w", err)
+	scope := c.scopeFor(cacheConfig)
+	if target.branch != "" {
+		scope.branch = target.branch
 	}
 
-	c.callProgress(cacheID, "checking_exists", "Checking if cache already exists", 0, 0)
+	c.callProgress(ctx, cacheID, "validating", "Validating cache configuration", 0, 0)
 
-	// Check if cache already exists
-	_, exists, err := c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
-		Key:    cacheConfig.Key,
-		Branch: c.branch,
-	})
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to check cache existence")
-		return result, fmt.Errorf("failed to check cache existence: %w", err)
+	// Validate cache paths exist, unless they have already been archived
+	if archivePath == "" {
+		var (
+			paths, missing []string
+			err            error
+		)
+		// a cache sharing all of its paths archives only its references
+		if len(cacheConfig.Paths) > 0 || len(target.references) == 0 {
+			paths, missing, err = checkPaths(ctx, cacheID, cacheConfig.Paths, cacheConfig.MissingPaths)
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid cache paths")
+			return result, fmt.Errorf("invalid cache paths: %w", err)
+		}
+
+		result.MissingPaths = missing
+
+		// an archive referencing shared entries is saved even without paths
+		// of its own
+		if len(paths) == 0 && len(target.references) == 0 {
+			result.PathsMissing = true
+			logging.FromContext(ctx).Info("All cache paths are missing or empty, skipping save", "cache_id", cacheID, "paths", missing)
+			result.TotalDuration = time.Since(startTime)
+			span.SetAttributes(
+				attribute.Bool("cache.created", false),
+				attribute.Int("cache.missing_paths_count", len(missing)),
+				attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
+			)
+			span.SetStatus(codes.Ok, "no paths to save")
+			c.callProgress(ctx, cacheID, "complete", "No paths to save", 0, 0)
+			return result, nil
+		}
+
+		// only the remaining paths are archived and recorded on the entry
+		if len(missing) > 0 {
+			span.SetAttributes(attribute.Int("cache.missing_paths_count", len(missing)))
+
+			archived := *cacheConfig
+			archived.Paths = paths
+			cacheConfig = &archived
+		}
+	}
+
+	// Check if cache already exists, unless it is being overwritten
+	var exists bool
+	if opts.Force {
+		logging.FromContext(ctx).Info("skipping cache existence check, overwriting any existing cache", "cache_id", cacheID, "key", cacheConfig.Key)
+	} else {
+		c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache already exists", 0, 0)
+
+		_, exists, err = c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+			Key:    cacheConfig.Key,
+			Branch: scope.branch,
+		})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to check cache existence")
+			return result, fmt.Errorf("failed to check cache existence: %w", err)
+		}
 	}
 
 	if exists {
@@ -113,14 +272,28 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 			attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
 		)
 		span.SetStatus(codes.Ok, "cache already exists")
-		c.callProgress(cacheID, "complete", "Cache already exists", 0, 0)
+		c.callProgress(ctx, cacheID, "complete", "Cache already exists", 0, 0)
 		return result, nil
 	}
 
-	c.callProgress(cacheID, "fetching_registry", "Looking up cache registry", 0, 0)
+	// a dry run stops before anything is archived or uploaded
+	if opts.DryRun {
+		result.WouldSave = true
+		result.TotalDuration = time.Since(startTime)
+		span.SetAttributes(
+			attribute.Bool("cache.created", false),
+			attribute.Bool("cache.dry_run", true),
+			attribute.Int64("cache.duration_ms", result.TotalDuration.Milliseconds()),
+		)
+		span.SetStatus(codes.Ok, "dry run")
+		c.callProgress(ctx, cacheID, "complete", "Dry run, cache would be saved", 0, 0)
+		return result, nil
+	}
+
+	c.callProgress(ctx, cacheID, "fetching_registry", "Looking up cache registry", 0, 0)
 
 	// Get cache registry information
-	registryResp, err := c.client.CacheRegistry(ctx, c.registry)
+	registryResp, err := c.cacheRegistry(ctx)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to get cache registry")
@@ -132,20 +305,80 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 	)
 
 	// Validate cache store configuration
-	if err := validateCacheStore(registryResp.Store, c.bucketURL); err != nil {
+	if err := validateCacheStore(registryResp.Store, c.bucketURLFor(registryResp.Store)); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "invalid cache store configuration")
 		return result, fmt.Errorf("invalid cache store configuration: %w", err)
 	}
 
-	c.callProgress(cacheID, "building_archive", "Building archive", 0, len(cacheConfig.Paths))
+	// archives given to SaveFromArchive are already built, so are saved in the
+	// format they were built in, and tar.gz archives are built for
+	// compatibility with other tools, so are saved as configured
+	result.Compression = c.format
+	var compression string
+	if archivePath != "" {
+		result.Compression, err = detectArchiveFormat(archivePath)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to inspect archive")
+			return result, fmt.Errorf("failed to inspect archive: %w", err)
+		}
+	} else if c.format == archive.FormatZip {
+		compression = negotiateCompression(registryResp)
+	}
+	if compression != "" {
+		result.Compression = compression
+	}
+
+	span.SetAttributes(attribute.String("cache.compression", result.Compression))
 
-	// Build archive
-	archiveInfo, err := archive.BuildArchive(ctx, cacheConfig.Paths, cacheConfig.Key)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to build archive")
-		return result, fmt.Errorf("failed to build archive: %w", err)
+	var archiveInfo *archive.ArchiveInfo
+	if archivePath != "" {
+		c.callProgress(ctx, cacheID, "inspecting_archive", "Inspecting archive", 0, 0)
+
+		archiveInfo, err = archive.InspectArchive(ctx, archivePath)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to inspect archive")
+			return result, fmt.Errorf("failed to inspect archive: %w", err)
+		}
+	} else {
+		// Wait for other archives being built by SaveAll
+		var releaseBuild func()
+		releaseBuild, err = acquireBuild(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to build archive")
+			return result, fmt.Errorf("failed to build archive: %w", err)
+		}
+
+		c.callProgress(ctx, cacheID, "building_archive", "Building archive", 0, len(cacheConfig.Paths))
+		c.emit(ctx, ArchiveStarted{EventInfo: newEventInfo(cacheID), Paths: cacheConfig.Paths})
+
+		// Build archive
+		archiveInfo, err = archive.BuildArchiveWithOptions(ctx, cacheConfig.Paths, cacheConfig.Key, archive.BuildOptions{
+			PreserveMtimes:  cacheConfig.PreserveMtimes,
+			Precompressed:   cacheConfig.Precompressed,
+			Manifest:        cacheConfig.Manifest,
+			Ignore:          cacheConfig.Ignore,
+			NoDefaultIgnore: cacheConfig.NoDefaultIgnore,
+			Deflate:         compression == CompressionZip,
+			Format:          c.format,
+			ExternalZstd:    c.externalZstd,
+			References:      target.references,
+		})
+		releaseBuild()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to build archive")
+			return result, fmt.Errorf("failed to build archive: %w", err)
+		}
+
+		// Remove the archive once saved, or keep it for inspection if
+		// configured, including when the save fails
+		defer func() {
+			result.KeptArchivePath = c.cleanupArchive(ctx, cacheID, archiveInfo.ArchivePath)
+		}()
 	}
 
 	// Populate archive metrics
@@ -157,8 +390,11 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 		Sha256Sum:        archiveInfo.Sha256sum,
 		Duration:         archiveInfo.Duration,
 		Paths:            cacheConfig.Paths,
+		PathStats:        archiveInfo.PathStats,
 	}
 
+	c.emit(ctx, ArchiveBuilt{EventInfo: newEventInfo(cacheID), Archive: result.Archive})
+
 	span.SetAttributes(
 		attribute.Int64("cache.archive_size_bytes", archiveInfo.Size),
 		attribute.Int64("cache.written_bytes", archiveInfo.WrittenBytes),
@@ -166,22 +402,34 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 		attribute.Float64("cache.compression_ratio", result.Archive.CompressionRatio),
 		attribute.String("cache.sha256sum", archiveInfo.Sha256sum),
 	)
+	trace.RecordArchiveBytes(ctx, archiveInfo.Size)
+
+	// Check the archive is within the size limit before uploading
+	if err := c.checkArchiveSize(cacheConfig, archiveInfo); err != nil {
+		if !c.warnOnArchiveSizeLimit {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "archive exceeds size limit")
+			return result, err
+		}
+		logging.FromContext(ctx).Warn("archive exceeds size limit, saving anyway", "cache_id", cacheID, "error", err)
+	}
 
-	c.callProgress(cacheID, "creating_entry", "Creating cache entry", 0, 0)
+	c.callProgress(ctx, cacheID, "creating_entry", "Creating cache entry", 0, 0)
 
 	// Create cache entry
 	createResp, err := c.client.CacheCreate(ctx, registryResp.Name, api.CacheCreateReq{
 		Key:          cacheConfig.Key,
 		FallbackKeys: cacheConfig.FallbackKeys,
-		Compression:  c.format,
+		Compression:  result.Compression,
 		FileSize:     int(archiveInfo.Size),
 		Digest:       fmt.Sprintf("sha256:%s", archiveInfo.Sha256sum),
 		Paths:        cacheConfig.Paths,
 		Platform:     c.platform,
-		Pipeline:     c.pipeline,
-		Branch:       c.branch,
-		Organization: c.organization,
+		Pipeline:     scope.pipeline,
+		Branch:       scope.branch,
+		Organization: scope.organization,
 		Store:        registryResp.Store,
+		Force:        opts.Force,
 	})
 	if err != nil {
 		span.RecordError(err)
@@ -196,21 +444,64 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 		attribute.String("cache.object_name", createResp.StoreObjectName),
 	)
 
-	c.callProgress(cacheID, "uploading", "Uploading cache archive", 0, int(archiveInfo.Size))
+	c.callProgress(ctx, cacheID, "uploading", "Uploading cache archive", 0, int(archiveInfo.Size))
+	c.emit(ctx, UploadStarted{EventInfo: newEventInfo(cacheID), Size: archiveInfo.Size})
 
 	// Upload archive
-	blobStore, err := store.NewBlobStore(ctx, registryResp.Store, c.bucketURL)
+	blobStore, err := c.newBlobStore(ctx, registryResp.Store, store.PresignedURLs{
+		Upload: presignedURL(createResp.UploadInstructions),
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create blob store")
+		return result, fmt.Errorf("failed to create blob store: %w: %w", ErrStoreUnavailable, err)
+	}
+
+	// Check the capabilities of the store itself, as the prefixed store
+	// forwards to it
+	_, canHead := blobStore.(store.HeadBlob)
+
+	blobStore, err = c.withKeyPrefix(blobStore)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to create blob store")
 		return result, fmt.Errorf("failed to create blob store: %w", err)
 	}
 
-	transferInfo, err := blobStore.Upload(ctx, archiveInfo.ArchivePath, createResp.StoreObjectName)
+	if c.chunkedStorage {
+		if canHead {
+			blobStore = store.NewChunkedBlob(blobStore)
+		} else {
+			logging.FromContext(ctx).Warn("store does not support chunked storage, uploading archive as a single object",
+				"cache_id", cacheID,
+				"store", registryResp.Store,
+			)
+		}
+	}
+
+	uploadCtx, cancelUpload := withTransferTimeout(store.WithTransferLimiter(ctx, c.transferLimiter), c.uploadTimeout)
+	defer cancelUpload()
+
+	var transferInfo *store.TransferInfo
+	if createResp.Pending && c.pendingUploadMatches(uploadCtx, cacheID, blobStore, createResp, archiveInfo.Sha256sum) {
+		// An earlier save uploaded this archive but was interrupted before
+		// committing, so commit it rather than uploading it again
+		result.UploadResumed = true
+		transferInfo = &store.TransferInfo{}
+	} else {
+		watchCtx, stopWatching := c.watchTransfer(uploadCtx, cacheID, "uploading", archiveInfo.Size)
+		if expiringStore, ok := blobStore.(store.ExpiringBlob); ok && !createResp.ExpiresAt.IsZero() {
+			transferInfo, err = expiringStore.UploadWithExpiry(watchCtx, archiveInfo.ArchivePath, createResp.StoreObjectName, createResp.ExpiresAt)
+		} else {
+			transferInfo, err = blobStore.Upload(watchCtx, archiveInfo.ArchivePath, createResp.StoreObjectName)
+		}
+		stopWatching(err == nil)
+	}
 	if err != nil {
+		err = transferTimeoutError(ctx, uploadCtx, c.uploadTimeout, err)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to upload cache")
-		return result, fmt.Errorf("failed to upload cache: %w", err)
+		return result, fmt.Errorf("%w: %w", ErrUploadFailed, err)
 	}
 
 	// Populate transfer metrics
@@ -221,15 +512,22 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 		RequestID:        transferInfo.RequestID,
 		PartCount:        transferInfo.PartCount,
 		Concurrency:      transferInfo.Concurrency,
+		ChunkCount:       transferInfo.ChunkCount,
+		ReusedChunks:     transferInfo.ReusedChunks,
 	}
 
+	c.emit(ctx, Uploaded{EventInfo: newEventInfo(cacheID), Transfer: *result.Transfer})
+
 	span.SetAttributes(
 		attribute.Int64("cache.transfer_bytes", transferInfo.BytesTransferred),
 		attribute.Float64("cache.transfer_speed_mbps", transferInfo.TransferSpeed),
 		attribute.String("cache.request_id", transferInfo.RequestID),
 	)
+	if !result.UploadResumed {
+		trace.RecordTransfer(ctx, trace.TransferUpload, transferInfo.BytesTransferred, transferInfo.Duration)
+	}
 
-	c.callProgress(cacheID, "committing", "Committing cache entry", 0, 0)
+	c.callProgress(ctx, cacheID, "committing", "Committing cache entry", 0, 0)
 
 	// Commit cache
 	_, err = c.client.CacheCommit(ctx, c.registry, api.CacheCommitReq{
@@ -244,6 +542,8 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 	result.CacheCreated = true
 	result.TotalDuration = time.Since(startTime)
 
+	c.emit(ctx, Committed{EventInfo: newEventInfo(cacheID), Key: cacheConfig.Key, UploadID: createResp.UploadID})
+
 	// Add final result attributes to span
 	span.SetAttributes(
 		attribute.Bool("cache.created", true),
@@ -251,34 +551,296 @@ func (c *Cache) Save(ctx context.Context, cacheID string) (SaveResult, error) {
 	)
 	span.SetStatus(codes.Ok, "cache saved successfully")
 
-	c.callProgress(cacheID, "complete", "Cache saved successfully", 0, 0)
+	c.callProgress(ctx, cacheID, "complete", "Cache saved successfully", 0, 0)
 
 	return result, nil
 }
 
-// checkPathsExist validates that all paths exist on the filesystem
-func checkPathsExist(paths []string) error {
+// SaveIfChanged saves a cache to storage by ID, unless its paths are unchanged
+// since they were last restored by this client.
+//
+// After a successful Restore, the client records a fingerprint of the cache
+// paths (file names, sizes, modes and modification times). SaveIfChanged
+// compares the current state of the paths against that fingerprint and skips
+// archiving and uploading entirely when they match, returning a SaveResult with
+// Unchanged=true and CacheCreated=false.
+//
+// If the cache was not restored by this client, was restored from a fallback
+// key or another key than it now has, or the paths have changed, this behaves
+// exactly like Save, so the exact key is created.
+//
+// Example:
+//
+//	result, err := cacheClient.SaveIfChanged(ctx, "node_modules")
+//	if err != nil {
+//	    log.Fatalf("Cache save failed: %v", err)
+//	}
+//	if result.Unchanged {
+//	    log.Printf("Cache unchanged since restore, skipped save for key: %s", result.Key)
+//	}
+func (c *Cache) SaveIfChanged(ctx context.Context, cacheID string) (SaveResult, error) {
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	c.emit(ctx, SaveStarted{EventInfo: newEventInfo(cacheID)})
+
+	result, err := c.saveIfChanged(ctx, cacheID)
+	result.Stages = stages.finish(time.Now())
+	c.emit(ctx, SaveCompleted{EventInfo: newEventInfo(cacheID), Result: result, Err: err})
+	c.reportSave(ctx, cacheID, result, err)
+
+	return result, err
+}
+
+func (c *Cache) saveIfChanged(ctx context.Context, cacheID string) (SaveResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.SaveIfChanged")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("cache.id", cacheID))
+
+	startTime := time.Now()
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return SaveResult{}, err
+	}
+
+	// an entry restored from another key, such as a fallback key, doesn't
+	// hold the paths under the cache's key
+	if restored, ok := c.restoredFingerprint(cacheID); ok && restored.key == cacheConfig.Key {
+		current, err := fingerprintPaths(ctx, cacheConfig.Paths)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to fingerprint cache paths")
+			return SaveResult{Key: cacheConfig.Key}, fmt.Errorf("failed to fingerprint cache paths: %w", err)
+		}
+
+		if current == restored.sum {
+			result := SaveResult{
+				Key:           cacheConfig.Key,
+				Unchanged:     true,
+				TotalDuration: time.Since(startTime),
+			}
+			span.SetAttributes(
+				attribute.Bool("cache.created", false),
+				attribute.Bool("cache.unchanged", true),
+				attribute.String("cache.restored_key", restored.key),
+			)
+			span.SetStatus(codes.Ok, "cache unchanged since restore")
+			c.callProgress(ctx, cacheID, "complete", "Cache unchanged since restore", 0, 0)
+			return result, nil
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("cache.unchanged", false))
+
+	return c.save(ctx, cacheID, "", saveTarget{}, SaveOptions{})
+}
+
+// cleanupArchive removes the archive built by a save, unless KeepArchiveDir is
+// configured in which case it is moved there and the new path returned.
+// Failures are logged rather than failing the save.
+func (c *Cache) cleanupArchive(ctx context.Context, cacheID, archivePath string) string {
+	if c.keepArchiveDir == "" {
+		if err := os.Remove(archivePath); err != nil {
+			logging.FromContext(ctx).Warn("failed to remove archive", "cache_id", cacheID, "path", archivePath, "error", err)
+		}
+		return ""
+	}
+
+	keptPath := filepath.Join(c.keepArchiveDir, cacheID+"."+c.format)
+	if err := moveFile(archivePath, keptPath); err != nil {
+		logging.FromContext(ctx).Warn("failed to keep archive", "cache_id", cacheID, "path", keptPath, "error", err)
+		_ = os.Remove(archivePath)
+		return ""
+	}
+
+	return keptPath
+}
+
+// moveFile moves a file, copying it if it can't be renamed, e.g. as the
+// destination is on another filesystem.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return fmt.Errorf("failed to copy archive: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// checkArchiveSize returns an *ArchiveSizeError if the archive exceeds the
+// cache's MaxSize, or the client's MaxArchiveSize if the cache has no limit.
+func (c *Cache) checkArchiveSize(cacheConfig *cache.Cache, archiveInfo *archive.ArchiveInfo) error {
+	limit := c.maxArchiveSize
+	if cacheConfig.MaxSize > 0 {
+		limit = cacheConfig.MaxSize
+	}
+
+	if limit <= 0 || archiveInfo.Size <= limit {
+		return nil
+	}
+
+	return &ArchiveSizeError{
+		CacheID:      cacheConfig.ID,
+		Size:         archiveInfo.Size,
+		Limit:        limit,
+		LargestFiles: largestFiles(archiveInfo.PathStats),
+	}
+}
+
+// largestFiles returns the largest files across all paths, largest first.
+func largestFiles(pathStats []archive.PathStats) []archive.FileStats {
+	var files []archive.FileStats
+	for _, stats := range pathStats {
+		files = append(files, stats.LargestFiles...)
+	}
+
+	slices.SortStableFunc(files, func(a, b archive.FileStats) int {
+		return cmp.Compare(b.Size, a.Size)
+	})
+
+	if len(files) > largestFilesReported {
+		files = files[:largestFilesReported]
+	}
+
+	return files
+}
+
+// checkPaths checks the cache paths before they're archived, returning the
+// paths to archive and those left out as missing or empty under the cache's
+// MissingPaths policy.
+func checkPaths(ctx context.Context, cacheID string, paths []string, policy cache.MissingPathsPolicy) (archived, missing []string, err error) {
 	if len(paths) == 0 {
-		return fmt.Errorf("no paths provided")
+		return nil, nil, fmt.Errorf("no paths provided")
 	}
 
 	for _, path := range paths {
-		// Handle ~ expansion
-		if len(path) > 0 && path[0] == '~' {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				return fmt.Errorf("failed to get home directory: %w", err)
+		resolvedPath, err := archive.ResolveHomeDir(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get home directory: %w", err)
+		}
+
+		exists, empty, err := pathContents(resolvedPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case exists && !empty:
+			archived = append(archived, path)
+		case exists && policy == "":
+			// empty directories are archived unless a policy is set
+			archived = append(archived, path)
+		case policy == "" || policy == cache.MissingPathsError:
+			if !exists {
+				return nil, nil, fmt.Errorf("path does not exist: %s", resolvedPath)
 			}
-			path = homeDir + path[1:]
+			return nil, nil, fmt.Errorf("path is empty: %s", resolvedPath)
+		case policy == cache.MissingPathsWarn:
+			logging.FromContext(ctx).Warn("Cache path is missing or empty, leaving it out of the archive", "cache_id", cacheID, "path", path, "exists", exists)
+			missing = append(missing, path)
+		default:
+			logging.FromContext(ctx).Debug("Cache path is missing or empty, leaving it out of the archive", "cache_id", cacheID, "path", path, "exists", exists)
+			missing = append(missing, path)
 		}
+	}
+
+	return archived, missing, nil
+}
+
+// pathContents reports whether a path exists, and if so whether it's an empty
+// directory.
+func pathContents(path string) (exists, empty bool, err error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	if !info.IsDir() {
+		return true, false, nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return true, false, fmt.Errorf("failed to open path: %w", err)
+	}
+	defer dir.Close()
+
+	if _, err := dir.Readdirnames(1); errors.Is(err, io.EOF) {
+		return true, true, nil
+	} else if err != nil {
+		return true, false, fmt.Errorf("failed to read path: %w", err)
+	}
+
+	return true, false, nil
+}
 
-		// Check if the path exists
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return fmt.Errorf("path does not exist: %s", path)
+// newBlobStore creates the blob store for the store type. Objects in the
+// Buildkite hosted store are transferred using the presigned URLs returned by
+// the cache API, other stores use the bucket URL for the store type.
+func (c *Cache) newBlobStore(ctx context.Context, storeType string, urls store.PresignedURLs) (store.Blob, error) {
+	if storeType == store.BuildkiteHostedStore {
+		var client *http.Client
+		if c.transport != nil {
+			client = &http.Client{Transport: c.transport}
 		}
+		return store.NewPresignedBlob(urls, client)
 	}
 
-	return nil
+	return store.NewBlobStore(store.WithTransport(ctx, c.transport), storeType, c.bucketURLFor(storeType))
+}
+
+// presignedURL returns the presigned URL from the upload or download
+// instructions of a cache entry, which hold a single URL for the Buildkite
+// hosted store.
+func presignedURL(instructions []string) string {
+	if len(instructions) == 0 {
+		return ""
+	}
+
+	return instructions[0]
+}
+
+// withKeyPrefix wraps the blob store to store objects under the configured key
+// prefix, if any.
+func (c *Cache) withKeyPrefix(blobStore store.Blob) (store.Blob, error) {
+	if c.keyPrefix == "" {
+		return blobStore, nil
+	}
+
+	return store.NewPrefixedBlob(blobStore, c.keyPrefix)
 }
 
 // validateCacheStore validates the cache store configuration
@@ -293,6 +855,10 @@ func validateCacheStore(storeType string, bucketURL string) error {
 			return fmt.Errorf("bucket URL is required for S3 store")
 		}
 		// Note: We allow both s3:// and file:// for S3 store (file:// is for local testing)
+	case store.LocalHTTPStore:
+		if bucketURL == "" {
+			return fmt.Errorf("bucket URL is required for HTTP store")
+		}
 	case store.LocalHostedAgents:
 		if bucketURL != "" {
 			return fmt.Errorf("NSC store should not have bucket URL set, got: %s", bucketURL)
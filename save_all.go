@@ -0,0 +1,310 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultSaveConcurrency is the number of caches saved concurrently by SaveAll
+// when SaveAllOptions.Concurrency isn't set.
+const DefaultSaveConcurrency = 4
+
+// DefaultSaveBuildConcurrency is the number of archives built concurrently by
+// SaveAll when SaveAllOptions.BuildConcurrency isn't set. It is lower than
+// DefaultSaveConcurrency as building archives is CPU bound, while uploading
+// them is network bound.
+const DefaultSaveBuildConcurrency = 2
+
+// SaveAllOptions controls the behaviour of SaveAll.
+type SaveAllOptions struct {
+	// Concurrency is the maximum number of caches saved at once, whether
+	// building, uploading or committing. Defaults to DefaultSaveConcurrency.
+	Concurrency int
+
+	// BuildConcurrency is the maximum number of archives built at once.
+	// Caches which have been archived are uploaded while the archives of
+	// other caches are built, so saves are pipelined rather than each
+	// waiting for the network. Defaults to DefaultSaveBuildConcurrency, and
+	// is limited to Concurrency.
+	BuildConcurrency int
+
+	// ExcludeIDs are patterns of cache IDs which aren't saved, even if they
+	// match the requested IDs.
+	ExcludeIDs []string
+
+	// KeepGoing continues saving the remaining caches when one fails, rather
+	// than cancelling them. The errors of every failed cache are joined and
+	// returned, and listed by ErrorTable.
+	KeepGoing bool
+
+	// SharePaths archives paths configured identically by several of the
+	// caches once, in an entry shared by them, rather than in each cache's
+	// archive. Each cache's archive references the shared entry, which is
+	// restored along with it. Paths are only shared between caches with the
+	// same scope and archive options, and only in zip archives. Entries
+	// referencing shared entries can only be fully restored by clients which
+	// support references.
+	SharePaths bool
+
+	// Save is applied to each cache.
+	Save SaveOptions
+}
+
+// CacheSaveResult is the outcome of saving one cache in SaveAll.
+type CacheSaveResult struct {
+	// CacheID is the ID of the saved cache.
+	CacheID string
+
+	// Result contains the save metrics, valid when Err is nil.
+	Result SaveResult
+
+	// Err is the error which caused the save to fail, or nil.
+	Err error
+}
+
+// Status returns "created" when a new cache entry was uploaded, "exists" when
+// the cache entry already existed, "unchanged" when SaveIfChanged skipped the
+// save, "missing" when every cache path was missing or empty and "error" when
+// the save failed.
+func (r CacheSaveResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return "error"
+	case r.Result.CacheCreated:
+		return "created"
+	case r.Result.Unchanged:
+		return "unchanged"
+	case r.Result.PathsMissing:
+		return "missing"
+	default:
+		return "exists"
+	}
+}
+
+// SaveAllResult contains the results of SaveAll.
+type SaveAllResult struct {
+	// Caches holds a result for each cache, in the order requested. Caches
+	// which weren't attempted because an earlier save failed are omitted,
+	// unless SaveAllOptions.KeepGoing is set.
+	Caches []CacheSaveResult
+
+	// Shared holds a result for each entry holding paths shared by several
+	// caches, with SaveAllOptions.SharePaths. CacheID lists the caches
+	// sharing the entry joined with "+". A shared entry which fails to save
+	// doesn't fail SaveAll, as the caches archive the paths themselves.
+	Shared []CacheSaveResult
+}
+
+// Summary returns a single line listing the status of each cache, such as
+// "node_modules=created go=exists", for scripts to parse.
+func (r SaveAllResult) Summary() string {
+	fields := make([]string, 0, len(r.Caches))
+	for _, result := range r.Caches {
+		fields = append(fields, result.CacheID+"="+result.Status())
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// Err returns the errors of the caches which failed joined together, each
+// naming its cache, or nil if none failed.
+func (r SaveAllResult) Err() error {
+	var errs []error
+	for _, result := range r.Caches {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to save cache %s: %w", result.CacheID, result.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ErrorTable renders the caches which failed as a table with a row for each
+// cache and its error, or returns an empty string if none failed:
+//
+//	CACHE  ERROR
+//	gems   failed to download cache: connection reset
+func (r SaveAllResult) ErrorTable() string {
+	var failed []cacheError
+	for _, result := range r.Caches {
+		if result.Err != nil {
+			failed = append(failed, cacheError{cacheID: result.CacheID, err: result.Err})
+		}
+	}
+
+	return renderErrorTable(failed)
+}
+
+// SaveAll saves several caches concurrently. cacheIDs may be patterns such as
+// "node_*", see MatchCacheIDs. If cacheIDs is empty, all of the client's
+// caches are saved, other than those matching SaveAllOptions.ExcludeIDs.
+//
+// Each cache is saved as with SaveWithOptions using SaveAllOptions.Save.
+// With SaveAllOptions.SharePaths, paths configured by several caches are
+// first saved once in a shared entry, and left out of each cache's archive.
+// Archive building and uploading are pipelined: up to
+// SaveAllOptions.BuildConcurrency archives are built at once, and the
+// remaining caches in flight upload and commit archives already built, so the
+// total time approaches that of the slowest stage rather than the sum of the
+// stages.
+//
+// The first failure cancels the remaining saves, and is returned along with
+// the results of the caches saved so far. With SaveAllOptions.KeepGoing, the
+// remaining caches are still saved, and the errors of all of the failed caches
+// are returned, see SaveAllResult.ErrorTable.
+//
+// Example:
+//
+//	results, err := cacheClient.SaveAll(ctx, nil, zstash.SaveAllOptions{})
+//	if err != nil {
+//	    log.Fatalf("Cache save failed: %v", err)
+//	}
+//	fmt.Println(results.Aggregate())
+func (c *Cache) SaveAll(ctx context.Context, cacheIDs []string, opts SaveAllOptions) (SaveAllResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.SaveAll")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	cacheIDs, err := c.MatchCacheIDs(cacheIDs, opts.ExcludeIDs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid save options")
+		return SaveAllResult{}, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultSaveConcurrency
+	}
+
+	buildConcurrency := opts.BuildConcurrency
+	if buildConcurrency == 0 {
+		buildConcurrency = DefaultSaveBuildConcurrency
+	}
+	buildConcurrency = min(buildConcurrency, concurrency)
+
+	span.SetAttributes(
+		attribute.StringSlice("cache.ids", cacheIDs),
+		attribute.Int("cache.concurrency", concurrency),
+		attribute.Int("cache.build_concurrency", buildConcurrency),
+	)
+
+	if opts.BuildConcurrency < 0 {
+		err := fmt.Errorf("build concurrency must be non-negative, got %d", opts.BuildConcurrency)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid save options")
+		return SaveAllResult{}, err
+	}
+
+	if err := c.validateCacheIDs(cacheIDs, opts.Concurrency); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid save options")
+		return SaveAllResult{}, err
+	}
+
+	ctx = withBuildLimiter(ctx, buildConcurrency)
+
+	var (
+		targets map[string]saveTarget
+		shared  []CacheSaveResult
+	)
+	if opts.SharePaths {
+		targets, shared, err = c.saveSharedPaths(ctx, cacheIDs, opts.Save)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to save shared paths")
+			return SaveAllResult{Shared: shared}, err
+		}
+
+		span.SetAttributes(attribute.Int("cache.shared_entries", len(shared)))
+	}
+
+	results := make([]CacheSaveResult, len(cacheIDs))
+	attempted := make([]bool, len(cacheIDs))
+
+	// with KeepGoing, failures don't cancel the remaining saves
+	wg, wctx := &errgroup.Group{}, ctx
+	if !opts.KeepGoing {
+		wg, wctx = errgroup.WithContext(ctx)
+	}
+	wg.SetLimit(concurrency)
+
+	for i, cacheID := range cacheIDs {
+		if wctx.Err() != nil {
+			break
+		}
+
+		attempted[i] = true
+
+		wg.Go(func() error {
+			result, err := c.saveWithTarget(wctx, cacheID, targets[cacheID], opts.Save)
+			results[i] = CacheSaveResult{CacheID: cacheID, Result: result, Err: err}
+			if err != nil {
+				return fmt.Errorf("failed to save cache %s: %w", cacheID, err)
+			}
+			return nil
+		})
+	}
+
+	err = wg.Wait()
+
+	allResult := SaveAllResult{Shared: shared}
+	for i, result := range results {
+		if attempted[i] {
+			allResult.Caches = append(allResult.Caches, result)
+		}
+	}
+
+	if opts.KeepGoing {
+		err = allResult.Err()
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to save caches")
+		return allResult, err
+	}
+
+	span.SetStatus(codes.Ok, "caches saved")
+
+	return allResult, nil
+}
+
+// buildLimiter limits the number of archives built at once by the saves using
+// it, so archives already built can be uploaded while others are built.
+type buildLimiter chan struct{}
+
+type buildLimiterKey struct{}
+
+// withBuildLimiter returns a context which limits the saves using it to
+// building n archives at once.
+func withBuildLimiter(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, buildLimiterKey{}, make(buildLimiter, n))
+}
+
+// acquireBuild waits until an archive can be built under the limiter of ctx,
+// if any, returning a function to call once it has been built, or an error if
+// ctx is cancelled first.
+func acquireBuild(ctx context.Context) (func(), error) {
+	limiter, ok := ctx.Value(buildLimiterKey{}).(buildLimiter)
+	if !ok {
+		return func() {}, nil
+	}
+
+	select {
+	case limiter <- struct{}{}:
+		return func() { <-limiter }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,38 @@
+package zstash
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireBuild(t *testing.T) {
+	ctx := withBuildLimiter(context.Background(), 1)
+
+	release, err := acquireBuild(ctx)
+	require.NoError(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = acquireBuild(cancelled)
+	require.ErrorIs(t, err, context.Canceled)
+
+	release()
+
+	release, err = acquireBuild(ctx)
+	require.NoError(t, err)
+	release()
+}
+
+func TestAcquireBuild_Unlimited(t *testing.T) {
+	ctx := context.Background()
+
+	for range 3 {
+		release, err := acquireBuild(ctx)
+		require.NoError(t, err)
+		assert.NotNil(t, release)
+	}
+}
@@ -0,0 +1,53 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// SaveFromArchive saves a cache by uploading an existing archive file rather
+// than building one from the cache paths, e.g. an archive built by custom
+// tooling in an earlier step. The archive is uploaded under the cache's
+// resolved key, with its size and digest computed locally. It must be a zip
+// or tar.gz archive, whose entries are restored relative to the cache paths in
+// the same way as an archive built by Save.
+//
+// The workflow is otherwise the same as Save, including returning early if
+// the cache already exists. The archive file is left in place.
+//
+// Example:
+//
+//	result, err := cacheClient.SaveFromArchive(ctx, "node_modules", "build/node_modules.zip")
+//	if err != nil {
+//	    log.Fatalf("Cache save failed: %v", err)
+//	}
+func (c *Cache) SaveFromArchive(ctx context.Context, cacheID, archivePath string) (SaveResult, error) {
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	c.emit(ctx, SaveStarted{EventInfo: newEventInfo(cacheID)})
+
+	result, err := c.save(ctx, cacheID, archivePath, saveTarget{}, SaveOptions{})
+	result.Stages = stages.finish(time.Now())
+	c.emit(ctx, SaveCompleted{EventInfo: newEventInfo(cacheID), Result: result, Err: err})
+	c.reportSave(ctx, cacheID, result, err)
+
+	return result, err
+}
+
+// detectArchiveFormat returns the format of the archive file at path, see
+// archive.DetectFormat.
+func detectArchiveFormat(path string) (string, error) {
+	f, err := os.Open(path) // #nosec G304 -- path is the archive being saved
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	return archive.DetectFormat(f)
+}
@@ -0,0 +1,341 @@
+package zstash
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// sharedKeyPrefix is the prefix of the keys of entries holding paths shared
+// by several caches.
+const sharedKeyPrefix = "shared-"
+
+// sharedPaths is a set of paths configured by several caches, which SaveAll
+// archives once in a shared entry with SaveAllOptions.SharePaths.
+type sharedPaths struct {
+	cacheIDs []string
+	keys     []string
+	paths    []string
+}
+
+// key returns the key of the entry holding the shared paths, derived from the
+// keys of the caches sharing them so it changes whenever any of theirs do.
+func (s sharedPaths) key() string {
+	hash := sha256.New()
+	for _, key := range s.keys {
+		_, _ = fmt.Fprintf(hash, "key\x00%s\n", key)
+	}
+	for _, path := range s.paths {
+		_, _ = fmt.Fprintf(hash, "path\x00%s\n", path)
+	}
+
+	return sharedKeyPrefix + hex.EncodeToString(hash.Sum(nil))
+}
+
+// findSharedPaths returns the paths configured by more than one of the
+// caches, grouped by the caches configuring them. Paths are only shared
+// between caches which archive them the same way and have the same scope, as
+// each cache restores the shared entry from its own scope.
+func (c *Cache) findSharedPaths(cacheIDs []string) ([]sharedPaths, error) {
+	configs := make(map[string]*cache.Cache, len(cacheIDs))
+	owners := make(map[string][]string)
+
+	var order []string
+	for _, cacheID := range cacheIDs {
+		cacheConfig, err := c.findCache(cacheID)
+		if err != nil {
+			return nil, err
+		}
+		configs[cacheID] = cacheConfig
+
+		for _, path := range cacheConfig.Paths {
+			if _, ok := owners[path]; !ok {
+				order = append(order, path)
+			}
+			if !slices.Contains(owners[path], cacheID) {
+				owners[path] = append(owners[path], cacheID)
+			}
+		}
+	}
+
+	var groups []sharedPaths
+	index := make(map[string]int)
+	for _, path := range order {
+		cacheIDs := owners[path]
+		if len(cacheIDs) < 2 || !c.shareable(configs, cacheIDs) {
+			continue
+		}
+
+		group := strings.Join(cacheIDs, "\x00")
+		i, ok := index[group]
+		if !ok {
+			i = len(groups)
+			index[group] = i
+
+			shared := sharedPaths{cacheIDs: cacheIDs}
+			for _, cacheID := range cacheIDs {
+				shared.keys = append(shared.keys, configs[cacheID].Key)
+			}
+			groups = append(groups, shared)
+		}
+
+		groups[i].paths = append(groups[i].paths, path)
+	}
+
+	return groups, nil
+}
+
+// shareable reports whether the caches can share an entry, as they archive
+// paths with the same options and restore entries from the same scope.
+func (c *Cache) shareable(configs map[string]*cache.Cache, cacheIDs []string) bool {
+	first := configs[cacheIDs[0]]
+	for _, cacheID := range cacheIDs[1:] {
+		other := configs[cacheID]
+		if c.scopeFor(first) != c.scopeFor(other) ||
+			first.PreserveMtimes != other.PreserveMtimes ||
+			first.Precompressed != other.Precompressed ||
+			first.NoDefaultIgnore != other.NoDefaultIgnore ||
+			!slices.Equal(first.Ignore, other.Ignore) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveSharedPaths saves an entry for each set of paths shared by the caches,
+// returning the save target of each cache sharing paths, which leaves them out
+// of its archive and references the shared entry instead. Shared entries are
+// saved using the configuration of the first cache sharing them, without its
+// fallback keys. Paths which aren't archived in a shared entry, e.g. as they
+// are missing or it failed to save, are archived by each cache itself.
+func (c *Cache) saveSharedPaths(ctx context.Context, cacheIDs []string, opts SaveOptions) (map[string]saveTarget, []CacheSaveResult, error) {
+	if c.format != "" && c.format != archive.FormatZip {
+		logging.FromContext(ctx).Warn("shared paths are only supported in zip archives, archiving them with each cache", "format", c.format)
+		return nil, nil, nil
+	}
+
+	groups, err := c.findSharedPaths(cacheIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets := make(map[string]saveTarget)
+
+	var results []CacheSaveResult
+	for _, group := range groups {
+		key := group.key()
+
+		result, err := c.save(ctx, group.cacheIDs[0], "", saveTarget{key: key, paths: group.paths, shared: true}, opts)
+		results = append(results, CacheSaveResult{CacheID: strings.Join(group.cacheIDs, "+"), Result: result, Err: err})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, results, ctx.Err()
+			}
+
+			logging.FromContext(ctx).Warn("failed to save shared paths, archiving them with each cache",
+				"cache_ids", group.cacheIDs,
+				"paths", group.paths,
+				"error", err,
+			)
+			continue
+		}
+
+		if result.PathsMissing {
+			continue
+		}
+
+		// paths left out under the MissingPaths policy aren't in the entry
+		archived := withoutPaths(group.paths, result.MissingPaths)
+
+		for _, cacheID := range group.cacheIDs {
+			target := targets[cacheID]
+			target.references = append(target.references, archive.Reference{Key: key, Paths: archived})
+			targets[cacheID] = target
+		}
+	}
+
+	for cacheID, target := range targets {
+		cacheConfig, err := c.findCache(cacheID)
+		if err != nil {
+			return nil, results, err
+		}
+
+		// not nil, so a cache sharing every path archives none itself
+		target.paths = append([]string{}, withoutPaths(cacheConfig.Paths, referencedPaths(target.references))...)
+		targets[cacheID] = target
+	}
+
+	return targets, results, nil
+}
+
+// referencedPaths returns the paths held by the referenced entries.
+func referencedPaths(references []archive.Reference) []string {
+	var paths []string
+	for _, reference := range references {
+		paths = append(paths, reference.Paths...)
+	}
+
+	return paths
+}
+
+// sharedArchive is a downloaded entry holding paths shared with other caches.
+type sharedArchive struct {
+	// paths are the paths archived in the entry, and restorePaths those of
+	// them being restored.
+	paths        []string
+	restorePaths []string
+	file         string
+	size         int64
+}
+
+// sharedArchives are the entries referenced by a restored archive.
+type sharedArchives struct {
+	archives []sharedArchive
+	// paths are the restore paths held by referenced entries, including
+	// missing, those whose entries weren't found.
+	paths            []string
+	missing          []string
+	bytesTransferred int64
+	tmpDirs          []string
+}
+
+// remove removes the downloaded archives.
+func (s *sharedArchives) remove() {
+	for _, dir := range s.tmpDirs {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// downloadSharedArchives downloads the entries referenced by a cache's archive
+// which hold any of the restore paths, see SaveAllOptions.SharePaths. Entries
+// which aren't found are logged and their paths reported as missing, so the
+// rest of the cache is still restored.
+func (c *Cache) downloadSharedArchives(ctx context.Context, cacheID string, cacheConfig *cache.Cache, archiveFile string, archiveSize int64, restorePaths []string) (*sharedArchives, error) {
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+
+	references, err := archive.ReadReferences(ctx, f, archiveSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read references: %w", err)
+	}
+
+	shared := &sharedArchives{}
+	for _, reference := range references {
+		var paths []string
+		for _, path := range reference.Paths {
+			if slices.Contains(restorePaths, path) {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		shared.paths = append(shared.paths, paths...)
+
+		// only the exact key holds the paths shared with this entry
+		referenced := *cacheConfig
+		referenced.Key = reference.Key
+		referenced.FallbackKeys = nil
+
+		retrieveResp, exists, err := c.retrieveCache(ctx, &referenced, "")
+		if err != nil {
+			shared.remove()
+			return nil, fmt.Errorf("failed to retrieve shared entry %s: %w", reference.Key, err)
+		}
+
+		if !exists {
+			logging.FromContext(ctx).Warn("shared entry not found, its paths aren't restored", "cache_id", cacheID, "key", reference.Key, "paths", paths)
+			shared.missing = append(shared.missing, paths...)
+			continue
+		}
+
+		tmpDir, file, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
+		if err != nil {
+			shared.remove()
+			return nil, fmt.Errorf("failed to download shared entry %s: %w", reference.Key, err)
+		}
+
+		shared.tmpDirs = append(shared.tmpDirs, tmpDir)
+		shared.bytesTransferred += transferInfo.BytesTransferred
+		shared.archives = append(shared.archives, sharedArchive{
+			paths:        reference.Paths,
+			restorePaths: paths,
+			file:         file,
+			size:         transferInfo.BytesTransferred,
+		})
+	}
+
+	return shared, nil
+}
+
+// listSharedConflicts returns the existing files which extracting the shared
+// archives would replace.
+func (c *Cache) listSharedConflicts(ctx context.Context, shared *sharedArchives) ([]string, error) {
+	var conflicts []string
+	for _, a := range shared.archives {
+		files, err := c.listConflicts(ctx, a.file, a.size, a.paths, archive.ExtractOptions{Include: a.restorePaths})
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, files...)
+	}
+
+	return conflicts, nil
+}
+
+// extractSharedArchives extracts the shared archives in the same way as the
+// cache's own archive, adding their metrics to info.
+func (c *Cache) extractSharedArchives(ctx context.Context, shared *sharedArchives, atomic bool, opts archive.ExtractOptions, info *archive.ArchiveInfo) error {
+	for _, a := range shared.archives {
+		sharedOpts := opts
+		sharedOpts.Include = a.restorePaths
+
+		var (
+			sharedInfo *archive.ArchiveInfo
+			err        error
+		)
+		if atomic {
+			sharedInfo, err = c.extractAtomic(ctx, a.file, a.size, a.paths, a.restorePaths, sharedOpts)
+		} else {
+			sharedInfo, err = c.extractCache(ctx, a.file, a.size, a.paths, sharedOpts)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract shared paths %s: %w", strings.Join(a.restorePaths, ", "), err)
+		}
+
+		info.Size += sharedInfo.Size
+		info.WrittenBytes += sharedInfo.WrittenBytes
+		info.WrittenEntries += sharedInfo.WrittenEntries
+		info.Duration += sharedInfo.Duration
+		info.PathStats = append(info.PathStats, sharedInfo.PathStats...)
+		info.Skipped = append(info.Skipped, sharedInfo.Skipped...)
+		info.Overwritten = append(info.Overwritten, sharedInfo.Overwritten...)
+	}
+
+	return nil
+}
+
+// withoutPaths returns the paths which aren't in excluded.
+func withoutPaths(paths, excluded []string) []string {
+	var remaining []string
+	for _, path := range paths {
+		if !slices.Contains(excluded, path) {
+			remaining = append(remaining, path)
+		}
+	}
+
+	return remaining
+}
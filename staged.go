@@ -0,0 +1,157 @@
+package zstash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/internal/logging"
+)
+
+// RestoreMode controls where Restore writes the files in a cache archive.
+type RestoreMode string
+
+const (
+	// RestoreModeExtract extracts files into the cache paths. This is the
+	// default.
+	RestoreModeExtract RestoreMode = "extract"
+	// RestoreModeStaged extracts files into a staging directory rather than
+	// the working tree, so they can be mounted into a container, e.g.
+	// read-only, or linked into place with RestoreOptions.LinkStaged.
+	RestoreModeStaged RestoreMode = "staged"
+	// RestoreModePartial extracts only RestoreOptions.Files into the cache
+	// paths, reading them from zip archives with ranged requests rather than
+	// downloading the whole archive where the store supports it.
+	RestoreModePartial RestoreMode = "partial"
+)
+
+// IsValid reports whether m is a known restore mode, or empty to use the default.
+func (m RestoreMode) IsValid() bool {
+	switch m {
+	case "", RestoreModeExtract, RestoreModeStaged, RestoreModePartial:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateRestoreMode checks the restore mode and the options which depend on it.
+func validateRestoreMode(opts RestoreOptions) error {
+	if !opts.Mode.IsValid() {
+		return fmt.Errorf("invalid restore mode: %q", opts.Mode)
+	}
+
+	if opts.Mode != RestoreModeStaged && (opts.StagingDir != "" || opts.LinkStaged) {
+		return fmt.Errorf("StagingDir and LinkStaged require restore mode %q", RestoreModeStaged)
+	}
+
+	if opts.Mode != RestoreModePartial && len(opts.Files) > 0 {
+		return fmt.Errorf("Files requires restore mode %q", RestoreModePartial)
+	}
+
+	switch opts.Mode {
+	case RestoreModeStaged, RestoreModePartial:
+		if opts.ValidateManifest {
+			return fmt.Errorf("ValidateManifest isn't supported with restore mode %q", opts.Mode)
+		}
+	}
+
+	if opts.Mode == RestoreModePartial && len(opts.Files) == 0 {
+		return fmt.Errorf("restore mode %q requires Files", RestoreModePartial)
+	}
+
+	return nil
+}
+
+// stagingDir returns the staging directory for a staged restore, creating a
+// temporary directory if dir is empty.
+func stagingDir(dir string) (string, error) {
+	if dir == "" {
+		dir, err := os.MkdirTemp("", "zstash-staged-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		return dir, nil
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute staging directory: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+// stagedPaths returns the staged location of each path beneath dir.
+func stagedPaths(dir string, paths []string) (map[string]string, error) {
+	mappings, err := archive.PathsToMappings(paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mappings: %w", err)
+	}
+
+	staged := make(map[string]string, len(mappings))
+	for _, mapping := range mappings {
+		staged[mapping.Path] = archive.StagedPath(dir, mapping)
+	}
+
+	return staged, nil
+}
+
+// linkStaged replaces each path with a symlink to its staged location, applying
+// the conflict policy to paths which already exist. Returns the paths which
+// were left in place with archive.ConflictSkip.
+func (c *Cache) linkStaged(ctx context.Context, paths []string, staged map[string]string, onConflict archive.ConflictPolicy) ([]string, error) {
+	var skipped []string
+
+	for _, path := range paths {
+		linkPath, err := archive.ResolveHomeDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve home dir for %q: %w", path, err)
+		}
+
+		// paths missing from the archive have nothing to link to
+		if _, err := os.Stat(staged[path]); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		info, err := os.Lstat(linkPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to stat %s: %w", linkPath, err)
+		case onConflict == archive.ConflictFail:
+			return nil, fmt.Errorf("%w: %s already exists", archive.ErrExtractConflict, linkPath)
+		case onConflict == archive.ConflictSkip:
+			logging.FromContext(ctx).Info("staged path conflict", "path", linkPath, "policy", onConflict, "action", "skipped")
+			skipped = append(skipped, linkPath)
+			continue
+		case !info.IsDir():
+			// e.g. the link from a previous staged restore
+			if err := os.Remove(linkPath); err != nil {
+				return nil, fmt.Errorf("failed to remove %s: %w", linkPath, err)
+			}
+		default:
+			if err := cleanPath(ctx, linkPath); err != nil {
+				return nil, fmt.Errorf("failed to clean path %q: %w", linkPath, err)
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create parent directory of %s: %w", linkPath, err)
+		}
+
+		if err := os.Symlink(staged[path], linkPath); err != nil {
+			return nil, fmt.Errorf("failed to link %s to staging directory: %w", linkPath, err)
+		}
+	}
+
+	return skipped, nil
+}
@@ -0,0 +1,81 @@
+package zstash
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// stageTimings records the stages of a save or restore as they're reported
+// to callProgress, for SaveResult.Stages and RestoreResult.Stages. A nil
+// *stageTimings records nothing.
+type stageTimings struct {
+	mu     sync.Mutex
+	stages []StageTiming
+	// open reports whether the last stage is still running
+	open bool
+}
+
+type stageTimingsKey struct{}
+
+// withStageTimings returns a context recording the stages reported by the
+// operation using it, replacing those of any enclosing operation.
+func withStageTimings(ctx context.Context) (context.Context, *stageTimings) {
+	timings := &stageTimings{}
+	return context.WithValue(ctx, stageTimingsKey{}, timings), timings
+}
+
+// stageTimingsFrom returns the stage timings recorded for ctx, or nil.
+func stageTimingsFrom(ctx context.Context) *stageTimings {
+	timings, _ := ctx.Value(stageTimingsKey{}).(*stageTimings)
+	return timings
+}
+
+// enter finishes the running stage and starts the named stage, unless it's
+// already running, such as a transfer reporting its progress. The "complete"
+// stage only finishes the running stage.
+func (t *stageTimings) enter(stage string, now time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.open && t.stages[len(t.stages)-1].Name == stage {
+		return
+	}
+
+	t.stop(now)
+
+	if stage != "complete" {
+		t.stages = append(t.stages, StageTiming{Name: stage, Start: now})
+		t.open = true
+	}
+}
+
+// finish finishes the running stage and returns the stages recorded.
+func (t *stageTimings) finish(now time.Time) []StageTiming {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stop(now)
+
+	return slices.Clone(t.stages)
+}
+
+// stop finishes the running stage, if any. t.mu must be held.
+func (t *stageTimings) stop(now time.Time) {
+	if !t.open {
+		return
+	}
+
+	last := &t.stages[len(t.stages)-1]
+	last.Duration = now.Sub(last.Start)
+	t.open = false
+}
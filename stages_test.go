@@ -0,0 +1,42 @@
+package zstash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStageTimings(t *testing.T) {
+	start := time.Now()
+
+	ctx, timings := withStageTimings(context.Background())
+	require.Same(t, timings, stageTimingsFrom(ctx))
+
+	timings.enter("validating", start)
+	timings.enter("uploading", start.Add(time.Second))
+	// progress of the running stage doesn't start a new stage
+	timings.enter("uploading", start.Add(2*time.Second))
+	timings.enter("committing", start.Add(4*time.Second))
+	timings.enter("complete", start.Add(5*time.Second))
+
+	assert.Equal(t, []StageTiming{
+		{Name: "validating", Start: start, Duration: time.Second},
+		{Name: "uploading", Start: start.Add(time.Second), Duration: 3 * time.Second},
+		{Name: "committing", Start: start.Add(4 * time.Second), Duration: time.Second},
+	}, timings.finish(start.Add(6*time.Second)))
+
+	// a stage still running when the operation finishes, e.g. after an error
+	_, timings = withStageTimings(context.Background())
+	timings.enter("downloading", start)
+	assert.Equal(t, []StageTiming{
+		{Name: "downloading", Start: start, Duration: 2 * time.Second},
+	}, timings.finish(start.Add(2*time.Second)))
+
+	// operations without timings record nothing
+	assert.Nil(t, stageTimingsFrom(context.Background()))
+	stageTimingsFrom(context.Background()).enter("validating", start)
+	assert.Nil(t, stageTimingsFrom(context.Background()).finish(start))
+}
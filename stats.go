@@ -0,0 +1,273 @@
+package zstash
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/buildkite/zstash/archive"
+)
+
+// DefaultStatsLedger is the conventional path of the stats ledger, for
+// Config.StatsLedger and ReadStats.
+const DefaultStatsLedger = "~/.zstash/stats.jsonl"
+
+// maxStatsRecordSize limits the size of a single record read from a ledger.
+const maxStatsRecordSize = 1 << 20
+
+// StatsLedger is a Reporter which appends each event as a line of JSON to a
+// local file, so the cache usage of a long-lived agent can be analysed over
+// many builds with ReadStats.
+type StatsLedger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStatsLedger creates a StatsLedger appending to the file at path, which
+// may start with "~" for the home directory. The file and its directory are
+// created when the first event is reported.
+func NewStatsLedger(path string) *StatsLedger {
+	return &StatsLedger{path: path}
+}
+
+// Report appends the event to the ledger.
+func (l *StatsLedger) Report(ctx context.Context, event UsageEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage event: %w", err)
+	}
+
+	path, err := archive.ResolveHomeDir(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve stats ledger path: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create stats ledger directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- path is configured by the user
+	if err != nil {
+		return fmt.Errorf("failed to open stats ledger: %w", err)
+	}
+
+	// a single write per record, so records from concurrent clients sharing
+	// the ledger aren't interleaved
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write stats ledger: %w", err)
+	}
+
+	return f.Close()
+}
+
+// Stats summarises the records of a stats ledger, see ReadStats.
+type Stats struct {
+	// Records is the number of records summarised.
+	Records int
+
+	// Saves and Restores are the number of save and restore records.
+	Saves    int
+	Restores int
+
+	// Hits is the number of restores which matched the exact cache key.
+	Hits int
+
+	// Fallbacks is the number of restores of a fallback key.
+	Fallbacks int
+
+	// Misses is the number of restores which found no cache entry.
+	Misses int
+
+	// Errors is the number of saves and restores which failed.
+	Errors int
+
+	// BytesUploaded is the total number of bytes uploaded by saves.
+	BytesUploaded int64
+
+	// BytesDownloaded is the total number of bytes downloaded by restores.
+	BytesDownloaded int64
+
+	// AverageSaveDuration is the mean end-to-end duration of the saves which
+	// didn't fail.
+	AverageSaveDuration time.Duration
+
+	// AverageRestoreDuration is the mean end-to-end duration of the restores
+	// which didn't fail.
+	AverageRestoreDuration time.Duration
+
+	// First and Last are the timestamps of the oldest and newest records.
+	First time.Time
+	Last  time.Time
+}
+
+// HitRate returns the fraction of restores which hit the exact key, or 0 if
+// no restores succeeded.
+func (s Stats) HitRate() float64 {
+	restores := s.Hits + s.Fallbacks + s.Misses
+	if restores == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(restores)
+}
+
+// String returns the stats as a single line of key=value fields, such as
+// "records=20 saves=4 restores=16 hits=12 ... hit_rate=0.75 ...", for
+// printing when tuning cache keys.
+func (s Stats) String() string {
+	return fmt.Sprintf(
+		"records=%d saves=%d restores=%d hits=%d fallbacks=%d misses=%d errors=%d hit_rate=%.2f bytes_uploaded=%d bytes_downloaded=%d average_save_duration=%s average_restore_duration=%s",
+		s.Records, s.Saves, s.Restores, s.Hits, s.Fallbacks, s.Misses, s.Errors, s.HitRate(),
+		s.BytesUploaded, s.BytesDownloaded, s.AverageSaveDuration, s.AverageRestoreDuration,
+	)
+}
+
+// ReadStats summarises the last n records of the stats ledger at path, which
+// may start with "~" for the home directory, or all of its records if n isn't
+// positive. A missing ledger has no records. Lines which can't be decoded,
+// such as a record truncated by a crash, are skipped.
+//
+// Example:
+//
+//	stats, err := zstash.ReadStats(zstash.DefaultStatsLedger, 100)
+//	if err != nil {
+//	    log.Fatalf("Failed to read cache stats: %v", err)
+//	}
+//	fmt.Printf("hit rate over the last 100 operations: %.2f\n", stats.HitRate())
+func ReadStats(path string, n int) (Stats, error) {
+	path, err := archive.ResolveHomeDir(path)
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to resolve stats ledger path: %w", err)
+	}
+
+	f, err := os.Open(path) // #nosec G304 -- path is configured by the user
+	if errors.Is(err, os.ErrNotExist) {
+		return Stats{}, nil
+	}
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to open stats ledger: %w", err)
+	}
+	defer f.Close()
+
+	var events []UsageEvent
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStatsRecordSize)
+	for scanner.Scan() {
+		var event UsageEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+
+		events = append(events, event)
+		// only the last n records are kept
+		if n > 0 && len(events) > n {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Stats{}, fmt.Errorf("failed to read stats ledger: %w", err)
+	}
+
+	return summariseStats(events), nil
+}
+
+// summariseStats summarises the ledger records.
+func summariseStats(events []UsageEvent) Stats {
+	var (
+		stats                     Stats
+		saveTotal, restoreTotal   time.Duration
+		savesTimed, restoresTimed int
+	)
+
+	for _, event := range events {
+		stats.Records++
+
+		if stats.First.IsZero() || event.Timestamp.Before(stats.First) {
+			stats.First = event.Timestamp
+		}
+		if event.Timestamp.After(stats.Last) {
+			stats.Last = event.Timestamp
+		}
+
+		switch event.Operation {
+		case "save":
+			stats.Saves++
+			if event.Status == "error" {
+				stats.Errors++
+				continue
+			}
+
+			stats.BytesUploaded += event.BytesTransferred
+			saveTotal += event.TotalDuration
+			savesTimed++
+		case "restore":
+			stats.Restores++
+			switch event.Status {
+			case "error":
+				stats.Errors++
+				continue
+			case "hit":
+				stats.Hits++
+			case "fallback":
+				stats.Fallbacks++
+			default:
+				stats.Misses++
+			}
+
+			stats.BytesDownloaded += event.BytesTransferred
+			restoreTotal += event.TotalDuration
+			restoresTimed++
+		}
+	}
+
+	if savesTimed > 0 {
+		stats.AverageSaveDuration = saveTotal / time.Duration(savesTimed)
+	}
+	if restoresTimed > 0 {
+		stats.AverageRestoreDuration = restoreTotal / time.Duration(restoresTimed)
+	}
+
+	return stats
+}
+
+// multiReporter sends each event to several reporters, such as the configured
+// Reporter and the stats ledger.
+type multiReporter []Reporter
+
+// Report sends the event to each reporter, returning their errors joined.
+func (m multiReporter) Report(ctx context.Context, event UsageEvent) error {
+	var errs []error
+	for _, reporter := range m {
+		if err := reporter.Report(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newReporter returns the reporter for the client's configuration, combining
+// the configured Reporter with the stats ledger, or nil if neither is set.
+func newReporter(reporter Reporter, statsLedger string) Reporter {
+	if statsLedger == "" {
+		return reporter
+	}
+
+	ledger := NewStatsLedger(statsLedger)
+	if reporter == nil {
+		return ledger
+	}
+
+	return multiReporter{reporter, ledger}
+}
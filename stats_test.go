@@ -0,0 +1,91 @@
+package zstash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsLedger(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "zstash", "stats.jsonl")
+	ledger := NewStatsLedger(path)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []UsageEvent{
+		{Operation: "save", Status: "created", BytesTransferred: 1000, TotalDuration: 4 * time.Second},
+		{Operation: "restore", Status: "miss", TotalDuration: time.Second},
+		{Operation: "restore", Status: "hit", Hit: true, BytesTransferred: 500, TotalDuration: 3 * time.Second},
+		{Operation: "restore", Status: "fallback", BytesTransferred: 300, TotalDuration: 2 * time.Second},
+		{Operation: "restore", Status: "error", Error: "download failed", TotalDuration: time.Minute},
+		{Operation: "restore", Status: "hit", Hit: true, BytesTransferred: 500, TotalDuration: 4 * time.Second},
+	}
+	for i, event := range events {
+		event.Timestamp = start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, ledger.Report(ctx, event))
+	}
+
+	t.Run("all records", func(t *testing.T) {
+		stats, err := ReadStats(path, 0)
+		require.NoError(t, err)
+
+		assert.Equal(t, 6, stats.Records)
+		assert.Equal(t, 1, stats.Saves)
+		assert.Equal(t, 5, stats.Restores)
+		assert.Equal(t, 2, stats.Hits)
+		assert.Equal(t, 1, stats.Fallbacks)
+		assert.Equal(t, 1, stats.Misses)
+		assert.Equal(t, 1, stats.Errors)
+		assert.Equal(t, int64(1000), stats.BytesUploaded)
+		assert.Equal(t, int64(1300), stats.BytesDownloaded)
+		assert.Equal(t, 4*time.Second, stats.AverageSaveDuration)
+		assert.Equal(t, 2500*time.Millisecond, stats.AverageRestoreDuration)
+		assert.InDelta(t, 0.5, stats.HitRate(), 0.001)
+		assert.Equal(t, start, stats.First)
+		assert.Equal(t, start.Add(5*time.Hour), stats.Last)
+	})
+
+	t.Run("last records", func(t *testing.T) {
+		stats, err := ReadStats(path, 2)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Records)
+		assert.Equal(t, 1, stats.Hits)
+		assert.Equal(t, 1, stats.Errors)
+		assert.InDelta(t, 1.0, stats.HitRate(), 0.001)
+		assert.Equal(t, start.Add(4*time.Hour), stats.First)
+	})
+
+	t.Run("truncated record", func(t *testing.T) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"operation":"resto`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		stats, err := ReadStats(path, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 6, stats.Records)
+	})
+}
+
+func TestReadStats_Missing(t *testing.T) {
+	stats, err := ReadStats(filepath.Join(t.TempDir(), "stats.jsonl"), 10)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats)
+	assert.Zero(t, stats.HitRate())
+}
+
+func TestNewReporter(t *testing.T) {
+	webhook := NewWebhookReporter("http://localhost", "")
+
+	assert.Nil(t, newReporter(nil, ""))
+	assert.Equal(t, webhook, newReporter(webhook, ""))
+	assert.IsType(t, &StatsLedger{}, newReporter(nil, "stats.jsonl"))
+	assert.IsType(t, multiReporter{}, newReporter(webhook, "stats.jsonl"))
+}
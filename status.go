@@ -0,0 +1,245 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultStatusConcurrency is the number of caches checked concurrently by
+// Status when StatusOptions.Concurrency isn't set.
+const DefaultStatusConcurrency = 8
+
+// StatusOptions controls the behaviour of Status.
+type StatusOptions struct {
+	// Concurrency is the maximum number of caches checked at once. Defaults
+	// to DefaultStatusConcurrency.
+	Concurrency int
+
+	// ExcludeIDs are patterns of cache IDs which aren't checked, even if they
+	// match the requested IDs.
+	ExcludeIDs []string
+}
+
+// KeyStatus describes whether a cache entry exists for one of a cache's keys.
+type KeyStatus struct {
+	// Key is the configured key or fallback key which was checked.
+	Key string
+
+	// MatchedKey is the key of the entry found, which may differ from Key
+	// for a fallback key. Only populated when Exists is true.
+	MatchedKey string
+
+	// Fallback is true for the cache's fallback keys.
+	Fallback bool
+
+	// Exists indicates whether a cache entry exists for the key. The
+	// remaining fields are only populated when it exists.
+	Exists bool
+
+	// FileSize is the size of the cache archive in bytes.
+	FileSize int64
+
+	// CreatedAt indicates when the cache entry was created.
+	CreatedAt time.Time
+
+	// Age is how long ago the cache entry was created, when it was checked.
+	Age time.Duration
+}
+
+// CacheStatus describes the cache entries which exist for a cache's keys.
+type CacheStatus struct {
+	// CacheID is the ID of the checked cache.
+	CacheID string
+
+	// Keys holds the status of the cache key followed by each fallback key,
+	// in the order configured.
+	Keys []KeyStatus
+
+	// Err is the error which caused the check to fail, or nil.
+	Err error
+}
+
+// Restores returns the key a restore would use with the default fallback
+// strategy, the cache key if it exists and otherwise the first fallback key
+// which exists, or false for a miss.
+func (s CacheStatus) Restores() (KeyStatus, bool) {
+	for _, key := range s.Keys {
+		if key.Exists {
+			return key, true
+		}
+	}
+
+	return KeyStatus{}, false
+}
+
+// StatusResult contains the results of Status.
+type StatusResult struct {
+	// Caches holds the status of each cache, in the order requested.
+	Caches []CacheStatus
+}
+
+// String renders the results as a table with a row for each key, showing
+// which keys exist along with their sizes and ages, and which key each cache
+// would restore:
+//
+//	CACHE         KEY                 TYPE      STATUS   SIZE       AGE
+//	node_modules  v1-node-abc123      key       miss     -          -
+//	node_modules  v1-node-main        fallback  restore  104857600  26h0m0s
+func (r StatusResult) String() string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CACHE\tKEY\tTYPE\tSTATUS\tSIZE\tAGE")
+
+	for _, cacheStatus := range r.Caches {
+		if cacheStatus.Err != nil {
+			_, _ = fmt.Fprintf(w, "%s\t-\t-\terror: %s\t-\t-\n", cacheStatus.CacheID, cacheStatus.Err)
+			continue
+		}
+
+		restored := false
+		for _, key := range cacheStatus.Keys {
+			keyType := "key"
+			if key.Fallback {
+				keyType = "fallback"
+			}
+
+			status, size, age := "miss", "-", "-"
+			if key.Exists {
+				status = "hit"
+				// the first key which exists is restored
+				if !restored {
+					status, restored = "restore", true
+				}
+				size = strconv.FormatInt(key.FileSize, 10)
+				age = key.Age.Round(time.Second).String()
+			}
+
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", cacheStatus.CacheID, key.Key, keyType, status, size, age)
+		}
+	}
+
+	_ = w.Flush()
+
+	return b.String()
+}
+
+// Status checks which cache entries exist for the keys and fallback keys of
+// several caches concurrently, without downloading or restoring them, giving
+// a quick view of what a build will restore. cacheIDs may be patterns, see
+// MatchCacheIDs. If cacheIDs is empty, all of the client's caches are checked.
+//
+// Each key is checked as with Peek. Fallback keys are checked as exact keys,
+// so the server may match them differently when restoring. A failure to check
+// a cache is reported in its CacheStatus.Err rather than failing the others;
+// an error is only returned if cacheIDs or the options are invalid.
+//
+// Example:
+//
+//	result, err := cacheClient.Status(ctx, nil, zstash.StatusOptions{})
+//	if err != nil {
+//	    log.Fatalf("Cache status failed: %v", err)
+//	}
+//	fmt.Print(result)
+func (c *Cache) Status(ctx context.Context, cacheIDs []string, opts StatusOptions) (StatusResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.Status")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	cacheIDs, err := c.MatchCacheIDs(cacheIDs, opts.ExcludeIDs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid status options")
+		return StatusResult{}, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = DefaultStatusConcurrency
+	}
+
+	span.SetAttributes(
+		attribute.StringSlice("cache.ids", cacheIDs),
+		attribute.Int("cache.concurrency", concurrency),
+	)
+
+	if err := c.validateCacheIDs(cacheIDs, opts.Concurrency); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid status options")
+		return StatusResult{}, err
+	}
+
+	results := make([]CacheStatus, len(cacheIDs))
+
+	var wg errgroup.Group
+	wg.SetLimit(concurrency)
+
+	for i, cacheID := range cacheIDs {
+		wg.Go(func() error {
+			results[i] = c.cacheStatus(ctx, cacheID)
+			return nil
+		})
+	}
+
+	_ = wg.Wait()
+
+	span.SetStatus(codes.Ok, "status checked")
+
+	return StatusResult{Caches: results}, nil
+}
+
+// cacheStatus peeks the cache key and each fallback key of a cache.
+func (c *Cache) cacheStatus(ctx context.Context, cacheID string) CacheStatus {
+	status := CacheStatus{CacheID: cacheID}
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	branch := c.scopeFor(cacheConfig).branch
+	now := time.Now()
+
+	keys := append([]string{cacheConfig.Key}, cacheConfig.FallbackKeys...)
+	for i, key := range keys {
+		peekResp, exists, err := c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+			Key:    key,
+			Branch: branch,
+		})
+		if err != nil {
+			status.Err = fmt.Errorf("failed to check key %s: %w", key, err)
+			return status
+		}
+
+		keyStatus := KeyStatus{Key: key, Fallback: i > 0, Exists: exists}
+		if exists {
+			keyStatus.MatchedKey = peekResp.Key
+			if keyStatus.MatchedKey == "" {
+				keyStatus.MatchedKey = key
+			}
+			keyStatus.FileSize = int64(peekResp.FileSize)
+			keyStatus.CreatedAt = peekResp.CreatedAt
+			if !peekResp.CreatedAt.IsZero() {
+				keyStatus.Age = now.Sub(peekResp.CreatedAt)
+			}
+		}
+
+		status.Keys = append(status.Keys, keyStatus)
+	}
+
+	return status
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// BackgroundWork collects best effort work which blob stores continue after a
+// transfer returns, such as S3Blob refreshing the expiration of a downloaded
+// object, so it overlaps with whatever the caller does next. It is applied to
+// a transfer using WithBackgroundWork, and the caller must call Wait before
+// returning so the work isn't abandoned when the process exits.
+type BackgroundWork struct {
+	wg sync.WaitGroup
+}
+
+// Wait blocks until the work started in the background has completed.
+func (w *BackgroundWork) Wait() {
+	w.wg.Wait()
+}
+
+type backgroundWorkKey struct{}
+
+// WithBackgroundWork returns a context which runs the background work of
+// transfers using it in w. Transfers using a context without one run their
+// work before returning. A nil w returns ctx unchanged.
+func WithBackgroundWork(ctx context.Context, w *BackgroundWork) context.Context {
+	if w == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, backgroundWorkKey{}, w)
+}
+
+// runInBackground runs f in the background work of ctx, or before returning if
+// ctx has none.
+func runInBackground(ctx context.Context, f func()) {
+	w, _ := ctx.Value(backgroundWorkKey{}).(*BackgroundWork)
+	if w == nil {
+		f()
+		return
+	}
+
+	w.wg.Go(f)
+}
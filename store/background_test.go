@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunInBackground(t *testing.T) {
+	assert := require.New(t)
+
+	var ran atomic.Bool
+
+	// without background work, f runs before returning
+	runInBackground(context.Background(), func() { ran.Store(true) })
+	assert.True(ran.Load())
+
+	var background BackgroundWork
+	ctx := WithBackgroundWork(context.Background(), &background)
+
+	release := make(chan struct{})
+	ran.Store(false)
+	runInBackground(ctx, func() {
+		<-release
+		ran.Store(true)
+	})
+	assert.False(ran.Load(), "f runs in the background")
+
+	close(release)
+	background.Wait()
+	assert.True(ran.Load())
+
+	assert.Equal(context.Background(), WithBackgroundWork(context.Background(), nil))
+}
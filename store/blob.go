@@ -3,9 +3,16 @@ package store
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
 )
 
-// Blob interface defines the operations for blob storage
+// Blob interface defines the operations for blob storage.
+//
+// Library consumers can provide their own implementations and make them
+// available to NewBlobStore using RegisterScheme.
 type Blob interface {
 	// Upload uploads a file to blob storage
 	Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error)
@@ -14,14 +21,133 @@ type Blob interface {
 	Download(ctx context.Context, key string, destPath string) (*TransferInfo, error)
 }
 
+// ExpiringBlob is implemented by blob stores which can expire uploaded objects,
+// allowing the expiry provided by the cache API to be forwarded to the store.
+type ExpiringBlob interface {
+	Blob
+
+	// UploadWithExpiry uploads a file to blob storage which expires at expiresAt
+	UploadWithExpiry(ctx context.Context, filePath string, key string, expiresAt time.Time) (*TransferInfo, error)
+}
+
+// HeadBlob is implemented by blob stores which can check whether an object
+// exists without downloading it, which is required for chunked storage.
+type HeadBlob interface {
+	Blob
+
+	// Exists reports whether an object exists in blob storage
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// RangeBlob is implemented by blob stores which can read part of an object
+// without downloading it, e.g. to list the entries of an archive from its zip
+// central directory. See NewRangeReader.
+type RangeBlob interface {
+	Blob
+
+	// Size returns the size of an object in bytes
+	Size(ctx context.Context, key string) (int64, error)
+
+	// ReadRange reads len(p) bytes of an object starting at offset into p
+	ReadRange(ctx context.Context, key string, p []byte, offset int64) error
+}
+
+// DeleteBlob is implemented by blob stores which can delete objects.
+type DeleteBlob interface {
+	Blob
+
+	// Delete removes an object from blob storage, succeeding if it doesn't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// BlobFactory creates a Blob from a bucket URL.
+type BlobFactory func(ctx context.Context, bucketURL string) (Blob, error)
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = make(map[string]BlobFactory)
+)
+
+// RegisterScheme registers a factory used by NewBlobStore to create a Blob for
+// bucket URLs with the given scheme, e.g. "minio" for "minio://bucket/prefix".
+//
+// Registered schemes take precedence over the built-in backends, so a scheme
+// such as "s3" can be overridden to customise the S3 client. Registering a nil
+// factory removes a previously registered scheme.
+//
+// RegisterScheme is safe for concurrent use and is typically called from an
+// init function.
+func RegisterScheme(scheme string, factory BlobFactory) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+
+	if factory == nil {
+		delete(schemes, scheme)
+		return
+	}
+
+	schemes[scheme] = factory
+}
+
+// RegisteredSchemes returns the sorted list of schemes registered with RegisterScheme.
+func RegisteredSchemes() []string {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+
+	names := make([]string, 0, len(schemes))
+	for scheme := range schemes {
+		names = append(names, scheme)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// lookupScheme returns the registered factory for the scheme of bucketURL, if any.
+func lookupScheme(bucketURL string) (BlobFactory, bool) {
+	if bucketURL == "" {
+		return nil, false
+	}
+
+	u, err := url.Parse(bucketURL)
+	if err != nil || u.Scheme == "" {
+		return nil, false
+	}
+
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+
+	factory, ok := schemes[u.Scheme]
+	return factory, ok
+}
+
 func NewBlobStore(ctx context.Context, store string, bucketURL string) (Blob, error) {
+	if !IsValidStore(store) {
+		return nil, fmt.Errorf("unsupported store type: %s", store)
+	}
+
+	if factory, ok := lookupScheme(bucketURL); ok {
+		return factory(ctx, bucketURL)
+	}
+
 	switch store {
 	case LocalS3Store:
 		return NewS3Blob(ctx, bucketURL)
 	case LocalHostedAgents:
-		return NewNscStore()
+		nscStore, err := NewNscStore()
+		if err != nil {
+			return nil, err
+		}
+		if err := nscStore.CheckHealth(ctx); err != nil {
+			return nil, err
+		}
+		return nscStore, nil
 	case LocalFileStore:
 		return NewLocalFileBlob(ctx, bucketURL)
+	case LocalHTTPStore:
+		return NewHTTPBlob(ctx, bucketURL)
+	case BuildkiteHostedStore:
+		return nil, fmt.Errorf("%s store objects are transferred using presigned URLs, see NewPresignedBlob", store)
 	default:
 		return nil, fmt.Errorf("unsupported store type: %s", store)
 	}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBlob struct {
+	bucketURL string
+}
+
+func (f *fakeBlob) Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error) {
+	return &TransferInfo{}, nil
+}
+
+func (f *fakeBlob) Download(ctx context.Context, key string, destPath string) (*TransferInfo, error) {
+	return &TransferInfo{}, nil
+}
+
+func TestRegisterScheme(t *testing.T) {
+	assert := require.New(t)
+
+	RegisterScheme("fake", func(ctx context.Context, bucketURL string) (Blob, error) {
+		return &fakeBlob{bucketURL: bucketURL}, nil
+	})
+	t.Cleanup(func() { RegisterScheme("fake", nil) })
+
+	assert.Contains(RegisteredSchemes(), "fake")
+
+	blob, err := NewBlobStore(context.Background(), LocalS3Store, "fake://bucket/prefix")
+	assert.NoError(err)
+	assert.IsType(&fakeBlob{}, blob)
+	assert.Equal("fake://bucket/prefix", blob.(*fakeBlob).bucketURL)
+
+	t.Run("unregister with nil factory", func(t *testing.T) {
+		RegisterScheme("fake", nil)
+		require.NotContains(t, RegisteredSchemes(), "fake")
+
+		_, err := NewBlobStore(context.Background(), LocalS3Store, "fake://bucket/prefix")
+		require.Error(t, err)
+	})
+}
+
+func TestNewBlobStore_UnsupportedStore(t *testing.T) {
+	assert := require.New(t)
+
+	RegisterScheme("fake", func(ctx context.Context, bucketURL string) (Blob, error) {
+		return &fakeBlob{bucketURL: bucketURL}, nil
+	})
+	t.Cleanup(func() { RegisterScheme("fake", nil) })
+
+	_, err := NewBlobStore(context.Background(), "unknown", "fake://bucket")
+	assert.Error(err)
+	assert.Contains(err.Error(), "unsupported store type")
+}
@@ -0,0 +1,437 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/buildkite/zstash/internal/cdc"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// ChunkManifestFormat identifies objects holding a chunk manifest rather
+	// than the archive itself.
+	ChunkManifestFormat = "zstash-chunks-v1"
+
+	// chunkPrefix is the key prefix under which chunks are stored, shared by
+	// all cache entries in the store so identical chunks are only stored once.
+	chunkPrefix = "chunks"
+
+	// chunkConcurrency is the number of chunks transferred concurrently.
+	chunkConcurrency = 4
+
+	// maxManifestSize bounds how much of a downloaded object is read when
+	// checking whether it's a manifest.
+	maxManifestSize = 64 << 20
+)
+
+// ChunkManifest is stored in place of an archive when using chunked storage,
+// listing the chunks which are concatenated to reassemble the archive.
+type ChunkManifest struct {
+	Format string     `json:"format"`
+	Size   int64      `json:"size"`
+	SHA256 string     `json:"sha256"`
+	Chunks []ChunkRef `json:"chunks"`
+}
+
+// ChunkRef identifies a chunk by the SHA256 digest of its contents.
+type ChunkRef struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// ChunkedBlob stores files as content-defined chunks, so only chunks which
+// aren't already in the store are uploaded. A manifest listing the chunks is
+// stored at the file's key, and chunks are stored under a shared "chunks/"
+// prefix keyed by their SHA256 digest.
+//
+// Downloads detect whether the object is a manifest or a plain file, so
+// objects uploaded without chunking can still be downloaded.
+type ChunkedBlob struct {
+	blob Blob
+	opts cdc.Options
+}
+
+// NewChunkedBlob wraps blob to store files as content-defined chunks. Uploads
+// require blob to implement HeadBlob.
+func NewChunkedBlob(blob Blob) *ChunkedBlob {
+	return &ChunkedBlob{blob: blob, opts: cdc.DefaultOptions}
+}
+
+// Upload splits the file into chunks, uploads those not already in the store
+// and then uploads the manifest to key.
+func (b *ChunkedBlob) Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error) {
+	ctx, span := trace.StartLinked(ctx, "ChunkedBlob.Upload")
+	defer span.End()
+
+	start := time.Now()
+
+	headBlob, ok := b.blob.(HeadBlob)
+	if !ok {
+		return nil, fmt.Errorf("store %T does not support chunked storage", b.blob)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "zstash-chunks")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	fileHash := sha256.New()
+
+	chunker, err := cdc.NewChunker(io.TeeReader(file, fileHash), b.opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunker: %w", err)
+	}
+
+	manifest := ChunkManifest{Format: ChunkManifestFormat}
+	seen := make(map[string]bool)
+
+	var uploadedBytes, uploadedChunks atomic.Int64
+
+	wg, wctx := errgroup.WithContext(ctx)
+	wg.SetLimit(chunkConcurrency)
+
+	// wait returns the first error from the upload workers, falling back to err.
+	wait := func(err error) error {
+		if werr := wg.Wait(); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	for {
+		if wctx.Err() != nil {
+			return nil, wait(ctx.Err())
+		}
+
+		chunk, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, wait(fmt.Errorf("failed to chunk file: %w", err))
+		}
+
+		sum := sha256.Sum256(chunk)
+		digest := hex.EncodeToString(sum[:])
+
+		manifest.Size += int64(len(chunk))
+		manifest.Chunks = append(manifest.Chunks, ChunkRef{Digest: digest, Size: int64(len(chunk))})
+
+		if seen[digest] {
+			continue
+		}
+		seen[digest] = true
+
+		// the chunk is only valid until the next call to Next, so it's written
+		// to a temporary file for uploading
+		chunkPath := filepath.Join(tmpDir, digest)
+		if err := os.WriteFile(chunkPath, chunk, 0o600); err != nil {
+			return nil, wait(fmt.Errorf("failed to write chunk: %w", err))
+		}
+
+		wg.Go(func() error {
+			defer func() {
+				_ = os.Remove(chunkPath)
+			}()
+
+			exists, err := headBlob.Exists(wctx, chunkKey(digest))
+			if err != nil {
+				return fmt.Errorf("failed to check chunk %s: %w", digest, err)
+			}
+			if exists {
+				return nil
+			}
+
+			info, err := b.blob.Upload(wctx, chunkPath, chunkKey(digest))
+			if err != nil {
+				return fmt.Errorf("failed to upload chunk %s: %w", digest, err)
+			}
+
+			uploadedBytes.Add(info.BytesTransferred)
+			uploadedChunks.Add(1)
+
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	manifest.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chunk manifest: %w", err)
+	}
+
+	manifestPath := filepath.Join(tmpDir, "manifest.json")
+	if err := os.WriteFile(manifestPath, manifestData, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write chunk manifest: %w", err)
+	}
+
+	manifestInfo, err := b.blob.Upload(ctx, manifestPath, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload chunk manifest: %w", err)
+	}
+
+	bytesWritten := uploadedBytes.Load() + manifestInfo.BytesTransferred
+	reusedChunks := len(manifest.Chunks) - int(uploadedChunks.Load())
+
+	duration := time.Since(start)
+	averageSpeed := calculateTransferSpeedMBps(bytesWritten, duration)
+
+	logging.FromContext(ctx).Debug("completed chunked upload",
+		"key", key,
+		"size", manifest.Size,
+		"chunks", len(manifest.Chunks),
+		"reused_chunks", reusedChunks,
+		"bytes_transferred", bytesWritten,
+	)
+
+	span.SetAttributes(
+		attribute.Int64("bytes_transferred", bytesWritten),
+		attribute.String("transfer_speed", fmt.Sprintf("%.2fMB/s", averageSpeed)),
+		attribute.Int("chunk_count", len(manifest.Chunks)),
+		attribute.Int("reused_chunks", reusedChunks),
+	)
+
+	return &TransferInfo{
+		BytesTransferred: bytesWritten,
+		TransferSpeed:    averageSpeed,
+		RequestID:        manifestInfo.RequestID,
+		Duration:         duration,
+		PartCount:        int(uploadedChunks.Load()),
+		Concurrency:      chunkConcurrency,
+		ChunkCount:       len(manifest.Chunks),
+		ReusedChunks:     reusedChunks,
+	}, nil
+}
+
+// Download downloads the object at key to destPath. If the object is a chunk
+// manifest, the chunks are downloaded and reassembled in its place.
+func (b *ChunkedBlob) Download(ctx context.Context, key string, destPath string) (*TransferInfo, error) {
+	ctx, span := trace.StartLinked(ctx, "ChunkedBlob.Download")
+	defer span.End()
+
+	start := time.Now()
+
+	info, err := b.blob.Download(ctx, key, destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, ok, err := readChunkManifest(destPath)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// a plain file, uploaded without chunking
+		return info, nil
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(destPath), ".zstash-chunks-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	assembledPath := filepath.Join(tmpDir, "assembled")
+	assembled, err := os.Create(assembledPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		_ = assembled.Close()
+	}()
+
+	bytesRead := info.BytesTransferred
+
+	wg, wctx := errgroup.WithContext(ctx)
+	wg.SetLimit(chunkConcurrency)
+
+	var downloadedBytes atomic.Int64
+	var offset int64
+
+	for i, ref := range manifest.Chunks {
+		chunkOffset := offset
+		offset += ref.Size
+
+		wg.Go(func() error {
+			chunkPath := filepath.Join(tmpDir, fmt.Sprintf("%d-%s", i, ref.Digest))
+			defer func() {
+				_ = os.Remove(chunkPath)
+			}()
+
+			chunkInfo, err := b.blob.Download(wctx, chunkKey(ref.Digest), chunkPath)
+			if err != nil {
+				return fmt.Errorf("failed to download chunk %s: %w", ref.Digest, err)
+			}
+			downloadedBytes.Add(chunkInfo.BytesTransferred)
+
+			data, err := os.ReadFile(chunkPath) // #nosec G304 -- chunk path is built from the temp directory
+			if err != nil {
+				return fmt.Errorf("failed to read chunk %s: %w", ref.Digest, err)
+			}
+
+			if err := verifyChunk(ref, data); err != nil {
+				return err
+			}
+
+			if _, err := assembled.WriteAt(data, chunkOffset); err != nil {
+				return fmt.Errorf("failed to write chunk %s: %w", ref.Digest, err)
+			}
+
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err := verifyFile(assembled, manifest); err != nil {
+		return nil, err
+	}
+
+	if err := assembled.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(assembledPath, destPath); err != nil {
+		return nil, fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	bytesRead += downloadedBytes.Load()
+	duration := time.Since(start)
+	averageSpeed := calculateTransferSpeedMBps(bytesRead, duration)
+
+	span.SetAttributes(
+		attribute.Int64("bytes_transferred", bytesRead),
+		attribute.String("transfer_speed", fmt.Sprintf("%.2fMB/s", averageSpeed)),
+		attribute.Int("chunk_count", len(manifest.Chunks)),
+	)
+
+	return &TransferInfo{
+		BytesTransferred: bytesRead,
+		TransferSpeed:    averageSpeed,
+		RequestID:        info.RequestID,
+		Duration:         duration,
+		PartCount:        len(manifest.Chunks),
+		Concurrency:      chunkConcurrency,
+		ChunkCount:       len(manifest.Chunks),
+	}, nil
+}
+
+// chunkKey returns the key a chunk is stored under.
+func chunkKey(digest string) string {
+	return path.Join(chunkPrefix, digest[:2], digest)
+}
+
+// readChunkManifest reads the manifest at path, returning false if the file
+// isn't a manifest. Archives are zip files, so are never mistaken for a manifest.
+func readChunkManifest(path string) (ChunkManifest, bool, error) {
+	file, err := os.Open(path) // #nosec G304 -- path is the download destination
+	if err != nil {
+		return ChunkManifest{}, false, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// check the first byte before reading the whole file, as plain archives
+	// can be large
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(file, first); err != nil || first[0] != '{' {
+		return ChunkManifest{}, false, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ChunkManifest{}, false, fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxManifestSize))
+	if err != nil {
+		return ChunkManifest{}, false, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var manifest ChunkManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Format != ChunkManifestFormat {
+		return ChunkManifest{}, false, nil
+	}
+
+	for _, ref := range manifest.Chunks {
+		if len(ref.Digest) != sha256.Size*2 {
+			return ChunkManifest{}, false, fmt.Errorf("invalid chunk digest in manifest: %q", ref.Digest)
+		}
+		if _, err := hex.DecodeString(ref.Digest); err != nil {
+			return ChunkManifest{}, false, fmt.Errorf("invalid chunk digest in manifest: %q", ref.Digest)
+		}
+	}
+
+	return manifest, true, nil
+}
+
+// verifyChunk checks the downloaded chunk matches the manifest.
+func verifyChunk(ref ChunkRef, data []byte) error {
+	if int64(len(data)) != ref.Size {
+		return fmt.Errorf("chunk %s size mismatch: expected %d bytes, got %d: %w", ref.Digest, ref.Size, len(data), ErrDigestMismatch)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != ref.Digest {
+		return fmt.Errorf("chunk %s: %w", ref.Digest, ErrDigestMismatch)
+	}
+
+	return nil
+}
+
+// verifyFile checks the reassembled file matches the manifest.
+func verifyFile(file *os.File, manifest ChunkManifest) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to checksum file: %w", err)
+	}
+
+	if size != manifest.Size {
+		return fmt.Errorf("reassembled file size mismatch: expected %d bytes, got %d: %w", manifest.Size, size, ErrDigestMismatch)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return fmt.Errorf("reassembled file: %w", ErrDigestMismatch)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/zstash/internal/cdc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChunkOptions = cdc.Options{
+	MinSize: 4 << 10,
+	AvgSize: 16 << 10,
+	MaxSize: 64 << 10,
+}
+
+func newTestChunkedBlob(t *testing.T) (*ChunkedBlob, *LocalFileBlob) {
+	t.Helper()
+
+	fileBlob, err := NewLocalFileBlob(context.Background(), "file://"+t.TempDir())
+	require.NoError(t, err)
+
+	return &ChunkedBlob{blob: fileBlob, opts: testChunkOptions}, fileBlob
+}
+
+func writeRandomFile(t *testing.T, path string, size int, seed byte) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	_, err := rand.NewChaCha8([32]byte{seed}).Read(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	return data
+}
+
+func TestChunkedBlob_UploadDownload(t *testing.T) {
+	ctx := context.Background()
+	blob, _ := newTestChunkedBlob(t)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zip")
+	data := writeRandomFile(t, srcPath, 512<<10, 1)
+
+	uploadInfo, err := blob.Upload(ctx, srcPath, "pipeline/key.zip")
+	require.NoError(t, err)
+	assert.Greater(t, uploadInfo.ChunkCount, 1)
+	assert.Equal(t, 0, uploadInfo.ReusedChunks)
+
+	destPath := filepath.Join(dir, "dest.zip")
+	downloadInfo, err := blob.Download(ctx, "pipeline/key.zip", destPath)
+	require.NoError(t, err)
+	assert.Equal(t, uploadInfo.ChunkCount, downloadInfo.ChunkCount)
+
+	got, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestChunkedBlob_ReusesExistingChunks(t *testing.T) {
+	ctx := context.Background()
+	blob, _ := newTestChunkedBlob(t)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zip")
+	data := writeRandomFile(t, srcPath, 512<<10, 2)
+
+	first, err := blob.Upload(ctx, srcPath, "first.zip")
+	require.NoError(t, err)
+
+	second, err := blob.Upload(ctx, srcPath, "second.zip")
+	require.NoError(t, err)
+	assert.Equal(t, second.ChunkCount, second.ReusedChunks, "unchanged archive should reuse every chunk")
+	assert.Less(t, second.BytesTransferred, first.BytesTransferred)
+
+	// appending to the archive should only upload the chunks near the end
+	edited := append(data, []byte("appended bytes")...)
+	require.NoError(t, os.WriteFile(srcPath, edited, 0o600))
+
+	third, err := blob.Upload(ctx, srcPath, "third.zip")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, third.ReusedChunks, third.ChunkCount-2)
+
+	destPath := filepath.Join(dir, "dest.zip")
+	_, err = blob.Download(ctx, "third.zip", destPath)
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, edited, got)
+}
+
+func TestChunkedBlob_DownloadPlainObject(t *testing.T) {
+	ctx := context.Background()
+	blob, fileBlob := newTestChunkedBlob(t)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zip")
+	data := writeRandomFile(t, srcPath, 64<<10, 3)
+
+	_, err := fileBlob.Upload(ctx, srcPath, "plain.zip")
+	require.NoError(t, err)
+
+	destPath := filepath.Join(dir, "dest.zip")
+	info, err := blob.Download(ctx, "plain.zip", destPath)
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.ChunkCount)
+
+	got, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestChunkedBlob_DownloadCorruptChunk(t *testing.T) {
+	ctx := context.Background()
+	blob, fileBlob := newTestChunkedBlob(t)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zip")
+	writeRandomFile(t, srcPath, 128<<10, 4)
+
+	_, err := blob.Upload(ctx, srcPath, "key.zip")
+	require.NoError(t, err)
+
+	manifest, ok, err := readChunkManifest(filepath.Join(fileBlob.root, "key.zip"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	corruptPath := filepath.Join(dir, "corrupt")
+	require.NoError(t, os.WriteFile(corruptPath, []byte("corrupt"), 0o600))
+	_, err = fileBlob.Upload(ctx, corruptPath, chunkKey(manifest.Chunks[0].Digest))
+	require.NoError(t, err)
+
+	_, err = blob.Download(ctx, "key.zip", filepath.Join(dir, "dest.zip"))
+	require.ErrorIs(t, err, ErrDigestMismatch)
+}
+
+func TestChunkedBlob_UploadRequiresHeadBlob(t *testing.T) {
+	blob := NewChunkedBlob(&NscStore{})
+
+	srcPath := filepath.Join(t.TempDir(), "src.zip")
+	require.NoError(t, os.WriteFile(srcPath, []byte("data"), 0o600))
+
+	_, err := blob.Upload(context.Background(), srcPath, "key.zip")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not support chunked storage")
+}
@@ -5,9 +5,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/buildkite/zstash/internal/logging"
 	"github.com/buildkite/zstash/internal/trace"
 	"go.opentelemetry.io/otel/attribute"
 )
@@ -101,7 +102,7 @@ func NewLocalFileBlob(ctx context.Context, fileURL string) (*LocalFileBlob, erro
 		return nil, fmt.Errorf("failed to create root directory: %w", err)
 	}
 
-	slog.Debug("configured local file store", "root", root)
+	logging.FromContext(ctx).Debug("configured local file store", "root", root)
 
 	return &LocalFileBlob{root: root}, nil
 }
@@ -121,7 +122,7 @@ func NewLocalFileBlob(ctx context.Context, fileURL string) (*LocalFileBlob, erro
 //
 // Returns TransferInfo with bytes transferred, transfer speed, and duration.
 func (b *LocalFileBlob) Upload(ctx context.Context, srcPath string, key string) (*TransferInfo, error) {
-	_, span := trace.Start(ctx, "LocalFileBlob.Upload")
+	_, span := trace.StartLinked(ctx, "LocalFileBlob.Upload")
 	defer span.End()
 
 	start := time.Now()
@@ -167,7 +168,7 @@ func (b *LocalFileBlob) Upload(ctx context.Context, srcPath string, key string)
 	// Note: For large files (GB-scale), this adds CPU overhead. Consider making
 	// this optional via configuration if performance becomes an issue.
 	hash := sha256.New()
-	teeReader := io.TeeReader(srcFile, hash)
+	teeReader := io.TeeReader(countReader(ctx, srcFile), hash)
 
 	bytesWritten, err := io.Copy(tmpFile, teeReader)
 	if err != nil {
@@ -196,7 +197,7 @@ func (b *LocalFileBlob) Upload(ctx context.Context, srcPath string, key string)
 	// Fsync parent directory for durability (optional but recommended)
 	if dir, err := os.Open(filepath.Dir(dataPath)); err == nil {
 		if err := dir.Sync(); err != nil {
-			slog.Warn("failed to fsync directory after upload", "path", filepath.Dir(dataPath), "error", err)
+			logging.FromContext(ctx).Warn("failed to fsync directory after upload", "path", filepath.Dir(dataPath), "error", err)
 		}
 		_ = dir.Close()
 	}
@@ -281,7 +282,7 @@ func (b *LocalFileBlob) Upload(ctx context.Context, srcPath string, key string)
 // Returns TransferInfo with bytes transferred, transfer speed, and duration.
 // Returns an error if the cache key doesn't exist or file operations fail.
 func (b *LocalFileBlob) Download(ctx context.Context, key string, destPath string) (*TransferInfo, error) {
-	_, span := trace.Start(ctx, "LocalFileBlob.Download")
+	_, span := trace.StartLinked(ctx, "LocalFileBlob.Download")
 	defer span.End()
 
 	start := time.Now()
@@ -322,11 +323,17 @@ func (b *LocalFileBlob) Download(ctx context.Context, key string, destPath strin
 		}
 	}()
 
-	bytesWritten, err := io.Copy(tmpFile, srcFile)
+	hash := sha256.New()
+	bytesWritten, err := io.Copy(io.MultiWriter(tmpFile, hash), countReader(ctx, srcFile))
 	if err != nil {
 		return nil, fmt.Errorf("failed to copy data: %w", err)
 	}
 
+	metadata, hasMetadata := readFileMetadata(ctx, metaPath)
+	if hasMetadata && metadata.SHA256 != "" && metadata.SHA256 != hex.EncodeToString(hash.Sum(nil)) {
+		return nil, fmt.Errorf("cached file for key %s: %w", key, ErrDigestMismatch)
+	}
+
 	if err := tmpFile.Sync(); err != nil {
 		return nil, fmt.Errorf("failed to sync temp file: %w", err)
 	}
@@ -349,22 +356,15 @@ func (b *LocalFileBlob) Download(ctx context.Context, key string, destPath strin
 	// Fsync parent directory for durability (optional but recommended)
 	if dir, err := os.Open(filepath.Dir(destPath)); err == nil {
 		if err := dir.Sync(); err != nil {
-			slog.Warn("failed to fsync directory after download", "path", filepath.Dir(destPath), "error", err)
+			logging.FromContext(ctx).Warn("failed to fsync directory after download", "path", filepath.Dir(destPath), "error", err)
 		}
 		_ = dir.Close()
 	}
 
 	// Attempt to restore metadata if available (best-effort)
-	if metaData, err := os.ReadFile(metaPath); err == nil {
-		var metadata FileMetadata
-		if err := json.Unmarshal(metaData, &metadata); err == nil {
-			if metadata.ModTime != "" {
-				if modTime, err := time.Parse(time.RFC3339Nano, metadata.ModTime); err == nil {
-					_ = os.Chtimes(destPath, time.Now(), modTime)
-				}
-			}
-		} else {
-			slog.Warn("failed to parse metadata file", "path", metaPath, "error", err)
+	if hasMetadata && metadata.ModTime != "" {
+		if modTime, err := time.Parse(time.RFC3339Nano, metadata.ModTime); err == nil {
+			_ = os.Chtimes(destPath, time.Now(), modTime)
 		}
 	}
 
@@ -385,6 +385,96 @@ func (b *LocalFileBlob) Download(ctx context.Context, key string, destPath strin
 	}, nil
 }
 
+// readFileMetadata reads the metadata sidecar file, returning false if it's
+// missing or invalid. Files cached by older versions may not have metadata.
+func readFileMetadata(ctx context.Context, metaPath string) (FileMetadata, bool) {
+	metaData, err := os.ReadFile(metaPath) // #nosec G304 -- path is derived from a validated key
+	if err != nil {
+		return FileMetadata{}, false
+	}
+
+	var metadata FileMetadata
+	if err := json.Unmarshal(metaData, &metadata); err != nil {
+		logging.FromContext(ctx).Warn("failed to parse metadata file", "path", metaPath, "error", err)
+		return FileMetadata{}, false
+	}
+
+	return metadata, true
+}
+
+// Exists reports whether a cached file exists for key.
+func (b *LocalFileBlob) Exists(ctx context.Context, key string) (bool, error) {
+	dataPath, _, err := b.keyToPaths(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(dataPath)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("failed to stat file: %w", err)
+}
+
+// Size returns the size of the cached file for key.
+func (b *LocalFileBlob) Size(ctx context.Context, key string) (int64, error) {
+	dataPath, _, err := b.keyToPaths(key)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// ReadRange reads part of the cached file for key.
+func (b *LocalFileBlob) ReadRange(ctx context.Context, key string, p []byte, offset int64) error {
+	dataPath, _, err := b.keyToPaths(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(dataPath) // #nosec G304 -- path is derived from a validated key
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// ReadAt may return io.EOF along with the last bytes of the file
+	n, err := f.ReadAt(p, offset)
+	if err != nil && (n < len(p) || !errors.Is(err, io.EOF)) {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the cached file and its metadata for key.
+func (b *LocalFileBlob) Delete(ctx context.Context, key string) error {
+	dataPath, metaPath, err := b.keyToPaths(key)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{dataPath, metaPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (b *LocalFileBlob) keyToPaths(key string) (dataPath, metaPath string, err error) {
 	if err := validateFileKey(key); err != nil {
 		return "", "", err
@@ -255,6 +255,88 @@ func TestLocalFileBlobDownloadNonExistent(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to open source file")
 }
 
+func TestLocalFileBlobDownloadDigestMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+	rootDir := filepath.Join(tmpDir, "cache-root")
+
+	blob, err := NewLocalFileBlob(ctx, "file://"+rootDir)
+	require.NoError(t, err)
+
+	srcFile := filepath.Join(tmpDir, "source.txt")
+	require.NoError(t, os.WriteFile(srcFile, []byte("content"), 0o600))
+
+	_, err = blob.Upload(ctx, srcFile, "test/key")
+	require.NoError(t, err)
+
+	// corrupt the cached file without updating its metadata
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "test", "key"), []byte("corrupt"), 0o600))
+
+	destFile := filepath.Join(tmpDir, "dest", "key.txt")
+	_, err = blob.Download(ctx, "test/key", destFile)
+	require.ErrorIs(t, err, ErrDigestMismatch)
+
+	_, err = os.Stat(destFile)
+	assert.True(t, os.IsNotExist(err), "corrupt file should not be written to the destination")
+}
+
+func TestLocalFileBlobReadRange(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	blob, err := NewLocalFileBlob(ctx, "file://"+tempDir)
+	require.NoError(t, err)
+
+	srcPath := filepath.Join(t.TempDir(), "source.txt")
+	require.NoError(t, os.WriteFile(srcPath, []byte("0123456789abcdef"), 0o600))
+
+	_, err = blob.Upload(ctx, srcPath, "test/key")
+	require.NoError(t, err)
+
+	size, err := blob.Size(ctx, "test/key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), size)
+
+	p := make([]byte, 4)
+	require.NoError(t, blob.ReadRange(ctx, "test/key", p, 12))
+	assert.Equal(t, "cdef", string(p))
+
+	err = blob.ReadRange(ctx, "test/key", p, 14)
+	require.Error(t, err, "reading past the end of the file should fail")
+
+	_, err = blob.Size(ctx, "missing/key")
+	require.Error(t, err)
+}
+
+func TestLocalFileBlobDelete(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+	rootDir := filepath.Join(tmpDir, "cache-root")
+
+	blob, err := NewLocalFileBlob(ctx, "file://"+rootDir)
+	require.NoError(t, err)
+
+	srcFile := filepath.Join(tmpDir, "source.txt")
+	require.NoError(t, os.WriteFile(srcFile, []byte("content"), 0o600))
+
+	_, err = blob.Upload(ctx, srcFile, "test/key")
+	require.NoError(t, err)
+
+	require.NoError(t, blob.Delete(ctx, "test/key"))
+
+	exists, err := blob.Exists(ctx, "test/key")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = os.Stat(filepath.Join(rootDir, "test", "key"+metadataSuffix))
+	assert.True(t, os.IsNotExist(err), "metadata should be removed")
+
+	// deleting a missing key succeeds
+	require.NoError(t, blob.Delete(ctx, "test/key"))
+}
+
 func TestLocalFileBlobUploadInvalidKey(t *testing.T) {
 	ctx := context.Background()
 
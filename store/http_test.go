@@ -0,0 +1,240 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeArtifactServer is a minimal generic repository storing objects in memory.
+type fakeArtifactServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers []http.Header
+}
+
+func newFakeArtifactServer(t *testing.T) (*fakeArtifactServer, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeArtifactServer{objects: make(map[string][]byte)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		fake.headers = append(fake.headers, r.Header.Clone())
+
+		switch r.Method {
+		case http.MethodPut:
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fake.objects[r.URL.Path] = data
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			data, ok := fake.objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("X-Request-Id", "req-123")
+			_, _ = w.Write(data)
+		case http.MethodDelete:
+			if _, ok := fake.objects[r.URL.Path]; !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			delete(fake.objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return fake, server
+}
+
+func TestHTTPBlob_UploadDownload(t *testing.T) {
+	ctx := context.Background()
+	fake, server := newFakeArtifactServer(t)
+
+	blob, err := NewHTTPBlobWithOptions(ctx, server.URL+"/repository/cache", HTTPOptions{
+		Header: http.Header{"X-Jfrog-Art-Api": []string{"secret"}},
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.tar.zst")
+	require.NoError(t, os.WriteFile(srcPath, []byte("cache contents"), 0o600))
+
+	uploadInfo, err := blob.Upload(ctx, srcPath, "pipeline/key.tar.zst")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("cache contents")), uploadInfo.BytesTransferred)
+	assert.Contains(t, fake.objects, "/repository/cache/pipeline/key.tar.zst")
+
+	destPath := filepath.Join(dir, "dest.tar.zst")
+	downloadInfo, err := blob.Download(ctx, "pipeline/key.tar.zst", destPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("cache contents")), downloadInfo.BytesTransferred)
+	assert.Equal(t, "req-123", downloadInfo.RequestID)
+
+	data, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cache contents", string(data))
+
+	require.Len(t, fake.headers, 2)
+	for _, header := range fake.headers {
+		assert.Equal(t, "secret", header.Get("X-Jfrog-Art-Api"))
+	}
+}
+
+func TestHTTPBlob_BasicAuthFromURL(t *testing.T) {
+	ctx := context.Background()
+
+	var username, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ = r.BasicAuth()
+		_, _ = w.Write([]byte("data"))
+	}))
+	t.Cleanup(server.Close)
+
+	u := "http://deploy:hunter2@" + server.Listener.Addr().String() + "/cache"
+
+	blob, err := NewHTTPBlobWithOptions(ctx, u, HTTPOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, blob.baseURL.User, "credentials should be removed from the base URL")
+
+	_, err = blob.Download(ctx, "key", filepath.Join(t.TempDir(), "dest"))
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", username)
+	assert.Equal(t, "hunter2", password)
+}
+
+func TestHTTPBlob_Delete(t *testing.T) {
+	ctx := context.Background()
+	fake, server := newFakeArtifactServer(t)
+
+	blob, err := NewHTTPBlobWithOptions(ctx, server.URL, HTTPOptions{})
+	require.NoError(t, err)
+
+	fake.objects["/key"] = []byte("data")
+
+	require.NoError(t, blob.Delete(ctx, "key"))
+	assert.NotContains(t, fake.objects, "/key")
+
+	// deleting a missing object succeeds
+	require.NoError(t, blob.Delete(ctx, "key"))
+}
+
+func TestHTTPBlob_ReadRange(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("0123456789abcdef")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	blob, err := NewHTTPBlobWithOptions(ctx, server.URL, HTTPOptions{})
+	require.NoError(t, err)
+
+	size, err := blob.Size(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	p := make([]byte, 4)
+	require.NoError(t, blob.ReadRange(ctx, "key", p, 10))
+	assert.Equal(t, "abcd", string(p))
+}
+
+func TestHTTPBlob_ReadRangeUnsupported(t *testing.T) {
+	ctx := context.Background()
+
+	// the server ignores the Range header, returning the whole object
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("0123456789abcdef"))
+	}))
+	t.Cleanup(server.Close)
+
+	blob, err := NewHTTPBlobWithOptions(ctx, server.URL, HTTPOptions{})
+	require.NoError(t, err)
+
+	err = blob.ReadRange(ctx, "key", make([]byte, 4), 10)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestHTTPBlob_DownloadNotFound(t *testing.T) {
+	ctx := context.Background()
+	_, server := newFakeArtifactServer(t)
+
+	blob, err := NewHTTPBlobWithOptions(ctx, server.URL, HTTPOptions{})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	_, err = blob.Download(ctx, "missing", filepath.Join(dir, "dest"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404 Not Found")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no partial files should be left behind")
+}
+
+func TestNewHTTPBlob_InvalidURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		errContains string
+	}{
+		{name: "s3 scheme", url: "s3://bucket", errContains: "must be http or https"},
+		{name: "missing host", url: "https:///path", errContains: "host cannot be empty"},
+		{name: "unparseable", url: "://invalid", errContains: "failed to parse HTTP URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHTTPBlobWithOptions(context.Background(), tt.url, HTTPOptions{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errContains)
+		})
+	}
+}
+
+func TestHTTPBlob_ObjectURL(t *testing.T) {
+	blob, err := NewHTTPBlobWithOptions(context.Background(), "https://example.com/repo/?token=abc", HTTPOptions{})
+	require.NoError(t, err)
+
+	got, err := blob.objectURL("/pipeline/key.tar.zst")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/repo/pipeline/key.tar.zst?token=abc", got)
+
+	_, err = blob.objectURL("../escape")
+	require.Error(t, err)
+
+	_, err = blob.objectURL("")
+	require.Error(t, err)
+}
+
+func TestParseHTTPHeaders(t *testing.T) {
+	header, err := parseHTTPHeaders("Authorization: Bearer abc\n\nx-custom-header:  value \n")
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer abc", header.Get("Authorization"))
+	assert.Equal(t, "value", header.Get("X-Custom-Header"))
+
+	_, err = parseHTTPHeaders("Authorization Bearer secret")
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
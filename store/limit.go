@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TransferLimiter limits the number of concurrent requests made to blob storage
+// by all of the transfers which use it, so transfers running in parallel, such
+// as the caches saved by SaveAll, don't each use their full concurrency and
+// saturate the network. It is applied to a transfer using WithTransferLimiter.
+//
+// Requests made by S3Blob are limited, including each part of a multipart
+// transfer.
+type TransferLimiter struct {
+	slots chan struct{}
+}
+
+// NewTransferLimiter creates a limiter allowing up to n concurrent requests.
+// Returns nil, which doesn't limit transfers, if n isn't positive.
+func NewTransferLimiter(n int) *TransferLimiter {
+	if n <= 0 {
+		return nil
+	}
+
+	return &TransferLimiter{slots: make(chan struct{}, n)}
+}
+
+// Acquire waits for a request slot, returning an error if ctx is cancelled
+// first.
+func (l *TransferLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release releases a request slot acquired with Acquire.
+func (l *TransferLimiter) Release() {
+	<-l.slots
+}
+
+type transferLimiterKey struct{}
+
+// WithTransferLimiter returns a context which limits the requests made by
+// transfers using it with the limiter. A nil limiter returns ctx unchanged.
+func WithTransferLimiter(ctx context.Context, limiter *TransferLimiter) context.Context {
+	if limiter == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, transferLimiterKey{}, limiter)
+}
+
+// transferLimiterFrom returns the limiter of ctx, or nil.
+func transferLimiterFrom(ctx context.Context) *TransferLimiter {
+	limiter, _ := ctx.Value(transferLimiterKey{}).(*TransferLimiter)
+	return limiter
+}
+
+// limitedHTTPClient limits the requests made by an S3 client using the
+// limiter of each request's context. A slot is held until the response body is
+// closed, so downloads are limited while the body is read.
+type limitedHTTPClient struct {
+	client s3.HTTPClient
+}
+
+func (c limitedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	limiter := transferLimiterFrom(req.Context())
+	if limiter == nil {
+		return c.client.Do(req)
+	}
+
+	if err := limiter.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		limiter.Release()
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: sync.OnceFunc(limiter.Release)}
+
+	return resp, nil
+}
+
+// releasingBody releases a limiter slot when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
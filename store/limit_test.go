@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingHTTPClient records the maximum number of requests with open bodies.
+type countingHTTPClient struct {
+	active atomic.Int32
+	max    atomic.Int32
+}
+
+func (c *countingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	active := c.active.Add(1)
+	for {
+		current := c.max.Load()
+		if active <= current || c.max.CompareAndSwap(current, active) {
+			break
+		}
+	}
+
+	// give other requests a chance to run concurrently
+	time.Sleep(10 * time.Millisecond)
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       &countingBody{Reader: strings.NewReader("data"), client: c},
+	}, nil
+}
+
+type countingBody struct {
+	io.Reader
+	client *countingHTTPClient
+}
+
+func (b *countingBody) Close() error {
+	b.client.active.Add(-1)
+	return nil
+}
+
+func TestLimitedHTTPClient(t *testing.T) {
+	inner := &countingHTTPClient{}
+	client := limitedHTTPClient{client: inner}
+	ctx := WithTransferLimiter(context.Background(), NewTransferLimiter(2))
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+			assert.NoError(t, err)
+
+			resp, err := client.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, _ = io.Copy(io.Discard, resp.Body)
+			assert.NoError(t, resp.Body.Close())
+		})
+	}
+	wg.Wait()
+
+	assert.Positive(t, inner.max.Load())
+	assert.LessOrEqual(t, inner.max.Load(), int32(2))
+	assert.Zero(t, inner.active.Load())
+}
+
+func TestNewTransferLimiter_Unlimited(t *testing.T) {
+	assert.Nil(t, NewTransferLimiter(0))
+
+	ctx := context.Background()
+	assert.Equal(t, ctx, WithTransferLimiter(ctx, nil))
+}
+
+func TestTransferLimiter_AcquireCancelled(t *testing.T) {
+	limiter := NewTransferLimiter(1)
+	require.NoError(t, limiter.Acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, limiter.Acquire(ctx), context.Canceled)
+
+	limiter.Release()
+	require.NoError(t, limiter.Acquire(context.Background()))
+}
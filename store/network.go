@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// NetworkOptions configures how connections are made to blob storage and the
+// cache API, for agents in networks which require a proxy or a TLS
+// intercepting proxy or VPC endpoint with a private CA.
+type NetworkOptions struct {
+	// Proxy is the URL of the proxy requests are sent through, replacing the
+	// proxy configured by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables, which are honoured if it is empty.
+	Proxy string
+
+	// CABundle is the path of a PEM file of CA certificates trusted in
+	// addition to the system roots.
+	CABundle string
+}
+
+// NewTransport returns a clone of http.DefaultTransport applying the options,
+// which can also be given to api.WithTransport so the cache API and blob
+// storage are reached the same way.
+func NewTransport(opts NetworkOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", opts.Proxy)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.CABundle != "" {
+		pool, err := loadCABundle(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return transport, nil
+}
+
+// loadCABundle returns the system roots with the certificates of the PEM file
+// at path added.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path) // #nosec G304 -- path is configured by the user
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+type transportKey struct{}
+
+// WithTransport returns a context whose blob stores are created using the
+// transport, e.g. one returned by NewTransport, rather than
+// http.DefaultTransport. A nil transport returns ctx unchanged.
+func WithTransport(ctx context.Context, transport *http.Transport) context.Context {
+	if transport == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, transportKey{}, transport)
+}
+
+// transportFrom returns the transport of ctx, or nil.
+func transportFrom(ctx context.Context) *http.Transport {
+	transport, _ := ctx.Value(transportKey{}).(*http.Transport)
+	return transport
+}
+
+// httpClientFrom returns a client using the transport of ctx, or
+// http.DefaultClient if it has none.
+func httpClientFrom(ctx context.Context) *http.Client {
+	transport := transportFrom(ctx)
+	if transport == nil {
+		return http.DefaultClient
+	}
+
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransport_Proxy(t *testing.T) {
+	transport, err := NewTransport(NetworkOptions{Proxy: "http://proxy.internal:3128"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "https://bucket.s3.amazonaws.com/key", nil)
+	proxyURL, err := transport.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, &url.URL{Scheme: "http", Host: "proxy.internal:3128"}, proxyURL)
+
+	_, err = NewTransport(NetworkOptions{Proxy: "proxy.internal"})
+	assert.ErrorContains(t, err, "invalid proxy URL")
+}
+
+func TestNewTransport_CABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("cached"))
+	}))
+	defer server.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0o600))
+
+	transport, err := NewTransport(NetworkOptions{CABundle: caBundle})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// the server's certificate isn't trusted without the CA bundle
+	blob, err := NewBlobStore(ctx, LocalHTTPStore, server.URL)
+	require.NoError(t, err)
+	_, err = blob.Download(ctx, "key", filepath.Join(t.TempDir(), "untrusted"))
+	require.Error(t, err)
+
+	blob, err = NewBlobStore(WithTransport(ctx, transport), LocalHTTPStore, server.URL)
+	require.NoError(t, err)
+
+	destPath := filepath.Join(t.TempDir(), "trusted")
+	_, err = blob.Download(ctx, "key", destPath)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cached", string(content))
+}
+
+func TestNewTransport_InvalidCABundle(t *testing.T) {
+	_, err := NewTransport(NetworkOptions{CABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "failed to read CA bundle")
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, []byte("not a certificate"), 0o600))
+
+	_, err = NewTransport(NetworkOptions{CABundle: caBundle})
+	assert.ErrorContains(t, err, "no certificates found in CA bundle")
+}
+
+func TestWithTransport_Nil(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, ctx, WithTransport(ctx, nil))
+	assert.Equal(t, http.DefaultClient, httpClientFrom(ctx))
+}
@@ -15,14 +15,52 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 )
 
+const (
+	// NscBinaryEnv is the environment variable used to override the path of the nsc CLI.
+	NscBinaryEnv = "BUILDKITE_ZSTASH_NSC_BINARY"
+
+	defaultNscBinary = "nsc"
+)
+
 // NscStore implements the Blob interface for NSC artifact storage which uses the nsc CLI tool
 // https://namespace.so/docs/reference/cli/artifact-download
 // https://namespace.so/docs/reference/cli/artifact-upload
 type NscStore struct {
+	binary string
 }
 
+// NewNscStore creates an NscStore using the nsc CLI named by BUILDKITE_ZSTASH_NSC_BINARY,
+// falling back to "nsc" on the PATH.
 func NewNscStore() (*NscStore, error) {
-	return &NscStore{}, nil
+	return NewNscStoreWithBinary(os.Getenv(NscBinaryEnv))
+}
+
+// NewNscStoreWithBinary creates an NscStore using the given nsc CLI binary, which
+// may be a name to look up on the PATH or a path. An empty binary defaults to "nsc".
+func NewNscStoreWithBinary(binary string) (*NscStore, error) {
+	if binary == "" {
+		binary = defaultNscBinary
+	}
+
+	return &NscStore{binary: binary}, nil
+}
+
+// Binary returns the nsc CLI binary used by the store.
+func (n *NscStore) Binary() string {
+	return n.binary
+}
+
+// CheckHealth returns an error if the nsc CLI can't be found.
+func (n *NscStore) CheckHealth(ctx context.Context) error {
+	_, span := trace.Start(ctx, "NscStore.CheckHealth")
+	defer span.End()
+
+	if _, err := exec.LookPath(n.binary); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("nsc CLI %q not found, install it or set %s to its path: %w", n.binary, NscBinaryEnv, err)
+	}
+
+	return nil
 }
 
 // validateFilePath validates that a file path is safe for use in commands
@@ -80,7 +118,13 @@ func validateKey(key string) error {
 }
 
 func (n *NscStore) Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error) {
-	_, span := trace.Start(ctx, "NscStore.Upload")
+	return n.UploadWithExpiry(ctx, filePath, key, time.Time{})
+}
+
+// UploadWithExpiry uploads a file to NSC artifact storage, expiring the artifact at
+// expiresAt. A zero expiresAt uses the default artifact expiry.
+func (n *NscStore) UploadWithExpiry(ctx context.Context, filePath string, key string, expiresAt time.Time) (*TransferInfo, error) {
+	_, span := trace.StartLinked(ctx, "NscStore.Upload")
 	defer span.End()
 
 	// Validate input parameters to prevent command injection
@@ -93,8 +137,14 @@ func (n *NscStore) Upload(ctx context.Context, filePath string, key string) (*Tr
 
 	start := time.Now()
 
+	args := []string{n.binary, "artifact", "upload", filePath, key}
+	if expiresIn := time.Until(expiresAt).Round(time.Second); !expiresAt.IsZero() && expiresIn > 0 {
+		args = append(args, "--expires_in", expiresIn.String())
+		span.SetAttributes(attribute.String("nsc_expires_at", expiresAt.Format(time.RFC3339)))
+	}
+
 	// Execute nsc artifact upload command
-	result, err := runCommand(ctx, "", "nsc", "artifact", "upload", filePath, key)
+	result, err := runCommand(ctx, "", args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute nsc upload command: %w", err)
 	}
@@ -128,7 +178,7 @@ func (n *NscStore) Upload(ctx context.Context, filePath string, key string) (*Tr
 }
 
 func (n *NscStore) Download(ctx context.Context, key string, filePath string) (*TransferInfo, error) {
-	_, span := trace.Start(ctx, "NscStore.Download")
+	_, span := trace.StartLinked(ctx, "NscStore.Download")
 	defer span.End()
 
 	// Validate input parameters to prevent command injection
@@ -142,7 +192,7 @@ func (n *NscStore) Download(ctx context.Context, key string, filePath string) (*
 	start := time.Now()
 
 	// Execute nsc artifact download command
-	result, err := runCommand(ctx, "", "nsc", "artifact", "download", key, filePath)
+	result, err := runCommand(ctx, "", n.binary, "artifact", "download", key, filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute nsc download command: %w", err)
 	}
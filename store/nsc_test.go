@@ -13,6 +13,40 @@ import (
 func TestNscStore_Interface(t *testing.T) {
 	// This test ensures that NscStore properly implements the Blob interface
 	var _ Blob = (*NscStore)(nil)
+	var _ ExpiringBlob = (*NscStore)(nil)
+}
+
+func TestNewNscStore_Binary(t *testing.T) {
+	t.Run("defaults to nsc", func(t *testing.T) {
+		t.Setenv(NscBinaryEnv, "")
+
+		store, err := NewNscStore()
+		require.NoError(t, err)
+		assert.Equal(t, "nsc", store.Binary())
+	})
+
+	t.Run("uses env override", func(t *testing.T) {
+		t.Setenv(NscBinaryEnv, "/opt/namespace/bin/nsc")
+
+		store, err := NewNscStore()
+		require.NoError(t, err)
+		assert.Equal(t, "/opt/namespace/bin/nsc", store.Binary())
+	})
+}
+
+func TestNscStore_CheckHealth(t *testing.T) {
+	store, err := NewNscStoreWithBinary(filepath.Join(t.TempDir(), "missing-nsc"))
+	require.NoError(t, err)
+
+	err = store.CheckHealth(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), NscBinaryEnv)
+
+	t.Setenv(NscBinaryEnv, store.Binary())
+
+	_, err = NewBlobStore(context.Background(), LocalHostedAgents, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
 }
 
 func TestValidateFilePath(t *testing.T) {
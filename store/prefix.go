@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// validKeyPrefix matches prefixes using characters which are safe in the keys
+// of every built-in store.
+var validKeyPrefix = regexp.MustCompile(`^[a-zA-Z0-9._-]+(/[a-zA-Z0-9._-]+)*$`)
+
+// ValidateKeyPrefix checks a key prefix is made up of one or more path
+// segments of alphanumeric characters, ".", "_" or "-", separated by "/",
+// e.g. "my-org/my-pipeline".
+func ValidateKeyPrefix(prefix string) error {
+	if !validKeyPrefix.MatchString(prefix) {
+		return fmt.Errorf("invalid key prefix %q: must be path segments of alphanumeric characters, '.', '_' or '-' separated by '/'", prefix)
+	}
+
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("invalid key prefix %q: must not contain relative path segments", prefix)
+		}
+	}
+
+	return nil
+}
+
+// PrefixedBlob stores objects under a key prefix, so stores shared by several
+// pipelines or organizations keep their objects apart even if the object
+// names generated by the cache API match.
+//
+// PrefixedBlob implements ExpiringBlob, HeadBlob, RangeBlob and DeleteBlob,
+// forwarding to the wrapped store. Uploads with an expiry fall back to a plain
+// upload if the wrapped store can't expire objects, while the other methods
+// return an error wrapping errors.ErrUnsupported.
+type PrefixedBlob struct {
+	blob   Blob
+	prefix string
+}
+
+// NewPrefixedBlob wraps blob to store objects under prefix, which must be
+// valid according to ValidateKeyPrefix.
+func NewPrefixedBlob(blob Blob, prefix string) (*PrefixedBlob, error) {
+	if err := ValidateKeyPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	return &PrefixedBlob{blob: blob, prefix: prefix}, nil
+}
+
+// Key returns the key an object is stored under in the wrapped store.
+func (b *PrefixedBlob) Key(key string) string {
+	return path.Join(b.prefix, key)
+}
+
+func (b *PrefixedBlob) Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error) {
+	return b.blob.Upload(ctx, filePath, b.Key(key))
+}
+
+func (b *PrefixedBlob) UploadWithExpiry(ctx context.Context, filePath string, key string, expiresAt time.Time) (*TransferInfo, error) {
+	if expiringBlob, ok := b.blob.(ExpiringBlob); ok {
+		return expiringBlob.UploadWithExpiry(ctx, filePath, b.Key(key), expiresAt)
+	}
+
+	return b.blob.Upload(ctx, filePath, b.Key(key))
+}
+
+func (b *PrefixedBlob) Download(ctx context.Context, key string, destPath string) (*TransferInfo, error) {
+	return b.blob.Download(ctx, b.Key(key), destPath)
+}
+
+func (b *PrefixedBlob) Exists(ctx context.Context, key string) (bool, error) {
+	headBlob, ok := b.blob.(HeadBlob)
+	if !ok {
+		return false, fmt.Errorf("store %T can't check objects exist: %w", b.blob, errors.ErrUnsupported)
+	}
+
+	return headBlob.Exists(ctx, b.Key(key))
+}
+
+func (b *PrefixedBlob) Delete(ctx context.Context, key string) error {
+	deleteBlob, ok := b.blob.(DeleteBlob)
+	if !ok {
+		return fmt.Errorf("store %T can't delete objects: %w", b.blob, errors.ErrUnsupported)
+	}
+
+	return deleteBlob.Delete(ctx, b.Key(key))
+}
+
+func (b *PrefixedBlob) Size(ctx context.Context, key string) (int64, error) {
+	rangeBlob, ok := b.blob.(RangeBlob)
+	if !ok {
+		return 0, fmt.Errorf("store %T can't read object ranges: %w", b.blob, errors.ErrUnsupported)
+	}
+
+	return rangeBlob.Size(ctx, b.Key(key))
+}
+
+func (b *PrefixedBlob) ReadRange(ctx context.Context, key string, p []byte, offset int64) error {
+	rangeBlob, ok := b.blob.(RangeBlob)
+	if !ok {
+		return fmt.Errorf("store %T can't read object ranges: %w", b.blob, errors.ErrUnsupported)
+	}
+
+	return rangeBlob.ReadRange(ctx, b.Key(key), p, offset)
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateKeyPrefix(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		wantErr bool
+	}{
+		{prefix: "my-org"},
+		{prefix: "my-org/my_pipeline/v1.2"},
+		{prefix: "", wantErr: true},
+		{prefix: "/my-org", wantErr: true},
+		{prefix: "my-org/", wantErr: true},
+		{prefix: "my-org//pipeline", wantErr: true},
+		{prefix: "my-org/../other", wantErr: true},
+		{prefix: "./my-org", wantErr: true},
+		{prefix: "my org", wantErr: true},
+		{prefix: "my-org;rm", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			err := ValidateKeyPrefix(tt.prefix)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPrefixedBlob(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+
+	fileBlob, err := NewLocalFileBlob(ctx, "file://"+root)
+	require.NoError(t, err)
+
+	blob, err := NewPrefixedBlob(fileBlob, "my-org/my-pipeline")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zip")
+	require.NoError(t, os.WriteFile(srcPath, []byte("archive"), 0o600))
+
+	_, err = blob.Upload(ctx, srcPath, "key.zip")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(root, "my-org", "my-pipeline", "key.zip"))
+	assert.NoFileExists(t, filepath.Join(root, "key.zip"))
+
+	exists, err := blob.Exists(ctx, "key.zip")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// objects outside the prefix aren't visible
+	otherBlob, err := NewPrefixedBlob(fileBlob, "other-org")
+	require.NoError(t, err)
+	exists, err = otherBlob.Exists(ctx, "key.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	destPath := filepath.Join(dir, "dest.zip")
+	_, err = blob.Download(ctx, "key.zip", destPath)
+	require.NoError(t, err)
+	got, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(got))
+}
+
+func TestPrefixedBlob_Unsupported(t *testing.T) {
+	blob, err := NewPrefixedBlob(&fakeBlob{}, "my-org")
+	require.NoError(t, err)
+
+	_, err = blob.Exists(context.Background(), "key.zip")
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	err = blob.Delete(context.Background(), "key.zip")
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	_, err = blob.Size(context.Background(), "key.zip")
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	err = blob.ReadRange(context.Background(), "key.zip", make([]byte, 4), 0)
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	_, err = NewPrefixedBlob(&fakeBlob{}, "../escape")
+	assert.Error(t, err)
+}
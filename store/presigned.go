@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/buildkite/zstash/internal/trace"
+)
+
+// PresignedBlob implements the Blob interface for a single object using
+// presigned URLs, such as those returned in the upload and download
+// instructions of the cache API for BuildkiteHostedStore, so no bucket or
+// cloud credentials are needed. The object is uploaded using PUT to the
+// upload URL and downloaded using GET from the download URL; keys are ignored
+// as the URLs identify the object.
+type PresignedBlob struct {
+	client      *http.Client
+	uploadURL   string
+	downloadURL string
+}
+
+// PresignedURLs holds the presigned URLs of an object. Either may be empty if
+// the object is only uploaded or downloaded.
+type PresignedURLs struct {
+	Upload   string
+	Download string
+}
+
+// NewPresignedBlob creates a PresignedBlob from http:// or https:// presigned
+// URLs. Requests are sent using client, or http.DefaultClient if nil.
+func NewPresignedBlob(urls PresignedURLs, client *http.Client) (*PresignedBlob, error) {
+	for _, presignedURL := range []string{urls.Upload, urls.Download} {
+		if presignedURL == "" {
+			continue
+		}
+
+		u, err := url.Parse(presignedURL)
+		if err != nil {
+			// the URL isn't included as it holds a signature
+			return nil, fmt.Errorf("failed to parse presigned URL")
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("invalid presigned URL scheme %q: must be http or https", u.Scheme)
+		}
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &PresignedBlob{
+		client:      client,
+		uploadURL:   urls.Upload,
+		downloadURL: urls.Download,
+	}, nil
+}
+
+// Upload uploads a file to the presigned upload URL using PUT.
+func (b *PresignedBlob) Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error) {
+	ctx, span := trace.StartLinked(ctx, "PresignedBlob.Upload")
+	defer span.End()
+
+	if b.uploadURL == "" {
+		return nil, fmt.Errorf("no presigned upload URL for %s", key)
+	}
+
+	info, err := uploadHTTP(b.client, filePath, func(body io.Reader) (*http.Request, error) {
+		return newPresignedRequest(ctx, http.MethodPut, b.uploadURL, body)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setTransferAttributes(span, info, key)
+
+	return info, nil
+}
+
+// Download downloads a file from the presigned download URL using GET. The
+// file is written to a temporary file alongside destPath and renamed once
+// complete.
+func (b *PresignedBlob) Download(ctx context.Context, key string, destPath string) (*TransferInfo, error) {
+	ctx, span := trace.StartLinked(ctx, "PresignedBlob.Download")
+	defer span.End()
+
+	if b.downloadURL == "" {
+		return nil, fmt.Errorf("no presigned download URL for %s", key)
+	}
+
+	req, err := newPresignedRequest(ctx, http.MethodGet, b.downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := downloadHTTP(b.client, req, destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	setTransferAttributes(span, info, key)
+
+	return info, nil
+}
+
+func newPresignedRequest(ctx context.Context, method string, presignedURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, presignedURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	return req, nil
+}
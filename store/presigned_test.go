@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresignedBlob_UploadDownload(t *testing.T) {
+	ctx := context.Background()
+	fake, server := newFakeArtifactServer(t)
+
+	objectURL := server.URL + "/hosted/object.zip?X-Amz-Signature=abc"
+
+	blob, err := NewPresignedBlob(PresignedURLs{Upload: objectURL, Download: objectURL}, nil)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.zip")
+	require.NoError(t, os.WriteFile(srcPath, []byte("cache contents"), 0o600))
+
+	// the key is ignored as the URL identifies the object
+	uploadInfo, err := blob.Upload(ctx, srcPath, "ignored")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("cache contents")), uploadInfo.BytesTransferred)
+	assert.Equal(t, []byte("cache contents"), fake.objects["/hosted/object.zip"])
+
+	destPath := filepath.Join(dir, "dest.zip")
+	downloadInfo, err := blob.Download(ctx, "ignored", destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "req-123", downloadInfo.RequestID)
+
+	data, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cache contents", string(data))
+}
+
+func TestPresignedBlob_MissingURL(t *testing.T) {
+	ctx := context.Background()
+
+	blob, err := NewPresignedBlob(PresignedURLs{}, nil)
+	require.NoError(t, err)
+
+	_, err = blob.Upload(ctx, "archive.zip", "key")
+	assert.ErrorContains(t, err, "no presigned upload URL")
+
+	_, err = blob.Download(ctx, "key", filepath.Join(t.TempDir(), "archive.zip"))
+	assert.ErrorContains(t, err, "no presigned download URL")
+}
+
+func TestNewPresignedBlob_InvalidScheme(t *testing.T) {
+	_, err := NewPresignedBlob(PresignedURLs{Upload: "s3://bucket/object.zip"}, nil)
+	assert.ErrorContains(t, err, "invalid presigned URL scheme")
+}
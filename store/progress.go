@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TransferProgress counts the bytes sent and received by the transfers which
+// use it, so their progress can be reported while they run. It is applied to
+// a transfer using WithTransferProgress. The zero value is ready to use.
+//
+// Bytes are counted by S3Blob, HTTPBlob, PresignedBlob and LocalFileBlob,
+// including each chunk transferred by ChunkedBlob. Requests retried by S3 are
+// counted again, so the count may exceed the size of the object.
+type TransferProgress struct {
+	bytes atomic.Int64
+}
+
+// Bytes returns the number of bytes transferred so far.
+func (p *TransferProgress) Bytes() int64 {
+	return p.bytes.Load()
+}
+
+type transferProgressKey struct{}
+
+// WithTransferProgress returns a context which counts the bytes transferred
+// by transfers using it with progress. A nil progress returns ctx unchanged.
+func WithTransferProgress(ctx context.Context, progress *TransferProgress) context.Context {
+	if progress == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, transferProgressKey{}, progress)
+}
+
+// transferProgressFrom returns the progress of ctx, or nil.
+func transferProgressFrom(ctx context.Context) *TransferProgress {
+	progress, _ := ctx.Value(transferProgressKey{}).(*TransferProgress)
+	return progress
+}
+
+// countReader returns a reader counting the bytes read from r with the
+// progress of ctx, or r if there is none.
+func countReader(ctx context.Context, r io.Reader) io.Reader {
+	progress := transferProgressFrom(ctx)
+	if progress == nil {
+		return r
+	}
+
+	return &progressReader{Reader: r, progress: progress}
+}
+
+// countBody returns a body counting the bytes read from body with the
+// progress of ctx, or body if there is none.
+func countBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	progress := transferProgressFrom(ctx)
+	if progress == nil || body == nil || body == http.NoBody {
+		return body
+	}
+
+	return &progressBody{ReadCloser: body, progress: progress}
+}
+
+// progressReader counts the bytes read from a reader.
+type progressReader struct {
+	io.Reader
+	progress *TransferProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress.bytes.Add(int64(n))
+	return n, err
+}
+
+// progressBody counts the bytes read from a request or response body.
+type progressBody struct {
+	io.ReadCloser
+	progress *TransferProgress
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.progress.bytes.Add(int64(n))
+	return n, err
+}
+
+// progressHTTPClient counts the bytes of the request and response bodies of
+// an S3 client using the progress of each request's context, so the parts of
+// multipart transfers are counted as they are sent and received.
+type progressHTTPClient struct {
+	client s3.HTTPClient
+}
+
+func (c progressHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if transferProgressFrom(req.Context()) == nil {
+		return c.client.Do(req)
+	}
+
+	// a body with an unknown length would be sent chunked
+	if req.ContentLength > 0 {
+		req.Body = countBody(req.Context(), req.Body)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = countBody(req.Context(), resp.Body)
+
+	return resp, nil
+}
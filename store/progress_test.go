@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferProgress_LocalFileBlob(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	blob, err := NewLocalFileBlob(ctx, "file://"+filepath.Join(dir, "cache-root"))
+	require.NoError(t, err)
+
+	srcPath := filepath.Join(dir, "src.txt")
+	content := []byte("Hello, World! This is a test file.")
+	require.NoError(t, os.WriteFile(srcPath, content, 0o600))
+
+	upload := &TransferProgress{}
+	_, err = blob.Upload(WithTransferProgress(ctx, upload), srcPath, "key.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), upload.Bytes())
+
+	download := &TransferProgress{}
+	_, err = blob.Download(WithTransferProgress(ctx, download), "key.txt", filepath.Join(dir, "dest.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), download.Bytes())
+}
+
+func TestTransferProgress_HTTPBlob(t *testing.T) {
+	ctx := context.Background()
+	_, server := newFakeArtifactServer(t)
+
+	blob, err := NewHTTPBlob(ctx, server.URL+"/repository/cache")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.tar.zst")
+	require.NoError(t, os.WriteFile(srcPath, []byte("cache contents"), 0o600))
+
+	upload := &TransferProgress{}
+	_, err = blob.Upload(WithTransferProgress(ctx, upload), srcPath, "key.tar.zst")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("cache contents")), upload.Bytes())
+
+	download := &TransferProgress{}
+	_, err = blob.Download(WithTransferProgress(ctx, download), "key.tar.zst", filepath.Join(dir, "dest.tar.zst"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("cache contents")), download.Bytes())
+}
+
+// echoHTTPClient responds with the body of each request.
+type echoHTTPClient struct{}
+
+func (echoHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(string(data))),
+	}, nil
+}
+
+func TestProgressHTTPClient(t *testing.T) {
+	client := progressHTTPClient{client: echoHTTPClient{}}
+
+	progress := &TransferProgress{}
+	ctx := WithTransferProgress(context.Background(), progress)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://example.com", strings.NewReader("data"))
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), progress.Bytes())
+
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), progress.Bytes())
+
+	// requests without progress are not counted
+	req, err = http.NewRequestWithContext(context.Background(), http.MethodPut, "http://example.com", strings.NewReader("data"))
+	require.NoError(t, err)
+
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), progress.Bytes())
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// rangeBlockSize is the size of the blocks read by a RangeReader, so the
+// small reads made when parsing a zip central directory don't each make a
+// request.
+const rangeBlockSize = 1 << 20
+
+// maxRangeBlocks limits the blocks cached by a RangeReader, so extracting
+// entries from a large archive doesn't hold all of it in memory.
+const maxRangeBlocks = 64
+
+// RangeReader reads an object in a RangeBlob without downloading it, by
+// reading the blocks of the object which are needed. It implements
+// io.ReaderAt, so it can be used to open an archive with zip.NewReader. Up to
+// 64 blocks are cached once read. A RangeReader is safe for concurrent use,
+// though reads are made one at a time.
+type RangeReader struct {
+	mu        sync.Mutex
+	ctx       context.Context
+	blob      RangeBlob
+	key       string
+	size      int64
+	blocks    map[int64][]byte
+	bytesRead int64
+}
+
+// NewRangeReader creates a reader for the object identified by key, using ctx
+// for the reads it makes.
+func NewRangeReader(ctx context.Context, blob RangeBlob, key string) (*RangeReader, error) {
+	size, err := blob.Size(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RangeReader{
+		ctx:    ctx,
+		blob:   blob,
+		key:    key,
+		size:   size,
+		blocks: make(map[int64][]byte),
+	}, nil
+}
+
+// Size returns the size of the object in bytes.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// BytesRead returns the number of bytes read from the store.
+func (r *RangeReader) BytesRead() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.bytesRead
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+
+		block, err := r.block(pos / rangeBlockSize)
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(p[n:], block[pos%rangeBlockSize:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// block returns the block at index, reading it if it isn't cached.
+func (r *RangeReader) block(index int64) ([]byte, error) {
+	if block, ok := r.blocks[index]; ok {
+		return block, nil
+	}
+
+	offset := index * rangeBlockSize
+	block := make([]byte, min(rangeBlockSize, r.size-offset))
+	if err := r.blob.ReadRange(r.ctx, r.key, block, offset); err != nil {
+		return nil, err
+	}
+
+	if len(r.blocks) >= maxRangeBlocks {
+		// evict any block, the blocks needed again are re-read
+		for cached := range r.blocks {
+			delete(r.blocks, cached)
+			break
+		}
+	}
+
+	r.blocks[index] = block
+	r.bytesRead += int64(len(block))
+
+	return block, nil
+}
+
+// byteRange returns the value of a Range header reading length bytes from
+// offset.
+func byteRange(offset int64, length int) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1)
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRangeBlob serves ranges of an in-memory object, counting the reads.
+type memoryRangeBlob struct {
+	fakeBlob
+	data  []byte
+	reads int
+}
+
+func (b *memoryRangeBlob) Size(ctx context.Context, key string) (int64, error) {
+	return int64(len(b.data)), nil
+}
+
+func (b *memoryRangeBlob) ReadRange(ctx context.Context, key string, p []byte, offset int64) error {
+	b.reads++
+	copy(p, b.data[offset:])
+	return nil
+}
+
+func TestRangeReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), rangeBlockSize/8)
+	blob := &memoryRangeBlob{data: data}
+
+	reader, err := NewRangeReader(context.Background(), blob, "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), reader.Size())
+
+	// a read spanning two blocks
+	p := make([]byte, 20)
+	n, err := reader.ReadAt(p, rangeBlockSize-10)
+	require.NoError(t, err)
+	assert.Equal(t, 20, n)
+	assert.Equal(t, data[rangeBlockSize-10:rangeBlockSize+10], p)
+	assert.Equal(t, 2, blob.reads)
+
+	// cached blocks aren't read again
+	_, err = reader.ReadAt(p, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 2, blob.reads)
+	assert.Equal(t, int64(2*rangeBlockSize), reader.BytesRead())
+
+	// a read past the end returns io.EOF
+	n, err = reader.ReadAt(p, int64(len(data))-5)
+	require.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, data[len(data)-5:], p[:5])
+
+	_, err = reader.ReadAt(p, int64(len(data)))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestRangeReader_EvictsBlocks(t *testing.T) {
+	data := make([]byte, (maxRangeBlocks+1)*rangeBlockSize)
+	blob := &memoryRangeBlob{data: data}
+
+	reader, err := NewRangeReader(context.Background(), blob, "key")
+	require.NoError(t, err)
+
+	p := make([]byte, 1)
+	for offset := int64(0); offset < int64(len(data)); offset += rangeBlockSize {
+		_, err := reader.ReadAt(p, offset)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, maxRangeBlocks+1, blob.reads)
+	assert.Len(t, reader.blocks, maxRangeBlocks)
+}
@@ -2,21 +2,29 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log/slog"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	smithymiddleware "github.com/aws/smithy-go/middleware"
+	"github.com/buildkite/zstash/internal/logging"
 	"github.com/buildkite/zstash/internal/trace"
 	"go.opentelemetry.io/otel/attribute"
 )
@@ -28,6 +36,8 @@ import (
 //	s3://my-bucket/prefix
 //	s3://my-bucket?region=us-east-1
 //	s3://my-bucket/prefix?region=us-east-1&endpoint=http://localhost:9000&use_path_style=true
+//	s3://my-bucket?accelerate=true&use_arn_region=true
+//	s3://my-bucket?sse=aws:kms&kms_key_id=alias/cache&storage_class=STANDARD_IA&tag.team=ci
 type Options struct {
 	S3Endpoint   string
 	Bucket       string
@@ -36,6 +46,35 @@ type Options struct {
 	UsePathStyle bool
 	Concurrency  int
 	PartSizeMB   int
+
+	// UseAccelerate enables S3 Transfer Acceleration, which must also be enabled
+	// on the bucket.
+	UseAccelerate bool
+	// UseARNRegion uses the region from access point ARNs, including multi-region
+	// access points, instead of the configured region.
+	UseARNRegion bool
+
+	// SSE is the server-side encryption algorithm applied to uploaded objects,
+	// e.g. "AES256" or "aws:kms". Empty uses the bucket default.
+	SSE string
+	// KMSKeyID is the KMS key used when SSE is "aws:kms" or "aws:kms:dsse".
+	// Empty uses the AWS managed key.
+	KMSKeyID string
+	// StorageClass is the storage class of uploaded objects, e.g. "STANDARD_IA".
+	// Empty uses the bucket default.
+	StorageClass string
+	// Tags are applied to uploaded objects, configured using "tag.<key>=<value>"
+	// query parameters.
+	Tags map[string]string
+	// SkipRefreshOnRead disables copying objects to themselves after they are
+	// downloaded, which resets LastModified to extend lifecycle expiration.
+	// Configured using "refresh_on_read=false", for buckets where agents are
+	// only permitted to read.
+	SkipRefreshOnRead bool
+	// SkipVerifyChecksum disables verifying the SHA-256 checksum S3 reports
+	// for uploaded objects. Configured using "verify_checksum=false", for
+	// S3-compatible stores which don't support additional checksums.
+	SkipVerifyChecksum bool
 }
 
 func OptionsFromURL(s3url string) (*Options, error) {
@@ -65,6 +104,17 @@ func OptionsFromURL(s3url string) (*Options, error) {
 		opts.UsePathStyle = true
 	}
 
+	if u.Query().Get("accelerate") == "true" {
+		if opts.UsePathStyle {
+			return nil, fmt.Errorf("accelerate can't be used with use_path_style")
+		}
+		opts.UseAccelerate = true
+	}
+
+	if u.Query().Get("use_arn_region") == "true" {
+		opts.UseARNRegion = true
+	}
+
 	if concurrencyStr := u.Query().Get("concurrency"); concurrencyStr != "" {
 		concurrency, err := strconv.Atoi(concurrencyStr)
 		if err != nil {
@@ -87,9 +137,63 @@ func OptionsFromURL(s3url string) (*Options, error) {
 		opts.PartSizeMB = partSizeMB
 	}
 
+	if sse := u.Query().Get("sse"); sse != "" {
+		if !slices.Contains(types.ServerSideEncryption("").Values(), types.ServerSideEncryption(sse)) {
+			return nil, fmt.Errorf("invalid sse value %q", sse)
+		}
+		opts.SSE = sse
+	}
+
+	if kmsKeyID := u.Query().Get("kms_key_id"); kmsKeyID != "" {
+		if opts.SSE != string(types.ServerSideEncryptionAwsKms) && opts.SSE != string(types.ServerSideEncryptionAwsKmsDsse) {
+			return nil, fmt.Errorf("kms_key_id requires sse to be %s or %s", types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse)
+		}
+		opts.KMSKeyID = kmsKeyID
+	}
+
+	if storageClass := u.Query().Get("storage_class"); storageClass != "" {
+		if !slices.Contains(types.StorageClass("").Values(), types.StorageClass(storageClass)) {
+			return nil, fmt.Errorf("invalid storage_class value %q", storageClass)
+		}
+		opts.StorageClass = storageClass
+	}
+
+	if refreshStr := u.Query().Get("refresh_on_read"); refreshStr != "" {
+		refreshOnRead, err := strconv.ParseBool(refreshStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid refresh_on_read value %q: %w", refreshStr, err)
+		}
+		opts.SkipRefreshOnRead = !refreshOnRead
+	}
+
+	if verifyStr := u.Query().Get("verify_checksum"); verifyStr != "" {
+		verifyChecksum, err := strconv.ParseBool(verifyStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verify_checksum value %q: %w", verifyStr, err)
+		}
+		opts.SkipVerifyChecksum = !verifyChecksum
+	}
+
+	for name, values := range u.Query() {
+		tagKey, ok := strings.CutPrefix(name, "tag.")
+		if !ok {
+			continue
+		}
+		if tagKey == "" {
+			return nil, fmt.Errorf("invalid tag parameter %q: tag key is required", name)
+		}
+		if opts.Tags == nil {
+			opts.Tags = make(map[string]string)
+		}
+		opts.Tags[tagKey] = values[0]
+	}
+
 	return opts, nil
 }
 
+// refreshTimeout bounds how long an expiration refresh can take.
+const refreshTimeout = 30 * time.Second
+
 // S3Blob implements the Blob interface using AWS S3
 type S3Blob struct {
 	client      *s3.Client
@@ -99,6 +203,14 @@ type S3Blob struct {
 	prefix      string
 	concurrency int
 	partSize    int64
+
+	sse          types.ServerSideEncryption
+	kmsKeyID     string
+	storageClass types.StorageClass
+	tagging      string
+
+	refreshOnRead  bool
+	verifyChecksum bool
 }
 
 // NewS3Blob creates a new S3Blob instance using an S3 URL and prefix
@@ -108,17 +220,27 @@ func NewS3Blob(ctx context.Context, s3url string) (*S3Blob, error) {
 		return nil, fmt.Errorf("failed to parse S3 URL: %w", err)
 	}
 
-	// Load the AWS configuration
-	cfg, err := config.LoadDefaultConfig(ctx)
+	// Load the AWS configuration, connecting through the transport of ctx if
+	// it has one, see WithTransport
+	var loadOpts []func(*config.LoadOptions) error
+	if transport := transportFrom(ctx); transport != nil {
+		loadOpts = append(loadOpts, config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+			t.Proxy = transport.Proxy
+			t.TLSClientConfig = transport.TLSClientConfig.Clone()
+		})))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	slog.Debug("configured S3 bucket",
+	logging.FromContext(ctx).Debug("configured S3 bucket",
 		"bucket", opts.Bucket,
 		"region", opts.Region,
 		"prefix", opts.Prefix,
-		"endpoint", opts.S3Endpoint)
+		"endpoint", opts.S3Endpoint,
+		"accelerate", opts.UseAccelerate)
 
 	// Create a new S3 client
 	client := s3.NewFromConfig(cfg,
@@ -127,6 +249,12 @@ func NewS3Blob(ctx context.Context, s3url string) (*S3Blob, error) {
 			if opts.UsePathStyle {
 				o.UsePathStyle = true
 			}
+			o.UseAccelerate = opts.UseAccelerate
+			o.UseARNRegion = opts.UseARNRegion
+
+			// limit requests across transfers and count their progress, see
+			// WithTransferLimiter and WithTransferProgress
+			o.HTTPClient = progressHTTPClient{client: limitedHTTPClient{client: o.HTTPClient}}
 
 			// used for local testing or custom S3 endpoints
 			if opts.S3Endpoint != "" {
@@ -157,7 +285,7 @@ func NewS3Blob(ctx context.Context, s3url string) (*S3Blob, error) {
 		d.PartSize = partSize
 	})
 
-	slog.Debug("configured S3 transfer manager",
+	logging.FromContext(ctx).Debug("configured S3 transfer manager",
 		"concurrency", concurrency,
 		"part_size_bytes", partSize,
 	)
@@ -170,12 +298,83 @@ func NewS3Blob(ctx context.Context, s3url string) (*S3Blob, error) {
 		prefix:      opts.Prefix,
 		concurrency: concurrency,
 		partSize:    partSize,
+
+		sse:          types.ServerSideEncryption(opts.SSE),
+		kmsKeyID:     opts.KMSKeyID,
+		storageClass: types.StorageClass(opts.StorageClass),
+		tagging:      encodeTags(opts.Tags),
+
+		refreshOnRead:  !opts.SkipRefreshOnRead,
+		verifyChecksum: !opts.SkipVerifyChecksum,
 	}, nil
 }
 
+// encodeTags encodes tags as a URL query string, as expected by the Tagging
+// field of PutObject and CreateMultipartUpload.
+func encodeTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+
+	return values.Encode()
+}
+
+// putObjectInput builds the upload request, applying the configured encryption,
+// storage class and tags. The uploader applies these to CreateMultipartUpload
+// for multipart uploads. When verifying checksums, S3 is asked to record the
+// SHA-256 checksum of the object, or of each part of a multipart upload.
+func (b *S3Blob) putObjectInput(fullKey string, body io.Reader) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(fullKey),
+		Body:                 body,
+		ServerSideEncryption: b.sse,
+		StorageClass:         b.storageClass,
+	}
+
+	if b.verifyChecksum {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+
+	if b.kmsKeyID != "" {
+		input.SSEKMSKeyId = aws.String(b.kmsKeyID)
+	}
+
+	if b.tagging != "" {
+		input.Tagging = aws.String(b.tagging)
+	}
+
+	return input
+}
+
+// copyObjectInput builds the request used to copy an object to itself. The
+// encryption and storage class are reapplied as S3 otherwise falls back to the
+// bucket defaults for the copy.
+func (b *S3Blob) copyObjectInput(fullKey string) *s3.CopyObjectInput {
+	input := &s3.CopyObjectInput{
+		Bucket:               aws.String(b.bucketName),
+		Key:                  aws.String(fullKey),
+		CopySource:           aws.String(fmt.Sprintf("%s/%s", b.bucketName, fullKey)),
+		MetadataDirective:    types.MetadataDirectiveReplace,
+		ServerSideEncryption: b.sse,
+		StorageClass:         b.storageClass,
+	}
+
+	if b.kmsKeyID != "" {
+		input.SSEKMSKeyId = aws.String(b.kmsKeyID)
+	}
+
+	return input
+}
+
 // Upload uploads a file to S3 using multipart upload for parallel transfers
 func (b *S3Blob) Upload(ctx context.Context, filePath string, key string) (*TransferInfo, error) {
-	ctx, span := trace.Start(ctx, "S3Blob.Upload")
+	ctx, span := trace.StartLinked(ctx, "S3Blob.Upload")
 	defer span.End()
 
 	start := time.Now()
@@ -200,22 +399,24 @@ func (b *S3Blob) Upload(ctx context.Context, filePath string, key string) (*Tran
 
 	bytesWritten := fileInfo.Size()
 
-	slog.Debug("starting S3 upload",
+	logging.FromContext(ctx).Debug("starting S3 upload",
 		"key", fullKey,
 		"file_size", bytesWritten,
 		"concurrency", b.concurrency,
 	)
 
 	// Upload the file to S3 using the multipart uploader
-	result, err := b.uploader.Upload(ctx, &s3.PutObjectInput{ //nolint:staticcheck // SA1019: pending migration to transfermanager
-		Bucket: aws.String(b.bucketName),
-		Key:    aws.String(fullKey),
-		Body:   file,
-	})
+	result, err := b.uploader.Upload(ctx, b.putObjectInput(fullKey, file)) //nolint:staticcheck // SA1019: pending migration to transfermanager
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload file to S3: %w", err)
 	}
 
+	if b.verifyChecksum {
+		if err := b.checkUploadChecksum(ctx, file, bytesWritten, fullKey, aws.ToString(result.ChecksumSHA256)); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get actual part count from completed parts
 	// For single part uploads (small files), CompletedParts is empty so default to 1
 	partCount := len(result.CompletedParts)
@@ -229,7 +430,7 @@ func (b *S3Blob) Upload(ctx context.Context, filePath string, key string) (*Tran
 	duration := time.Since(start)
 	averageSpeed := calculateTransferSpeedMBps(bytesWritten, duration)
 
-	slog.Debug("completed S3 upload",
+	logging.FromContext(ctx).Debug("completed S3 upload",
 		"key", fullKey,
 		"bytes_transferred", bytesWritten,
 		"parts_uploaded", partCount,
@@ -256,9 +457,81 @@ func (b *S3Blob) Upload(ctx context.Context, filePath string, key string) (*Tran
 	}, nil
 }
 
+// checkUploadChecksum compares the checksum S3 reported for an uploaded object
+// with the checksum of the local file, so data corrupted in flight fails the
+// upload rather than being committed. Stores which don't report a checksum
+// aren't verified. A mismatched object is deleted on a best effort basis.
+func (b *S3Blob) checkUploadChecksum(ctx context.Context, file *os.File, size int64, fullKey, checksum string) error {
+	if checksum == "" {
+		logging.FromContext(ctx).Debug("store didn't return a checksum, skipping verification", "key", fullKey)
+		return nil
+	}
+
+	expected, err := s3Checksum(io.NewSectionReader(file, 0, size), size, b.partSize)
+	if err != nil {
+		return fmt.Errorf("failed to checksum file: %w", err)
+	}
+
+	if checksumsMatch(checksum, expected) {
+		return nil
+	}
+
+	if _, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(fullKey),
+	}); err != nil {
+		logging.FromContext(ctx).Warn("failed to delete corrupt object", "key", fullKey, "error", err)
+	}
+
+	return fmt.Errorf("uploaded object %s has checksum %s, expected %s: %w", fullKey, checksum, expected, ErrDigestMismatch)
+}
+
+// s3Checksum returns the SHA-256 checksum S3 reports for a file of size bytes
+// read from r when uploaded by the uploader with partSize. This is the base64
+// checksum of the content for single part uploads, and for multipart uploads
+// the checksum of the part checksums followed by the number of parts, e.g.
+// "DUoRhQ==-3".
+func s3Checksum(r io.Reader, size, partSize int64) (string, error) {
+	if size <= partSize {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r); err != nil {
+			return "", err
+		}
+
+		return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	// the uploader grows the part size to fit within the maximum number of parts
+	if size/partSize >= int64(manager.MaxUploadParts) {
+		partSize = size/int64(manager.MaxUploadParts) + 1
+	}
+
+	checksums := sha256.New()
+	parts := 0
+	for remaining := size; remaining > 0; remaining -= partSize {
+		hash := sha256.New()
+		if _, err := io.CopyN(hash, r, min(partSize, remaining)); err != nil {
+			return "", err
+		}
+		_, _ = checksums.Write(hash.Sum(nil))
+		parts++
+	}
+
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(checksums.Sum(nil)), parts), nil
+}
+
+// checksumsMatch compares S3 checksums, ignoring the part count suffix of
+// multipart checksums as it isn't included in every response.
+func checksumsMatch(a, b string) bool {
+	a, _, _ = strings.Cut(a, "-")
+	b, _, _ = strings.Cut(b, "-")
+
+	return a == b
+}
+
 // Download downloads a file from S3 using parallel range requests for large files
 func (b *S3Blob) Download(ctx context.Context, key string, destPath string) (*TransferInfo, error) {
-	ctx, span := trace.Start(ctx, "S3Blob.Download")
+	ctx, span := trace.StartLinked(ctx, "S3Blob.Download")
 	defer span.End()
 
 	start := time.Now()
@@ -275,7 +548,7 @@ func (b *S3Blob) Download(ctx context.Context, key string, destPath string) (*Tr
 		_ = destFile.Close()
 	}()
 
-	slog.Debug("starting S3 download",
+	logging.FromContext(ctx).Debug("starting S3 download",
 		"key", fullKey,
 		"concurrency", b.concurrency,
 	)
@@ -313,7 +586,7 @@ func (b *S3Blob) Download(ctx context.Context, key string, destPath string) (*Tr
 	duration := time.Since(start)
 	averageSpeed := calculateTransferSpeedMBps(bytesWritten, duration)
 
-	slog.Debug("completed S3 download",
+	logging.FromContext(ctx).Debug("completed S3 download",
 		"key", fullKey,
 		"bytes_transferred", bytesWritten,
 		"parts_downloaded", actualPartCount,
@@ -329,24 +602,10 @@ func (b *S3Blob) Download(ctx context.Context, key string, destPath string) (*Tr
 		attribute.Int("concurrency", b.concurrency),
 	)
 
-	// Copy the object to itself to reset the LastModified timestamp,
-	// which extends the lifecycle expiration.
-	copySource := fmt.Sprintf("%s/%s", b.bucketName, fullKey)
-	_, err = b.client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:            aws.String(b.bucketName),
-		Key:               aws.String(fullKey),
-		CopySource:        aws.String(copySource),
-		MetadataDirective: "REPLACE",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to refresh object expiration: %w", err)
+	if b.refreshOnRead {
+		b.refreshExpiration(ctx, fullKey)
 	}
 
-	slog.Debug("refreshed object expiration",
-		"key", fullKey,
-		"bucket", b.bucketName,
-	)
-
 	return &TransferInfo{
 		BytesTransferred: bytesWritten,
 		TransferSpeed:    averageSpeed,
@@ -357,6 +616,122 @@ func (b *S3Blob) Download(ctx context.Context, key string, destPath string) (*Tr
 	}, nil
 }
 
+// Exists reports whether an object exists using HeadObject.
+func (b *S3Blob) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, span := trace.StartLinked(ctx, "S3Blob.Exists")
+	defer span.End()
+
+	_, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(b.getFullKey(key)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check object: %w", err)
+	}
+
+	return true, nil
+}
+
+// Size returns the size of an object using HeadObject.
+func (b *S3Blob) Size(ctx context.Context, key string) (int64, error) {
+	ctx, span := trace.StartLinked(ctx, "S3Blob.Size")
+	defer span.End()
+
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(b.getFullKey(key)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to check object: %w", err)
+	}
+
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+// ReadRange reads part of an object using a ranged GetObject.
+func (b *S3Blob) ReadRange(ctx context.Context, key string, p []byte, offset int64) error {
+	ctx, span := trace.StartLinked(ctx, "S3Blob.ReadRange")
+	defer span.End()
+
+	if len(p) == 0 {
+		return nil
+	}
+
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(b.getFullKey(key)),
+		Range:  aws.String(byteRange(offset, len(p))),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	defer func() {
+		_ = out.Body.Close()
+	}()
+
+	if _, err := io.ReadFull(out.Body, p); err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+
+	span.SetAttributes(
+		attribute.Int64("offset", offset),
+		attribute.Int("length", len(p)),
+	)
+
+	return nil
+}
+
+// Delete removes an object using DeleteObject.
+func (b *S3Blob) Delete(ctx context.Context, key string) error {
+	ctx, span := trace.StartLinked(ctx, "S3Blob.Delete")
+	defer span.End()
+
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucketName),
+		Key:    aws.String(b.getFullKey(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
+// refreshExpiration copies the object to itself to reset the LastModified
+// timestamp, which extends the lifecycle expiration. It runs in the background
+// work of ctx if it has one, see WithBackgroundWork. This is best effort, as
+// agents may only be permitted to read from the bucket.
+func (b *S3Blob) refreshExpiration(ctx context.Context, fullKey string) {
+	// the refresh may outlive the download, so it isn't cancelled with it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+
+	runInBackground(ctx, func() {
+		defer cancel()
+
+		ctx, span := trace.StartLinked(ctx, "S3Blob.RefreshExpiration")
+		defer span.End()
+
+		if _, err := b.client.CopyObject(ctx, b.copyObjectInput(fullKey)); err != nil {
+			span.RecordError(err)
+			logging.FromContext(ctx).Warn("failed to refresh object expiration",
+				"key", fullKey,
+				"bucket", b.bucketName,
+				"error", err,
+			)
+			return
+		}
+
+		logging.FromContext(ctx).Debug("refreshed object expiration",
+			"key", fullKey,
+			"bucket", b.bucketName,
+		)
+	})
+}
+
 // getFullKey combines the prefix with the key
 func (b *S3Blob) getFullKey(key string) string {
 	// Remove leading slash from key if present
@@ -1,8 +1,14 @@
 package store
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -237,6 +243,129 @@ func TestOptionsFromURL(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "transfer acceleration",
+			url:  "s3://my-bucket?accelerate=true",
+			want: &Options{
+				Bucket:        "my-bucket",
+				Region:        "us-east-1",
+				UseAccelerate: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "use arn region",
+			url:  "s3://my-bucket?use_arn_region=true",
+			want: &Options{
+				Bucket:       "my-bucket",
+				Region:       "us-east-1",
+				UseARNRegion: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:        "accelerate with path style",
+			url:         "s3://my-bucket?accelerate=true&use_path_style=true",
+			wantErr:     true,
+			errContains: "accelerate can't be used with use_path_style",
+		},
+		{
+			name: "sse with kms key",
+			url:  "s3://my-bucket?sse=aws:kms&kms_key_id=alias/cache",
+			want: &Options{
+				Bucket:   "my-bucket",
+				Region:   "us-east-1",
+				SSE:      "aws:kms",
+				KMSKeyID: "alias/cache",
+			},
+			wantErr: false,
+		},
+		{
+			name: "sse with AES256",
+			url:  "s3://my-bucket?sse=AES256",
+			want: &Options{
+				Bucket: "my-bucket",
+				Region: "us-east-1",
+				SSE:    "AES256",
+			},
+			wantErr: false,
+		},
+		{
+			name:        "invalid sse value",
+			url:         "s3://my-bucket?sse=rot13",
+			wantErr:     true,
+			errContains: "invalid sse value",
+		},
+		{
+			name:        "kms_key_id without kms sse",
+			url:         "s3://my-bucket?kms_key_id=alias/cache",
+			wantErr:     true,
+			errContains: "kms_key_id requires sse",
+		},
+		{
+			name: "storage class",
+			url:  "s3://my-bucket?storage_class=INTELLIGENT_TIERING",
+			want: &Options{
+				Bucket:       "my-bucket",
+				Region:       "us-east-1",
+				StorageClass: "INTELLIGENT_TIERING",
+			},
+			wantErr: false,
+		},
+		{
+			name:        "invalid storage class",
+			url:         "s3://my-bucket?storage_class=COLD",
+			wantErr:     true,
+			errContains: "invalid storage_class value",
+		},
+		{
+			name: "object tags",
+			url:  "s3://my-bucket?tag.team=ci&tag.cost-centre=1234",
+			want: &Options{
+				Bucket: "my-bucket",
+				Region: "us-east-1",
+				Tags:   map[string]string{"team": "ci", "cost-centre": "1234"},
+			},
+			wantErr: false,
+		},
+		{
+			name:        "tag without key",
+			url:         "s3://my-bucket?tag.=ci",
+			wantErr:     true,
+			errContains: "tag key is required",
+		},
+		{
+			name: "refresh_on_read disabled",
+			url:  "s3://my-bucket?refresh_on_read=false",
+			want: &Options{
+				Bucket:            "my-bucket",
+				Region:            "us-east-1",
+				SkipRefreshOnRead: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:        "invalid refresh_on_read value",
+			url:         "s3://my-bucket?refresh_on_read=sometimes",
+			wantErr:     true,
+			errContains: "invalid refresh_on_read value",
+		},
+		{
+			name: "verify_checksum disabled",
+			url:  "s3://my-bucket?verify_checksum=false",
+			want: &Options{
+				Bucket:             "my-bucket",
+				Region:             "us-east-1",
+				SkipVerifyChecksum: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:        "invalid verify_checksum value",
+			url:         "s3://my-bucket?verify_checksum=sometimes",
+			wantErr:     true,
+			errContains: "invalid verify_checksum value",
+		},
 		{
 			name:        "invalid URL",
 			url:         "://invalid",
@@ -266,6 +395,14 @@ func TestOptionsFromURL(t *testing.T) {
 			assert.Equal(t, tt.want.UsePathStyle, got.UsePathStyle, "UsePathStyle mismatch")
 			assert.Equal(t, tt.want.Concurrency, got.Concurrency, "Concurrency mismatch")
 			assert.Equal(t, tt.want.PartSizeMB, got.PartSizeMB, "PartSizeMB mismatch")
+			assert.Equal(t, tt.want.UseAccelerate, got.UseAccelerate, "UseAccelerate mismatch")
+			assert.Equal(t, tt.want.UseARNRegion, got.UseARNRegion, "UseARNRegion mismatch")
+			assert.Equal(t, tt.want.SSE, got.SSE, "SSE mismatch")
+			assert.Equal(t, tt.want.KMSKeyID, got.KMSKeyID, "KMSKeyID mismatch")
+			assert.Equal(t, tt.want.StorageClass, got.StorageClass, "StorageClass mismatch")
+			assert.Equal(t, tt.want.Tags, got.Tags, "Tags mismatch")
+			assert.Equal(t, tt.want.SkipRefreshOnRead, got.SkipRefreshOnRead, "SkipRefreshOnRead mismatch")
+			assert.Equal(t, tt.want.SkipVerifyChecksum, got.SkipVerifyChecksum, "SkipVerifyChecksum mismatch")
 		})
 	}
 }
@@ -325,3 +462,77 @@ func TestGetFullKey(t *testing.T) {
 		})
 	}
 }
+
+func TestS3Blob_PutObjectInput(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		blob := &S3Blob{bucketName: "my-bucket"}
+
+		input := blob.putObjectInput("prefix/key", nil)
+		assert.Equal(t, "my-bucket", aws.ToString(input.Bucket))
+		assert.Equal(t, "prefix/key", aws.ToString(input.Key))
+		assert.Empty(t, input.ServerSideEncryption)
+		assert.Nil(t, input.SSEKMSKeyId)
+		assert.Empty(t, input.StorageClass)
+		assert.Nil(t, input.Tagging)
+		assert.Empty(t, input.ChecksumAlgorithm)
+	})
+
+	t.Run("checksum verification", func(t *testing.T) {
+		blob := &S3Blob{bucketName: "my-bucket", verifyChecksum: true}
+
+		input := blob.putObjectInput("key", nil)
+		assert.Equal(t, types.ChecksumAlgorithmSha256, input.ChecksumAlgorithm)
+	})
+
+	t.Run("encryption, storage class and tags", func(t *testing.T) {
+		blob := &S3Blob{
+			bucketName:   "my-bucket",
+			sse:          types.ServerSideEncryptionAwsKms,
+			kmsKeyID:     "alias/cache",
+			storageClass: types.StorageClassStandardIa,
+			tagging:      encodeTags(map[string]string{"team": "ci", "cost centre": "1234"}),
+		}
+
+		input := blob.putObjectInput("key", nil)
+		assert.Equal(t, types.ServerSideEncryptionAwsKms, input.ServerSideEncryption)
+		assert.Equal(t, "alias/cache", aws.ToString(input.SSEKMSKeyId))
+		assert.Equal(t, types.StorageClassStandardIa, input.StorageClass)
+		assert.Equal(t, "cost+centre=1234&team=ci", aws.ToString(input.Tagging))
+
+		copyInput := blob.copyObjectInput("key")
+		assert.Equal(t, "my-bucket/key", aws.ToString(copyInput.CopySource))
+		assert.Equal(t, types.ServerSideEncryptionAwsKms, copyInput.ServerSideEncryption)
+		assert.Equal(t, "alias/cache", aws.ToString(copyInput.SSEKMSKeyId))
+		assert.Equal(t, types.StorageClassStandardIa, copyInput.StorageClass)
+	})
+}
+
+func TestS3Checksum(t *testing.T) {
+	data := []byte("hello world, this is a cache archive")
+
+	sum := sha256.Sum256(data)
+
+	t.Run("single part", func(t *testing.T) {
+		got, err := s3Checksum(bytes.NewReader(data), int64(len(data)), 64)
+		require.NoError(t, err)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), got)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		part1 := sha256.Sum256(data[:16])
+		part2 := sha256.Sum256(data[16:32])
+		part3 := sha256.Sum256(data[32:])
+		composite := sha256.Sum256(slices.Concat(part1[:], part2[:], part3[:]))
+
+		got, err := s3Checksum(bytes.NewReader(data), int64(len(data)), 16)
+		require.NoError(t, err)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(composite[:])+"-3", got)
+	})
+}
+
+func TestChecksumsMatch(t *testing.T) {
+	assert.True(t, checksumsMatch("DUoRhQ==-3", "DUoRhQ==-3"))
+	assert.True(t, checksumsMatch("DUoRhQ==", "DUoRhQ==-3"))
+	assert.True(t, checksumsMatch("DUoRhQ==", "DUoRhQ=="))
+	assert.False(t, checksumsMatch("DUoRhQ==-3", "AAAAAA==-3"))
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"time"
 )
 
@@ -11,8 +12,18 @@ const (
 	LocalHostedAgents = "local_hosted_agents"
 	// local file store type
 	LocalFileStore = "local_file"
+	// local HTTP store type, for generic artifact servers
+	LocalHTTPStore = "local_http"
+	// Buildkite hosted store type, transferred using presigned URLs from the
+	// cache API, see PresignedBlob
+	BuildkiteHostedStore = "buildkite_hosted"
 )
 
+// ErrDigestMismatch is returned when downloaded data doesn't match the
+// checksum recorded when it was uploaded, or when the checksum of an uploaded
+// object reported by the store doesn't match the local file.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
 type TransferInfo struct {
 	BytesTransferred int64
 	TransferSpeed    float64 // in MB/s
@@ -20,11 +31,13 @@ type TransferInfo struct {
 	Duration         time.Duration
 	PartCount        int // number of parts used in multipart transfer (0 if not multipart)
 	Concurrency      int // number of concurrent uploads/downloads used
+	ChunkCount       int // number of chunks in the object when using chunked storage
+	ReusedChunks     int // number of chunks already present in the store which weren't uploaded
 }
 
 func IsValidStore(storeType string) bool {
 	switch storeType {
-	case LocalS3Store, LocalHostedAgents, LocalFileStore:
+	case LocalS3Store, LocalHostedAgents, LocalFileStore, LocalHTTPStore, BuildkiteHostedStore:
 		return true
 	default:
 		return false
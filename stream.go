@@ -0,0 +1,239 @@
+package zstash
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SaveFromReader saves a cache by uploading an archive read from r, such as
+// the standard input of a process, so a build running in a container can
+// save a cache without sharing a filesystem with the agent. The stream must be
+// a zip or tar.gz archive with entries relative to the cache paths, such as
+// the output of "tar -czf - -C ~ .npm" for a cache of "~/.npm".
+//
+// The archive is read into a temporary file before anything is uploaded, as
+// its size and digest must be known up front, and is otherwise saved as with
+// SaveFromArchive. The whole of r is read even if the cache already exists.
+//
+// Example:
+//
+//	result, err := cacheClient.SaveFromReader(ctx, "node_modules", os.Stdin)
+//	if err != nil {
+//	    log.Fatalf("Cache save failed: %v", err)
+//	}
+func (c *Cache) SaveFromReader(ctx context.Context, cacheID string, r io.Reader) (SaveResult, error) {
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	c.emit(ctx, SaveStarted{EventInfo: newEventInfo(cacheID)})
+
+	result, err := c.saveFromReader(ctx, cacheID, r)
+	result.Stages = stages.finish(time.Now())
+	c.emit(ctx, SaveCompleted{EventInfo: newEventInfo(cacheID), Result: result, Err: err})
+	c.reportSave(ctx, cacheID, result, err)
+
+	return result, err
+}
+
+func (c *Cache) saveFromReader(ctx context.Context, cacheID string, r io.Reader) (SaveResult, error) {
+	if _, err := c.findCache(cacheID); err != nil {
+		return SaveResult{}, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "zstash-stream")
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	archivePath := filepath.Join(tmpDir, "archive")
+	if err := writeStream(archivePath, r); err != nil {
+		return SaveResult{}, err
+	}
+
+	return c.save(ctx, cacheID, archivePath, saveTarget{}, SaveOptions{})
+}
+
+// writeStream writes everything read from r to a new file at path.
+func writeStream(path string, r io.Reader) error {
+	f, err := os.Create(path) // #nosec G304 -- path is in a temp directory
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreToWriter downloads the archive of a cache and writes it to w, such as
+// the standard output of a process, rather than extracting it, so a build
+// running in a container can restore a cache without sharing a filesystem
+// with the agent.
+//
+// The entry is found as with Restore, trying the cache key and then its
+// fallback keys. Nothing is written on a cache miss, which is not an error
+// condition; check RestoreResult.CacheRestored. The archive is written as
+// stored, in the format reported by its entry, and Archive.Size reports its
+// size. As nothing is extracted, the remaining Archive metrics are not
+// populated.
+//
+// Example:
+//
+//	result, err := cacheClient.RestoreToWriter(ctx, "node_modules", os.Stdout)
+//	if err != nil {
+//	    log.Fatalf("Cache restore failed: %v", err)
+//	}
+//	if !result.CacheRestored {
+//	    log.Printf("Cache miss for key: %s", result.Key)
+//	}
+func (c *Cache) RestoreToWriter(ctx context.Context, cacheID string, w io.Writer) (RestoreResult, error) {
+	ctx = logging.WithLogger(ctx, c.logger)
+	ctx, stages := withStageTimings(ctx)
+
+	c.emit(ctx, RestoreStarted{EventInfo: newEventInfo(cacheID)})
+
+	result, err := c.restoreToWriter(ctx, cacheID, w)
+	result.Stages = stages.finish(time.Now())
+	c.emit(ctx, RestoreCompleted{EventInfo: newEventInfo(cacheID), Result: result, Err: err})
+	c.reportRestore(ctx, cacheID, result, err)
+
+	return result, err
+}
+
+func (c *Cache) restoreToWriter(ctx context.Context, cacheID string, w io.Writer) (RestoreResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.RestoreToWriter")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("cache.id", cacheID))
+
+	startTime := time.Now()
+	result := RestoreResult{}
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return result, err
+	}
+
+	result.Key = cacheConfig.Key
+
+	span.SetAttributes(attribute.String("cache.key", cacheConfig.Key))
+
+	ctx = trace.WithCache(ctx, cacheID, cacheConfig.Key, c.registry)
+
+	c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache exists", 0, 0)
+
+	retrieveResp, exists, err := c.retrieveCache(ctx, cacheConfig, FallbackOrdered)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to retrieve cache")
+		return result, fmt.Errorf("failed to retrieve cache: %w", err)
+	}
+
+	if !exists {
+		result.TotalDuration = time.Since(startTime)
+		span.SetAttributes(attribute.Bool("cache.hit", false))
+		span.SetStatus(codes.Ok, "cache miss")
+		c.callProgress(ctx, cacheID, "complete", "Cache miss", 0, 0)
+		return result, nil
+	}
+
+	result.Key = retrieveResp.Key
+	result.FallbackUsed = retrieveResp.Fallback
+	result.CacheHit = !retrieveResp.Fallback
+	result.ExpiresAt = retrieveResp.ExpiresAt
+
+	// only the temp directory holds the archive, nothing is extracted
+	if err := checkRestoreSpace(ctx, cacheID, int64(retrieveResp.FileSize), nil, false); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "insufficient disk space")
+		return result, err
+	}
+
+	c.callProgress(ctx, cacheID, "downloading", "Downloading cache archive", 0, 0)
+	c.emit(ctx, DownloadStarted{EventInfo: newEventInfo(cacheID), Key: result.Key, Fallback: result.FallbackUsed})
+
+	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to download cache")
+		return result, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	result.Transfer = TransferMetrics{
+		BytesTransferred: transferInfo.BytesTransferred,
+		TransferSpeed:    transferInfo.TransferSpeed,
+		Duration:         transferInfo.Duration,
+		RequestID:        transferInfo.RequestID,
+		PartCount:        transferInfo.PartCount,
+		Concurrency:      transferInfo.Concurrency,
+		ChunkCount:       transferInfo.ChunkCount,
+	}
+
+	c.emit(ctx, Downloaded{EventInfo: newEventInfo(cacheID), Transfer: result.Transfer})
+
+	c.callProgress(ctx, cacheID, "writing", "Writing cache archive", 0, int(transferInfo.BytesTransferred))
+
+	written, err := copyFile(w, archiveFile)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write archive")
+		return result, err
+	}
+
+	result.Archive = ArchiveMetrics{Size: written}
+	result.CacheRestored = true
+	result.TotalDuration = time.Since(startTime)
+
+	span.SetAttributes(
+		attribute.Bool("cache.hit", result.CacheHit),
+		attribute.Bool("cache.fallback_used", result.FallbackUsed),
+		attribute.Int64("cache.archive_size_bytes", written),
+	)
+	span.SetStatus(codes.Ok, "cache archive written")
+
+	c.callProgress(ctx, cacheID, "complete", "Cache archive written", 0, 0)
+
+	return result, nil
+}
+
+// copyFile writes the contents of the file at path to w.
+func copyFile(w io.Writer, path string) (int64, error) {
+	f, err := os.Open(path) // #nosec G304 -- path is the downloaded archive
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+
+	written, err := io.Copy(w, f)
+	if err != nil {
+		return written, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return written, nil
+}
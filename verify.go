@@ -0,0 +1,251 @@
+package zstash
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/archive"
+	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/internal/logging"
+	"github.com/buildkite/zstash/internal/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// VerifyOptions controls the behaviour of Verify.
+type VerifyOptions struct {
+	// Key verifies this cache key instead of the cache's configured key, e.g.
+	// the key reported by an earlier restore.
+	Key string
+
+	// CompareWorkingTree compares the archived files against the cache paths
+	// on disk, reporting any drift in VerifyResult.Drift.
+	CompareWorkingTree bool
+}
+
+// VerifyResult contains the outcome of verifying a cache entry.
+type VerifyResult struct {
+	// Exists indicates whether the cache entry was found. The remaining
+	// fields other than Key are only populated when it exists.
+	Exists bool
+
+	// Key is the cache key that was verified.
+	Key string
+
+	// Digest is the digest recorded for the entry when it was saved,
+	// e.g. "sha256:...".
+	Digest string
+
+	// ActualDigest is the digest of the downloaded archive.
+	ActualDigest string
+
+	// Entries lists the names of the files and directories in the archive.
+	Entries []string
+
+	// Drift lists the files which differ between the archive and the cache
+	// paths on disk. Only populated with VerifyOptions.CompareWorkingTree.
+	Drift []archive.Diff
+
+	// Transfer contains information about the download operation.
+	Transfer TransferMetrics
+
+	// TotalDuration is the end-to-end duration of the verification.
+	TotalDuration time.Duration
+}
+
+// Verify downloads the archive for a cache entry and checks it without
+// restoring it, for auditing cache correctness, e.g. after a toolchain
+// upgrade.
+//
+// The archive's digest is checked against the digest recorded when it was
+// saved, and its entries are listed. With VerifyOptions.CompareWorkingTree the
+// archived files are also compared with the cache paths on disk; files which
+// differ are reported in VerifyResult.Drift rather than as an error.
+//
+// Only the exact key is verified, fallback keys are not considered. A missing
+// cache entry is not an error. Returns an error wrapping ErrDigestMismatch if
+// the archive doesn't match its digest.
+//
+// Example:
+//
+//	result, err := cacheClient.Verify(ctx, "node_modules", zstash.VerifyOptions{CompareWorkingTree: true})
+//	if err != nil {
+//	    log.Fatalf("Cache verify failed: %v", err)
+//	}
+//	for _, diff := range result.Drift {
+//	    log.Printf("%s: %s", diff.Kind, diff.Path)
+//	}
+func (c *Cache) Verify(ctx context.Context, cacheID string, opts VerifyOptions) (VerifyResult, error) {
+	tracer := otel.Tracer("github.com/buildkite/zstash")
+	ctx, span := tracer.Start(ctx, "Cache.Verify")
+	defer span.End()
+
+	ctx = logging.WithLogger(ctx, c.logger)
+
+	span.SetAttributes(
+		attribute.String("cache.id", cacheID),
+		attribute.Bool("cache.compare_working_tree", opts.CompareWorkingTree),
+	)
+
+	startTime := time.Now()
+	result := VerifyResult{}
+
+	cacheConfig, err := c.findCache(cacheID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to find cache configuration")
+		return result, err
+	}
+
+	result.Key = cacheConfig.Key
+	if opts.Key != "" {
+		result.Key = opts.Key
+	}
+
+	span.SetAttributes(attribute.String("cache.key", result.Key))
+
+	ctx = trace.WithCache(ctx, cacheID, result.Key, c.registry)
+
+	branch := c.scopeFor(cacheConfig).branch
+
+	c.callProgress(ctx, cacheID, "checking_exists", "Checking if cache exists", 0, 0)
+
+	peekResp, exists, err := c.client.CachePeekExists(ctx, c.registry, api.CachePeekReq{
+		Key:    result.Key,
+		Branch: branch,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to check cache existence")
+		return result, fmt.Errorf("failed to check cache existence: %w", err)
+	}
+
+	if !exists {
+		result.TotalDuration = time.Since(startTime)
+		span.SetStatus(codes.Ok, "cache not found")
+		c.callProgress(ctx, cacheID, "complete", "Cache not found", 0, 0)
+		return result, nil
+	}
+
+	result.Exists = true
+	result.Digest = peekResp.Digest
+
+	retrieveResp, exists, err := c.client.CacheRetrieve(ctx, c.registry, api.CacheRetrieveReq{
+		Key:    result.Key,
+		Branch: branch,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to retrieve cache")
+		return result, fmt.Errorf("failed to retrieve cache: %w", err)
+	}
+	if !exists {
+		err := fmt.Errorf("cache entry for key %s expired during verification", result.Key)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to retrieve cache")
+		return result, err
+	}
+
+	c.callProgress(ctx, cacheID, "downloading", "Downloading cache archive", 0, 0)
+
+	tmpDir, archiveFile, transferInfo, err := c.downloadCache(ctx, cacheID, retrieveResp)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to download cache")
+		return result, fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	result.Transfer = TransferMetrics{
+		BytesTransferred: transferInfo.BytesTransferred,
+		TransferSpeed:    transferInfo.TransferSpeed,
+		Duration:         transferInfo.Duration,
+		RequestID:        transferInfo.RequestID,
+		PartCount:        transferInfo.PartCount,
+		Concurrency:      transferInfo.Concurrency,
+		ChunkCount:       transferInfo.ChunkCount,
+	}
+
+	c.callProgress(ctx, cacheID, "verifying", "Verifying cache archive", 0, 0)
+
+	err = c.verifyArchive(ctx, archiveFile, cacheConfig, opts, &result)
+	result.TotalDuration = time.Since(startTime)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to verify cache")
+		return result, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("cache.entries", len(result.Entries)),
+		attribute.Int("cache.drift", len(result.Drift)),
+	)
+	span.SetStatus(codes.Ok, "cache verified")
+	c.callProgress(ctx, cacheID, "complete", "Cache verified", 0, 0)
+
+	return result, nil
+}
+
+// verifyArchive checks the digest of the downloaded archive, lists its entries
+// and compares it with the working tree if requested.
+func (c *Cache) verifyArchive(ctx context.Context, archiveFile string, cacheConfig *cache.Cache, opts VerifyOptions, result *VerifyResult) error {
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("failed to read archive file: %w", err)
+	}
+
+	result.ActualDigest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	if result.Digest != "" && !strings.EqualFold(result.Digest, result.ActualDigest) {
+		return fmt.Errorf("%w: archive is %s, expected %s", ErrDigestMismatch, result.ActualDigest, result.Digest)
+	}
+
+	result.Entries, err = archive.ListArchive(ctx, f, size)
+	if err != nil {
+		return fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	if opts.CompareWorkingTree {
+		result.Drift, err = compareWorkingTree(ctx, f, size, cacheConfig.Paths, archive.CompareOptions{
+			Ignore:          cacheConfig.Ignore,
+			NoDefaultIgnore: cacheConfig.NoDefaultIgnore,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to compare archive with working tree: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// compareWorkingTree compares the archive with the files on disk, using the
+// archive's manifest when it has one to avoid decompressing every file.
+func compareWorkingTree(ctx context.Context, f *os.File, size int64, paths []string, compareOpts archive.CompareOptions) ([]archive.Diff, error) {
+	manifest, err := archive.ReadManifest(ctx, f, size)
+	if errors.Is(err, archive.ErrNoManifest) {
+		return archive.CompareFilesWithOptions(ctx, f, size, paths, compareOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return archive.CompareManifestWithOptions(ctx, manifest, paths, compareOpts)
+}
@@ -31,12 +31,23 @@ package zstash
 
 import (
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/buildkite/zstash/api"
+	"github.com/buildkite/zstash/archive"
 	"github.com/buildkite/zstash/cache"
+	"github.com/buildkite/zstash/store"
 )
 
+// DefaultTransferTimeout is the time limit for uploading or downloading an
+// archive when Config.UploadTimeout or Config.DownloadTimeout isn't set.
+const DefaultTransferTimeout = time.Hour
+
 // Sentinel errors for common scenarios
 var (
 	// ErrCacheNotFound is returned when a requested cache ID doesn't exist
@@ -46,8 +57,113 @@ var (
 	// ErrInvalidConfiguration is returned when configuration validation fails
 	// during cache client creation.
 	ErrInvalidConfiguration = errors.New("invalid configuration")
+
+	// ErrArchiveTooLarge is returned by Save when the built archive exceeds the
+	// configured size limit. Use errors.As with *ArchiveSizeError for details.
+	ErrArchiveTooLarge = errors.New("archive exceeds size limit")
+
+	// ErrRegistryNotFound is returned when the configured cache registry
+	// doesn't exist or isn't accessible with the API token.
+	ErrRegistryNotFound = api.ErrCacheRegistryNotFound
+
+	// ErrStoreUnavailable is returned when the blob store can't be created,
+	// e.g. the bucket URL is invalid or the nsc CLI is missing.
+	ErrStoreUnavailable = errors.New("store unavailable")
+
+	// ErrUploadFailed is returned by Save when uploading the archive to the
+	// blob store fails.
+	ErrUploadFailed = errors.New("failed to upload cache")
+
+	// ErrDownloadFailed is returned by Restore when downloading the archive
+	// from the blob store fails.
+	ErrDownloadFailed = errors.New("failed to download cache")
+
+	// ErrDigestMismatch is returned by Restore when the downloaded archive
+	// doesn't match the checksum recorded when it was saved, indicating the
+	// stored cache is corrupt. It's returned along with ErrDownloadFailed.
+	// It's also returned by Save, along with ErrUploadFailed, when the store
+	// reports a checksum for the uploaded archive which doesn't match it, in
+	// which case the cache entry isn't committed.
+	ErrDigestMismatch = store.ErrDigestMismatch
+
+	// ErrManifestMismatch is returned by Restore with
+	// RestoreOptions.ValidateManifest when restored files don't match the
+	// manifest recorded in the archive.
+	ErrManifestMismatch = errors.New("restored files don't match manifest")
+
+	// ErrPlatformMismatch is returned by Restore with
+	// RestoreOptions.FailOnPlatformMismatch when a cache with StrictPlatform
+	// matches an entry saved on a different platform.
+	ErrPlatformMismatch = errors.New("cache entry was saved on a different platform")
+
+	// ErrInsufficientSpace is returned by Restore when the temp directory or
+	// the cache paths don't have enough free space for the cache archive,
+	// before it's downloaded. Use errors.As with *DiskSpaceError for details.
+	ErrInsufficientSpace = errors.New("insufficient disk space")
 )
 
+// ArchiveSizeError is returned by Save when the built archive exceeds the
+// cache's MaxSize or the client's MaxArchiveSize.
+type ArchiveSizeError struct {
+	// CacheID is the ID of the cache being saved.
+	CacheID string
+	// Size is the size of the built archive in bytes.
+	Size int64
+	// Limit is the configured size limit in bytes.
+	Limit int64
+	// LargestFiles lists the largest files in the archive, largest first.
+	LargestFiles []archive.FileStats
+}
+
+func (e *ArchiveSizeError) Error() string {
+	msg := fmt.Sprintf("%s: cache %s archive is %d bytes, limit is %d bytes", ErrArchiveTooLarge, e.CacheID, e.Size, e.Limit)
+
+	if len(e.LargestFiles) > 0 {
+		files := make([]string, len(e.LargestFiles))
+		for i, file := range e.LargestFiles {
+			files[i] = fmt.Sprintf("%s (%d bytes)", file.Path, file.Size)
+		}
+		msg += fmt.Sprintf("; largest files: %s", strings.Join(files, ", "))
+	}
+
+	return msg
+}
+
+func (e *ArchiveSizeError) Unwrap() error {
+	return ErrArchiveTooLarge
+}
+
+// DiskSpaceError is returned by Restore when a filesystem the cache archive is
+// downloaded or extracted to has less free space than the archive.
+type DiskSpaceError struct {
+	// CacheID is the ID of the cache being restored.
+	CacheID string
+	// Path is the directory whose filesystem is short of space.
+	Path string
+	// Required is the size of the cache archive in bytes.
+	Required int64
+	// Available is the free space in bytes, including the existing files in
+	// the cache paths which would be replaced.
+	Available uint64
+	// TempDir is set when Path is the temp directory the archive is
+	// downloaded to.
+	TempDir bool
+}
+
+func (e *DiskSpaceError) Error() string {
+	msg := fmt.Sprintf("%s: cache %s archive is %d bytes, only %d bytes available in %s", ErrInsufficientSpace, e.CacheID, e.Required, e.Available, e.Path)
+
+	if e.TempDir {
+		return msg + "; free up space or set TMPDIR to a directory on a larger filesystem"
+	}
+
+	return msg + "; free up space on the filesystem of the cache paths"
+}
+
+func (e *DiskSpaceError) Unwrap() error {
+	return ErrInsufficientSpace
+}
+
 // Cache provides cache save and restore operations with the Buildkite cache API.
 //
 // A Cache client is created once with configuration and can be used for multiple
@@ -58,7 +174,10 @@ var (
 type Cache struct {
 	client       api.CacheClient
 	bucketURL    string
+	bucketURLs   map[string]string
+	region       string
 	format       string
+	externalZstd bool
 	branch       string
 	pipeline     string
 	organization string
@@ -66,6 +185,28 @@ type Cache struct {
 	registry     string
 	caches       []cache.Cache
 	onProgress   ProgressCallback
+	progressLog  bool
+	events       chan<- Event
+
+	maxArchiveSize         int64
+	warnOnArchiveSizeLimit bool
+	chunkedStorage         bool
+	uploadTimeout          time.Duration
+	downloadTimeout        time.Duration
+	overlaps               []PathOverlap
+	reporter               Reporter
+	keepArchiveDir         string
+	keyPrefix              string
+	transferLimiter        *store.TransferLimiter
+	transport              *http.Transport
+	logger                 *slog.Logger
+
+	mu           sync.Mutex
+	fingerprints map[string]pathsFingerprint
+
+	registryCacheTTL time.Duration
+	registryMu       sync.Mutex
+	registries       map[string]cachedRegistry
 }
 
 // Config holds all configuration for creating a Cache client.
@@ -81,9 +222,42 @@ type Config struct {
 	// Examples: "s3://bucket-name", "gs://bucket-name", "file:///path/to/dir"
 	BucketURL string
 
-	// Format is the archive format. Defaults to "zip" if not specified.
+	// BucketURLs optionally maps regions or store types (e.g. "local_s3") to
+	// bucket URLs, so agents can use their nearest bucket. The bucket for
+	// Region is used if there is one, then the bucket for the store type
+	// returned by the cache registry, falling back to BucketURL. Entries are
+	// only restorable from the bucket they were saved to, so buckets for
+	// different regions should be replicated if agents share caches.
+	BucketURLs map[string]string
+
+	// Region selects a bucket from BucketURLs. Defaults to the AGENT_REGION
+	// environment variable, read from Env if provided.
+	Region string
+
+	// KeyPrefix, if set, is prepended to the object names of cache entries in
+	// the store, e.g. "my-org/my-pipeline", so pipelines sharing a bucket
+	// can't overwrite or read each other's objects even if their names match.
+	// Must be path segments of alphanumeric characters, ".", "_" or "-"
+	// separated by "/". Entries saved with a different prefix can't be
+	// restored.
+	KeyPrefix string
+
+	// Format is the archive format saved, "zip", "tar.gz", "tar.zst" or a
+	// format registered with archive.RegisterFormat. Defaults to "zip" if not
+	// specified. tar.gz archives can be exchanged with other cache tools
+	// during a migration. Restores detect the format of each archive, so any
+	// supported format is restored whatever the Format.
+	// Registries which advertise the compression formats they accept are
+	// sent zip archives in the most preferred format they accept instead, see
+	// CompressionZstd and CompressionZip.
 	Format string
 
+	// ExternalZstd compresses and decompresses tar.zst archives with the zstd
+	// binary using all cores ("zstd -T0"), which is significantly faster than
+	// compressing in process on agents with many cores. Archives are
+	// compressed in process if the binary isn't installed.
+	ExternalZstd bool
+
 	// Branch is the git branch name, used for cache scoping in the Buildkite API.
 	Branch string
 
@@ -93,7 +267,10 @@ type Config struct {
 	// Organization is the organization slug, used for cache scoping in the Buildkite API.
 	Organization string
 
-	// Platform is the OS/arch string (e.g., "linux/amd64", "darwin/arm64").
+	// Platform is the platform tag sent to the API when saving and returned by
+	// the platform key template function. It is usually the OS/arch string
+	// (e.g., "linux/amd64", "darwin/arm64") but can be customised to separate
+	// caches which aren't compatible, such as "linux/amd64/musl".
 	// If empty, defaults to runtime.GOOS/runtime.GOARCH.
 	Platform string
 
@@ -105,16 +282,114 @@ type Config struct {
 	// Env is an optional environment variable map used for cache template expansion.
 	// If nil, OS environment variables are used instead via os.Getenv.
 	// Cache keys and paths can use templates like "{{ env \"NODE_VERSION\" }}".
+	// Use configuration.LoadEnvFile to add variables from a dotenv file.
 	Env map[string]string
 
 	// Caches is the list of cache configurations to manage.
 	// Cache keys and paths will be expanded using template variables.
 	Caches []cache.Cache
 
+	// VersionKeys appends a short hash of each cache's definition, its paths,
+	// the working directory's .zstashignore, the archive format and archive
+	// options, to its key and fallback keys, e.g. "v1-go-abc123-1f2e3d4c".
+	// Changing the cached paths then invalidates old entries rather than
+	// restoring archives which are missing directories.
+	VersionKeys bool
+
+	// MaxArchiveSize is the maximum size in bytes of archives built by Save,
+	// guarding against accidentally caching large directories. Individual
+	// caches can override this using MaxSize. Zero disables the limit.
+	MaxArchiveSize int64
+
+	// WarnOnArchiveSizeLimit logs a warning and continues saving when an
+	// archive exceeds the size limit, rather than failing with ErrArchiveTooLarge.
+	WarnOnArchiveSizeLimit bool
+
+	// ChunkedStorage stores archives as content-defined chunks, so only chunks
+	// which aren't already in the store are uploaded. This reduces uploads when
+	// archives change a little between saves. Requires a store which can check
+	// for existing objects; other stores upload archives as usual.
+	ChunkedStorage bool
+
+	// TransferConcurrency limits the number of concurrent requests made to
+	// blob storage across all of the client's transfers, such as the caches
+	// saved in parallel by SaveAll, so each transfer doesn't use its full
+	// concurrency and saturate the network. Currently applies to S3 stores.
+	// Zero doesn't limit requests.
+	TransferConcurrency int
+
+	// UploadTimeout limits how long uploading an archive to the blob store can
+	// take, so a hung connection fails the save rather than stalling the job.
+	// Defaults to DefaultTransferTimeout if zero. Negative disables the limit.
+	UploadTimeout time.Duration
+
+	// DownloadTimeout limits how long downloading an archive from the blob
+	// store can take. Defaults to DefaultTransferTimeout if zero. Negative
+	// disables the limit.
+	DownloadTimeout time.Duration
+
+	// Proxy is the URL of the proxy used to reach blob storage, replacing the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, which are
+	// honoured if it is empty. See store.NetworkOptions.
+	Proxy string
+
+	// CABundle is the path of a PEM file of CA certificates trusted when
+	// connecting to blob storage, in addition to the system roots, e.g. for
+	// a TLS intercepting proxy or an S3 VPC endpoint with a private CA.
+	//
+	// Neither Proxy nor CABundle apply to Client, which isn't created by
+	// NewCache. Reach the API the same way by giving the transport returned by
+	// store.NewTransport to api.WithTransport.
+	CABundle string
+
+	// RegistryCacheTTL is how long the cache registry response is reused by
+	// saves, avoiding a request for every cache. Defaults to
+	// DefaultRegistryCacheTTL if zero. Negative disables caching.
+	RegistryCacheTTL time.Duration
+
 	// OnProgress is an optional callback for progress updates during operations.
 	// If nil, no progress callbacks are made. The callback must be thread-safe
 	// as it may be called from multiple goroutines.
 	OnProgress ProgressCallback
+
+	// ProgressLog controls whether uploads and downloads log a line to Logger
+	// each time another 10% of the archive is transferred, with the transfer
+	// speed and estimated time remaining, so long transfers don't look hung in
+	// a job log. Defaults to ProgressLogAuto, which logs progress when the
+	// BUILDKITE environment variable is set, read from Env if provided.
+	ProgressLog ProgressLogMode
+
+	// KeepArchiveDir, if set, is a directory where archives built by saves are
+	// kept as "<cache ID>.<format>", such as "node_modules.zip", rather than
+	// deleted, e.g. to upload them as a build artifact or inspect them.
+	// Archives are kept whether or not the upload succeeds. See
+	// SaveResult.KeptArchivePath.
+	KeepArchiveDir string
+
+	// Events is an optional channel which receives typed progress and
+	// lifecycle events, such as ArchiveBuilt and Committed, as an alternative
+	// to parsing OnProgress stages. Each send waits for the event to be
+	// received, unless the operation's context is cancelled, so the channel
+	// should be buffered or drained concurrently. The channel isn't closed by
+	// the cache client.
+	Events chan<- Event
+
+	// Reporter, if set, receives a UsageEvent after each save and restore,
+	// e.g. a WebhookReporter. Failures to report are logged, and don't fail
+	// the save or restore.
+	Reporter Reporter
+
+	// StatsLedger, if set, is the path of a local file a UsageEvent is
+	// appended to as a line of JSON after each save and restore, alongside
+	// the Reporter, e.g. DefaultStatsLedger. ReadStats summarises the ledger
+	// to tune cache keys on long-lived agents.
+	StatsLedger string
+
+	// Logger receives the client's logs, including those of the API client,
+	// stores and archives used by its operations, so embedders control where
+	// they are written and in which format, e.g. with slog.NewJSONHandler or
+	// slog.NewTextHandler. Defaults to slog.Default().
+	Logger *slog.Logger
 }
 
 // ProgressCallback is called during long-running operations to report progress.
@@ -135,6 +410,7 @@ type Config struct {
 //   - "checking_exists": Checking if cache already exists
 //   - "fetching_registry": Looking up cache registry
 //   - "building_archive": Building archive (current=files processed, total=total files)
+//   - "inspecting_archive": Inspecting an existing archive, for SaveFromArchive
 //   - "creating_entry": Creating cache entry in API
 //   - "uploading": Uploading cache (current=bytes sent, total=total bytes)
 //   - "committing": Committing cache entry
@@ -143,8 +419,11 @@ type Config struct {
 // Restore operation stages:
 //   - "validating": Validating cache configuration
 //   - "checking_exists": Checking if cache exists
+//   - "waiting_for_pending": Waiting for another job to commit the cache entry, with RestoreOptions.WaitForPending
 //   - "downloading": Downloading cache (current=bytes received, total=total bytes)
 //   - "extracting": Extracting files (current=files extracted, total=total files)
+//   - "validating_manifest": Validating restored files, with RestoreOptions.ValidateManifest
+//   - "linking": Linking cache paths to staged files, with RestoreOptions.LinkStaged
 //   - "complete": Operation finished successfully
 type ProgressCallback func(cacheID string, stage string, message string, current int, total int)
 
@@ -184,6 +463,10 @@ type SaveResult struct {
 	// When false, Transfer will be nil since no upload was performed.
 	CacheCreated bool
 
+	// Unchanged indicates that SaveIfChanged skipped the save because the
+	// cache paths were identical to those produced by the last restore.
+	Unchanged bool
+
 	// Key is the actual cache key that was used (after template expansion).
 	Key string
 
@@ -191,6 +474,10 @@ type SaveResult struct {
 	// Empty if CacheCreated is false.
 	UploadID string
 
+	// Compression is the compression format the archive was saved in,
+	// negotiated with the cache registry. Empty if CacheCreated is false.
+	Compression string
+
 	// Archive contains information about the archive that was built,
 	// including size, compression ratio, and file counts.
 	Archive ArchiveMetrics
@@ -199,9 +486,41 @@ type SaveResult struct {
 	// Nil if CacheCreated is false (cache already existed).
 	Transfer *TransferMetrics
 
+	// UploadResumed indicates an earlier save of the same archive was
+	// interrupted after uploading it but before committing, so the uploaded
+	// object was verified and committed rather than uploaded again. Transfer
+	// then reports no bytes transferred.
+	UploadResumed bool
+
+	// KeptArchivePath is where the archive was kept when Config.KeepArchiveDir
+	// is set. Empty if no archive was built or it couldn't be kept.
+	KeptArchivePath string
+
+	// MissingPaths lists the cache paths left out of the archive as missing or
+	// empty, under the cache's MissingPaths policy.
+	MissingPaths []string
+
+	// SharedPaths lists the cache paths left out of the archive as they're
+	// archived once in an entry shared with other caches, with
+	// SaveAllOptions.SharePaths.
+	SharedPaths []string
+
+	// PathsMissing indicates the save was skipped because every cache path
+	// was missing or empty, so there was nothing to archive.
+	PathsMissing bool
+
+	// WouldSave indicates a dry run, see SaveOptions.DryRun, found the cache
+	// would be saved, as it has paths to archive and no entry for its key.
+	WouldSave bool
+
 	// TotalDuration is the end-to-end duration of the save operation,
 	// from validation through commit (if created) or early exit (if exists).
 	TotalDuration time.Duration
+
+	// Stages lists the stages of the save in the order they ran, such as
+	// "building_archive" and "uploading", with when each started and how long
+	// it took. See ProgressCallback for the stages.
+	Stages []StageTiming
 }
 
 // RestoreResult contains detailed information about a cache restore operation.
@@ -238,9 +557,214 @@ type RestoreResult struct {
 	// ExpiresAt indicates when this cache entry will expire.
 	ExpiresAt time.Time
 
+	// Metadata describes where and when the restored cache entry was saved,
+	// e.g. to trace a bad cache back to the job which created it. Fields are
+	// empty if the metadata couldn't be fetched.
+	Metadata EntryMetadata
+
+	// PlatformMismatch is the platform the matched entry was saved on, when
+	// a cache with StrictPlatform treated it as a miss because it differs
+	// from Config.Platform.
+	PlatformMismatch string
+
+	// WaitedForPending is how long the restore waited for a pending entry
+	// to be committed, with RestoreOptions.WaitForPending.
+	WaitedForPending time.Duration
+
+	// OverwrittenFiles lists existing files which were replaced by the
+	// restored archive. Only populated with the ConflictOverwrite policy.
+	OverwrittenFiles []string
+
+	// SkippedFiles lists existing files which were left untouched instead of
+	// being replaced by the restored archive. Only populated with the
+	// ConflictSkip policy.
+	SkippedFiles []string
+
+	// ManifestValidated indicates the restored files were validated against
+	// the archive's manifest, with RestoreOptions.ValidateManifest.
+	ManifestValidated bool
+
+	// StagingPath is the directory files were extracted into with
+	// RestoreModeStaged.
+	StagingPath string
+
+	// StagedPaths maps each restored cache path to the directory beneath
+	// StagingPath its files were extracted into, with RestoreModeStaged.
+	StagedPaths map[string]string
+
+	// MissingSharedPaths lists the cache paths archived in an entry shared
+	// with other caches, see SaveAllOptions.SharePaths, which couldn't be
+	// restored as the shared entry wasn't found. CacheHit is false when any
+	// are missing, as the cache is only partly restored.
+	MissingSharedPaths []string
+
+	// Ranged indicates the files were read from the archive with ranged
+	// requests rather than downloading it, with RestoreModePartial.
+	Ranged bool
+
 	// TotalDuration is the end-to-end duration of the restore operation,
 	// from validation through extraction.
 	TotalDuration time.Duration
+
+	// Stages lists the stages of the restore in the order they ran, such as
+	// "downloading" and "extracting", with when each started and how long it
+	// took. See ProgressCallback for the stages.
+	Stages []StageTiming
+}
+
+// PeekResult contains information about a cache entry found by Peek.
+//
+// Check Exists to see if the cache entry was found, the remaining fields
+// other than Key are only populated when it exists.
+type PeekResult struct {
+	// Exists indicates whether a cache entry exists for the key.
+	Exists bool
+
+	// Key is the cache key that was checked (after template expansion).
+	Key string
+
+	// MatchedKey is the key of the entry found, which is Key or, with
+	// PeekOptions.FallbackKeys, one of the fallback keys.
+	MatchedKey string
+
+	// Fallback indicates the entry was found for a fallback key.
+	Fallback bool
+
+	// Store is the storage backend holding the cache entry.
+	Store string
+
+	// Digest is the digest of the cache archive, e.g. "sha256:...".
+	Digest string
+
+	// Compression is the compression format of the cache archive.
+	Compression string
+
+	// FileSize is the size of the cache archive in bytes.
+	FileSize int64
+
+	// Paths are the filesystem paths stored in the cache entry.
+	Paths []string
+
+	// Pipeline and Branch identify where the cache entry was saved.
+	Pipeline string
+	Branch   string
+
+	// Platform is the OS/arch string the cache entry was saved on (e.g., "linux/amd64").
+	Platform string
+
+	// CreatedAt indicates when this cache entry was created.
+	CreatedAt time.Time
+
+	// ExpiresAt indicates when this cache entry will expire.
+	ExpiresAt time.Time
+
+	// Metadata describes where and when the cache entry was saved, including
+	// the build, job and agent which saved it.
+	Metadata EntryMetadata
+}
+
+// PeekOptions controls the behaviour of PeekWithOptions.
+type PeekOptions struct {
+	// FallbackKeys checks the cache's fallback keys in order when its key
+	// doesn't exist, as a restore would. Fallback keys are checked as exact
+	// keys, so the server may match them differently when restoring.
+	FallbackKeys bool
+}
+
+// RestoreOptions controls the behaviour of a single restore operation.
+//
+// The zero value restores using the default behaviour.
+type RestoreOptions struct {
+	// OnConflict controls how files which already exist in the cache paths are
+	// handled. Defaults to archive.ConflictOverwrite, where the cache paths are
+	// removed before extraction so the restored files exactly match the archive.
+	//
+	// With archive.ConflictSkip or archive.ConflictFail the cache paths are not
+	// removed; existing files are either left untouched, or cause the restore
+	// to fail before any files are written. Caches with AtomicRestore set
+	// require archive.ConflictOverwrite.
+	OnConflict archive.ConflictPolicy
+
+	// Paths restricts the restore to a subset of the cache's configured paths.
+	// Each entry must exactly match one of the configured (expanded) paths.
+	// Only the selected paths are cleaned and extracted. If empty, all paths
+	// are restored.
+	Paths []string
+
+	// Chown changes the owner of every restored file, for example to
+	// archive.CurrentUserOwnership() when restoring a cache saved as root in a
+	// container. If nil, restored files are owned by the current user.
+	Chown *archive.Ownership
+
+	// FallbackStrategy controls which entry is restored when the key misses and
+	// several fallback keys match. Defaults to FallbackOrdered, where the first
+	// matching fallback key is restored. FallbackNewest and FallbackLargest
+	// check every fallback key, restoring the newest or largest entry.
+	FallbackStrategy FallbackStrategy
+
+	// DisableFallback restricts the restore to the exact cache key, ignoring
+	// the fallback keys, for jobs which must start from scratch when the key
+	// misses. FallbackStrategy is ignored.
+	DisableFallback bool
+
+	// ValidateManifest checks the restored files against the manifest
+	// recorded in the archive by caches with Manifest set, failing the
+	// restore with ErrManifestMismatch if any were modified or are missing.
+	// Archives without a manifest aren't validated, see
+	// RestoreResult.ManifestValidated.
+	ValidateManifest bool
+
+	// Mode controls where files are restored. Defaults to RestoreModeExtract.
+	// With RestoreModeStaged files are extracted into StagingDir, reported in
+	// RestoreResult.StagedPaths, and the cache paths are left untouched unless
+	// LinkStaged is set. OnConflict then applies to existing files in the
+	// staging directory and to cache paths replaced by links.
+	// With RestoreModePartial only Files are extracted.
+	Mode RestoreMode
+
+	// StagingDir is the directory files are extracted into with
+	// RestoreModeStaged. Defaults to a new temporary directory, which the
+	// caller is responsible for removing.
+	StagingDir string
+
+	// LinkStaged replaces each restored cache path with a symlink to its
+	// staged location, with RestoreModeStaged.
+	LinkStaged bool
+
+	// FailOnPlatformMismatch fails the restore with ErrPlatformMismatch,
+	// rather than treating it as a miss, when a cache with StrictPlatform
+	// matches an entry saved on a different platform.
+	FailOnPlatformMismatch bool
+
+	// WaitForPending is how long to wait for an entry for the cache key
+	// which has been created but not yet committed, as another job is still
+	// saving it, rather than reporting a miss or restoring a fallback key.
+	// This lets fan-out jobs depend on a single job warming the cache without
+	// explicit pipeline dependencies. If the entry isn't committed in time,
+	// the restore continues as if it hadn't waited. Zero doesn't wait.
+	WaitForPending time.Duration
+
+	// PendingPollInterval is how often a pending entry is checked with
+	// WaitForPending. Defaults to DefaultPendingPollInterval.
+	PendingPollInterval time.Duration
+
+	// Files restricts the restore to the files and directories at or beneath
+	// these paths, with RestoreModePartial. Each must be beneath one of the
+	// cache's configured paths, e.g. "~/.npm/_cacache/index-v5" for a path of
+	// "~/.npm".
+	Files []string
+
+	// SkipSpaceCheck downloads the archive without first checking the temp
+	// directory and the cache paths have at least as much free space as the
+	// archive. By default the restore fails with ErrInsufficientSpace rather
+	// than running out of space part way through.
+	SkipSpaceCheck bool
+
+	// DryRun looks up the entry which would be restored without downloading
+	// or extracting it, or waiting for a pending entry. The result reports its
+	// Key, CacheHit, FallbackUsed, ExpiresAt and Metadata as a restore would,
+	// with CacheRestored false. Dry runs aren't reported to Config.Reporter.
+	DryRun bool
 }
 
 // ArchiveMetrics contains metrics about archive build and extraction operations.
@@ -267,6 +791,11 @@ type ArchiveMetrics struct {
 
 	// Paths are the filesystem paths that were archived or extracted.
 	Paths []string
+
+	// PathStats is a per-path breakdown of the entries and bytes archived or
+	// extracted, including the largest files, to find which path is
+	// responsible for a large cache.
+	PathStats []archive.PathStats
 }
 
 // TransferMetrics contains metrics about upload and download operations.
@@ -289,4 +818,26 @@ type TransferMetrics struct {
 
 	// Concurrency is the number of concurrent uploads/downloads used.
 	Concurrency int
+
+	// ChunkCount is the number of chunks in the archive when using chunked
+	// storage (0 if the archive was stored as a single object).
+	ChunkCount int
+
+	// ReusedChunks is the number of chunks which were already in the store
+	// and weren't uploaded again. Only set when saving.
+	ReusedChunks int
+}
+
+// StageTiming is the timing of a stage of a save or restore, e.g. to build a
+// flame chart of the operation or submit per-stage metrics.
+type StageTiming struct {
+	// Name is the stage, as passed to the ProgressCallback.
+	Name string
+
+	// Start is when the stage started.
+	Start time.Time
+
+	// Duration is how long the stage took, until the next stage started or
+	// the operation finished.
+	Duration time.Duration
 }